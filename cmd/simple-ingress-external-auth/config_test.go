@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCmdConfigTenants(t *testing.T) {
+	tests := map[string]struct {
+		args   []string
+		expErr bool
+	}{
+		"Tenants with the main token config should be valid.": {
+			args: []string{"--token-config-file", "tokens.yaml"},
+		},
+
+		"Tenants with Kubernetes Secrets should fail.": {
+			args:   []string{"--kubernetes-secrets-label-selector", "app=test"},
+			expErr: true,
+		},
+
+		"Tenants with Kubernetes TokenReview should fail.": {
+			args:   []string{"--kubernetes-token-review"},
+			expErr: true,
+		},
+
+		"Tenants with a token SQLite DB should fail.": {
+			args:   []string{"--token-sqlite-db", "tokens.db"},
+			expErr: true,
+		},
+
+		"Tenants with a token Redis URL should fail.": {
+			args:   []string{"--token-redis-url", "redis://127.0.0.1:6379"},
+			expErr: true,
+		},
+
+		"Tenants with an introspection URL should fail.": {
+			args:   []string{"--introspection-url", "https://issuer.slok.dev/introspect"},
+			expErr: true,
+		},
+
+		"Tenants with a JWT config file should fail.": {
+			args:   []string{"--jwt-config-file", "jwt.json"},
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			args := append([]string{"simple-ingress-external-auth", "--tenants-config-file", "tenants.yaml"}, test.args...)
+			_, err := NewCmdConfig(args)
+			if test.expErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+	"github.com/slok/simple-ingress-external-auth/internal/tokenhash"
+	apiv1 "github.com/slok/simple-ingress-external-auth/pkg/api/v1"
+	apiv2 "github.com/slok/simple-ingress-external-auth/pkg/api/v2"
+)
+
+// RunHashConfig prints the token configuration replacing the plain token values with their hashes.
+// The env vars are substituted before hashing, so the printed configuration will have them expanded.
+func RunHashConfig(ctx context.Context, cmdCfg CmdConfig, stdout io.Writer) error {
+	loader, err := newTokenConfigLoader(log.Noop, cmdCfg, metrics.Noop)
+	if err != nil {
+		return err
+	}
+
+	configs, err := loader.LoadConfigs(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The printed configuration is the same one, so we can't merge multiple configurations.
+	if len(configs) != 1 {
+		return fmt.Errorf("a single token config is required, got %d", len(configs))
+	}
+
+	var configData string
+	for _, c := range configs {
+		configData = c
+	}
+
+	version, err := memory.DecodeConfigVersion(configData)
+	if err != nil {
+		return fmt.Errorf("could not decode token config: %w", err)
+	}
+
+	var config any
+	switch version {
+	case "v2":
+		config, err = hashConfigV2(configData, cmdCfg.HashConfigAlgorithm)
+	default:
+		config, err = hashConfigV1(configData, cmdCfg.HashConfigAlgorithm)
+	}
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	switch cmdCfg.HashConfigOutputFormat {
+	case OutputFormatYAML:
+		data, err = yaml.Marshal(config)
+	default:
+		data, err = json.MarshalIndent(config, "", "\t")
+	}
+	if err != nil {
+		return fmt.Errorf("could not marshal token config: %w", err)
+	}
+
+	_, err = fmt.Fprintln(stdout, string(data))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func hashConfigV1(configData string, algorithm string) (*apiv1.Config, error) {
+	config, err := memory.DecodeConfigV1(configData)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode token config: %w", err)
+	}
+
+	for i, t := range config.Tokens {
+		// Already hashed.
+		if t.Value == "" {
+			continue
+		}
+
+		hash, err := tokenhash.Generate(algorithm, t.Value)
+		if err != nil {
+			return nil, fmt.Errorf("could not hash token %d: %w", i, err)
+		}
+
+		config.Tokens[i].Value = ""
+		config.Tokens[i].ValueHash = hash
+	}
+
+	return config, nil
+}
+
+func hashConfigV2(configData string, algorithm string) (*apiv2.Config, error) {
+	config, err := memory.DecodeConfigV2(configData)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode token config: %w", err)
+	}
+
+	for i, c := range config.Clients {
+		for j, cred := range c.Credentials {
+			// Already hashed.
+			if cred.Value == "" {
+				continue
+			}
+
+			hash, err := tokenhash.Generate(algorithm, cred.Value)
+			if err != nil {
+				return nil, fmt.Errorf("could not hash client %q credential %d: %w", c.ID, j, err)
+			}
+
+			config.Clients[i].Credentials[j].Value = ""
+			config.Clients[i].Credentials[j].ValueHash = hash
+		}
+	}
+
+	return config, nil
+}
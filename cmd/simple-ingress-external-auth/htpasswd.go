@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/slok/simple-ingress-external-auth/internal/htpasswd"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+	"github.com/slok/simple-ingress-external-auth/internal/reload"
+)
+
+// newHtpasswdUserRepository returns the htpasswd file user repository and its reloader, reloaded
+// like the token configurations.
+func newHtpasswdUserRepository(ctx context.Context, logger log.Logger, cmdCfg CmdConfig) (*htpasswd.UserRepository, *reload.Reloader, error) {
+	loader := reload.NewFileConfigLoader(cmdCfg.BasicAuthHtpasswdFile)
+	configs, err := loader.LoadConfigs(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read htpasswd file: %w", err)
+	}
+
+	repo, err := htpasswd.NewUserRepository(configs[cmdCfg.BasicAuthHtpasswdFile])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid htpasswd file: %w", err)
+	}
+
+	// The htpasswd file is not a token configuration, so its reloads are not measured as one.
+	logger = logger.WithValues(log.Kv{"htpasswd": cmdCfg.BasicAuthHtpasswdFile})
+	reloader := reload.NewReloader(logger, metrics.Noop, "", loader, repo, configs)
+
+	return repo, reloader, nil
+}
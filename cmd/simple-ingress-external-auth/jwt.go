@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/slok/simple-ingress-external-auth/internal/jwt"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+)
+
+// newJWTTokenGetter returns the JWT token getter and the remote key sets that need to be run
+// to refresh their keys.
+func newJWTTokenGetter(logger log.Logger, cmdCfg CmdConfig) (*jwt.TokenGetter, []*jwt.RemoteKeySet, error) {
+	data, err := os.ReadFile(cmdCfg.JWTConfigFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read JWT config file: %w", err)
+	}
+
+	config, err := jwt.DecodeConfig(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid JWT config: %w", err)
+	}
+
+	issuers, err := jwt.NewIssuersFromConfig(*config, jwt.IssuersOptions{
+		BaseDir:            filepath.Dir(cmdCfg.JWTConfigFile),
+		HTTPClient:         &http.Client{Timeout: 30 * time.Second},
+		RefreshInterval:    cmdCfg.JWTKeysRefreshInterval,
+		MinRefetchInterval: cmdCfg.JWTKeysMinRefetchInterval,
+		Logger:             logger,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid JWT config: %w", err)
+	}
+
+	var remoteKeySets []*jwt.RemoteKeySet
+	for _, i := range issuers {
+		if ks, ok := i.Keys.(*jwt.RemoteKeySet); ok {
+			remoteKeySets = append(remoteKeySets, ks)
+		}
+	}
+
+	getter, err := jwt.NewTokenGetter(logger, issuers...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create JWT token getter: %w", err)
+	}
+
+	return getter, remoteKeySets, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/tokenreview"
+)
+
+func newKubernetesClient(kubeConfig string) (kubernetes.Interface, error) {
+	var cfg *rest.Config
+	var err error
+	if kubeConfig == "" {
+		cfg, err = rest.InClusterConfig()
+	} else {
+		cfg, err = clientcmd.BuildConfigFromFlags("", kubeConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(cfg)
+}
+
+// newTokenReviewTokenGetter returns the Kubernetes TokenReview token getter.
+func newTokenReviewTokenGetter(logger log.Logger, cmdCfg CmdConfig) (*tokenreview.TokenGetter, error) {
+	var serviceAccounts []tokenreview.ServiceAccount
+	if cmdCfg.TokenReviewConfigFile != "" {
+		data, err := os.ReadFile(cmdCfg.TokenReviewConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read Kubernetes TokenReview config file: %w", err)
+		}
+
+		config, err := tokenreview.DecodeConfig(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Kubernetes TokenReview config: %w", err)
+		}
+
+		serviceAccounts, err = tokenreview.NewServiceAccountsFromConfig(*config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Kubernetes TokenReview config: %w", err)
+		}
+	}
+
+	client, err := newKubernetesClient(cmdCfg.KubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not create Kubernetes client: %w", err)
+	}
+
+	getter, err := tokenreview.NewTokenGetter(tokenreview.TokenGetterConfig{
+		Client:           client,
+		Audiences:        cmdCfg.TokenReviewAudiences,
+		ServiceAccounts:  serviceAccounts,
+		CacheSize:        cmdCfg.TokenReviewCacheSize,
+		CacheTTL:         cmdCfg.TokenReviewCacheTTL,
+		NegativeCacheTTL: cmdCfg.TokenReviewNegativeCacheTTL,
+		Logger:           logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create Kubernetes TokenReview token getter: %w", err)
+	}
+
+	return getter, nil
+}
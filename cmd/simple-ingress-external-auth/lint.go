@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/slok/simple-ingress-external-auth/internal/lint"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+)
+
+// RunLint lints the token configurations printing the findings. It fails if there are findings.
+func RunLint(ctx context.Context, cmdCfg CmdConfig, stdout io.Writer) error {
+	loader, err := newTokenConfigLoader(log.Noop, cmdCfg, metrics.Noop)
+	if err != nil {
+		return err
+	}
+
+	rawConfigs, err := loader.LoadConfigs(ctx)
+	if err != nil {
+		return err
+	}
+
+	configs := map[string]lint.Source{}
+	for source, data := range rawConfigs {
+		c, positions, err := memory.DecodeConfigTokenPositions(data)
+		if err != nil {
+			return fmt.Errorf("could not decode token config %s (use validate command for details): %w", source, err)
+		}
+		configs[source] = lint.Source{Config: *c, TokenPositions: positions}
+	}
+
+	findings, err := lint.Lint(lint.Config{
+		ExpiryWindow:       &cmdCfg.LintExpiryWindow,
+		MinLength:          &cmdCfg.LintMinLength,
+		MinEntropyBits:     &cmdCfg.LintMinEntropyBits,
+		MaxRegexDuplicates: &cmdCfg.LintMaxRegexDuplicates,
+		DisabledRules:      cmdCfg.LintDisabledRules,
+	}, configs)
+	if err != nil {
+		return fmt.Errorf("could not lint token config: %w", err)
+	}
+
+	switch cmdCfg.LintOutputFormat {
+	case OutputFormatJSON:
+		data, err := json.MarshalIndent(map[string]any{"findings": findings}, "", "\t")
+		if err != nil {
+			return fmt.Errorf("could not marshal findings: %w", err)
+		}
+
+		_, err = fmt.Fprintln(stdout, string(data))
+		if err != nil {
+			return err
+		}
+	default:
+		for _, f := range findings {
+			_, err := fmt.Fprintf(stdout, "[WARNING] %s\n", f)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = fmt.Fprintf(stdout, "Findings: %d\n", len(findings))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(findings) > 0 {
+		return fmt.Errorf("token config has %d lint findings", len(findings))
+	}
+
+	return nil
+}
@@ -4,24 +4,36 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
 	"github.com/oklog/run"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 
 	appauth "github.com/slok/simple-ingress-external-auth/internal/app/auth"
+	grpcextauthz "github.com/slok/simple-ingress-external-auth/internal/grpc/extauthz"
 	httpauthenticate "github.com/slok/simple-ingress-external-auth/internal/http/authenticate"
+	httplogin "github.com/slok/simple-ingress-external-auth/internal/http/login"
+	httpreload "github.com/slok/simple-ingress-external-auth/internal/http/reload"
+	httptenant "github.com/slok/simple-ingress-external-auth/internal/http/tenant"
+	httptokenreview "github.com/slok/simple-ingress-external-auth/internal/http/tokenreview"
 	"github.com/slok/simple-ingress-external-auth/internal/info"
 	"github.com/slok/simple-ingress-external-auth/internal/log"
 	loglogrus "github.com/slok/simple-ingress-external-auth/internal/log/logrus"
 	metrics "github.com/slok/simple-ingress-external-auth/internal/metrics/prometheus"
+	"github.com/slok/simple-ingress-external-auth/internal/oidc"
+	"github.com/slok/simple-ingress-external-auth/internal/reload"
+	"github.com/slok/simple-ingress-external-auth/internal/spoe"
 	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
 )
 
@@ -37,6 +49,18 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
 		return fmt.Errorf("could not load command configuration: %w", err)
 	}
 
+	// Offline commands.
+	switch cmdCfg.Command {
+	case CmdHashConfig:
+		return RunHashConfig(ctx, *cmdCfg, stdout)
+	case CmdSQLiteImport:
+		return RunSQLiteImport(ctx, *cmdCfg, stdout)
+	case CmdValidate:
+		return RunValidate(ctx, *cmdCfg, stdout)
+	case CmdLint:
+		return RunLint(ctx, *cmdCfg, stdout)
+	}
+
 	// Set up logger.
 	logrusLog := logrus.New()
 	logrusLog.Out = stderr // By default logger goes to stderr (so it can split stdout prints).
@@ -53,6 +77,159 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
 	// Set up metrics with default metrics recorder.
 	metricsRecorder := metrics.NewRecorder(prometheus.DefaultRegisterer)
 
+	// Load token sources.
+	var tokenGetters []appauth.TokenGetter
+	var userGetters []appauth.UserGetter
+	var certGetter appauth.CertGetter
+	var signatureKeyGetter appauth.SignatureKeyGetter
+	var reloader *reload.Reloader
+	var tokenConfigRepo *memory.TokenRepository
+	if cmdCfg.HasTokenConfig() {
+		configLoader, err := newTokenConfigLoader(logger, *cmdCfg, metricsRecorder)
+		if err != nil {
+			return err
+		}
+
+		configs, err := configLoader.LoadConfigs(ctx)
+		if err != nil {
+			return err
+		}
+
+		repo, err := memory.NewTokenRepositoryFromConfigs(logger, configs)
+		if err != nil {
+			return fmt.Errorf("could not create memory token repository: %w", err)
+		}
+
+		reloader = reload.NewReloader(logger, metricsRecorder, "", configLoader, repo, configs)
+		tokenConfigRepo = repo
+		tokenGetters = append(tokenGetters, repo)
+		userGetters = append(userGetters, repo)
+		if cmdCfg.ClientCertHeader != "" {
+			certGetter = repo
+		}
+		if cmdCfg.HTTPSignatures {
+			signatureKeyGetter = repo
+		}
+	}
+
+	sourceGetters, jwtRemoteKeySets, closeSources, err := newTokenGetters(ctx, logger, metricsRecorder, *cmdCfg)
+	if err != nil {
+		return err
+	}
+	defer closeSources()
+	tokenGetters = append(tokenGetters, sourceGetters...)
+
+	var htpasswdReloader *reload.Reloader
+	if cmdCfg.BasicAuthHtpasswdFile != "" {
+		repo, reloader, err := newHtpasswdUserRepository(ctx, logger, *cmdCfg)
+		if err != nil {
+			return err
+		}
+		htpasswdReloader = reloader
+		userGetters = append(userGetters, repo)
+	}
+
+	var loginHandler http.Handler
+	tokenSources := cmdCfg.TokenSources
+	if cmdCfg.OIDCIssuerURL != "" {
+		codec, err := oidc.NewCodec(cmdCfg.OIDCCookieSecret)
+		if err != nil {
+			return fmt.Errorf("invalid OIDC cookie secret: %w", err)
+		}
+
+		provider, err := oidc.NewProvider(oidc.ProviderConfig{
+			IssuerURL:    cmdCfg.OIDCIssuerURL,
+			ClientID:     cmdCfg.OIDCClientID,
+			ClientSecret: cmdCfg.OIDCClientSecret,
+			RedirectURL:  cmdCfg.OIDCRedirectURL,
+			Scopes:       cmdCfg.OIDCScopes,
+			GroupsClaim:  cmdCfg.OIDCGroupsClaim,
+			Logger:       logger,
+		})
+		if err != nil {
+			return fmt.Errorf("could not create OIDC provider: %w", err)
+		}
+
+		policy := oidc.Policy{
+			AllowedUsers:  cmdCfg.OIDCAllowedUsers,
+			AllowedGroups: cmdCfg.OIDCAllowedGroups,
+		}
+
+		loginHandler = httplogin.New(logger, httplogin.Config{
+			Provider:               provider,
+			Codec:                  codec,
+			Policy:                 policy,
+			CookieName:             cmdCfg.OIDCCookieName,
+			CookieDomain:           cmdCfg.OIDCCookieDomain,
+			CookieInsecure:         cmdCfg.OIDCCookieInsecure,
+			SessionTTL:             cmdCfg.OIDCSessionTTL,
+			AllowedRedirectDomains: cmdCfg.OIDCAllowedRedirectDomains,
+		})
+
+		// The sessions go first, so the session cookies are not sent to the remote token sources.
+		tokenGetters = append([]appauth.TokenGetter{oidc.NewSessionTokenGetter(logger, codec, policy)}, tokenGetters...)
+		tokenSources = append(tokenSources, httpauthenticate.TokenSource{Kind: httpauthenticate.TokenSourceCookie, Name: cmdCfg.OIDCCookieName})
+	}
+
+	tokenGetter := appauth.NewTokenGetterChain(tokenGetters...)
+	userGetter := appauth.NewUserGetterChain(userGetters...)
+
+	// The HTTP and gRPC servers share the same auth service.
+	appSvc, err := appauth.NewService(appauth.ServiceConfig{
+		TokenGetter:                 tokenGetter,
+		UserGetter:                  userGetter,
+		CertGetter:                  certGetter,
+		SignatureKeyGetter:          signatureKeyGetter,
+		SignatureMaxAge:             cmdCfg.HTTPSignatureMaxAge,
+		SignatureClockSkew:          cmdCfg.HTTPSignatureClockSkew,
+		SignatureRequiredComponents: cmdCfg.HTTPSignatureComponents,
+		MetricsRecorder:             metricsRecorder,
+		Logger:                      logger,
+	})
+	if err != nil {
+		return fmt.Errorf("could not create auth service: %w", err)
+	}
+
+	headerKeys, err := newHeaderKeys(cmdCfg.ProxyPreset, cmdCfg.ClientIDHeader, cmdCfg.RequestMethodHeader, cmdCfg.RequestURLHeader)
+	if err != nil {
+		return err
+	}
+	headerKeys.Scopes = cmdCfg.ScopesHeader
+	headerKeys.RequiredScopes = cmdCfg.RequiredScopesHeader
+
+	authConfig := httpauthenticate.Config{
+		HeaderKeys:             headerKeys,
+		AuthenticationPath:     cmdCfg.AuthenticationPath,
+		BasicAuthRealm:         cmdCfg.BasicAuthRealm,
+		TokenSources:           tokenSources,
+		ClientCertHeader:       cmdCfg.ClientCertHeader,
+		ClientCertVerifyHeader: cmdCfg.ClientCertVerifyHeader,
+		HTTPSignatures:         cmdCfg.HTTPSignatures,
+		// Browsers without session must be redirected to the login.
+		MissingCredentialsUnauthorized: loginHandler != nil,
+	}
+
+	// Load tenants.
+	var tenants []tenantSet
+	if cmdCfg.TenantsConfigFile != "" {
+		tenants, err = newTenants(ctx, logger, metricsRecorder, *cmdCfg, authConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The main, the tenant token config and the htpasswd file reloaders.
+	var reloaders reload.Reloaders
+	if reloader != nil {
+		reloaders = append(reloaders, reloader)
+	}
+	for _, t := range tenants {
+		reloaders = append(reloaders, t.reloader)
+	}
+	if htpasswdReloader != nil {
+		reloaders = append(reloaders, htpasswdReloader)
+	}
+
 	// Prepare our main runner.
 	var g run.Group
 
@@ -60,31 +237,47 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
 	{
 		logger := logger.WithValues(log.Kv{"addr": cmdCfg.ListenAddress})
 
-		configData := cmdCfg.TokenConfigData
-		if cmdCfg.TokenConfigFile != "" {
-			data, err := os.ReadFile(cmdCfg.TokenConfigFile)
-			if err != nil {
-				return fmt.Errorf("could not read token config file: %w", err)
+		// Create server.
+		handler := httpauthenticate.New(logger, metricsRecorder, appSvc, authConfig)
+		// The tenants are served under the authentication path.
+		authPrefix := strings.TrimSuffix(cmdCfg.AuthenticationPath, "/")
+		forwardedRequest := headerKeys.ForwardedRequest
+		var tenantPaths []string
+		if len(tenants) > 0 {
+			ts := make([]httptenant.Tenant, 0, len(tenants))
+			for _, t := range tenants {
+				ts = append(ts, t.tenant)
+				if t.tenant.HeaderKeys.ForwardedRequest {
+					forwardedRequest = true
+				} else {
+					tenantPaths = append(tenantPaths, authPrefix+"/"+t.tenant.Name)
+				}
 			}
-			configData = string(data)
-		}
 
-		// Create dependencies.
-		repo, err := memory.NewTokenRepository(logger, configData)
-		if err != nil {
-			return fmt.Errorf("could not create memory token repository: %w", err)
+			handler, err = httptenant.New(logger, httptenant.Config{
+				Tenants:                 ts,
+				Default:                 handler,
+				AuthenticationPath:      cmdCfg.AuthenticationPath,
+				DefaultForwardedRequest: headerKeys.ForwardedRequest,
+			})
+			if err != nil {
+				return fmt.Errorf("could not create tenant router: %w", err)
+			}
 		}
 
-		appSvc := appauth.NewService(logger, metricsRecorder, repo)
-
-		// Create server.
-		handler := httpauthenticate.New(logger, metricsRecorder, appSvc, httpauthenticate.HeaderKeys{
-			ClientID:       cmdCfg.ClientIDHeader,
-			OriginalMethod: cmdCfg.RequestMethodHeader,
-			OriginalURL:    cmdCfg.RequestURLHeader,
-		})
 		mux := http.NewServeMux()
 		mux.Handle(cmdCfg.AuthenticationPath, handler)
+		// The original request path is forwarded under the authentication path.
+		if subtree := authPrefix + "/"; forwardedRequest && subtree != cmdCfg.AuthenticationPath {
+			mux.Handle(subtree, handler)
+		} else if !forwardedRequest {
+			for _, p := range tenantPaths {
+				mux.Handle(p, handler)
+			}
+		}
+		if loginHandler != nil {
+			mux.Handle("/oauth2/", loginHandler)
+		}
 
 		server := &http.Server{
 			Addr:    cmdCfg.ListenAddress,
@@ -110,6 +303,103 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
 		)
 	}
 
+	// Serving Envoy ext_authz gRPC server.
+	if cmdCfg.EnvoyGRPCListenAddr != "" {
+		logger := logger.WithValues(log.Kv{"addr": cmdCfg.EnvoyGRPCListenAddr})
+
+		server := grpc.NewServer()
+		authv3.RegisterAuthorizationServer(server, grpcextauthz.New(logger, metricsRecorder, appSvc, authConfig))
+
+		g.Add(
+			func() error {
+				l, err := net.Listen("tcp", cmdCfg.EnvoyGRPCListenAddr)
+				if err != nil {
+					return fmt.Errorf("could not listen on %s: %w", cmdCfg.EnvoyGRPCListenAddr, err)
+				}
+
+				logger.Infof("Envoy ext_authz gRPC server listening for requests")
+				return server.Serve(l)
+			},
+			func(_ error) {
+				logger.Infof("Envoy ext_authz gRPC server shutdown, draining connections...")
+				server.GracefulStop()
+				logger.Infof("Connections drained")
+			},
+		)
+	}
+
+	// Serving HAProxy SPOE agent.
+	if cmdCfg.HAProxySPOEListenAddr != "" {
+		logger := logger.WithValues(log.Kv{"addr": cmdCfg.HAProxySPOEListenAddr})
+
+		agent := spoe.New(logger, appSvc, spoe.Config{MessageName: cmdCfg.HAProxySPOEMessage, Timeout: cmdCfg.HAProxySPOETimeout})
+
+		g.Add(
+			func() error {
+				l, err := net.Listen("tcp", cmdCfg.HAProxySPOEListenAddr)
+				if err != nil {
+					return fmt.Errorf("could not listen on %s: %w", cmdCfg.HAProxySPOEListenAddr, err)
+				}
+
+				logger.Infof("HAProxy SPOE agent listening for requests")
+				return agent.Serve(l)
+			},
+			func(_ error) {
+				logger.Infof("HAProxy SPOE agent shutdown, closing connections...")
+				err := agent.Close()
+				if err != nil {
+					logger.Errorf("error shutting down agent: %s", err)
+				}
+
+				logger.Infof("Connections closed")
+			},
+		)
+	}
+
+	// Serving Kubernetes webhook token authentication HTTP server.
+	if cmdCfg.KubernetesWebhookListenAddr != "" {
+		logger := logger.WithValues(log.Kv{"addr": cmdCfg.KubernetesWebhookListenAddr, "path": cmdCfg.KubernetesWebhookPath})
+
+		// Only the token configuration tokens that opt in can be used as Kubernetes credentials.
+		appSvc, err := appauth.NewService(appauth.ServiceConfig{
+			TokenGetter:     appauth.NewKubernetesTokenGetter(tokenConfigRepo),
+			MetricsRecorder: metricsRecorder,
+			Logger:          logger,
+		})
+		if err != nil {
+			return fmt.Errorf("could not create Kubernetes webhook auth service: %w", err)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle(cmdCfg.KubernetesWebhookPath, httptokenreview.New(logger, appSvc))
+
+		server := &http.Server{
+			Addr:    cmdCfg.KubernetesWebhookListenAddr,
+			Handler: mux,
+		}
+
+		g.Add(
+			func() error {
+				logger.Infof("Kubernetes webhook HTTP server listening for requests")
+				if cmdCfg.KubernetesWebhookTLSCertFile != "" {
+					return server.ListenAndServeTLS(cmdCfg.KubernetesWebhookTLSCertFile, cmdCfg.KubernetesWebhookTLSKeyFile)
+				}
+				return server.ListenAndServe()
+			},
+			func(_ error) {
+				logger.Infof("Kubernetes webhook HTTP server shutdown, draining connections...")
+				ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+				defer cancel()
+				err := server.Shutdown(ctx)
+				if err != nil {
+					logger.Errorf("error shutting down server: %w", err)
+				}
+
+				logger.Infof("Connections drained")
+			},
+		)
+	}
+
 	// Serving internal HTTP server.
 	{
 		logger := logger.WithValues(log.Kv{
@@ -117,6 +407,7 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
 			"metrics":      cmdCfg.MetricsPath,
 			"health-check": cmdCfg.HealthCheckPath,
 			"pprof":        cmdCfg.PprofPath,
+			"reload":       cmdCfg.ReloadPath,
 		})
 		mux := http.NewServeMux()
 
@@ -133,6 +424,11 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
 		// Health check.
 		mux.Handle(cmdCfg.HealthCheckPath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte(`{"status":"ok"}`)) }))
 
+		// Token config reload, the main and the tenant token configurations and the htpasswd file.
+		if len(reloaders) > 0 {
+			mux.Handle(cmdCfg.ReloadPath, httpreload.New(logger, reloaders))
+		}
+
 		// Create server.
 		server := &http.Server{
 			Addr:    cmdCfg.InternalListenAddr,
@@ -159,6 +455,113 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
 		)
 	}
 
+	// Token config files watcher.
+	watchPaths := append(append([]string{}, cmdCfg.TokenConfigFiles...), cmdCfg.TokenConfigDirs...)
+	if len(watchPaths) > 0 && cmdCfg.TokenConfigWatch {
+		ctx, cancel := context.WithCancel(ctx)
+		watcher := reload.NewFileWatcher(logger, reloader, watchPaths...)
+
+		g.Add(
+			func() error {
+				return watcher.Run(ctx)
+			},
+			func(_ error) {
+				cancel()
+			},
+		)
+	}
+
+	// Tenant token config files watchers.
+	for _, t := range tenants {
+		if !cmdCfg.TokenConfigWatch {
+			break
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		watcher := reload.NewFileWatcher(logger.WithValues(log.Kv{"tenant": t.tenant.Name}), t.reloader, t.watchPaths...)
+
+		g.Add(
+			func() error {
+				return watcher.Run(ctx)
+			},
+			func(_ error) {
+				cancel()
+			},
+		)
+	}
+
+	// Htpasswd file watcher.
+	if htpasswdReloader != nil && cmdCfg.TokenConfigWatch {
+		ctx, cancel := context.WithCancel(ctx)
+		watcher := reload.NewFileWatcher(logger, htpasswdReloader, cmdCfg.BasicAuthHtpasswdFile)
+
+		g.Add(
+			func() error {
+				return watcher.Run(ctx)
+			},
+			func(_ error) {
+				cancel()
+			},
+		)
+	}
+
+	// Remote token config poller.
+	if cmdCfg.TokenConfigURL != "" {
+		ctx, cancel := context.WithCancel(ctx)
+		poller := reload.NewPoller(logger, reloader, cmdCfg.TokenConfigURLPollInterval)
+
+		g.Add(
+			func() error {
+				return poller.Run(ctx)
+			},
+			func(_ error) {
+				cancel()
+			},
+		)
+	}
+
+	// JWT remote keys refresh.
+	for _, keySet := range jwtRemoteKeySets {
+		ctx, cancel := context.WithCancel(ctx)
+
+		g.Add(
+			func() error {
+				return keySet.Run(ctx)
+			},
+			func(_ error) {
+				cancel()
+			},
+		)
+	}
+
+	// Token config reload on SIGHUP.
+	if len(reloaders) > 0 {
+		sigC := make(chan os.Signal, 1)
+		exitC := make(chan struct{})
+		signal.Notify(sigC, syscall.SIGHUP)
+
+		g.Add(
+			func() error {
+				for {
+					select {
+					case <-sigC:
+						logger.Infof("SIGHUP received, reloading token config")
+						err := reloaders.Reload(ctx)
+						if err != nil {
+							logger.Errorf("Could not reload token config, keeping the previous one: %s", err)
+						}
+					case <-exitC:
+						return nil
+					}
+				}
+			},
+			func(_ error) {
+				signal.Stop(sigC)
+				close(exitC)
+			},
+		)
+	}
+
 	// OS signals.
 	{
 		sigC := make(chan os.Signal, 1)
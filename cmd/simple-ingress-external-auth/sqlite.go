@@ -0,0 +1,25 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+
+	_ "modernc.org/sqlite"
+)
+
+func openSQLiteDB(path string) (*sql.DB, error) {
+	// Wait on locks instead of failing, the database could be updated by other tools. The path is
+	// escaped so it's not mixed with the DSN query (e.g `?` or `#` on the path).
+	dsn := url.URL{
+		Scheme:   "file",
+		Path:     path,
+		RawQuery: "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)",
+	}
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, fmt.Errorf("could not open SQLite database: %w", err)
+	}
+
+	return db, nil
+}
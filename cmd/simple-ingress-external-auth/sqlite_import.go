@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+	storagesql "github.com/slok/simple-ingress-external-auth/internal/storage/sql"
+	apiv1 "github.com/slok/simple-ingress-external-auth/pkg/api/v1"
+)
+
+// RunSQLiteImport imports the token configurations into the SQLite database, all the
+// configurations are merged and imported in a single transaction.
+func RunSQLiteImport(ctx context.Context, cmdCfg CmdConfig, stdout io.Writer) error {
+	loader, err := newTokenConfigLoader(log.Noop, cmdCfg, metrics.Noop)
+	if err != nil {
+		return err
+	}
+
+	configs, err := loader.LoadConfigs(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Be deterministic.
+	sources := make([]string, 0, len(configs))
+	for source := range configs {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	config := apiv1.Config{Version: "v1"}
+	for _, source := range sources {
+		c, err := memory.DecodeConfig(configs[source])
+		if err != nil {
+			return fmt.Errorf("could not decode token config %s: %w", source, err)
+		}
+		// All the sections are merged, so the import fails on the ones not supported by the database.
+		config.Tokens = append(config.Tokens, c.Tokens...)
+		config.Users = append(config.Users, c.Users...)
+		config.ClientCerts = append(config.ClientCerts, c.ClientCerts...)
+		config.SignatureKeys = append(config.SignatureKeys, c.SignatureKeys...)
+	}
+
+	db, err := openSQLiteDB(cmdCfg.TokenSQLiteDB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	n, err := storagesql.ImportConfigV1(ctx, db, config, cmdCfg.SQLiteImportReplace)
+	if err != nil {
+		return fmt.Errorf("could not import token config: %w", err)
+	}
+
+	_, err = fmt.Fprintf(stdout, "%d tokens imported\n", n)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenSQLiteDB(t *testing.T) {
+	tests := map[string]struct {
+		name string
+	}{
+		"A regular path should be opened.": {
+			name: "tokens.db",
+		},
+
+		"A path with DSN characters should be opened on the same path.": {
+			name: "my tokens?mode=ro#1%20.db",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			path := filepath.Join(t.TempDir(), test.name)
+			db, err := openSQLiteDB(path)
+			require.NoError(err)
+			defer db.Close()
+
+			_, err = db.Exec("CREATE TABLE test (id INTEGER)")
+			require.NoError(err)
+
+			_, err = os.Stat(path)
+			assert.NoError(err)
+
+			var journalMode string
+			err = db.QueryRow("PRAGMA journal_mode").Scan(&journalMode)
+			require.NoError(err)
+			assert.Equal("wal", journalMode)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	appauth "github.com/slok/simple-ingress-external-auth/internal/app/auth"
+	httpauthenticate "github.com/slok/simple-ingress-external-auth/internal/http/authenticate"
+	httptenant "github.com/slok/simple-ingress-external-auth/internal/http/tenant"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+	"github.com/slok/simple-ingress-external-auth/internal/reload"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+	"github.com/slok/simple-ingress-external-auth/internal/tenant"
+)
+
+// newHeaderKeys returns the header keys of a proxy preset, the method and URL headers override
+// the preset ones.
+func newHeaderKeys(preset, clientIDHeader, methodHeader, urlHeader string) (httpauthenticate.HeaderKeys, error) {
+	keys, err := httpauthenticate.ProxyPresetHeaderKeys(preset)
+	if err != nil {
+		return httpauthenticate.HeaderKeys{}, err
+	}
+
+	keys.ClientID = clientIDHeader
+	if methodHeader != "" {
+		keys.OriginalMethod = methodHeader
+	}
+	if urlHeader != "" {
+		keys.OriginalURL = urlHeader
+		keys.ForwardedRequest = false
+	}
+
+	return keys, nil
+}
+
+// tenantSet is a loaded tenant with the token config reloader and the token config files that
+// need to be watched.
+type tenantSet struct {
+	tenant     httptenant.Tenant
+	reloader   *reload.Reloader
+	watchPaths []string
+}
+
+// newTenants returns the tenants of the tenants config file, the empty tenant options use the
+// main authentication configuration ones.
+func newTenants(ctx context.Context, logger log.Logger, metricsRec metrics.Recorder, cmdCfg CmdConfig, authConfig httpauthenticate.Config) ([]tenantSet, error) {
+	data, err := os.ReadFile(cmdCfg.TenantsConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read tenants config file: %w", err)
+	}
+
+	config, err := tenant.DecodeConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenants config: %w", err)
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenants config: %w", err)
+	}
+
+	// The relative token config files are relative to the tenants config file.
+	baseDir := filepath.Dir(cmdCfg.TenantsConfigFile)
+
+	var tenants []tenantSet
+	for _, tc := range config.Tenants {
+		logger := logger.WithValues(log.Kv{"tenant": tc.Name})
+
+		files := make([]string, 0, len(tc.TokenConfigFiles))
+		for _, f := range tc.TokenConfigFiles {
+			if !filepath.IsAbs(f) {
+				f = filepath.Join(baseDir, f)
+			}
+			files = append(files, f)
+		}
+
+		loader := reload.NewFileConfigLoader(files...)
+		configs, err := loader.LoadConfigs(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %q: %w", tc.Name, err)
+		}
+
+		repo, err := memory.NewTokenRepositoryFromConfigs(logger, configs)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %q: could not create memory token repository: %w", tc.Name, err)
+		}
+
+		svcConfig := appauth.ServiceConfig{
+			TokenGetter:                 repo,
+			UserGetter:                  repo,
+			SignatureMaxAge:             cmdCfg.HTTPSignatureMaxAge,
+			SignatureClockSkew:          cmdCfg.HTTPSignatureClockSkew,
+			SignatureRequiredComponents: cmdCfg.HTTPSignatureComponents,
+			Tenant:                      tc.Name,
+			MetricsRecorder:             metricsRec,
+			Logger:                      logger,
+		}
+		if cmdCfg.ClientCertHeader != "" {
+			svcConfig.CertGetter = repo
+		}
+		if cmdCfg.HTTPSignatures {
+			svcConfig.SignatureKeyGetter = repo
+		}
+		svc, err := appauth.NewService(svcConfig)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %q: could not create auth service: %w", tc.Name, err)
+		}
+
+		// Tenant authentication options.
+		preset := tc.ProxyPreset
+		if preset == "" {
+			preset = cmdCfg.ProxyPreset
+		}
+		clientIDHeader := tc.ClientIDHeader
+		if clientIDHeader == "" {
+			clientIDHeader = cmdCfg.ClientIDHeader
+		}
+		methodHeader := tc.RequestMethodHeader
+		if methodHeader == "" {
+			methodHeader = cmdCfg.RequestMethodHeader
+		}
+		urlHeader := tc.RequestURLHeader
+		if urlHeader == "" {
+			urlHeader = cmdCfg.RequestURLHeader
+		}
+		headerKeys, err := newHeaderKeys(preset, clientIDHeader, methodHeader, urlHeader)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %q: %w", tc.Name, err)
+		}
+		headerKeys.Scopes = cmdCfg.ScopesHeader
+		headerKeys.RequiredScopes = cmdCfg.RequiredScopesHeader
+
+		tenantAuthConfig := authConfig
+		tenantAuthConfig.HeaderKeys = headerKeys
+		tenantAuthConfig.Tenant = tc.Name
+		// The OIDC sessions are not tenant sessions.
+		tenantAuthConfig.TokenSources = cmdCfg.TokenSources
+		tenantAuthConfig.MissingCredentialsUnauthorized = false
+		if tc.BasicAuthRealm != "" {
+			tenantAuthConfig.BasicAuthRealm = tc.BasicAuthRealm
+		}
+		if len(tc.TokenSources) > 0 {
+			tenantAuthConfig.TokenSources = nil
+			for _, ts := range tc.TokenSources {
+				s, err := httpauthenticate.ParseTokenSource(ts)
+				if err != nil {
+					return nil, fmt.Errorf("tenant %q: invalid token source: %w", tc.Name, err)
+				}
+				tenantAuthConfig.TokenSources = append(tenantAuthConfig.TokenSources, s)
+			}
+		}
+
+		tenants = append(tenants, tenantSet{
+			tenant: httptenant.Tenant{
+				Name:       tc.Name,
+				Hosts:      tc.Hosts,
+				HeaderKeys: headerKeys,
+				Handler:    httpauthenticate.New(logger, metricsRec, svc, tenantAuthConfig),
+			},
+			reloader:   reload.NewReloader(logger, metricsRec, tc.Name, loader, repo, configs),
+			watchPaths: files,
+		})
+	}
+
+	return tenants, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+	"github.com/slok/simple-ingress-external-auth/internal/reload"
+)
+
+// newTokenConfigLoader returns the loader of all the configured token config sources, these
+// will be merged.
+func newTokenConfigLoader(logger log.Logger, cmdCfg CmdConfig, metricsRec metrics.Recorder) (reload.ConfigLoader, error) {
+	var loaders []reload.ConfigLoader
+	if cmdCfg.TokenConfigData != "" {
+		loaders = append(loaders, reload.NewStaticConfigLoader("token-config-data", cmdCfg.TokenConfigData))
+	}
+
+	if len(cmdCfg.TokenConfigFiles) > 0 {
+		loaders = append(loaders, reload.NewFileConfigLoader(cmdCfg.TokenConfigFiles...))
+	}
+
+	if len(cmdCfg.TokenConfigDirs) > 0 {
+		loaders = append(loaders, reload.NewDirConfigLoader(cmdCfg.TokenConfigDirs...))
+	}
+
+	if cmdCfg.TokenConfigURL != "" {
+		client, err := newTokenConfigHTTPClient(cmdCfg)
+		if err != nil {
+			return nil, err
+		}
+
+		l, err := reload.NewHTTPConfigLoader(reload.HTTPConfigLoaderConfig{
+			URL:             cmdCfg.TokenConfigURL,
+			Client:          client,
+			BearerToken:     cmdCfg.TokenConfigURLBearerToken,
+			MetricsRecorder: metricsRec,
+			Logger:          logger,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not create HTTP token config loader: %w", err)
+		}
+		loaders = append(loaders, l)
+	}
+
+	return reload.NewMultiConfigLoader(loaders...), nil
+}
+
+func newTokenConfigHTTPClient(cmdCfg CmdConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cmdCfg.TokenConfigURLTLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cmdCfg.TokenConfigURLTLSCert, cmdCfg.TokenConfigURLTLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load token config URL TLS client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cmdCfg.TokenConfigURLTLSCA != "" {
+		ca, err := os.ReadFile(cmdCfg.TokenConfigURLTLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("could not read token config URL TLS CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid token config URL TLS CA")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   30 * time.Second,
+	}, nil
+}
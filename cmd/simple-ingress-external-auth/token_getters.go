@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	goredis "github.com/redis/go-redis/v9"
+
+	appauth "github.com/slok/simple-ingress-external-auth/internal/app/auth"
+	"github.com/slok/simple-ingress-external-auth/internal/introspection"
+	"github.com/slok/simple-ingress-external-auth/internal/jwt"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+	storagekubernetes "github.com/slok/simple-ingress-external-auth/internal/storage/kubernetes"
+	storageredis "github.com/slok/simple-ingress-external-auth/internal/storage/redis"
+	storagesql "github.com/slok/simple-ingress-external-auth/internal/storage/sql"
+)
+
+// newTokenGetters returns the token getters of the token sources that are not the token
+// configuration, the JWT remote key sets that need to be run to refresh their keys, and a func
+// that closes the sources.
+//
+// The getters are checked in order until one has the token, the local ones go first, so the tokens
+// they verify are not sent to the remote sources (Kubernetes TokenReview and introspection), and
+// the remote source errors don't fail them.
+func newTokenGetters(ctx context.Context, logger log.Logger, metricsRec metrics.Recorder, cmdCfg CmdConfig) (getters []appauth.TokenGetter, jwtRemoteKeySets []*jwt.RemoteKeySet, closeAll func(), err error) {
+	var closers []func()
+	closeAll = func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+	defer func() {
+		if err != nil {
+			closeAll()
+		}
+	}()
+
+	if cmdCfg.JWTConfigFile != "" {
+		getter, remoteKeySets, err := newJWTTokenGetter(logger, cmdCfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		getters = append(getters, getter)
+		jwtRemoteKeySets = remoteKeySets
+	}
+
+	if cmdCfg.KubernetesSecretsLabelSelector != "" {
+		client, err := newKubernetesClient(cmdCfg.KubeConfig)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("could not create Kubernetes client: %w", err)
+		}
+
+		repo, err := storagekubernetes.NewTokenRepository(ctx, logger, metricsRec, client, cmdCfg.KubernetesSecretsNamespace, cmdCfg.KubernetesSecretsLabelSelector)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("could not create Kubernetes token repository: %w", err)
+		}
+		getters = append(getters, repo)
+	}
+
+	if cmdCfg.TokenSQLiteDB != "" {
+		db, err := openSQLiteDB(cmdCfg.TokenSQLiteDB)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		closers = append(closers, func() { _ = db.Close() })
+
+		repo, err := storagesql.NewTokenRepository(ctx, logger, db)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("could not create SQL token repository: %w", err)
+		}
+		getters = append(getters, repo)
+	}
+
+	if cmdCfg.TokenRedisURL != "" {
+		opts, err := goredis.ParseURL(cmdCfg.TokenRedisURL)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid Redis URL: %w", err)
+		}
+		client := goredis.NewClient(opts)
+		closers = append(closers, func() { _ = client.Close() })
+
+		repo, err := storageredis.NewTokenRepository(storageredis.RepositoryConfig{
+			Client:           client,
+			Logger:           logger,
+			KeyPrefix:        cmdCfg.TokenRedisKeyPrefix,
+			CacheSize:        cmdCfg.TokenRedisCacheSize,
+			CacheTTL:         cmdCfg.TokenRedisCacheTTL,
+			NegativeCacheTTL: cmdCfg.TokenRedisNegativeCacheTTL,
+		})
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("could not create Redis token repository: %w", err)
+		}
+		getters = append(getters, repo)
+	}
+
+	if cmdCfg.TokenReview {
+		getter, err := newTokenReviewTokenGetter(logger, cmdCfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		getters = append(getters, getter)
+	}
+
+	if cmdCfg.IntrospectionURL != "" {
+		getter, err := introspection.NewTokenGetter(introspection.TokenGetterConfig{
+			URL:              cmdCfg.IntrospectionURL,
+			ClientID:         cmdCfg.IntrospectionClientID,
+			ClientSecret:     cmdCfg.IntrospectionClientSecret,
+			CacheSize:        cmdCfg.IntrospectionCacheSize,
+			CacheTTL:         cmdCfg.IntrospectionCacheTTL,
+			NegativeCacheTTL: cmdCfg.IntrospectionNegativeCacheTTL,
+			Logger:           logger,
+		})
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("could not create introspection token getter: %w", err)
+		}
+		getters = append(getters, getter)
+	}
+
+	return getters, jwtRemoteKeySets, closeAll, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+)
+
+func TestNewTokenGettersOrder(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	jwtConfigFile := filepath.Join(dir, "jwt.json")
+	err := os.WriteFile(jwtConfigFile, []byte(`{"issuers": [{"issuer": "https://issuer.slok.dev", "jwks_url": "https://issuer.slok.dev/jwks"}]}`), 0o600)
+	require.NoError(err)
+
+	cmdCfg, err := NewCmdConfig([]string{
+		"simple-ingress-external-auth",
+		"--introspection-url", "https://issuer.slok.dev/introspect",
+		"--introspection-client-id", "test",
+		"--token-redis-url", "redis://127.0.0.1:6379",
+		"--token-sqlite-db", filepath.Join(dir, "tokens.db"),
+		"--jwt-config-file", jwtConfigFile,
+	})
+	require.NoError(err)
+
+	getters, _, closeAll, err := newTokenGetters(context.TODO(), log.Noop, metrics.Noop, *cmdCfg)
+	require.NoError(err)
+	defer closeAll()
+
+	// The local getters go before the remote ones.
+	gotTypes := []string{}
+	for _, g := range getters {
+		gotTypes = append(gotTypes, fmt.Sprintf("%T", g))
+	}
+	expTypes := []string{
+		"*jwt.TokenGetter",
+		"*sql.TokenRepository",
+		"*redis.TokenRepository",
+		"*introspection.TokenGetter",
+	}
+	assert.Equal(expTypes, gotTypes)
+}
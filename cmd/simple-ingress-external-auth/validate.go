@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+)
+
+// RunValidate validates the token configurations the same way they are loaded when running, printing
+// all the errors and a summary. It fails if the configuration is invalid.
+func RunValidate(ctx context.Context, cmdCfg CmdConfig, stdout io.Writer) error {
+	loader, err := newTokenConfigLoader(log.Noop, cmdCfg, metrics.Noop)
+	if err != nil {
+		return err
+	}
+
+	configs, err := loader.LoadConfigs(ctx)
+	if err != nil {
+		return err
+	}
+
+	report := memory.ValidateConfigs(configs, time.Now())
+	for _, err := range report.Errors {
+		_, err := fmt.Fprintf(stdout, "[ERROR] %s\n", err)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(stdout, "Configs: %d, tokens: %d, users: %d, client certs: %d, signature keys: %d, disabled: %d, expired: %d, errors: %d\n",
+		report.Configs, report.Tokens, report.Users, report.ClientCerts, report.SignatureKeys, report.Disabled, report.Expired, len(report.Errors))
+	if err != nil {
+		return err
+	}
+
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("invalid token config, %d errors", len(report.Errors))
+	}
+
+	return nil
+}
@@ -2,9 +2,12 @@ package auth
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/slok/simple-ingress-external-auth/internal/htpasswd"
 	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
 	"github.com/slok/simple-ingress-external-auth/internal/log"
 	"github.com/slok/simple-ingress-external-auth/internal/metrics"
@@ -15,27 +18,112 @@ type TokenGetter interface {
 	GetStaticTokenValidation(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error)
 }
 
+// UserGetter gets the Basic auth users.
+type UserGetter interface {
+	GetUserValidation(ctx context.Context, username string) (*model.UserValidation, error)
+}
+
+// CertGetter gets the client certificate identities.
+type CertGetter interface {
+	GetCertValidation(ctx context.Context, cert *x509.Certificate) (*model.CertValidation, error)
+}
+
+// SignatureKeyGetter gets the HTTP message signature keys.
+type SignatureKeyGetter interface {
+	GetSignatureKeyValidation(ctx context.Context, keyID string) (*model.SignatureKeyValidation, error)
+}
+
+// ServiceConfig is the auth Service configuration.
+type ServiceConfig struct {
+	TokenGetter TokenGetter
+	// UserGetter is optional, if nil, Basic auth is disabled.
+	UserGetter UserGetter
+	// CertGetter is optional, if nil, client certificate auth is disabled.
+	CertGetter CertGetter
+	// SignatureKeyGetter is optional, if nil, HTTP message signature auth is disabled.
+	SignatureKeyGetter SignatureKeyGetter
+	// SignatureMaxAge is how old a signature (`created`) can be, by default 5m.
+	SignatureMaxAge time.Duration
+	// SignatureClockSkew is the clock difference tolerated with the signers, by default 30s.
+	SignatureClockSkew time.Duration
+	// SignatureRequiredComponents are the components that all the signatures must cover, by
+	// default `@method` and `@target-uri`.
+	SignatureRequiredComponents []string
+	// Tenant is the tenant of the service tokens, used on the metrics.
+	Tenant          string
+	MetricsRecorder metrics.Recorder
+	Logger          log.Logger
+}
+
+func (c *ServiceConfig) defaults() error {
+	if c.TokenGetter == nil {
+		return fmt.Errorf("token getter is required")
+	}
+
+	if c.SignatureMaxAge == 0 {
+		c.SignatureMaxAge = 5 * time.Minute
+	}
+
+	if c.SignatureClockSkew == 0 {
+		c.SignatureClockSkew = 30 * time.Second
+	}
+
+	if c.SignatureMaxAge < 0 || c.SignatureClockSkew < 0 {
+		return fmt.Errorf("signature max age and clock skew can't be negative")
+	}
+
+	if len(c.SignatureRequiredComponents) == 0 {
+		c.SignatureRequiredComponents = []string{"@method", "@target-uri"}
+	}
+
+	if c.MetricsRecorder == nil {
+		c.MetricsRecorder = metrics.Noop
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Noop
+	}
+
+	return nil
+}
+
 type Service struct {
-	tokenGetter TokenGetter
-	metricsRec  metrics.Recorder
-	logger      log.Logger
+	tokenGetter        TokenGetter
+	userGetter         UserGetter
+	certGetter         CertGetter
+	signatureKeyGetter SignatureKeyGetter
+	tenant             string
+	metricsRec         metrics.Recorder
+	logger             log.Logger
 
 	authenticater authenticater
 }
 
-func NewService(logger log.Logger, metricsRec metrics.Recorder, tokenGetter TokenGetter) Service {
+// NewService returns a new auth Service.
+func NewService(config ServiceConfig) (Service, error) {
+	err := config.defaults()
+	if err != nil {
+		return Service{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+
 	return Service{
-		tokenGetter: tokenGetter,
-		metricsRec:  metricsRec,
-		logger:      logger,
+		tokenGetter:        config.TokenGetter,
+		userGetter:         config.UserGetter,
+		certGetter:         config.CertGetter,
+		signatureKeyGetter: config.SignatureKeyGetter,
+		tenant:             config.Tenant,
+		metricsRec:         config.MetricsRecorder,
+		logger:             config.Logger,
 
 		authenticater: newAuthenticaterChain(
 			newTokenExistAuthenticator(),
+			newSignatureAuthenticator(config.SignatureMaxAge, config.SignatureClockSkew, config.SignatureRequiredComponents, newNonceCache()),
 			newNotExpiredAuthenticator(),
 			newValidMethodAuthenticator(),
 			newValidURLAuthenticator(),
+			newRequiredScopesAuthenticator(),
 		),
-	}
+	}, nil
 }
 
 type AuthenticateRequest struct {
@@ -45,6 +133,10 @@ type AuthenticateResponse struct {
 	ClientID      string
 	Authenticated bool
 	Reason        string
+	// Scopes are the scopes granted to the token, if any.
+	Scopes []string
+	// Groups are the groups of the token client, if any.
+	Groups []string
 }
 
 func (s Service) Authenticate(ctx context.Context, req AuthenticateRequest) (resp *AuthenticateResponse, err error) {
@@ -55,17 +147,37 @@ func (s Service) Authenticate(ctx context.Context, req AuthenticateRequest) (res
 			reason = resp.Reason
 			clientID = resp.ClientID
 		}
-		s.metricsRec.TokenReview(ctx, err == nil, auth, clientID, reason)
+		s.metricsRec.TokenReview(ctx, s.tenant, err == nil, auth, clientID, reason)
 	}()
 
-	if req.Review.Token == "" {
+	hasCredentials := req.Review.Token != "" || req.Review.Username != ""
+	if !hasCredentials && req.Review.ClientCert == nil && req.Review.Signature == nil {
 		return nil, fmt.Errorf("token is missing")
 	}
 
 	logger := s.logger.WithValues(log.Kv{"url": req.Review.HTTPURL, "method": req.Review.HTTPMethod})
 
 	// Get token and its properties.
-	token, err := s.tokenGetter.GetStaticTokenValidation(ctx, req.Review.Token)
+	var token *model.StaticTokenValidation
+	switch {
+	case req.Review.Signature != nil:
+		// Signed requests are only authenticated by their signature.
+		token, err = s.getSignatureTokenValidation(ctx, req.Review)
+	case req.Review.ClientCert != nil:
+		token, err = s.getCertTokenValidation(ctx, req.Review)
+		// Unknown certificates can still be authenticated with the other credentials.
+		if errors.Is(err, internalerrors.ErrNotFound) && hasCredentials {
+			token, err = nil, nil
+		}
+	}
+
+	switch {
+	case token != nil || err != nil:
+	case req.Review.Username != "":
+		token, err = s.getBasicAuthTokenValidation(ctx, req.Review)
+	default:
+		token, err = s.tokenGetter.GetStaticTokenValidation(ctx, req.Review.Token)
+	}
 	if err != nil {
 		if errors.Is(err, internalerrors.ErrNotFound) {
 			logger.Infof("Unknown token")
@@ -89,5 +201,90 @@ func (s Service) Authenticate(ctx context.Context, req AuthenticateRequest) (res
 		ClientID:      token.ClientID,
 		Authenticated: res.Valid,
 		Reason:        res.Reason,
+		Scopes:        token.Scopes,
+		Groups:        token.Groups,
+	}, nil
+}
+
+// getBasicAuthTokenValidation verifies the Basic auth user password and maps the user to a token
+// validation, so it can be authenticated as any other token.
+func (s Service) getBasicAuthTokenValidation(ctx context.Context, r model.TokenReview) (*model.StaticTokenValidation, error) {
+	if s.userGetter == nil {
+		return nil, fmt.Errorf("basic auth is disabled: %w", internalerrors.ErrNotFound)
+	}
+
+	user, err := s.userGetter.GetUserValidation(ctx, r.Username)
+	if err != nil {
+		// The unknown users password is also compared, so they can't be told apart from the known
+		// ones by the response time.
+		if errors.Is(err, internalerrors.ErrNotFound) {
+			htpasswd.VerifyDummy(r.Password)
+		}
+		return nil, err
+	}
+
+	ok, err := htpasswd.Verify(user.PasswordHash, r.Password)
+	if err != nil {
+		return nil, fmt.Errorf("could not verify user password: %w", err)
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("invalid password: %w", internalerrors.ErrNotFound)
+	}
+
+	return &model.StaticTokenValidation{
+		// Basic auth reviews don't have token.
+		Value:     r.Token,
+		ClientID:  user.Username,
+		ExpiresAt: user.ExpiresAt,
+		Common:    user.Common,
+	}, nil
+}
+
+// getCertTokenValidation maps the client certificate identity to a token validation, so it can be
+// authenticated as any other token. The certificate expiration is also used as the token expiration.
+func (s Service) getCertTokenValidation(ctx context.Context, r model.TokenReview) (*model.StaticTokenValidation, error) {
+	if s.certGetter == nil {
+		return nil, fmt.Errorf("client certificate auth is disabled: %w", internalerrors.ErrNotFound)
+	}
+
+	cert, err := s.certGetter.GetCertValidation(ctx, r.ClientCert)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := cert.ExpiresAt
+	if notAfter := r.ClientCert.NotAfter; !notAfter.IsZero() && (expiresAt.IsZero() || notAfter.Before(expiresAt)) {
+		expiresAt = notAfter
+	}
+
+	return &model.StaticTokenValidation{
+		// Client certificate reviews are authenticated by the certificate, not the token.
+		Value:     r.Token,
+		ClientID:  cert.ClientID,
+		ExpiresAt: expiresAt,
+		Common:    cert.Common,
+	}, nil
+}
+
+// getSignatureTokenValidation maps the HTTP message signature key to a token validation with the
+// key, so the signature can be verified by the authenticators.
+func (s Service) getSignatureTokenValidation(ctx context.Context, r model.TokenReview) (*model.StaticTokenValidation, error) {
+	if s.signatureKeyGetter == nil {
+		return nil, fmt.Errorf("HTTP message signature auth is disabled: %w", internalerrors.ErrNotFound)
+	}
+
+	key, err := s.signatureKeyGetter.GetSignatureKeyValidation(ctx, r.Signature.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.StaticTokenValidation{
+		// Signature reviews are authenticated by the signature, not the token.
+		Value:        r.Token,
+		ClientID:     key.ClientID,
+		ExpiresAt:    key.ExpiresAt,
+		Common:       key.Common,
+		SignatureKey: &key.Key,
 	}, nil
 }
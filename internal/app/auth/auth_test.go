@@ -2,6 +2,10 @@ package auth_test
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"regexp"
 	"testing"
@@ -9,9 +13,11 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
 	"github.com/slok/simple-ingress-external-auth/internal/app/auth"
 	"github.com/slok/simple-ingress-external-auth/internal/app/auth/authmock"
+	"github.com/slok/simple-ingress-external-auth/internal/httpsig"
 	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
 	"github.com/slok/simple-ingress-external-auth/internal/log"
 	"github.com/slok/simple-ingress-external-auth/internal/metrics"
@@ -19,11 +25,22 @@ import (
 )
 
 func TestServiceAuth(t *testing.T) {
+	// Password: `secret`.
+	const bcryptSecret = "$2a$04$xxGQ8SPyvwCFzVRYhLVELO5PucK6vuJlmKDmdwF8kuXOREuQSv4HC"
+
+	t0 := time.Now()
+	cert := &x509.Certificate{NotAfter: t0.Add(time.Hour)}
+	expiredCert := &x509.Certificate{NotAfter: t0.Add(-time.Hour)}
+	sigKey := model.SignatureKey{ID: "key0", Algorithm: httpsig.AlgorithmHMACSHA256, Key: []byte("secret")}
+
 	tests := map[string]struct {
-		mock    func(mtg *authmock.TokenGetter)
-		req     auth.AuthenticateRequest
-		expResp *auth.AuthenticateResponse
-		expErr  bool
+		mock      func(mtg *authmock.TokenGetter)
+		mockUsers func(mug *authmock.UserGetter)
+		mockCerts func(mcg *authmock.CertGetter)
+		mockSigs  func(msg *authmock.SignatureKeyGetter)
+		req       auth.AuthenticateRequest
+		expResp   *auth.AuthenticateResponse
+		expErr    bool
 	}{
 		"A token review without token should fail.": {
 			mock: func(mtg *authmock.TokenGetter) {},
@@ -98,6 +115,36 @@ func TestServiceAuth(t *testing.T) {
 			expResp: &auth.AuthenticateResponse{Authenticated: false, Reason: auth.ReasonInvalidMethod},
 		},
 
+		"A token review with the required scopes should be authenticated.": {
+			mock: func(mtg *authmock.TokenGetter) {
+				mtg.On("GetStaticTokenValidation", mock.Anything, "token0").Once().Return(&model.StaticTokenValidation{
+					Value:    "token0",
+					ClientID: "client0",
+					Scopes:   []string{"billing:read", "billing:write"},
+				}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Token:          "token0",
+				RequiredScopes: []string{"billing:write"},
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: true, ClientID: "client0", Scopes: []string{"billing:read", "billing:write"}},
+		},
+
+		"A token review without all the required scopes should be invalid.": {
+			mock: func(mtg *authmock.TokenGetter) {
+				mtg.On("GetStaticTokenValidation", mock.Anything, "token0").Once().Return(&model.StaticTokenValidation{
+					Value:    "token0",
+					ClientID: "client0",
+					Scopes:   []string{"billing:read"},
+				}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Token:          "token0",
+				RequiredScopes: []string{"billing:read", "billing:write"},
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, ClientID: "client0", Reason: auth.ReasonInsufficientScope, Scopes: []string{"billing:read"}},
+		},
+
 		"A token review that is valid, should be authenticated.": {
 			mock: func(mtg *authmock.TokenGetter) {
 				mtg.On("GetStaticTokenValidation", mock.Anything, "token0").Once().Return(&model.StaticTokenValidation{
@@ -110,6 +157,302 @@ func TestServiceAuth(t *testing.T) {
 			}},
 			expResp: &auth.AuthenticateResponse{Authenticated: true, ClientID: "client0"},
 		},
+
+		"A token review that is valid with scopes, should return the scopes.": {
+			mock: func(mtg *authmock.TokenGetter) {
+				mtg.On("GetStaticTokenValidation", mock.Anything, "token0").Once().Return(&model.StaticTokenValidation{
+					Value:    "token0",
+					ClientID: "client0",
+					Scopes:   []string{"billing:read"},
+				}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Token: "token0",
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: true, ClientID: "client0", Scopes: []string{"billing:read"}},
+		},
+
+		"A Basic auth review with a valid password should be valid.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockUsers: func(mug *authmock.UserGetter) {
+				mug.On("GetUserValidation", mock.Anything, "user0").Once().Return(&model.UserValidation{
+					Username:     "user0",
+					PasswordHash: bcryptSecret,
+				}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Username: "user0",
+				Password: "secret",
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: true, ClientID: "user0"},
+		},
+
+		"A Basic auth review with an invalid password should be invalid.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockUsers: func(mug *authmock.UserGetter) {
+				mug.On("GetUserValidation", mock.Anything, "user0").Once().Return(&model.UserValidation{
+					Username:     "user0",
+					PasswordHash: bcryptSecret,
+				}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Username: "user0",
+				Password: "wrong",
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, Reason: auth.ReasonInvalidToken},
+		},
+
+		"A Basic auth review with a missing user should be invalid.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockUsers: func(mug *authmock.UserGetter) {
+				mug.On("GetUserValidation", mock.Anything, "missing").Once().Return(nil, internalerrors.ErrNotFound)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Username: "missing",
+				Password: "secret",
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, Reason: auth.ReasonInvalidToken},
+		},
+
+		"A Basic auth review should apply the user restrictions.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockUsers: func(mug *authmock.UserGetter) {
+				mug.On("GetUserValidation", mock.Anything, "user0").Once().Return(&model.UserValidation{
+					Username:     "user0",
+					PasswordHash: bcryptSecret,
+					Common: model.TokenCommon{
+						AllowedMethod: regexp.MustCompile("^GET$"),
+					},
+				}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Username:   "user0",
+				Password:   "secret",
+				HTTPMethod: "POST",
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, ClientID: "user0", Reason: auth.ReasonInvalidMethod},
+		},
+
+		"A Basic auth review without user getter should be invalid.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Username: "user0",
+				Password: "secret",
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, Reason: auth.ReasonInvalidToken},
+		},
+
+		"A client certificate review should be valid.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockCerts: func(mcg *authmock.CertGetter) {
+				mcg.On("GetCertValidation", mock.Anything, cert).Once().Return(&model.CertValidation{ClientID: "svc0"}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				ClientCert: cert,
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: true, ClientID: "svc0"},
+		},
+
+		"A client certificate review should apply the identity restrictions.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockCerts: func(mcg *authmock.CertGetter) {
+				mcg.On("GetCertValidation", mock.Anything, cert).Once().Return(&model.CertValidation{
+					ClientID: "svc0",
+					Common: model.TokenCommon{
+						AllowedURL: regexp.MustCompile("^https://api.example.com/.*$"),
+					},
+				}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				ClientCert: cert,
+				HTTPURL:    "https://admin.example.com/",
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, ClientID: "svc0", Reason: auth.ReasonInvalidURL},
+		},
+
+		"An expired client certificate review should be invalid.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockCerts: func(mcg *authmock.CertGetter) {
+				mcg.On("GetCertValidation", mock.Anything, expiredCert).Once().Return(&model.CertValidation{ClientID: "svc0"}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				ClientCert: expiredCert,
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, ClientID: "svc0", Reason: auth.ReasonExpiredToken},
+		},
+
+		"An unknown client certificate review without other credentials should be invalid.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockCerts: func(mcg *authmock.CertGetter) {
+				mcg.On("GetCertValidation", mock.Anything, cert).Once().Return(nil, internalerrors.ErrNotFound)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				ClientCert: cert,
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, Reason: auth.ReasonInvalidToken},
+		},
+
+		"An unknown client certificate review with a token should use the token.": {
+			mock: func(mtg *authmock.TokenGetter) {
+				mtg.On("GetStaticTokenValidation", mock.Anything, "token0").Once().Return(&model.StaticTokenValidation{
+					Value:    "token0",
+					ClientID: "client0",
+				}, nil)
+			},
+			mockCerts: func(mcg *authmock.CertGetter) {
+				mcg.On("GetCertValidation", mock.Anything, cert).Once().Return(nil, internalerrors.ErrNotFound)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Token:      "token0",
+				ClientCert: cert,
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: true, ClientID: "client0"},
+		},
+
+		"A client certificate review without cert getter should be invalid.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				ClientCert: cert,
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, Reason: auth.ReasonInvalidToken},
+		},
+
+		"A signature review should be valid.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockSigs: func(msg *authmock.SignatureKeyGetter) {
+				msg.On("GetSignatureKeyValidation", mock.Anything, "key0").Once().Return(&model.SignatureKeyValidation{Key: sigKey, ClientID: "partner0"}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Signature: newTestSignature("key0", "secret", t0),
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: true, ClientID: "partner0"},
+		},
+
+		"A signature review should apply the key restrictions.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockSigs: func(msg *authmock.SignatureKeyGetter) {
+				msg.On("GetSignatureKeyValidation", mock.Anything, "key0").Once().Return(&model.SignatureKeyValidation{
+					Key:      sigKey,
+					ClientID: "partner0",
+					Common:   model.TokenCommon{AllowedMethod: regexp.MustCompile("^POST$")},
+				}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Signature:  newTestSignature("key0", "secret", t0),
+				HTTPMethod: "GET",
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, ClientID: "partner0", Reason: auth.ReasonInvalidMethod},
+		},
+
+		"A signature review with a wrong signature should be invalid.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockSigs: func(msg *authmock.SignatureKeyGetter) {
+				msg.On("GetSignatureKeyValidation", mock.Anything, "key0").Once().Return(&model.SignatureKeyValidation{Key: sigKey, ClientID: "partner0"}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Signature: newTestSignature("key0", "wrong", t0),
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, ClientID: "partner0", Reason: auth.ReasonInvalidSignature},
+		},
+
+		"A signature review with a different algorithm should be invalid.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockSigs: func(msg *authmock.SignatureKeyGetter) {
+				msg.On("GetSignatureKeyValidation", mock.Anything, "key0").Once().Return(&model.SignatureKeyValidation{Key: sigKey, ClientID: "partner0"}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Signature: func() *model.SignatureReview {
+					s := newTestSignature("key0", "secret", t0)
+					s.Algorithm = httpsig.AlgorithmEd25519
+					return s
+				}(),
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, ClientID: "partner0", Reason: auth.ReasonInvalidSignature},
+		},
+
+		"A signature review without the required components should be invalid.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockSigs: func(msg *authmock.SignatureKeyGetter) {
+				msg.On("GetSignatureKeyValidation", mock.Anything, "key0").Once().Return(&model.SignatureKeyValidation{Key: sigKey, ClientID: "partner0"}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Signature: func() *model.SignatureReview {
+					s := newTestSignature("key0", "secret", t0)
+					s.Components = []string{"@method"}
+					return s
+				}(),
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, ClientID: "partner0", Reason: auth.ReasonInvalidSignature},
+		},
+
+		"A signature review created in the future should be invalid.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockSigs: func(msg *authmock.SignatureKeyGetter) {
+				msg.On("GetSignatureKeyValidation", mock.Anything, "key0").Once().Return(&model.SignatureKeyValidation{Key: sigKey, ClientID: "partner0"}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Signature: newTestSignature("key0", "secret", t0.Add(time.Hour)),
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, ClientID: "partner0", Reason: auth.ReasonInvalidSignature},
+		},
+
+		"A signature review older than the max age should be expired.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockSigs: func(msg *authmock.SignatureKeyGetter) {
+				msg.On("GetSignatureKeyValidation", mock.Anything, "key0").Once().Return(&model.SignatureKeyValidation{Key: sigKey, ClientID: "partner0"}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Signature: newTestSignature("key0", "secret", t0.Add(-time.Hour)),
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, ClientID: "partner0", Reason: auth.ReasonExpiredSignature},
+		},
+
+		"A signature review past its expiration should be expired.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockSigs: func(msg *authmock.SignatureKeyGetter) {
+				msg.On("GetSignatureKeyValidation", mock.Anything, "key0").Once().Return(&model.SignatureKeyValidation{Key: sigKey, ClientID: "partner0"}, nil)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Signature: func() *model.SignatureReview {
+					s := newTestSignature("key0", "secret", t0.Add(-2*time.Minute))
+					s.Expires = t0.Add(-time.Minute)
+					return s
+				}(),
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, ClientID: "partner0", Reason: auth.ReasonExpiredSignature},
+		},
+
+		"A signature review with an unknown key should be invalid.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockSigs: func(msg *authmock.SignatureKeyGetter) {
+				msg.On("GetSignatureKeyValidation", mock.Anything, "key1").Once().Return(nil, internalerrors.ErrNotFound)
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Token:     "token0",
+				Signature: newTestSignature("key1", "secret", t0),
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, Reason: auth.ReasonInvalidToken},
+		},
+
+		"A signature review without signature key getter should be invalid.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Signature: newTestSignature("key0", "secret", t0),
+			}},
+			expResp: &auth.AuthenticateResponse{Authenticated: false, Reason: auth.ReasonInvalidToken},
+		},
+
+		"An error getting the client certificate should fail.": {
+			mock: func(mtg *authmock.TokenGetter) {},
+			mockCerts: func(mcg *authmock.CertGetter) {
+				mcg.On("GetCertValidation", mock.Anything, cert).Once().Return(nil, fmt.Errorf("something"))
+			},
+			req: auth.AuthenticateRequest{Review: model.TokenReview{
+				Token:      "token0",
+				ClientCert: cert,
+			}},
+			expErr: true,
+		},
 	}
 
 	for name, test := range tests {
@@ -119,7 +462,36 @@ func TestServiceAuth(t *testing.T) {
 			mtg := &authmock.TokenGetter{}
 			test.mock(mtg)
 
-			svc := auth.NewService(log.Noop, metrics.Noop, mtg)
+			var ug auth.UserGetter
+			if test.mockUsers != nil {
+				mug := &authmock.UserGetter{}
+				test.mockUsers(mug)
+				ug = mug
+			}
+
+			var cg auth.CertGetter
+			if test.mockCerts != nil {
+				mcg := &authmock.CertGetter{}
+				test.mockCerts(mcg)
+				cg = mcg
+			}
+
+			var sg auth.SignatureKeyGetter
+			if test.mockSigs != nil {
+				msg := &authmock.SignatureKeyGetter{}
+				test.mockSigs(msg)
+				sg = msg
+			}
+
+			svc, err := auth.NewService(auth.ServiceConfig{
+				TokenGetter:        mtg,
+				UserGetter:         ug,
+				CertGetter:         cg,
+				SignatureKeyGetter: sg,
+				MetricsRecorder:    metrics.Noop,
+				Logger:             log.Noop,
+			})
+			require.NoError(t, err)
 
 			gotResp, err := svc.Authenticate(context.TODO(), test.req)
 
@@ -131,3 +503,166 @@ func TestServiceAuth(t *testing.T) {
 		})
 	}
 }
+
+// newTestSignature returns an HMAC HTTP message signature that covers the default required components.
+func newTestSignature(keyID, secret string, created time.Time) *model.SignatureReview {
+	base := []byte("test signature base")
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(base)
+
+	return &model.SignatureReview{
+		KeyID:      keyID,
+		Created:    created,
+		Nonce:      created.String(),
+		Components: []string{"@method", "@target-uri"},
+		Base:       base,
+		Value:      mac.Sum(nil),
+	}
+}
+
+func TestServiceAuthSignatureReplay(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	msg := &authmock.SignatureKeyGetter{}
+	msg.On("GetSignatureKeyValidation", mock.Anything, "key0").Return(&model.SignatureKeyValidation{
+		Key:      model.SignatureKey{ID: "key0", Algorithm: httpsig.AlgorithmHMACSHA256, Key: []byte("secret")},
+		ClientID: "partner0",
+	}, nil)
+
+	svc, err := auth.NewService(auth.ServiceConfig{
+		TokenGetter:        &authmock.TokenGetter{},
+		SignatureKeyGetter: msg,
+	})
+	require.NoError(err)
+
+	sig := newTestSignature("key0", "secret", time.Now())
+	req := auth.AuthenticateRequest{Review: model.TokenReview{Signature: sig}}
+
+	// First time should be valid.
+	gotResp, err := svc.Authenticate(context.TODO(), req)
+	require.NoError(err)
+	assert.Equal(&auth.AuthenticateResponse{Authenticated: true, ClientID: "partner0"}, gotResp)
+
+	// Second time should be replayed.
+	gotResp, err = svc.Authenticate(context.TODO(), req)
+	require.NoError(err)
+	assert.Equal(&auth.AuthenticateResponse{Authenticated: false, ClientID: "partner0", Reason: auth.ReasonReplayedSignature}, gotResp)
+
+	// Without nonce, the signature itself is used.
+	sig2 := newTestSignature("key0", "secret", time.Now())
+	sig2.Nonce = ""
+	req2 := auth.AuthenticateRequest{Review: model.TokenReview{Signature: sig2}}
+	gotResp, err = svc.Authenticate(context.TODO(), req2)
+	require.NoError(err)
+	assert.True(gotResp.Authenticated)
+
+	gotResp, err = svc.Authenticate(context.TODO(), req2)
+	require.NoError(err)
+	assert.Equal(auth.ReasonReplayedSignature, gotResp.Reason)
+}
+
+func TestTokenGetterChain(t *testing.T) {
+	tests := map[string]struct {
+		mock     func(mtg0, mtg1 *authmock.TokenGetter)
+		expToken *model.StaticTokenValidation
+		expErr   error
+	}{
+		"If none of the getters have the token, it should return not found.": {
+			mock: func(mtg0, mtg1 *authmock.TokenGetter) {
+				mtg0.On("GetStaticTokenValidation", mock.Anything, "token0").Once().Return(nil, internalerrors.ErrNotFound)
+				mtg1.On("GetStaticTokenValidation", mock.Anything, "token0").Once().Return(nil, internalerrors.ErrNotFound)
+			},
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"If a getter fails, it should fail.": {
+			mock: func(mtg0, mtg1 *authmock.TokenGetter) {
+				mtg0.On("GetStaticTokenValidation", mock.Anything, "token0").Once().Return(nil, fmt.Errorf("something"))
+			},
+			expErr: fmt.Errorf("something"),
+		},
+
+		"The token from the first getter that has it should be returned.": {
+			mock: func(mtg0, mtg1 *authmock.TokenGetter) {
+				mtg0.On("GetStaticTokenValidation", mock.Anything, "token0").Once().Return(nil, internalerrors.ErrNotFound)
+				mtg1.On("GetStaticTokenValidation", mock.Anything, "token0").Once().Return(&model.StaticTokenValidation{Value: "token0", ClientID: "client1"}, nil)
+			},
+			expToken: &model.StaticTokenValidation{Value: "token0", ClientID: "client1"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mtg0 := &authmock.TokenGetter{}
+			mtg1 := &authmock.TokenGetter{}
+			test.mock(mtg0, mtg1)
+
+			getter := auth.NewTokenGetterChain(mtg0, mtg1)
+			gotToken, err := getter.GetStaticTokenValidation(context.TODO(), "token0")
+
+			if test.expErr != nil {
+				assert.Error(err)
+				if errors.Is(test.expErr, internalerrors.ErrNotFound) {
+					assert.ErrorIs(err, internalerrors.ErrNotFound)
+				}
+			} else if assert.NoError(err) {
+				assert.Equal(test.expToken, gotToken)
+			}
+
+			mtg0.AssertExpectations(t)
+			mtg1.AssertExpectations(t)
+		})
+	}
+}
+
+func TestKubernetesTokenGetter(t *testing.T) {
+	tests := map[string]struct {
+		mock     func(mtg *authmock.TokenGetter)
+		expToken *model.StaticTokenValidation
+		expErr   error
+	}{
+		"A token not allowed on Kubernetes should return not found.": {
+			mock: func(mtg *authmock.TokenGetter) {
+				mtg.On("GetStaticTokenValidation", mock.Anything, "token0").Once().Return(&model.StaticTokenValidation{Value: "token0", ClientID: "client1"}, nil)
+			},
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A missing token should return not found.": {
+			mock: func(mtg *authmock.TokenGetter) {
+				mtg.On("GetStaticTokenValidation", mock.Anything, "token0").Once().Return(nil, internalerrors.ErrNotFound)
+			},
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A token allowed on Kubernetes should be returned.": {
+			mock: func(mtg *authmock.TokenGetter) {
+				mtg.On("GetStaticTokenValidation", mock.Anything, "token0").Once().Return(&model.StaticTokenValidation{Value: "token0", ClientID: "client1", Kubernetes: true}, nil)
+			},
+			expToken: &model.StaticTokenValidation{Value: "token0", ClientID: "client1", Kubernetes: true},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mtg := &authmock.TokenGetter{}
+			test.mock(mtg)
+
+			getter := auth.NewKubernetesTokenGetter(mtg)
+			gotToken, err := getter.GetStaticTokenValidation(context.TODO(), "token0")
+
+			if test.expErr != nil {
+				assert.ErrorIs(err, test.expErr)
+			} else if assert.NoError(err) {
+				assert.Equal(test.expToken, gotToken)
+			}
+
+			mtg.AssertExpectations(t)
+		})
+	}
+}
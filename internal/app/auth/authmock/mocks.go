@@ -6,6 +6,7 @@ package authmock
 
 import (
 	"context"
+	"crypto/x509"
 
 	"github.com/slok/simple-ingress-external-auth/internal/model"
 	mock "github.com/stretchr/testify/mock"
@@ -94,3 +95,255 @@ func (_c *TokenGetter_GetStaticTokenValidation_Call) RunAndReturn(run func(ctx c
 	_c.Call.Return(run)
 	return _c
 }
+
+// NewUserGetter creates a new instance of UserGetter. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewUserGetter(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *UserGetter {
+	mock := &UserGetter{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
+
+// UserGetter is an autogenerated mock type for the UserGetter type
+type UserGetter struct {
+	mock.Mock
+}
+
+type UserGetter_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *UserGetter) EXPECT() *UserGetter_Expecter {
+	return &UserGetter_Expecter{mock: &_m.Mock}
+}
+
+// GetUserValidation provides a mock function for the type UserGetter
+func (_mock *UserGetter) GetUserValidation(ctx context.Context, username string) (*model.UserValidation, error) {
+	ret := _mock.Called(ctx, username)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetUserValidation")
+	}
+
+	var r0 *model.UserValidation
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(context.Context, string) (*model.UserValidation, error)); ok {
+		return returnFunc(ctx, username)
+	}
+	if returnFunc, ok := ret.Get(0).(func(context.Context, string) *model.UserValidation); ok {
+		r0 = returnFunc(ctx, username)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.UserValidation)
+		}
+	}
+	if returnFunc, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = returnFunc(ctx, username)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// UserGetter_GetUserValidation_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetUserValidation'
+type UserGetter_GetUserValidation_Call struct {
+	*mock.Call
+}
+
+// GetUserValidation is a helper method to define mock.On call
+//   - ctx
+//   - username
+func (_e *UserGetter_Expecter) GetUserValidation(ctx interface{}, username interface{}) *UserGetter_GetUserValidation_Call {
+	return &UserGetter_GetUserValidation_Call{Call: _e.mock.On("GetUserValidation", ctx, username)}
+}
+
+func (_c *UserGetter_GetUserValidation_Call) Run(run func(ctx context.Context, username string)) *UserGetter_GetUserValidation_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *UserGetter_GetUserValidation_Call) Return(userValidation *model.UserValidation, err error) *UserGetter_GetUserValidation_Call {
+	_c.Call.Return(userValidation, err)
+	return _c
+}
+
+func (_c *UserGetter_GetUserValidation_Call) RunAndReturn(run func(ctx context.Context, username string) (*model.UserValidation, error)) *UserGetter_GetUserValidation_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// NewCertGetter creates a new instance of CertGetter. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewCertGetter(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *CertGetter {
+	mock := &CertGetter{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
+
+// CertGetter is an autogenerated mock type for the CertGetter type
+type CertGetter struct {
+	mock.Mock
+}
+
+type CertGetter_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *CertGetter) EXPECT() *CertGetter_Expecter {
+	return &CertGetter_Expecter{mock: &_m.Mock}
+}
+
+// GetCertValidation provides a mock function for the type CertGetter
+func (_mock *CertGetter) GetCertValidation(ctx context.Context, cert *x509.Certificate) (*model.CertValidation, error) {
+	ret := _mock.Called(ctx, cert)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetCertValidation")
+	}
+
+	var r0 *model.CertValidation
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(context.Context, *x509.Certificate) (*model.CertValidation, error)); ok {
+		return returnFunc(ctx, cert)
+	}
+	if returnFunc, ok := ret.Get(0).(func(context.Context, *x509.Certificate) *model.CertValidation); ok {
+		r0 = returnFunc(ctx, cert)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.CertValidation)
+		}
+	}
+	if returnFunc, ok := ret.Get(1).(func(context.Context, *x509.Certificate) error); ok {
+		r1 = returnFunc(ctx, cert)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// CertGetter_GetCertValidation_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetCertValidation'
+type CertGetter_GetCertValidation_Call struct {
+	*mock.Call
+}
+
+// GetCertValidation is a helper method to define mock.On call
+//   - ctx
+//   - username
+func (_e *CertGetter_Expecter) GetCertValidation(ctx interface{}, cert interface{}) *CertGetter_GetCertValidation_Call {
+	return &CertGetter_GetCertValidation_Call{Call: _e.mock.On("GetCertValidation", ctx, cert)}
+}
+
+func (_c *CertGetter_GetCertValidation_Call) Run(run func(ctx context.Context, cert *x509.Certificate)) *CertGetter_GetCertValidation_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(*x509.Certificate))
+	})
+	return _c
+}
+
+func (_c *CertGetter_GetCertValidation_Call) Return(certValidation *model.CertValidation, err error) *CertGetter_GetCertValidation_Call {
+	_c.Call.Return(certValidation, err)
+	return _c
+}
+
+func (_c *CertGetter_GetCertValidation_Call) RunAndReturn(run func(ctx context.Context, cert *x509.Certificate) (*model.CertValidation, error)) *CertGetter_GetCertValidation_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// NewSignatureKeyGetter creates a new instance of SignatureKeyGetter. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewSignatureKeyGetter(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *SignatureKeyGetter {
+	mock := &SignatureKeyGetter{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
+
+// SignatureKeyGetter is an autogenerated mock type for the SignatureKeyGetter type
+type SignatureKeyGetter struct {
+	mock.Mock
+}
+
+type SignatureKeyGetter_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *SignatureKeyGetter) EXPECT() *SignatureKeyGetter_Expecter {
+	return &SignatureKeyGetter_Expecter{mock: &_m.Mock}
+}
+
+// GetSignatureKeyValidation provides a mock function for the type SignatureKeyGetter
+func (_mock *SignatureKeyGetter) GetSignatureKeyValidation(ctx context.Context, keyID string) (*model.SignatureKeyValidation, error) {
+	ret := _mock.Called(ctx, keyID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetSignatureKeyValidation")
+	}
+
+	var r0 *model.SignatureKeyValidation
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(context.Context, string) (*model.SignatureKeyValidation, error)); ok {
+		return returnFunc(ctx, keyID)
+	}
+	if returnFunc, ok := ret.Get(0).(func(context.Context, string) *model.SignatureKeyValidation); ok {
+		r0 = returnFunc(ctx, keyID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.SignatureKeyValidation)
+		}
+	}
+	if returnFunc, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = returnFunc(ctx, keyID)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// SignatureKeyGetter_GetSignatureKeyValidation_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetSignatureKeyValidation'
+type SignatureKeyGetter_GetSignatureKeyValidation_Call struct {
+	*mock.Call
+}
+
+// GetSignatureKeyValidation is a helper method to define mock.On call
+//   - ctx
+//   - keyID
+func (_e *SignatureKeyGetter_Expecter) GetSignatureKeyValidation(ctx interface{}, keyID interface{}) *SignatureKeyGetter_GetSignatureKeyValidation_Call {
+	return &SignatureKeyGetter_GetSignatureKeyValidation_Call{Call: _e.mock.On("GetSignatureKeyValidation", ctx, keyID)}
+}
+
+func (_c *SignatureKeyGetter_GetSignatureKeyValidation_Call) Run(run func(ctx context.Context, keyID string)) *SignatureKeyGetter_GetSignatureKeyValidation_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *SignatureKeyGetter_GetSignatureKeyValidation_Call) Return(signatureKeyValidation *model.SignatureKeyValidation, err error) *SignatureKeyGetter_GetSignatureKeyValidation_Call {
+	_c.Call.Return(signatureKeyValidation, err)
+	return _c
+}
+
+func (_c *SignatureKeyGetter_GetSignatureKeyValidation_Call) RunAndReturn(run func(ctx context.Context, keyID string) (*model.SignatureKeyValidation, error)) *SignatureKeyGetter_GetSignatureKeyValidation_Call {
+	_c.Call.Return(run)
+	return _c
+}
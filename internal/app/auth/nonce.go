@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// nonceCleanInterval is the min interval between the expired nonces cleanups.
+const nonceCleanInterval = time.Minute
+
+// nonceCache tracks the used signature nonces until they expire, to reject the replayed requests.
+type nonceCache struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	nextClean time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{nonces: map[string]time.Time{}}
+}
+
+// add tracks a nonce until its expiration, returns false if the nonce is already tracked.
+func (n *nonceCache) add(nonce string, expiresAt, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if now.After(n.nextClean) {
+		for k, exp := range n.nonces {
+			if now.After(exp) {
+				delete(n.nonces, k)
+			}
+		}
+		n.nextClean = now.Add(nonceCleanInterval)
+	}
+
+	if exp, ok := n.nonces[nonce]; ok && !now.After(exp) {
+		return false
+	}
+	n.nonces[nonce] = expiresAt
+
+	return true
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+)
+
+// TokenGetterFunc is a helper to use functions as TokenGetter.
+type TokenGetterFunc func(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error)
+
+func (t TokenGetterFunc) GetStaticTokenValidation(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error) {
+	return t(ctx, tokenValue)
+}
+
+// NewTokenGetterChain returns a TokenGetter that will return the token from the first getter that has it.
+// If none of them have it, it will return a not found error.
+func NewTokenGetterChain(getters ...TokenGetter) TokenGetter {
+	if len(getters) == 1 {
+		return getters[0]
+	}
+
+	return TokenGetterFunc(func(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error) {
+		for _, g := range getters {
+			token, err := g.GetStaticTokenValidation(ctx, tokenValue)
+			if err == nil {
+				return token, nil
+			}
+
+			if !errors.Is(err, internalerrors.ErrNotFound) {
+				return nil, err
+			}
+		}
+
+		return nil, fmt.Errorf("token not found: %w", internalerrors.ErrNotFound)
+	})
+}
+
+// NewKubernetesTokenGetter returns a TokenGetter that only returns the tokens allowed to authenticate
+// the Kubernetes API server users, the rest are not found.
+func NewKubernetesTokenGetter(getter TokenGetter) TokenGetter {
+	return TokenGetterFunc(func(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error) {
+		token, err := getter.GetStaticTokenValidation(ctx, tokenValue)
+		if err != nil {
+			return nil, err
+		}
+
+		if !token.Kubernetes {
+			return nil, fmt.Errorf("token not allowed on Kubernetes: %w", internalerrors.ErrNotFound)
+		}
+
+		return token, nil
+	})
+}
+
+// UserGetterFunc is a helper to use functions as UserGetter.
+type UserGetterFunc func(ctx context.Context, username string) (*model.UserValidation, error)
+
+func (u UserGetterFunc) GetUserValidation(ctx context.Context, username string) (*model.UserValidation, error) {
+	return u(ctx, username)
+}
+
+// NewUserGetterChain returns a UserGetter that will return the user from the first getter that has it.
+// If none of them have it, it will return a not found error.
+func NewUserGetterChain(getters ...UserGetter) UserGetter {
+	if len(getters) == 1 {
+		return getters[0]
+	}
+
+	return UserGetterFunc(func(ctx context.Context, username string) (*model.UserValidation, error) {
+		for _, g := range getters {
+			user, err := g.GetUserValidation(ctx, username)
+			if err == nil {
+				return user, nil
+			}
+
+			if !errors.Is(err, internalerrors.ErrNotFound) {
+				return nil, err
+			}
+		}
+
+		return nil, fmt.Errorf("user not found: %w", internalerrors.ErrNotFound)
+	})
+}
+
+// CertGetterFunc is a helper to use functions as CertGetter.
+type CertGetterFunc func(ctx context.Context, cert *x509.Certificate) (*model.CertValidation, error)
+
+func (c CertGetterFunc) GetCertValidation(ctx context.Context, cert *x509.Certificate) (*model.CertValidation, error) {
+	return c(ctx, cert)
+}
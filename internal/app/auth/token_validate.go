@@ -2,8 +2,10 @@ package auth
 
 import (
 	"context"
+	"slices"
 	"time"
 
+	"github.com/slok/simple-ingress-external-auth/internal/httpsig"
 	"github.com/slok/simple-ingress-external-auth/internal/model"
 )
 
@@ -12,6 +14,12 @@ const (
 	ReasonExpiredToken  = "expiredToken"
 	ReasonInvalidURL    = "invalidURL"
 	ReasonInvalidMethod = "invalidMethod"
+
+	ReasonInsufficientScope = "insufficientScope"
+
+	ReasonInvalidSignature  = "invalidSignature"
+	ReasonExpiredSignature  = "expiredSignature"
+	ReasonReplayedSignature = "replayedSignature"
 )
 
 type reviewResult struct {
@@ -60,6 +68,63 @@ func newTokenExistAuthenticator() authenticater {
 	})
 }
 
+// newSignatureAuthenticator verifies the HTTP message signatures with the token signature key. The
+// signatures must be recent (`created`), not expired (`expires`), cover the required components and
+// can't be reused (by `nonce` or, if missing, by the signature itself).
+func newSignatureAuthenticator(maxAge, clockSkew time.Duration, requiredComponents []string, nonces *nonceCache) authenticater {
+	return authenticaterFunc(func(ctx context.Context, r model.TokenReview, t model.StaticTokenValidation) (*reviewResult, error) {
+		sig, key := r.Signature, t.SignatureKey
+		if sig == nil && key == nil {
+			return &reviewResult{Valid: true}, nil
+		}
+
+		invalid := &reviewResult{Valid: false, Reason: ReasonInvalidSignature}
+		if sig == nil || key == nil || sig.KeyID != key.ID {
+			return invalid, nil
+		}
+
+		if sig.Algorithm != "" && sig.Algorithm != key.Algorithm {
+			return invalid, nil
+		}
+
+		for _, c := range requiredComponents {
+			if !slices.Contains(sig.Components, c) {
+				return invalid, nil
+			}
+		}
+
+		now := time.Now()
+		if sig.Created.IsZero() || sig.Created.After(now.Add(clockSkew)) {
+			return invalid, nil
+		}
+
+		expiresAt := sig.Created.Add(maxAge)
+		if !sig.Expires.IsZero() && sig.Expires.Before(expiresAt) {
+			expiresAt = sig.Expires
+		}
+		expiresAt = expiresAt.Add(clockSkew)
+		if now.After(expiresAt) {
+			return &reviewResult{Valid: false, Reason: ReasonExpiredSignature}, nil
+		}
+
+		err := httpsig.Verify(key.Algorithm, key.Key, sig.Base, sig.Value)
+		if err != nil {
+			return invalid, nil
+		}
+
+		// Only the verified signatures are tracked, until they expire.
+		nonce := sig.Nonce
+		if nonce == "" {
+			nonce = string(sig.Value)
+		}
+		if !nonces.add(key.ID+"\n"+nonce, expiresAt, now) {
+			return &reviewResult{Valid: false, Reason: ReasonReplayedSignature}, nil
+		}
+
+		return &reviewResult{Valid: true}, nil
+	})
+}
+
 func newNotExpiredAuthenticator() authenticater {
 	return authenticaterFunc(func(ctx context.Context, r model.TokenReview, t model.StaticTokenValidation) (*reviewResult, error) {
 		if t.ExpiresAt.IsZero() {
@@ -101,3 +166,16 @@ func newValidURLAuthenticator() authenticater {
 		return &reviewResult{Valid: false, Reason: ReasonInvalidURL}, nil
 	})
 }
+
+// newRequiredScopesAuthenticator checks that the token has all the required scopes of the review.
+func newRequiredScopesAuthenticator() authenticater {
+	return authenticaterFunc(func(ctx context.Context, r model.TokenReview, t model.StaticTokenValidation) (*reviewResult, error) {
+		for _, s := range r.RequiredScopes {
+			if !slices.Contains(t.Scopes, s) {
+				return &reviewResult{Valid: false, Reason: ReasonInsufficientScope}, nil
+			}
+		}
+
+		return &reviewResult{Valid: true}, nil
+	})
+}
@@ -0,0 +1,196 @@
+// Package extauthz has the Envoy external authorization (ext_authz) gRPC server.
+package extauthz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	httpmetrics "github.com/slok/go-http-metrics/middleware"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+
+	"github.com/slok/simple-ingress-external-auth/internal/app/auth"
+	httpauthenticate "github.com/slok/simple-ingress-external-auth/internal/http/authenticate"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+)
+
+// checkMethod is the ext_authz gRPC method, used as the metrics handler.
+const checkMethod = "/envoy.service.auth.v3.Authorization/Check"
+
+type server struct {
+	authv3.UnimplementedAuthorizationServer
+
+	logger            log.Logger
+	authAppSvc        auth.Service
+	config            httpauthenticate.Config
+	metricsMiddleware httpmetrics.Middleware
+}
+
+// New returns an Envoy ext_authz authorization server that authenticates the checked requests
+// the same way as the authentication HTTP handler, using the same configuration (token sources,
+// basic auth, client certificate headers and HTTP message signatures). The original method and
+// URL are taken from the check request instead of the original request headers.
+//
+// The authenticated requests are allowed with the client ID header injected on the upstream
+// request, the rest are denied with the same HTTP status codes as the HTTP handler.
+func New(logger log.Logger, metricRec metrics.Recorder, authAppSvc auth.Service, config httpauthenticate.Config) authv3.AuthorizationServer {
+	if config.HeaderKeys.ClientID == "" {
+		config.HeaderKeys.ClientID = "X-Ext-Auth-Client-Id"
+	}
+	if config.HeaderKeys.Scopes == "" {
+		config.HeaderKeys.Scopes = "X-Ext-Auth-Scopes"
+	}
+
+	return server{
+		logger:            logger,
+		authAppSvc:        authAppSvc,
+		config:            config,
+		metricsMiddleware: httpmetrics.New(httpmetrics.Config{Recorder: metricRec}),
+	}
+}
+
+// ContextExtensionScope is the Envoy ext_authz context extension with the required scopes (space
+// separated), set on the route `typed_per_filter_config`.
+const ContextExtensionScope = "scope"
+
+func (s server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	attrs := req.GetAttributes()
+	httpReq := attrs.GetRequest().GetHttp()
+
+	// Measure the checks with the same metrics as the HTTP handler.
+	var resp *authv3.CheckResponse
+	rep := &reporter{ctx: ctx, method: httpReq.GetMethod()}
+	s.metricsMiddleware.Measure(checkMethod, rep, func() {
+		resp, rep.statusCode = s.check(ctx, httpReq, attrs.GetContextExtensions())
+	})
+
+	return resp, nil
+}
+
+func (s server) check(ctx context.Context, httpReq *authv3.AttributeContext_HttpRequest, contextExtensions map[string]string) (*authv3.CheckResponse, int) {
+	// Map request to model.
+	r := mapHTTPRequest(ctx, httpReq)
+	review, err := httpauthenticate.MapRequest(r, httpReq.GetMethod(), requestURL(httpReq), s.config)
+	if errors.Is(err, httpauthenticate.ErrMissingCredentials) && (s.config.BasicAuthRealm != "" || s.config.MissingCredentialsUnauthorized) {
+		return s.denied(codes.Unauthenticated, http.StatusUnauthorized, "missing credentials"), http.StatusUnauthorized
+	}
+	if err != nil {
+		return s.denied(codes.InvalidArgument, http.StatusBadRequest, "error mapping request: "+err.Error()), http.StatusBadRequest
+	}
+
+	// The client request headers are sent on the checks, so the required scopes are set on the route
+	// context extensions, that only the proxy can set.
+	review.Review.RequiredScopes = strings.Fields(contextExtensions[ContextExtensionScope])
+
+	// Review authentication.
+	resp, err := s.authAppSvc.Authenticate(ctx, *review)
+	if err != nil {
+		s.logger.Errorf("auth app error: %s", err)
+		return &authv3.CheckResponse{
+			Status: &rpcstatus.Status{Code: int32(codes.Internal), Message: "error authenticating"},
+			HttpResponse: &authv3.CheckResponse_ErrorResponse{ErrorResponse: &authv3.DeniedHttpResponse{
+				Status: &typev3.HttpStatus{Code: typev3.StatusCode_InternalServerError},
+				Body:   "error authenticating",
+			}},
+		}, http.StatusInternalServerError
+	}
+
+	if !resp.Authenticated && resp.Reason == auth.ReasonInsufficientScope {
+		return s.denied(codes.PermissionDenied, http.StatusForbidden, "insufficient scope"), http.StatusForbidden
+	}
+
+	if !resp.Authenticated {
+		return s.denied(codes.Unauthenticated, http.StatusUnauthorized, "invalid token"), http.StatusUnauthorized
+	}
+
+	// Overwrite, so the clients can't set their own client ID and scopes.
+	ok := &authv3.OkHttpResponse{
+		Headers: []*corev3.HeaderValueOption{{
+			Header:       &corev3.HeaderValue{Key: s.config.HeaderKeys.ClientID, Value: resp.ClientID},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		}},
+	}
+	if len(resp.Scopes) > 0 {
+		ok.Headers = append(ok.Headers, &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: s.config.HeaderKeys.Scopes, Value: strings.Join(resp.Scopes, " ")},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		})
+	} else {
+		ok.HeadersToRemove = []string{s.config.HeaderKeys.Scopes}
+	}
+
+	return &authv3.CheckResponse{
+		Status:       &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: ok},
+	}, http.StatusOK
+}
+
+func (s server) denied(code codes.Code, httpCode int, body string) *authv3.CheckResponse {
+	var headers []*corev3.HeaderValueOption
+	if httpCode == http.StatusUnauthorized && s.config.BasicAuthRealm != "" {
+		headers = append(headers, &corev3.HeaderValueOption{Header: &corev3.HeaderValue{
+			Key:   "WWW-Authenticate",
+			Value: fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", s.config.BasicAuthRealm),
+		}})
+	}
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(code), Message: body},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+			Status:  &typev3.HttpStatus{Code: typev3.StatusCode(httpCode)},
+			Headers: headers,
+			Body:    body,
+		}},
+	}
+}
+
+// mapHTTPRequest returns an HTTP request with the checked request headers, Envoy sends them on
+// the headers map, or on the header map when `encode_raw_headers` is enabled.
+func mapHTTPRequest(ctx context.Context, httpReq *authv3.AttributeContext_HttpRequest) *http.Request {
+	h := http.Header{}
+	for k, v := range httpReq.GetHeaders() {
+		h.Add(k, v)
+	}
+	for _, hv := range httpReq.GetHeaderMap().GetHeaders() {
+		v := hv.GetValue()
+		if len(hv.GetRawValue()) > 0 {
+			v = string(hv.GetRawValue())
+		}
+		h.Add(hv.GetKey(), v)
+	}
+
+	r := &http.Request{Method: httpReq.GetMethod(), URL: &url.URL{Path: "/"}, Header: h}
+
+	return r.WithContext(ctx)
+}
+
+// requestURL returns the full URL of the checked request, the path includes the query.
+func requestURL(httpReq *authv3.AttributeContext_HttpRequest) string {
+	scheme := httpReq.GetScheme()
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	return scheme + "://" + httpReq.GetHost() + httpReq.GetPath()
+}
+
+// reporter is the go-http-metrics reporter of the checks.
+type reporter struct {
+	ctx        context.Context
+	method     string
+	statusCode int
+}
+
+func (r *reporter) Method() string           { return r.method }
+func (r *reporter) Context() context.Context { return r.ctx }
+func (r *reporter) URLPath() string          { return checkMethod }
+func (r *reporter) StatusCode() int          { return r.statusCode }
+func (r *reporter) BytesWritten() int64      { return 0 }
@@ -0,0 +1,186 @@
+package extauthz_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+
+	appauth "github.com/slok/simple-ingress-external-auth/internal/app/auth"
+	"github.com/slok/simple-ingress-external-auth/internal/grpc/extauthz"
+	httpauthenticate "github.com/slok/simple-ingress-external-auth/internal/http/authenticate"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+)
+
+var tokens = `
+{
+	"version": "v1",
+	"tokens": [
+		{"value": "token0", "client_id": "client0"},
+		{"value": "token1", "client_id": "client1", "allowed_url": "https://app.slok.dev/api/.*", "allowed_method": "GET"},
+		{"value": "token2", "client_id": "client2", "scopes": ["s0", "s1"]}
+	]
+}`
+
+func newCheckRequest(method, scheme, host, path string, headers map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{Request: &authv3.AttributeContext_Request{
+		Http: &authv3.AttributeContext_HttpRequest{
+			Method:  method,
+			Scheme:  scheme,
+			Host:    host,
+			Path:    path,
+			Headers: headers,
+		},
+	}}}
+}
+
+func withContextExtensions(req *authv3.CheckRequest, extensions map[string]string) *authv3.CheckRequest {
+	req.Attributes.ContextExtensions = extensions
+	return req
+}
+
+func okResponse(clientID string, scopes ...string) *authv3.CheckResponse {
+	ok := &authv3.OkHttpResponse{
+		Headers: []*corev3.HeaderValueOption{{
+			Header:       &corev3.HeaderValue{Key: "X-Ext-Auth-Client-Id", Value: clientID},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		}},
+	}
+	if len(scopes) > 0 {
+		ok.Headers = append(ok.Headers, &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: "X-Ext-Auth-Scopes", Value: strings.Join(scopes, " ")},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		})
+	} else {
+		ok.HeadersToRemove = []string{"X-Ext-Auth-Scopes"}
+	}
+
+	return &authv3.CheckResponse{
+		Status:       &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: ok},
+	}
+}
+
+func deniedResponse(code codes.Code, httpCode typev3.StatusCode, body string, headers ...*corev3.HeaderValueOption) *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(code), Message: body},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+			Status:  &typev3.HttpStatus{Code: httpCode},
+			Headers: headers,
+			Body:    body,
+		}},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := map[string]struct {
+		config  httpauthenticate.Config
+		request *authv3.CheckRequest
+		expResp *authv3.CheckResponse
+	}{
+		"A valid token should be allowed with the client ID header.": {
+			request: newCheckRequest("GET", "https", "app.slok.dev", "/", map[string]string{"authorization": "Bearer token0"}),
+			expResp: okResponse("client0"),
+		},
+
+		"A valid token on the raw header map should be allowed.": {
+			request: &authv3.CheckRequest{Attributes: &authv3.AttributeContext{Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Method:    "GET",
+					HeaderMap: &corev3.HeaderMap{Headers: []*corev3.HeaderValue{{Key: "authorization", RawValue: []byte("Bearer token0")}}},
+				},
+			}}},
+			expResp: okResponse("client0"),
+		},
+
+		"A valid token with scopes should be allowed with the scopes header.": {
+			request: newCheckRequest("GET", "https", "app.slok.dev", "/", map[string]string{"authorization": "Bearer token2"}),
+			expResp: okResponse("client2", "s0", "s1"),
+		},
+
+		"A token with the context extension required scopes should be allowed.": {
+			request: withContextExtensions(newCheckRequest("GET", "https", "app.slok.dev", "/", map[string]string{"authorization": "Bearer token2"}), map[string]string{"scope": "s0 s1"}),
+			expResp: okResponse("client2", "s0", "s1"),
+		},
+
+		"A token without the context extension required scopes should be forbidden.": {
+			request: withContextExtensions(newCheckRequest("GET", "https", "app.slok.dev", "/", map[string]string{"authorization": "Bearer token2"}), map[string]string{"scope": "s0 s2"}),
+			expResp: deniedResponse(codes.PermissionDenied, typev3.StatusCode_Forbidden, "insufficient scope"),
+		},
+
+		"The required scopes headers and query should be ignored.": {
+			request: newCheckRequest("GET", "https", "app.slok.dev", "/?scope=s0", map[string]string{"authorization": "Bearer token0", "x-ext-auth-required-scopes": "s0"}),
+			expResp: okResponse("client0"),
+		},
+
+		"A token should be validated with the check request method and full URL.": {
+			request: newCheckRequest("GET", "https", "app.slok.dev", "/api/users?page=1", map[string]string{"authorization": "Bearer token1"}),
+			expResp: okResponse("client1"),
+		},
+
+		"A token used on a not allowed URL should be denied.": {
+			request: newCheckRequest("GET", "https", "app.slok.dev", "/admin", map[string]string{"authorization": "Bearer token1"}),
+			expResp: deniedResponse(codes.Unauthenticated, typev3.StatusCode_Unauthorized, "invalid token"),
+		},
+
+		"A token used with a not allowed method should be denied.": {
+			request: newCheckRequest("POST", "https", "app.slok.dev", "/api/users", map[string]string{"authorization": "Bearer token1"}),
+			expResp: deniedResponse(codes.Unauthenticated, typev3.StatusCode_Unauthorized, "invalid token"),
+		},
+
+		"A missing token should be denied.": {
+			request: newCheckRequest("GET", "https", "app.slok.dev", "/", map[string]string{"authorization": "Bearer token9"}),
+			expResp: deniedResponse(codes.Unauthenticated, typev3.StatusCode_Unauthorized, "invalid token"),
+		},
+
+		"A request without credentials should be a bad request.": {
+			request: newCheckRequest("GET", "https", "app.slok.dev", "/", nil),
+			expResp: deniedResponse(codes.InvalidArgument, typev3.StatusCode_BadRequest, "error mapping request: missing token"),
+		},
+
+		"A request without credentials and a basic auth realm should be denied with a challenge.": {
+			config:  httpauthenticate.Config{BasicAuthRealm: "test"},
+			request: newCheckRequest("GET", "https", "app.slok.dev", "/", nil),
+			expResp: deniedResponse(codes.Unauthenticated, typev3.StatusCode_Unauthorized, "missing credentials", &corev3.HeaderValueOption{
+				Header: &corev3.HeaderValue{Key: "WWW-Authenticate", Value: `Basic realm="test", charset="UTF-8"`},
+			}),
+		},
+
+		"A token from a custom token source should be allowed.": {
+			config: httpauthenticate.Config{TokenSources: []httpauthenticate.TokenSource{
+				{Kind: httpauthenticate.TokenSourceQuery, Name: "api_key"},
+			}},
+			request: newCheckRequest("GET", "https", "app.slok.dev", "/?api_key=token0", nil),
+			expResp: okResponse("client0"),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Create dependencies.
+			repo, err := memory.NewTokenRepository(log.Noop, tokens)
+			require.NoError(err)
+			svc, err := appauth.NewService(appauth.ServiceConfig{TokenGetter: repo})
+			require.NoError(err)
+
+			server := extauthz.New(log.Noop, metrics.Noop, svc, test.config)
+			gotResp, err := server.Check(context.TODO(), test.request)
+			require.NoError(err)
+
+			assert.True(proto.Equal(test.expResp, gotResp), "got: %v", gotResp)
+		})
+	}
+}
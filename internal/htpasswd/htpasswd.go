@@ -0,0 +1,170 @@
+package htpasswd
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+)
+
+// Parse parses htpasswd data (`<username>:<password hash>` lines) returning the password hashes
+// by username. Empty lines and comments (`#`) are ignored.
+func Parse(data string) (map[string]string, error) {
+	users := map[string]string{}
+	s := bufio.NewScanner(strings.NewReader(data))
+	line := 0
+	for s.Scan() {
+		line++
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		username, hash, ok := strings.Cut(l, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("line %d: invalid format, expected <username>:<password hash>", line)
+		}
+
+		err := CheckHash(hash)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: user %q: %w", line, username, err)
+		}
+
+		if _, ok := users[username]; ok {
+			return nil, fmt.Errorf("line %d: user %q has been declared multiple times", line, username)
+		}
+		users[username] = hash
+	}
+
+	err := s.Err()
+	if err != nil {
+		return nil, fmt.Errorf("could not read htpasswd: %w", err)
+	}
+
+	return users, nil
+}
+
+// CheckHash checks that the password hash format is supported:
+//   - bcrypt: `$2y$...` (`htpasswd -B`), also `$2a$` and `$2b$`.
+//   - SHA1: `{SHA}<base64 encoded sha1>` (`htpasswd -s`).
+func CheckHash(hash string) error {
+	switch {
+	case isBcrypt(hash):
+		_, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return fmt.Errorf("invalid bcrypt hash: %w", err)
+		}
+	case strings.HasPrefix(hash, "{SHA}"):
+		d, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(hash, "{SHA}"))
+		if err != nil || len(d) != sha1.Size {
+			return fmt.Errorf("invalid SHA hash")
+		}
+	default:
+		return fmt.Errorf("unsupported password hash, only bcrypt and SHA are supported")
+	}
+
+	return nil
+}
+
+// Verify returns true if the password matches the password hash.
+func Verify(hash, password string) (bool, error) {
+	switch {
+	case isBcrypt(hash):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("invalid bcrypt hash: %w", err)
+		}
+		return true, nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		// SHA1 is weak, but it is required by the htpasswd {SHA} format.
+		d := sha1.Sum([]byte(password))
+		got := "{SHA}" + base64.StdEncoding.EncodeToString(d[:])
+		return subtle.ConstantTimeCompare([]byte(got), []byte(hash)) == 1, nil
+	default:
+		return false, fmt.Errorf("unsupported password hash, only bcrypt and SHA are supported")
+	}
+}
+
+// dummyHash is the bcrypt hash (default cost) compared when the user is unknown.
+const dummyHash = "$2a$10$1T7.SKontcQNNIcqw2Rk3etA0hmEVwjfyT0p6eFdFonsgLZl9PQmq"
+
+// VerifyDummy compares the password against a dummy bcrypt hash, this way the unknown users take
+// the same time as the known ones and they can't be told apart by the response time.
+func VerifyDummy(password string) {
+	_ = bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(password))
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$")
+}
+
+// UserRepository is a user getter that gets the users from htpasswd data.
+type UserRepository struct {
+	users atomic.Pointer[map[string]string]
+}
+
+// NewUserRepository returns a new UserRepository from htpasswd data.
+func NewUserRepository(data string) (*UserRepository, error) {
+	users, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &UserRepository{}
+	u.users.Store(&users)
+
+	return u, nil
+}
+
+// ReloadConfigs will load and merge multiple htpasswd data, identified by their source name (e.g the
+// file path), and replace the current users atomically. If the new data is invalid, the current
+// users will be kept.
+func (u *UserRepository) ReloadConfigs(configs map[string]string) error {
+	sources := make([]string, 0, len(configs))
+	for source := range configs {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	users := map[string]string{}
+	for _, source := range sources {
+		us, err := Parse(configs[source])
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+
+		for username, hash := range us {
+			if _, ok := users[username]; ok {
+				return fmt.Errorf("%s: user %q has been declared multiple times", source, username)
+			}
+			users[username] = hash
+		}
+	}
+
+	u.users.Store(&users)
+
+	return nil
+}
+
+func (u *UserRepository) GetUserValidation(ctx context.Context, username string) (*model.UserValidation, error) {
+	hash, ok := (*u.users.Load())[username]
+	if !ok {
+		return nil, fmt.Errorf("user not found: %w", internalerrors.ErrNotFound)
+	}
+
+	return &model.UserValidation{Username: username, PasswordHash: hash}, nil
+}
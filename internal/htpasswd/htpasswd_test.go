@@ -0,0 +1,182 @@
+package htpasswd_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/simple-ingress-external-auth/internal/htpasswd"
+)
+
+const (
+	bcryptSecret = "$2a$04$xxGQ8SPyvwCFzVRYhLVELO5PucK6vuJlmKDmdwF8kuXOREuQSv4HC"
+	shaSecret    = "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="
+)
+
+func TestVerify(t *testing.T) {
+	tests := map[string]struct {
+		hash      string
+		password  string
+		expErr    bool
+		expVerify bool
+	}{
+		"A bcrypt hash should verify the correct password.": {
+			hash:      bcryptSecret,
+			password:  "secret",
+			expVerify: true,
+		},
+
+		"A bcrypt hash should not verify an incorrect password.": {
+			hash:      bcryptSecret,
+			password:  "secret2",
+			expVerify: false,
+		},
+
+		"A SHA hash should verify the correct password.": {
+			hash:      shaSecret,
+			password:  "secret",
+			expVerify: true,
+		},
+
+		"A SHA hash should not verify an incorrect password.": {
+			hash:      shaSecret,
+			password:  "secret2",
+			expVerify: false,
+		},
+
+		"An unsupported hash should fail.": {
+			hash:     "$apr1$salt$hash",
+			password: "secret",
+			expErr:   true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			gotVerify, err := htpasswd.Verify(test.hash, test.password)
+			if test.expErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+
+			assert.Equal(test.expVerify, gotVerify)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		data     string
+		expUsers map[string]string
+		expErr   bool
+	}{
+		"Users should be parsed ignoring empty lines and comments.": {
+			data: `
+# Users.
+user1:` + bcryptSecret + `
+
+user2:` + shaSecret + `
+`,
+			expUsers: map[string]string{
+				"user1": bcryptSecret,
+				"user2": shaSecret,
+			},
+		},
+
+		"An invalid line should fail.": {
+			data:   "user1",
+			expErr: true,
+		},
+
+		"An unsupported hash should fail.": {
+			data:   "user1:plain",
+			expErr: true,
+		},
+
+		"An invalid SHA hash should fail.": {
+			data:   "user1:{SHA}abc",
+			expErr: true,
+		},
+
+		"A duplicated user should fail.": {
+			data:   "user1:" + shaSecret + "\nuser1:" + bcryptSecret,
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			gotUsers, err := htpasswd.Parse(test.data)
+			if test.expErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+
+			assert.Equal(test.expUsers, gotUsers)
+		})
+	}
+}
+
+func TestUserRepositoryReloadConfigs(t *testing.T) {
+	tests := map[string]struct {
+		data       string
+		newConfigs map[string]string
+		expErr     bool
+		expUsers   map[string]bool
+	}{
+		"A valid htpasswd should replace the previous users.": {
+			data:       "user1:" + shaSecret,
+			newConfigs: map[string]string{"a.htpasswd": "user2:" + shaSecret},
+			expUsers:   map[string]bool{"user1": false, "user2": true},
+		},
+
+		"An invalid htpasswd should fail and keep the previous users.": {
+			data:       "user1:" + shaSecret,
+			newConfigs: map[string]string{"a.htpasswd": "user2:plain"},
+			expErr:     true,
+			expUsers:   map[string]bool{"user1": true, "user2": false},
+		},
+
+		"A user declared on multiple htpasswd should fail and keep the previous users.": {
+			data: "user1:" + shaSecret,
+			newConfigs: map[string]string{
+				"a.htpasswd": "user2:" + shaSecret,
+				"b.htpasswd": "user2:" + bcryptSecret,
+			},
+			expErr:   true,
+			expUsers: map[string]bool{"user1": true, "user2": false},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			repo, err := htpasswd.NewUserRepository(test.data)
+			require.NoError(err)
+
+			err = repo.ReloadConfigs(test.newConfigs)
+			if test.expErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+
+			for username, exp := range test.expUsers {
+				_, err := repo.GetUserValidation(context.TODO(), username)
+				assert.Equal(exp, err == nil, username)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package authenticate
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -9,15 +10,29 @@ import (
 	httpmetricsstd "github.com/slok/go-http-metrics/middleware/std"
 
 	"github.com/slok/simple-ingress-external-auth/internal/app/auth"
+	"github.com/slok/simple-ingress-external-auth/internal/httpsig"
 	"github.com/slok/simple-ingress-external-auth/internal/log"
 	"github.com/slok/simple-ingress-external-auth/internal/metrics"
 	"github.com/slok/simple-ingress-external-auth/internal/model"
 )
 
 type HeaderKeys struct {
-	ClientID       string
+	ClientID string
+	// Scopes is the response header with the authenticated token scopes (space separated).
+	Scopes string
+	// RequiredScopes is the header where the proxy sets the required scopes (space separated) on the
+	// forwarded requests, as their query is the original request one. The proxy must always set it,
+	// otherwise clients could send their own required scopes.
+	RequiredScopes string
 	OriginalURL    string
 	OriginalMethod string
+	// OriginalProto and OriginalHost are used to rebuild the full original URL when the proxy only
+	// sends the path (e.g Traefik `X-Forwarded-Uri`). `Host` is the request host.
+	OriginalProto string
+	OriginalHost  string
+	// ForwardedRequest is set when the proxy forwards the original request under the authentication
+	// path (e.g Envoy HTTP ext_authz), the original method and path are the request ones.
+	ForwardedRequest bool
 }
 
 func (h *HeaderKeys) defaults() {
@@ -25,6 +40,14 @@ func (h *HeaderKeys) defaults() {
 		h.ClientID = "X-Ext-Auth-Client-Id"
 	}
 
+	if h.Scopes == "" {
+		h.Scopes = "X-Ext-Auth-Scopes"
+	}
+
+	if h.RequiredScopes == "" {
+		h.RequiredScopes = "X-Ext-Auth-Required-Scopes"
+	}
+
 	if h.OriginalMethod == "" {
 		h.OriginalMethod = "X-Original-Method"
 	}
@@ -34,13 +57,68 @@ func (h *HeaderKeys) defaults() {
 	}
 }
 
+// Config is the authentication handler configuration.
+type Config struct {
+	HeaderKeys HeaderKeys
+	// AuthenticationPath is the path where the handler is served, removed from the forwarded requests
+	// path and used as the metrics handler.
+	AuthenticationPath string
+	// Tenant is the tenant served by the handler, used as the metrics service.
+	Tenant string
+	// BasicAuthRealm is the realm of the Basic auth challenge (`WWW-Authenticate`) returned on
+	// the unauthenticated requests, so browsers prompt for the credentials. If empty, there
+	// is no challenge.
+	BasicAuthRealm string
+	// TokenSources are the places where the token is searched, in order. By default, the
+	// bearer authorization header.
+	TokenSources []TokenSource
+	// ClientCertHeader is the header where the proxy forwards the PEM client certificate (e.g
+	// `ssl-client-cert`), if empty, client certificate auth is disabled. The proxy must always
+	// overwrite it, otherwise clients could send any certificate.
+	ClientCertHeader string
+	// ClientCertVerifyHeader is the header where the proxy sets the client certificate verification
+	// result, if set, only certificates verified (`SUCCESS`) are used.
+	ClientCertVerifyHeader string
+	// HTTPSignatures enables the HTTP message signatures (RFC 9421) auth, the signed requests are
+	// only authenticated by their signature.
+	HTTPSignatures bool
+	// MissingCredentialsUnauthorized returns 401 instead of 400 on the requests without credentials,
+	// so the proxy can redirect the browsers to the login (e.g nginx `error_page 401`).
+	MissingCredentialsUnauthorized bool
+}
+
+func (c *Config) defaults() {
+	c.HeaderKeys.defaults()
+
+	if len(c.TokenSources) == 0 {
+		c.TokenSources = []TokenSource{{Kind: TokenSourceBearer}}
+	}
+}
+
 // New returns an HTTP handler that knows how to authenticate external requests.
-func New(logger log.Logger, metricRec metrics.Recorder, authAppSvc auth.Service, headerKeys HeaderKeys) http.Handler {
-	headerKeys.defaults()
+func New(logger log.Logger, metricRec metrics.Recorder, authAppSvc auth.Service, config Config) http.Handler {
+	config.defaults()
+	basicAuthRealm := config.BasicAuthRealm
+
+	challenge := func(w http.ResponseWriter) {
+		if basicAuthRealm != "" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", basicAuthRealm))
+		}
+	}
 
 	authHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Map request to model.
-		review, err := mapRequestToModel(r, headerKeys)
+		method, url := OriginalRequest(r, config.HeaderKeys, config.AuthenticationPath)
+		review, err := MapRequest(r, method, url, config)
+		if errors.Is(err, ErrMissingCredentials) && (basicAuthRealm != "" || config.MissingCredentialsUnauthorized) {
+			challenge(w)
+			w.WriteHeader(http.StatusUnauthorized)
+			_, err := w.Write([]byte("missing credentials"))
+			if err != nil {
+				logger.Warningf("Error writing response body: %s", err)
+			}
+			return
+		}
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			_, err := w.Write([]byte("error mapping request: " + err.Error()))
@@ -50,6 +128,8 @@ func New(logger log.Logger, metricRec metrics.Recorder, authAppSvc auth.Service,
 			return
 		}
 
+		review.Review.RequiredScopes = requiredScopes(r, config.HeaderKeys)
+
 		// Review authentication.
 		resp, err := authAppSvc.Authenticate(r.Context(), *review)
 		if err != nil {
@@ -62,7 +142,17 @@ func New(logger log.Logger, metricRec metrics.Recorder, authAppSvc auth.Service,
 			return
 		}
 
+		if !resp.Authenticated && resp.Reason == auth.ReasonInsufficientScope {
+			w.WriteHeader(http.StatusForbidden)
+			_, err := w.Write([]byte("insufficient scope"))
+			if err != nil {
+				logger.Warningf("Error writing response body: %s", err)
+			}
+			return
+		}
+
 		if !resp.Authenticated {
+			challenge(w)
 			w.WriteHeader(http.StatusUnauthorized)
 			_, err := w.Write([]byte("invalid token"))
 			if err != nil {
@@ -71,40 +161,109 @@ func New(logger log.Logger, metricRec metrics.Recorder, authAppSvc auth.Service,
 			return
 		}
 
-		w.Header().Set(headerKeys.ClientID, resp.ClientID)
+		w.Header().Set(config.HeaderKeys.ClientID, resp.ClientID)
+		if len(resp.Scopes) > 0 {
+			w.Header().Set(config.HeaderKeys.Scopes, strings.Join(resp.Scopes, " "))
+		}
 		w.WriteHeader(http.StatusOK)
 	})
 
 	// Measure handler.
-	metricsMiddleware := httpmetrics.New(httpmetrics.Config{Recorder: metricRec})
-	h := httpmetricsstd.Handler("", metricsMiddleware, authHandler)
+	metricsMiddleware := httpmetrics.New(httpmetrics.Config{Recorder: metricRec, Service: config.Tenant})
+	h := httpmetricsstd.Handler(config.AuthenticationPath, metricsMiddleware, authHandler)
 
 	return h
 }
 
-func mapRequestToModel(r *http.Request, hk HeaderKeys) (*auth.AuthenticateRequest, error) {
-	// Headers.
-	const (
-		authorization       = "Authorization"
-		authorizationBearer = "Bearer"
-	)
+// ScopeQueryParam is the authentication request query parameter with the required scopes (repeatable
+// or space separated), e.g `/auth?scope=billing:write`.
+const ScopeQueryParam = "scope"
 
-	// Get token.
-	token := r.Header.Get(authorization)
-	token = strings.Replace(token, authorizationBearer, "", 1)
-	token = strings.TrimSpace(token)
+// requiredScopes returns the required scopes set by the proxy on the authentication URL, on the
+// forwarded requests the query is the original request one, so they are set on a header.
+func requiredScopes(r *http.Request, keys HeaderKeys) []string {
+	values := r.URL.Query()[ScopeQueryParam]
+	if keys.ForwardedRequest {
+		values = r.Header.Values(keys.RequiredScopes)
+	}
 
-	if token == "" {
-		return nil, fmt.Errorf("missing token")
+	var scopes []string
+	for _, v := range values {
+		scopes = append(scopes, strings.Fields(v)...)
 	}
 
-	// Get other properties.
-	method := r.Header.Get(hk.OriginalMethod)
-	url := r.Header.Get(hk.OriginalURL)
+	return scopes
+}
+
+// ErrMissingCredentials is returned when the request doesn't have credentials.
+var ErrMissingCredentials = errors.New("missing token")
 
-	return &auth.AuthenticateRequest{Review: model.TokenReview{
-		Token:      token,
+// MapRequest maps the request credentials to an authentication request of the original method and URL,
+// so other transports (e.g Envoy ext_authz) can authenticate the same way as the HTTP handler.
+func MapRequest(r *http.Request, method, url string, config Config) (*auth.AuthenticateRequest, error) {
+	config.defaults()
+
+	review := model.TokenReview{
 		HTTPURL:    url,
 		HTTPMethod: method,
-	}}, nil
+	}
+
+	// HTTP message signature.
+	if config.HTTPSignatures && httpsig.HasSignature(r.Header) {
+		sig, err := mapSignatureToModel(r, method, url)
+		if err != nil {
+			return nil, err
+		}
+		review.Signature = sig
+
+		return &auth.AuthenticateRequest{Review: review}, nil
+	}
+
+	// Client certificate, it can be used alone or with the other credentials.
+	cert, err := extractClientCert(r, config.ClientCertHeader, config.ClientCertVerifyHeader)
+	if err != nil {
+		return nil, err
+	}
+	review.ClientCert = cert
+
+	// Basic auth.
+	if username, password, ok := r.BasicAuth(); ok {
+		if username == "" && cert == nil {
+			return nil, ErrMissingCredentials
+		}
+		review.Username = username
+		review.Password = password
+
+		return &auth.AuthenticateRequest{Review: review}, nil
+	}
+
+	// Get token.
+	review.Token = extractToken(r, url, config.TokenSources)
+	if review.Token == "" && cert == nil {
+		return nil, ErrMissingCredentials
+	}
+
+	return &auth.AuthenticateRequest{Review: review}, nil
+}
+
+func mapSignatureToModel(r *http.Request, method, url string) (*model.SignatureReview, error) {
+	if url == "" {
+		return nil, fmt.Errorf("signed requests require the original URL")
+	}
+
+	sig, err := httpsig.Parse(httpsig.Request{Method: method, URL: url, Header: r.Header})
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP message signature: %w", err)
+	}
+
+	return &model.SignatureReview{
+		KeyID:      sig.KeyID,
+		Algorithm:  sig.Algorithm,
+		Created:    sig.Created,
+		Expires:    sig.Expires,
+		Nonce:      sig.Nonce,
+		Components: sig.Components,
+		Base:       sig.Base,
+		Value:      sig.Value,
+	}, nil
 }
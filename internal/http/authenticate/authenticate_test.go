@@ -1,9 +1,22 @@
 package authenticate_test
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -20,14 +33,64 @@ var tokens = `
 	"version": "v1",
 	"tokens": [
 		{"value": "token0", "client_id": "foo"},
-		{"value": "token1", "disable": true}
+		{"value": "token1", "disable": true},
+		{"value": "token2", "client_id": "bar", "scopes": ["billing:read", "billing:write"]}
+	],
+	"users": [
+		{"username": "user0", "password_hash": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="}
+	],
+	"client_certs": [
+		{"client_id": "svc0", "san": "svc0.slok.dev"}
+	],
+	"signature_keys": [
+		{"key_id": "partner0-key", "client_id": "partner0", "secret": "partner0-secret"}
 	]
 }
 `
 
+// newTestSignatureHeaders returns the headers of a request signed with an HMAC HTTP message signature.
+func newTestSignatureHeaders(keyID, secret, method, url string) map[string]string {
+	params := fmt.Sprintf(`("@method" "@target-uri");created=%d;keyid=%q`, time.Now().Unix(), keyID)
+	base := fmt.Sprintf("\"@method\": %s\n\"@target-uri\": %s\n\"@signature-params\": %s", method, url, params)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(base))
+
+	return map[string]string{
+		"X-Original-Method": method,
+		"X-Original-URL":    url,
+		"Signature-Input":   "sig1=" + params,
+		"Signature":         "sig1=:" + base64.StdEncoding.EncodeToString(mac.Sum(nil)) + ":",
+	}
+}
+
+// newTestClientCert returns a self-signed client certificate URL escaped PEM with the SAN.
+func newTestClientCert(t *testing.T, san string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: san},
+		DNSNames:     []string{san},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return url.PathEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+}
+
 func TestIntegrationAuthenticate(t *testing.T) {
+	svc0Cert := newTestClientCert(t, "svc0.slok.dev")
+	unknownCert := newTestClientCert(t, "unknown.slok.dev")
+	certConfig := httpauthenticate.Config{ClientCertHeader: "Ssl-Client-Cert"}
+
 	tests := map[string]struct {
 		tokens      string
+		config      httpauthenticate.Config
+		query       string
+		basicAuth   []string
 		httpHeaders map[string]string
 		expCode     int
 		expHeaders  map[string]string
@@ -55,6 +118,383 @@ func TestIntegrationAuthenticate(t *testing.T) {
 			expCode:    http.StatusOK,
 			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "foo"},
 		},
+
+		"A request with valid Basic auth credentials, should return 200": {
+			tokens:     tokens,
+			basicAuth:  []string{"user0", "secret"},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "user0"},
+		},
+
+		"A request with invalid Basic auth credentials, should return 401": {
+			tokens:     tokens,
+			basicAuth:  []string{"user0", "wrong"},
+			expCode:    http.StatusUnauthorized,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "", "WWW-Authenticate": ""},
+		},
+
+		"A request with invalid Basic auth credentials and a realm, should return 401 with a challenge": {
+			tokens:     tokens,
+			config:     httpauthenticate.Config{BasicAuthRealm: "test"},
+			basicAuth:  []string{"user0", "wrong"},
+			expCode:    http.StatusUnauthorized,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "", "WWW-Authenticate": `Basic realm="test", charset="UTF-8"`},
+		},
+
+		"A request with a lowercase bearer scheme, should return 200": {
+			tokens: tokens,
+			httpHeaders: map[string]string{
+				"Authorization": "bearer token0",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "foo"},
+		},
+
+		"A request with a token that contains the bearer word, should not be mangled": {
+			tokens: `{"version": "v1", "tokens": [{"value": "myBearertoken", "client_id": "foo"}]}`,
+			httpHeaders: map[string]string{
+				"Authorization": "Bearer myBearertoken",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "foo"},
+		},
+
+		"A request with a bare token authorization without scheme, should return 200": {
+			tokens: tokens,
+			httpHeaders: map[string]string{
+				"Authorization": "token0",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "foo"},
+		},
+
+		"A request with a bearer authorization without token, should return 400": {
+			tokens: tokens,
+			httpHeaders: map[string]string{
+				"Authorization": "Bearer",
+			},
+			expCode:    http.StatusBadRequest,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
+
+		"A request with a token outside the b64token syntax, should return 200": {
+			tokens: `{"version": "v1", "tokens": [{"value": "t0k3n:with@special!chars#%", "client_id": "foo"}]}`,
+			httpHeaders: map[string]string{
+				"Authorization": "Bearer t0k3n:with@special!chars#%",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "foo"},
+		},
+
+		"A request with a bare token outside the b64token syntax, should return 200": {
+			tokens: `{"version": "v1", "tokens": [{"value": "t0k3n:with@special!chars#%", "client_id": "foo"}]}`,
+			httpHeaders: map[string]string{
+				"Authorization": "t0k3n:with@special!chars#%",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "foo"},
+		},
+
+		"A request with other authorization scheme, should return 400": {
+			tokens: tokens,
+			httpHeaders: map[string]string{
+				"Authorization": "Token token0",
+			},
+			expCode:    http.StatusBadRequest,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
+
+		"A request with an invalid bearer authorization, should return 400": {
+			tokens: tokens,
+			httpHeaders: map[string]string{
+				"Authorization": "Bearer token0 token1",
+			},
+			expCode:    http.StatusBadRequest,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
+
+		"A request with the token on a custom header, should return 200": {
+			tokens: tokens,
+			config: httpauthenticate.Config{TokenSources: []httpauthenticate.TokenSource{
+				{Kind: "bearer"},
+				{Kind: "header", Name: "X-Api-Key"},
+			}},
+			httpHeaders: map[string]string{
+				"X-Api-Key": "token0",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "foo"},
+		},
+
+		"A request with the token on the original URL query, should return 200": {
+			tokens: tokens,
+			config: httpauthenticate.Config{TokenSources: []httpauthenticate.TokenSource{
+				{Kind: "query", Name: "api_key"},
+			}},
+			httpHeaders: map[string]string{
+				"X-Original-URL": "https://slok.dev/webhook?api_key=token0",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "foo"},
+		},
+
+		"A request with the token on a cookie, should return 200": {
+			tokens: tokens,
+			config: httpauthenticate.Config{TokenSources: []httpauthenticate.TokenSource{
+				{Kind: "cookie", Name: "token"},
+			}},
+			httpHeaders: map[string]string{
+				"Cookie": "other=x; token=token0",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "foo"},
+		},
+
+		"A request should use the first token source that has a token": {
+			tokens: tokens,
+			config: httpauthenticate.Config{TokenSources: []httpauthenticate.TokenSource{
+				{Kind: "header", Name: "X-Api-Key"},
+				{Kind: "bearer"},
+			}},
+			httpHeaders: map[string]string{
+				"X-Api-Key":     "token1",
+				"Authorization": "Bearer token0",
+			},
+			expCode:    http.StatusUnauthorized,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
+
+		"A request with the token on a source that is not enabled, should return 400": {
+			tokens: tokens,
+			httpHeaders: map[string]string{
+				"X-Api-Key": "token0",
+			},
+			expCode:    http.StatusBadRequest,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
+
+		"A request without credentials and a realm, should return 401 with a challenge": {
+			tokens:     tokens,
+			config:     httpauthenticate.Config{BasicAuthRealm: "test"},
+			expCode:    http.StatusUnauthorized,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "", "WWW-Authenticate": `Basic realm="test", charset="UTF-8"`},
+		},
+
+		"A request without credentials and missing credentials unauthorized, should return 401 without a challenge": {
+			tokens:     tokens,
+			config:     httpauthenticate.Config{MissingCredentialsUnauthorized: true},
+			expCode:    http.StatusUnauthorized,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "", "WWW-Authenticate": ""},
+		},
+
+		"A request with a known client certificate, should return 200": {
+			tokens: tokens,
+			config: certConfig,
+			httpHeaders: map[string]string{
+				"Ssl-Client-Cert": svc0Cert,
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "svc0"},
+		},
+
+		"A request with an unknown client certificate, should return 401": {
+			tokens: tokens,
+			config: certConfig,
+			httpHeaders: map[string]string{
+				"Ssl-Client-Cert": unknownCert,
+			},
+			expCode:    http.StatusUnauthorized,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
+
+		"A request with an unknown client certificate and a valid token, should return 200": {
+			tokens: tokens,
+			config: certConfig,
+			httpHeaders: map[string]string{
+				"Ssl-Client-Cert": unknownCert,
+				"Authorization":   "Bearer token0",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "foo"},
+		},
+
+		"A request with an invalid client certificate, should return 400": {
+			tokens: tokens,
+			config: certConfig,
+			httpHeaders: map[string]string{
+				"Ssl-Client-Cert": "wrong",
+			},
+			expCode:    http.StatusBadRequest,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
+
+		"A request with a client certificate without client cert auth enabled, should return 400": {
+			tokens: tokens,
+			httpHeaders: map[string]string{
+				"Ssl-Client-Cert": svc0Cert,
+			},
+			expCode:    http.StatusBadRequest,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
+
+		"A request with a token without scopes, should not return the scopes header": {
+			tokens: tokens,
+			httpHeaders: map[string]string{
+				"Authorization": "Bearer token0",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "foo", "X-Ext-Auth-Scopes": ""},
+		},
+
+		"A request with a verified client certificate, should return 200": {
+			tokens: tokens,
+			config: httpauthenticate.Config{ClientCertHeader: "Ssl-Client-Cert", ClientCertVerifyHeader: "Ssl-Client-Verify"},
+			httpHeaders: map[string]string{
+				"Ssl-Client-Cert":   svc0Cert,
+				"Ssl-Client-Verify": "SUCCESS",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "svc0"},
+		},
+
+		"A request with a valid HTTP message signature, should return 200": {
+			tokens:      tokens,
+			config:      httpauthenticate.Config{HTTPSignatures: true},
+			httpHeaders: newTestSignatureHeaders("partner0-key", "partner0-secret", "POST", "https://webhooks.slok.dev/partner0"),
+			expCode:     http.StatusOK,
+			expHeaders:  map[string]string{"X-Ext-Auth-Client-Id": "partner0"},
+		},
+
+		"A request with an invalid HTTP message signature, should return 401": {
+			tokens:      tokens,
+			config:      httpauthenticate.Config{HTTPSignatures: true},
+			httpHeaders: newTestSignatureHeaders("partner0-key", "wrong", "POST", "https://webhooks.slok.dev/partner0"),
+			expCode:     http.StatusUnauthorized,
+			expHeaders:  map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
+
+		"A request with a malformed HTTP message signature, should return 400": {
+			tokens: tokens,
+			config: httpauthenticate.Config{HTTPSignatures: true},
+			httpHeaders: map[string]string{
+				"X-Original-URL":  "https://webhooks.slok.dev/partner0",
+				"Signature-Input": `sig1=("@method"`,
+				"Signature":       "sig1=:dGVzdA==:",
+			},
+			expCode:    http.StatusBadRequest,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
+
+		"A request with an HTTP message signature without HTTP signatures enabled, should return 400": {
+			tokens:      tokens,
+			httpHeaders: newTestSignatureHeaders("partner0-key", "partner0-secret", "POST", "https://webhooks.slok.dev/partner0"),
+			expCode:     http.StatusBadRequest,
+			expHeaders:  map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
+
+		"A request with a token that has the required scopes, should return 200": {
+			tokens: tokens,
+			query:  "?scope=billing:write",
+			httpHeaders: map[string]string{
+				"Authorization": "Bearer token2",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "bar", "X-Ext-Auth-Scopes": "billing:read billing:write"},
+		},
+
+		"A request with a token that has multiple required scopes, should return 200": {
+			tokens: tokens,
+			query:  "?scope=billing:read%20billing:write&scope=billing:read",
+			httpHeaders: map[string]string{
+				"Authorization": "Bearer token2",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "bar"},
+		},
+
+		"A request with a token that doesn't have all the required scopes, should return 403": {
+			tokens: tokens,
+			query:  "?scope=billing:write&scope=billing:admin",
+			httpHeaders: map[string]string{
+				"Authorization": "Bearer token2",
+			},
+			expCode:    http.StatusForbidden,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
+
+		"A request with a token without scopes and required scopes, should return 403": {
+			tokens: tokens,
+			query:  "?scope=billing:read",
+			httpHeaders: map[string]string{
+				"Authorization": "Bearer token0",
+			},
+			expCode:    http.StatusForbidden,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
+
+		"A request with an invalid token and required scopes, should return 401": {
+			tokens: tokens,
+			query:  "?scope=billing:read",
+			httpHeaders: map[string]string{
+				"Authorization": "Bearer token1",
+			},
+			expCode:    http.StatusUnauthorized,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
+
+		"A forwarded request with a token that has the required scopes header, should return 200": {
+			tokens: tokens,
+			config: httpauthenticate.Config{HeaderKeys: httpauthenticate.HeaderKeys{ForwardedRequest: true}},
+			httpHeaders: map[string]string{
+				"Authorization":              "Bearer token2",
+				"X-Ext-Auth-Required-Scopes": "billing:write",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "bar"},
+		},
+
+		"A forwarded request with a token that doesn't have the required scopes header, should return 403": {
+			tokens: tokens,
+			config: httpauthenticate.Config{HeaderKeys: httpauthenticate.HeaderKeys{ForwardedRequest: true}},
+			httpHeaders: map[string]string{
+				"Authorization":              "Bearer token0",
+				"X-Ext-Auth-Required-Scopes": "billing:read",
+			},
+			expCode:    http.StatusForbidden,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
+
+		"A forwarded request should ignore the original request scope query, should return 200": {
+			tokens: tokens,
+			config: httpauthenticate.Config{HeaderKeys: httpauthenticate.HeaderKeys{ForwardedRequest: true}},
+			query:  "?scope=billing:read",
+			httpHeaders: map[string]string{
+				"Authorization": "Bearer token0",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "foo"},
+		},
+
+		"A not forwarded request should ignore the required scopes header, should return 200": {
+			tokens: tokens,
+			httpHeaders: map[string]string{
+				"Authorization":              "Bearer token0",
+				"X-Ext-Auth-Required-Scopes": "billing:read",
+			},
+			expCode:    http.StatusOK,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": "foo"},
+		},
+
+		"A request with a not verified client certificate, should ignore the certificate": {
+			tokens: tokens,
+			config: httpauthenticate.Config{ClientCertHeader: "Ssl-Client-Cert", ClientCertVerifyHeader: "Ssl-Client-Verify"},
+			httpHeaders: map[string]string{
+				"Ssl-Client-Cert":   svc0Cert,
+				"Ssl-Client-Verify": "FAILED:unable to verify the first certificate",
+			},
+			expCode:    http.StatusBadRequest,
+			expHeaders: map[string]string{"X-Ext-Auth-Client-Id": ""},
+		},
 	}
 
 	for name, test := range tests {
@@ -65,18 +505,27 @@ func TestIntegrationAuthenticate(t *testing.T) {
 			// Create dependencies.
 			repo, err := memory.NewTokenRepository(log.Noop, test.tokens)
 			require.NoError(err)
-			svc := appauth.NewService(log.Noop, metrics.Noop, repo)
+			svc, err := appauth.NewService(appauth.ServiceConfig{
+				TokenGetter:        repo,
+				UserGetter:         repo,
+				CertGetter:         repo,
+				SignatureKeyGetter: repo,
+			})
+			require.NoError(err)
 
 			// Run server.
-			handler := httpauthenticate.New(log.Noop, metrics.Noop, svc, httpauthenticate.HeaderKeys{})
+			handler := httpauthenticate.New(log.Noop, metrics.Noop, svc, test.config)
 			server := httptest.NewServer(handler)
 			defer server.Close()
 
 			// Make request.
-			req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			req, _ := http.NewRequest(http.MethodGet, server.URL+test.query, nil)
 			for k, v := range test.httpHeaders {
 				req.Header.Add(k, v)
 			}
+			if len(test.basicAuth) == 2 {
+				req.SetBasicAuth(test.basicAuth[0], test.basicAuth[1])
+			}
 			resp, err := http.DefaultClient.Do(req)
 			require.NoError(err)
 
@@ -88,3 +537,62 @@ func TestIntegrationAuthenticate(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTokenSource(t *testing.T) {
+	tests := map[string]struct {
+		source    string
+		expSource httpauthenticate.TokenSource
+		expErr    bool
+	}{
+		"A bearer source should be parsed.": {
+			source:    "bearer",
+			expSource: httpauthenticate.TokenSource{Kind: "bearer"},
+		},
+
+		"A header source should be parsed.": {
+			source:    "header:X-Api-Key",
+			expSource: httpauthenticate.TokenSource{Kind: "header", Name: "X-Api-Key"},
+		},
+
+		"A query source should be parsed.": {
+			source:    "query:api_key",
+			expSource: httpauthenticate.TokenSource{Kind: "query", Name: "api_key"},
+		},
+
+		"A cookie source should be parsed.": {
+			source:    "Cookie:token",
+			expSource: httpauthenticate.TokenSource{Kind: "cookie", Name: "token"},
+		},
+
+		"A bearer source with name should fail.": {
+			source: "bearer:x",
+			expErr: true,
+		},
+
+		"A header source without name should fail.": {
+			source: "header",
+			expErr: true,
+		},
+
+		"An unknown source should fail.": {
+			source: "body:token",
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			gotSource, err := httpauthenticate.ParseTokenSource(test.source)
+			if test.expErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+
+			assert.Equal(test.expSource, gotSource)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package authenticate
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	pemCertBegin = "-----BEGIN CERTIFICATE-----"
+	pemCertEnd   = "-----END CERTIFICATE-----"
+)
+
+// clientCertVerifySuccess is the verification result set by ingress-nginx (`ssl-client-verify`)
+// when the client certificate has been verified.
+const clientCertVerifySuccess = "SUCCESS"
+
+// extractClientCert returns the client certificate forwarded by the proxy, nil if there is none or the
+// proxy didn't verify it.
+func extractClientCert(r *http.Request, certHeader, verifyHeader string) (*x509.Certificate, error) {
+	if certHeader == "" {
+		return nil, nil
+	}
+
+	data := r.Header.Get(certHeader)
+	if data == "" {
+		return nil, nil
+	}
+
+	if verifyHeader != "" && r.Header.Get(verifyHeader) != clientCertVerifySuccess {
+		return nil, nil
+	}
+
+	cert, err := parseClientCert(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client certificate: %w", err)
+	}
+
+	return cert, nil
+}
+
+// parseClientCert parses a PEM certificate forwarded on a header, the PEM can be URL escaped (e.g
+// ingress-nginx `$ssl_client_escaped_cert`) or have its line breaks replaced by spaces or tabs.
+func parseClientCert(data string) (*x509.Certificate, error) {
+	// Path unescape doesn't decode `+` as a space, that would break the base64 data.
+	data, err := url.PathUnescape(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not unescape certificate: %w", err)
+	}
+
+	_, body, ok := strings.Cut(data, pemCertBegin)
+	if !ok {
+		return nil, fmt.Errorf("missing PEM certificate")
+	}
+	body, _, ok = strings.Cut(body, pemCertEnd)
+	if !ok {
+		return nil, fmt.Errorf("missing PEM certificate end")
+	}
+
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode PEM certificate: %w", err)
+	}
+
+	return x509.ParseCertificate(der)
+}
@@ -0,0 +1,119 @@
+package authenticate
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Proxy presets.
+const (
+	// ProxyPresetNginx is ingress-nginx, it sends the full original URL.
+	ProxyPresetNginx = "nginx"
+	// ProxyPresetTraefik is Traefik forward auth, it sends only the original path.
+	ProxyPresetTraefik = "traefik"
+	// ProxyPresetEnvoy is Envoy HTTP ext_authz, it forwards the original request with the authentication
+	// path as the path prefix.
+	ProxyPresetEnvoy = "envoy"
+	// ProxyPresetCaddy is Caddy forward_auth, it sends only the original path.
+	ProxyPresetCaddy = "caddy"
+	// ProxyPresetHAProxy is HAProxy auth-request, the original request headers are set on the HAProxy
+	// configuration.
+	ProxyPresetHAProxy = "haproxy"
+)
+
+// ProxyPresets are the supported proxy presets.
+var ProxyPresets = []string{
+	ProxyPresetNginx,
+	ProxyPresetTraefik,
+	ProxyPresetEnvoy,
+	ProxyPresetCaddy,
+	ProxyPresetHAProxy,
+}
+
+// ProxyPresetHeaderKeys returns the header keys of the original request properties that a proxy sends
+// on the authentication requests.
+func ProxyPresetHeaderKeys(preset string) (HeaderKeys, error) {
+	switch preset {
+	case ProxyPresetNginx:
+		return HeaderKeys{
+			OriginalMethod: "X-Original-Method",
+			OriginalURL:    "X-Original-URL",
+		}, nil
+	case ProxyPresetTraefik, ProxyPresetCaddy:
+		return HeaderKeys{
+			OriginalMethod: "X-Forwarded-Method",
+			OriginalURL:    "X-Forwarded-Uri",
+			OriginalProto:  "X-Forwarded-Proto",
+			OriginalHost:   "X-Forwarded-Host",
+		}, nil
+	case ProxyPresetEnvoy:
+		return HeaderKeys{
+			ForwardedRequest: true,
+			OriginalProto:    "X-Forwarded-Proto",
+			OriginalHost:     "Host",
+		}, nil
+	case ProxyPresetHAProxy:
+		return HeaderKeys{
+			OriginalMethod: "X-Original-Method",
+			OriginalURL:    "X-Original-URI",
+			OriginalProto:  "X-Forwarded-Proto",
+			OriginalHost:   "Host",
+		}, nil
+	}
+
+	return HeaderKeys{}, fmt.Errorf("unknown proxy preset %q, expected %s", preset, strings.Join(ProxyPresets, ", "))
+}
+
+// OriginalRequest returns the original method and URL of an authentication request served on the
+// authentication path.
+func OriginalRequest(r *http.Request, keys HeaderKeys, authenticationPath string) (method, url string) {
+	keys.defaults()
+
+	if keys.ForwardedRequest {
+		method = r.Method
+		url = r.URL.RequestURI()
+		if authenticationPath != "" && authenticationPath != "/" {
+			url = strings.TrimPrefix(url, strings.TrimSuffix(authenticationPath, "/"))
+		}
+		if !strings.HasPrefix(url, "/") {
+			url = "/" + url
+		}
+	} else {
+		method = r.Header.Get(keys.OriginalMethod)
+		url = r.Header.Get(keys.OriginalURL)
+	}
+
+	// Rebuild the full URL when the proxy only sends the path.
+	if keys.OriginalHost == "" || !strings.HasPrefix(url, "/") {
+		return method, url
+	}
+
+	host := header(r, keys.OriginalHost)
+	if host == "" {
+		return method, url
+	}
+
+	proto := header(r, keys.OriginalProto)
+	if proto == "" {
+		proto = "http"
+	}
+
+	return method, proto + "://" + host + url
+}
+
+// header returns the first value of a header, the `Host` header is on the request host.
+func header(r *http.Request, key string) string {
+	if key == "" {
+		return ""
+	}
+
+	if strings.EqualFold(key, "Host") {
+		return r.Host
+	}
+
+	// Proxies can send a list of values (e.g `X-Forwarded-Proto: https,http`).
+	v, _, _ := strings.Cut(r.Header.Get(key), ",")
+
+	return strings.TrimSpace(v)
+}
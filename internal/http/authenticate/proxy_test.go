@@ -0,0 +1,191 @@
+package authenticate_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	appauth "github.com/slok/simple-ingress-external-auth/internal/app/auth"
+	httpauthenticate "github.com/slok/simple-ingress-external-auth/internal/http/authenticate"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+)
+
+var proxyTokens = `
+{
+	"version": "v1",
+	"tokens": [
+		{"value": "token0", "client_id": "foo", "allowed_url": "https://custom.host.slok.dev/api/.*", "allowed_method": "GET"}
+	]
+}
+`
+
+func TestIntegrationAuthenticateProxyPreset(t *testing.T) {
+	tests := map[string]struct {
+		preset      string
+		method      string
+		path        string
+		host        string
+		httpHeaders map[string]string
+		expCode     int
+	}{
+		"Nginx should use the full original URL.": {
+			preset: httpauthenticate.ProxyPresetNginx,
+			httpHeaders: map[string]string{
+				"X-Original-Method": "GET",
+				"X-Original-URL":    "https://custom.host.slok.dev/api/users?page=1",
+			},
+			expCode: http.StatusOK,
+		},
+
+		"Traefik should rebuild the original URL from the forwarded headers.": {
+			preset: httpauthenticate.ProxyPresetTraefik,
+			httpHeaders: map[string]string{
+				"X-Forwarded-Method": "GET",
+				"X-Forwarded-Proto":  "https",
+				"X-Forwarded-Host":   "custom.host.slok.dev",
+				"X-Forwarded-Uri":    "/api/users?page=1",
+			},
+			expCode: http.StatusOK,
+		},
+
+		"Traefik should rebuild the original URL with the forwarded host.": {
+			preset: httpauthenticate.ProxyPresetTraefik,
+			httpHeaders: map[string]string{
+				"X-Forwarded-Method": "GET",
+				"X-Forwarded-Proto":  "https",
+				"X-Forwarded-Host":   "other.host.slok.dev",
+				"X-Forwarded-Uri":    "/api/users",
+			},
+			expCode: http.StatusUnauthorized,
+		},
+
+		"Traefik should rebuild the original URL with the forwarded proto.": {
+			preset: httpauthenticate.ProxyPresetTraefik,
+			httpHeaders: map[string]string{
+				"X-Forwarded-Method": "GET",
+				"X-Forwarded-Proto":  "http",
+				"X-Forwarded-Host":   "custom.host.slok.dev",
+				"X-Forwarded-Uri":    "/api/users",
+			},
+			expCode: http.StatusUnauthorized,
+		},
+
+		"Caddy should rebuild the original URL from the forwarded headers.": {
+			preset: httpauthenticate.ProxyPresetCaddy,
+			httpHeaders: map[string]string{
+				"X-Forwarded-Method": "GET",
+				"X-Forwarded-Proto":  "https",
+				"X-Forwarded-Host":   "custom.host.slok.dev",
+				"X-Forwarded-Uri":    "/api/users",
+			},
+			expCode: http.StatusOK,
+		},
+
+		"HAProxy should rebuild the original URL from the original URI and the host.": {
+			preset: httpauthenticate.ProxyPresetHAProxy,
+			host:   "custom.host.slok.dev",
+			httpHeaders: map[string]string{
+				"X-Original-Method": "GET",
+				"X-Forwarded-Proto": "https",
+				"X-Original-URI":    "/api/users",
+			},
+			expCode: http.StatusOK,
+		},
+
+		"Envoy should use the forwarded request method and path without the authentication path.": {
+			preset: httpauthenticate.ProxyPresetEnvoy,
+			method: http.MethodGet,
+			path:   "/auth/api/users?page=1",
+			host:   "custom.host.slok.dev",
+			httpHeaders: map[string]string{
+				"X-Forwarded-Proto": "https",
+			},
+			expCode: http.StatusOK,
+		},
+
+		"Envoy should not use the original request query as the required scopes.": {
+			preset: httpauthenticate.ProxyPresetEnvoy,
+			method: http.MethodGet,
+			path:   "/auth/api/users?scope=admin",
+			host:   "custom.host.slok.dev",
+			httpHeaders: map[string]string{
+				"X-Forwarded-Proto": "https",
+			},
+			expCode: http.StatusOK,
+		},
+
+		"Envoy should use the forwarded request method.": {
+			preset: httpauthenticate.ProxyPresetEnvoy,
+			method: http.MethodPost,
+			path:   "/auth/api/users",
+			host:   "custom.host.slok.dev",
+			httpHeaders: map[string]string{
+				"X-Forwarded-Proto": "https",
+			},
+			expCode: http.StatusUnauthorized,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Create dependencies.
+			repo, err := memory.NewTokenRepository(log.Noop, proxyTokens)
+			require.NoError(err)
+			svc, err := appauth.NewService(appauth.ServiceConfig{TokenGetter: repo})
+			require.NoError(err)
+			keys, err := httpauthenticate.ProxyPresetHeaderKeys(test.preset)
+			require.NoError(err)
+
+			// Run server.
+			handler := httpauthenticate.New(log.Noop, metrics.Noop, svc, httpauthenticate.Config{
+				HeaderKeys:         keys,
+				AuthenticationPath: "/auth",
+			})
+			mux := http.NewServeMux()
+			mux.Handle("/auth/", handler)
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			// Make request.
+			method := http.MethodGet
+			if test.method != "" {
+				method = test.method
+			}
+			path := "/auth/"
+			if test.path != "" {
+				path = test.path
+			}
+			req, _ := http.NewRequest(method, server.URL+path, nil)
+			req.Header.Set("Authorization", "Bearer token0")
+			for k, v := range test.httpHeaders {
+				req.Header.Set(k, v)
+			}
+			if test.host != "" {
+				req.Host = test.host
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(err)
+			defer resp.Body.Close()
+
+			assert.Equal(test.expCode, resp.StatusCode)
+		})
+	}
+}
+
+func TestProxyPresetHeaderKeys(t *testing.T) {
+	for _, preset := range httpauthenticate.ProxyPresets {
+		_, err := httpauthenticate.ProxyPresetHeaderKeys(preset)
+		assert.NoError(t, err, preset)
+	}
+
+	_, err := httpauthenticate.ProxyPresetHeaderKeys("apache")
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package authenticate
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Token source kinds.
+const (
+	// TokenSourceBearer gets the token from the `Authorization: Bearer <token>` header (RFC 6750).
+	TokenSourceBearer = "bearer"
+	// TokenSourceHeader gets the token from a custom header (e.g `X-Api-Key`).
+	TokenSourceHeader = "header"
+	// TokenSourceQuery gets the token from a query parameter of the original request URL.
+	TokenSourceQuery = "query"
+	// TokenSourceCookie gets the token from a cookie.
+	TokenSourceCookie = "cookie"
+)
+
+// TokenSource is a place where the request token can be.
+type TokenSource struct {
+	Kind string
+	// Name is the name of the header, query parameter or cookie, not used by bearer sources.
+	Name string
+}
+
+func (t TokenSource) String() string {
+	if t.Name == "" {
+		return t.Kind
+	}
+
+	return t.Kind + ":" + t.Name
+}
+
+// ParseTokenSource parses a token source in the `<kind>[:<name>]` format, e.g: `bearer`,
+// `header:X-Api-Key`, `query:api_key` or `cookie:token`.
+func ParseTokenSource(s string) (TokenSource, error) {
+	kind, name, _ := strings.Cut(s, ":")
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	name = strings.TrimSpace(name)
+
+	switch kind {
+	case TokenSourceBearer:
+		if name != "" {
+			return TokenSource{}, fmt.Errorf("%s token source doesn't have name", kind)
+		}
+	case TokenSourceHeader, TokenSourceQuery, TokenSourceCookie:
+		if name == "" {
+			return TokenSource{}, fmt.Errorf("%s token source name is required (e.g %s:token)", kind, kind)
+		}
+	default:
+		return TokenSource{}, fmt.Errorf("unknown token source %q, expected bearer, header, query or cookie", kind)
+	}
+
+	return TokenSource{Kind: kind, Name: name}, nil
+}
+
+// extractToken returns the token of the first token source that has it.
+func extractToken(r *http.Request, originalURL string, sources []TokenSource) string {
+	for _, s := range sources {
+		var token string
+		switch s.Kind {
+		case TokenSourceBearer:
+			token = parseBearerToken(r.Header.Get("Authorization"))
+		case TokenSourceHeader:
+			token = strings.TrimSpace(r.Header.Get(s.Name))
+		case TokenSourceQuery:
+			u, err := url.Parse(originalURL)
+			if err == nil {
+				token = u.Query().Get(s.Name)
+			}
+		case TokenSourceCookie:
+			c, err := r.Cookie(s.Name)
+			if err == nil {
+				token = c.Value
+			}
+		}
+
+		if token != "" {
+			return token
+		}
+	}
+
+	return ""
+}
+
+// parseBearerToken parses a bearer authorization header (`Bearer <token>`), the scheme is
+// case-insensitive. The token is not limited to the RFC 6750 b64token syntax, so the tokens accepted
+// before keep working, but it can't have spaces. For backwards compatibility, a bare token without
+// scheme (`<token>`) is also accepted. Returns empty if the header doesn't have a bearer token.
+func parseBearerToken(header string) string {
+	fields := strings.Fields(header)
+	switch {
+	case len(fields) == 1 && !strings.EqualFold(fields[0], "Bearer"):
+		return fields[0]
+	case len(fields) == 2 && strings.EqualFold(fields[0], "Bearer"):
+		return fields[1]
+	}
+
+	return ""
+}
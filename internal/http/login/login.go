@@ -0,0 +1,254 @@
+// Package login has the HTTP handlers of the OIDC authorization code browser login.
+package login
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/oidc"
+)
+
+// Login paths.
+const (
+	StartPath    = "/oauth2/start"
+	CallbackPath = "/oauth2/callback"
+)
+
+// DefaultCookieName is the default session cookie name.
+const DefaultCookieName = "_siea_session"
+
+// loginTTL is how long users have to log in on the provider.
+const loginTTL = 10 * time.Minute
+
+// Provider knows how to log in users with an OIDC provider.
+type Provider interface {
+	AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error)
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (*oidc.Identity, error)
+}
+
+// Config is the login handler configuration.
+type Config struct {
+	Provider Provider
+	// Codec seals the session and login cookies.
+	Codec *oidc.Codec
+	// Policy restricts the users that can log in.
+	Policy oidc.Policy
+	// CookieName is the session cookie name, the login cookie uses it as prefix.
+	CookieName string
+	// CookieDomain is the cookie domain, if empty, the cookies are only sent to the login host.
+	CookieDomain string
+	// CookieInsecure allows sending the cookies on plain HTTP.
+	CookieInsecure bool
+	// SessionTTL is how long the sessions last.
+	SessionTTL time.Duration
+	// AllowedRedirectDomains are the domains where the users can be redirected after the login
+	// (`.example.com` allows its subdomains too), the relative redirects are always allowed.
+	AllowedRedirectDomains []string
+}
+
+func (c *Config) defaults() {
+	if c.CookieName == "" {
+		c.CookieName = DefaultCookieName
+	}
+
+	if c.SessionTTL == 0 {
+		c.SessionTTL = 12 * time.Hour
+	}
+}
+
+// loginState is the state of an ongoing login, stored on the login cookie.
+type loginState struct {
+	State        string    `json:"state"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	Redirect     string    `json:"rd"`
+	ExpiresAt    time.Time `json:"exp"`
+}
+
+type handler struct {
+	config Config
+	logger log.Logger
+}
+
+// New returns an HTTP handler that knows how to log in users with OIDC:
+//
+//   - StartPath: Redirects the users to the provider, the `rd` query parameter is where the users are
+//     redirected after the login.
+//   - CallbackPath: Completes the login and sets the session cookie.
+func New(logger log.Logger, config Config) http.Handler {
+	config.defaults()
+	h := handler{config: config, logger: logger}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(StartPath, h.start)
+	mux.HandleFunc(CallbackPath, h.callback)
+
+	return mux
+}
+
+func (h handler) start(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	rd := r.URL.Query().Get("rd")
+	if !h.allowedRedirect(rd) {
+		h.logger.Debugf("Redirect %q not allowed, using root", rd)
+		rd = "/"
+	}
+
+	state := loginState{
+		State:        randomString(),
+		Nonce:        randomString(),
+		CodeVerifier: randomString(),
+		Redirect:     rd,
+		ExpiresAt:    time.Now().Add(loginTTL),
+	}
+	challenge := sha256.Sum256([]byte(state.CodeVerifier))
+
+	authURL, err := h.config.Provider.AuthCodeURL(r.Context(), state.State, state.Nonce, base64.RawURLEncoding.EncodeToString(challenge[:]))
+	if err != nil {
+		h.logger.Errorf("Could not get provider login URL: %s", err)
+		h.writeError(w, http.StatusInternalServerError, "error starting login")
+		return
+	}
+
+	value, err := h.config.Codec.Seal(oidc.PurposeLogin, state)
+	if err != nil {
+		h.logger.Errorf("Could not seal login state: %s", err)
+		h.writeError(w, http.StatusInternalServerError, "error starting login")
+		return
+	}
+
+	http.SetCookie(w, h.cookie(h.loginCookieName(), value, state.ExpiresAt))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (h handler) callback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		h.writeError(w, http.StatusForbidden, "login error: "+e)
+		return
+	}
+
+	c, err := r.Cookie(h.loginCookieName())
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "missing login state")
+		return
+	}
+
+	// The login state can only be used once.
+	http.SetCookie(w, h.cookie(h.loginCookieName(), "", time.Unix(0, 0)))
+
+	state := loginState{}
+	err = h.config.Codec.Open(oidc.PurposeLogin, c.Value, &state)
+	if err != nil || time.Now().After(state.ExpiresAt) {
+		h.writeError(w, http.StatusBadRequest, "invalid login state")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(state.State)) != 1 {
+		h.writeError(w, http.StatusBadRequest, "invalid login state")
+		return
+	}
+
+	id, err := h.config.Provider.Exchange(r.Context(), q.Get("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		h.logger.Errorf("Could not complete login: %s", err)
+		h.writeError(w, http.StatusInternalServerError, "error completing login")
+		return
+	}
+
+	logger := h.logger.WithValues(log.Kv{"sub": id.Subject, "email": id.Email})
+	if !h.config.Policy.Allows(*id) {
+		logger.Infof("User not allowed")
+		h.writeError(w, http.StatusForbidden, "user not allowed")
+		return
+	}
+
+	session := oidc.Session{Identity: *id, ExpiresAt: time.Now().Add(h.config.SessionTTL)}
+	value, err := h.config.Codec.Seal(oidc.PurposeSession, session)
+	if err != nil {
+		logger.Errorf("Could not seal session: %s", err)
+		h.writeError(w, http.StatusInternalServerError, "error completing login")
+		return
+	}
+
+	logger.Infof("User logged in")
+	http.SetCookie(w, h.cookie(h.config.CookieName, value, session.ExpiresAt))
+	http.Redirect(w, r, state.Redirect, http.StatusFound)
+}
+
+func (h handler) loginCookieName() string {
+	return h.config.CookieName + "_login"
+}
+
+func (h handler) cookie(name, value string, expiresAt time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   h.config.CookieDomain,
+		Expires:  expiresAt,
+		Secure:   !h.config.CookieInsecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// allowedRedirect returns true for relative redirects and redirects to the allowed domains.
+func (h handler) allowedRedirect(rd string) bool {
+	// Browsers handle backslashes and some control characters as slashes or ignore them.
+	if rd == "" || strings.ContainsAny(rd, "\\\t\r\n") {
+		return false
+	}
+
+	if strings.HasPrefix(rd, "/") {
+		return !strings.HasPrefix(rd, "//")
+	}
+
+	u, err := url.Parse(rd)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, d := range h.config.AllowedRedirectDomains {
+		d = strings.ToLower(d)
+		if host == strings.TrimPrefix(d, ".") || (strings.HasPrefix(d, ".") && strings.HasSuffix(host, d)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h handler) writeError(w http.ResponseWriter, statusCode int, msg string) {
+	w.WriteHeader(statusCode)
+	_, err := w.Write([]byte(msg))
+	if err != nil {
+		h.logger.Warningf("Error writing response body: %s", err)
+	}
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,183 @@
+package login_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/simple-ingress-external-auth/internal/http/login"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/oidc"
+)
+
+// testProvider is a provider that logs in the identity when the code is the login nonce.
+type testProvider struct {
+	identity    *oidc.Identity
+	exchangeErr error
+}
+
+func (t testProvider) AuthCodeURL(_ context.Context, state, nonce, codeChallenge string) (string, error) {
+	q := url.Values{"state": {state}, "nonce": {nonce}, "code_challenge": {codeChallenge}}
+	return "https://idp.example.com/authorize?" + q.Encode(), nil
+}
+
+func (t testProvider) Exchange(_ context.Context, code, codeVerifier, nonce string) (*oidc.Identity, error) {
+	if t.exchangeErr != nil {
+		return nil, t.exchangeErr
+	}
+
+	if code != nonce || codeVerifier == "" {
+		return nil, fmt.Errorf("invalid code")
+	}
+
+	return t.identity, nil
+}
+
+func TestLogin(t *testing.T) {
+	tests := map[string]struct {
+		config        login.Config
+		provider      testProvider
+		rd            string
+		callbackQuery func(authQuery url.Values) url.Values
+		noLoginCookie bool
+		expStatus     int
+		expRedirect   string
+		expClientID   string
+	}{
+		"A login should set the session and redirect to the original URL.": {
+			provider:    testProvider{identity: &oidc.Identity{Subject: "u0", Email: "u0@example.com"}},
+			rd:          "/app?x=1",
+			expStatus:   http.StatusFound,
+			expRedirect: "/app?x=1",
+			expClientID: "u0@example.com",
+		},
+
+		"A login with a redirect to an allowed domain should redirect to it.": {
+			config:      login.Config{AllowedRedirectDomains: []string{".example.com"}},
+			provider:    testProvider{identity: &oidc.Identity{Subject: "u0"}},
+			rd:          "https://app.example.com/",
+			expStatus:   http.StatusFound,
+			expRedirect: "https://app.example.com/",
+			expClientID: "u0",
+		},
+
+		"A login with a redirect to other domain should redirect to the root.": {
+			config:      login.Config{AllowedRedirectDomains: []string{".example.com"}},
+			provider:    testProvider{identity: &oidc.Identity{Subject: "u0"}},
+			rd:          "https://evil.com/",
+			expStatus:   http.StatusFound,
+			expRedirect: "/",
+			expClientID: "u0",
+		},
+
+		"A login with a protocol relative redirect should redirect to the root.": {
+			provider:    testProvider{identity: &oidc.Identity{Subject: "u0"}},
+			rd:          "//evil.com/",
+			expStatus:   http.StatusFound,
+			expRedirect: "/",
+			expClientID: "u0",
+		},
+
+		"A login of an allowed group user should set the session.": {
+			config:      login.Config{Policy: oidc.Policy{AllowedGroups: []string{"admins"}}},
+			provider:    testProvider{identity: &oidc.Identity{Subject: "u0", Groups: []string{"admins"}}},
+			expStatus:   http.StatusFound,
+			expRedirect: "/",
+			expClientID: "u0",
+		},
+
+		"A login of a not allowed user should be forbidden.": {
+			config:    login.Config{Policy: oidc.Policy{AllowedUsers: []string{"u1"}, AllowedGroups: []string{"admins"}}},
+			provider:  testProvider{identity: &oidc.Identity{Subject: "u0", Groups: []string{"devs"}}},
+			expStatus: http.StatusForbidden,
+		},
+
+		"A login with a different state should fail.": {
+			provider: testProvider{identity: &oidc.Identity{Subject: "u0"}},
+			callbackQuery: func(q url.Values) url.Values {
+				return url.Values{"state": {"other"}, "code": {q.Get("nonce")}}
+			},
+			expStatus: http.StatusBadRequest,
+		},
+
+		"A login without the login cookie should fail.": {
+			provider:      testProvider{identity: &oidc.Identity{Subject: "u0"}},
+			noLoginCookie: true,
+			expStatus:     http.StatusBadRequest,
+		},
+
+		"A login rejected by the provider should be forbidden.": {
+			provider: testProvider{identity: &oidc.Identity{Subject: "u0"}},
+			callbackQuery: func(q url.Values) url.Values {
+				return url.Values{"state": {q.Get("state")}, "error": {"access_denied"}}
+			},
+			expStatus: http.StatusForbidden,
+		},
+
+		"A login with a code exchange error should fail.": {
+			provider:  testProvider{exchangeErr: fmt.Errorf("something")},
+			expStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			codec, err := oidc.NewCodec("0123456789abcdef0123456789abcdef")
+			require.NoError(err)
+			test.config.Provider = test.provider
+			test.config.Codec = codec
+			h := login.New(log.Noop, test.config)
+
+			// Start.
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, login.StartPath+"?rd="+url.QueryEscape(test.rd), nil))
+			require.Equal(http.StatusFound, rec.Code)
+			authURL, err := url.Parse(rec.Header().Get("Location"))
+			require.NoError(err)
+			authQuery := authURL.Query()
+			loginCookies := rec.Result().Cookies()
+			require.Len(loginCookies, 1)
+			assert.True(loginCookies[0].HttpOnly)
+			assert.True(loginCookies[0].Secure)
+
+			// Callback.
+			callbackQuery := url.Values{"state": {authQuery.Get("state")}, "code": {authQuery.Get("nonce")}}
+			if test.callbackQuery != nil {
+				callbackQuery = test.callbackQuery(authQuery)
+			}
+			req := httptest.NewRequest(http.MethodGet, login.CallbackPath+"?"+callbackQuery.Encode(), nil)
+			if !test.noLoginCookie {
+				req.AddCookie(loginCookies[0])
+			}
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(test.expStatus, rec.Code)
+			if test.expStatus != http.StatusFound {
+				return
+			}
+			assert.Equal(test.expRedirect, rec.Header().Get("Location"))
+
+			// The session cookie should be a valid token.
+			var session string
+			for _, c := range rec.Result().Cookies() {
+				if c.Name == login.DefaultCookieName {
+					session = c.Value
+				}
+			}
+			getter := oidc.NewSessionTokenGetter(log.Noop, codec, test.config.Policy)
+			token, err := getter.GetStaticTokenValidation(context.TODO(), session)
+			require.NoError(err)
+			assert.Equal(test.expClientID, token.ClientID)
+		})
+	}
+}
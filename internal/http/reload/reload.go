@@ -0,0 +1,41 @@
+package reload
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+)
+
+// Reloader knows how to force a reload.
+type Reloader interface {
+	Reload(ctx context.Context) error
+}
+
+// New returns an HTTP handler that forces a token configuration reload on POST requests.
+func New(logger log.Logger, reloader Reloader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		err := reloader.Reload(r.Context())
+		if err != nil {
+			logger.Errorf("Could not reload token config: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			_, err := w.Write([]byte("error reloading: " + err.Error()))
+			if err != nil {
+				logger.Warningf("Error writing response body: %s", err)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write([]byte(`{"status":"reloaded"}`))
+		if err != nil {
+			logger.Warningf("Error writing response body: %s", err)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+// Package tenant has the HTTP handler that routes the authentication requests to their tenant
+// authentication handler.
+package tenant
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	httpauthenticate "github.com/slok/simple-ingress-external-auth/internal/http/authenticate"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+)
+
+// Tenant is a tenant authentication handler.
+type Tenant struct {
+	// Name is the tenant name, the requests on `<authentication-path>/<name>` are routed to the tenant,
+	// unless the tenant forwards the original request (e.g Envoy).
+	Name string
+	// Hosts are the original request hosts routed to the tenant, `*.example.com` routes the
+	// `example.com` subdomains.
+	Hosts []string
+	// HeaderKeys are the tenant header keys, used to get the original request host. The tenants that
+	// forward the original request under the authentication path can only be selected by host, as the
+	// path is set by the client.
+	HeaderKeys httpauthenticate.HeaderKeys
+	// Handler is the tenant authentication handler, served on the authentication path.
+	Handler http.Handler
+}
+
+// Config is the tenant router configuration.
+type Config struct {
+	Tenants []Tenant
+	// Default is the handler of the requests without tenant, if nil, they are unauthorized.
+	Default http.Handler
+	// AuthenticationPath is the path where the router is served.
+	AuthenticationPath string
+	// DefaultForwardedRequest is set when the default handler proxy forwards the original request
+	// under the authentication path, the path is set by the client so the tenants can only be selected
+	// by host.
+	DefaultForwardedRequest bool
+}
+
+func (c *Config) defaults() error {
+	if c.Default == nil {
+		c.Default = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte("unknown tenant"))
+		})
+	}
+
+	names := map[string]struct{}{}
+	for _, t := range c.Tenants {
+		if t.Name == "" {
+			return fmt.Errorf("tenant name is required")
+		}
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("duplicated tenant %q", t.Name)
+		}
+		names[t.Name] = struct{}{}
+
+		if t.Handler == nil {
+			return fmt.Errorf("tenant %q handler is required", t.Name)
+		}
+
+		if len(t.Hosts) == 0 && (t.HeaderKeys.ForwardedRequest || c.DefaultForwardedRequest) {
+			return fmt.Errorf("tenant %q can only be selected by host on forwarded requests, hosts are required", t.Name)
+		}
+	}
+
+	return nil
+}
+
+type router struct {
+	tenants    map[string]Tenant
+	ordered    []Tenant
+	def        http.Handler
+	pathPrefix string
+	byPath     bool
+	logger     log.Logger
+}
+
+// New returns an HTTP handler that routes the authentication requests to their tenant handler. The
+// tenant is selected by the original request host, or by the authentication path set by the proxy
+// (`<authentication-path>/<name>`). The rest of the requests are routed to the default handler.
+func New(logger log.Logger, config Config) (http.Handler, error) {
+	err := config.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	// The forwarded request tenants are never selected by path.
+	tenants := map[string]Tenant{}
+	for _, t := range config.Tenants {
+		if !t.HeaderKeys.ForwardedRequest {
+			tenants[t.Name] = t
+		}
+	}
+
+	return router{
+		tenants:    tenants,
+		ordered:    config.Tenants,
+		def:        config.Default,
+		pathPrefix: strings.TrimSuffix(config.AuthenticationPath, "/"),
+		byPath:     !config.DefaultForwardedRequest,
+		logger:     logger,
+	}, nil
+}
+
+func (rt router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// By original request host, it goes first so a path can't select other tenant on a tenant host.
+	if t, ok := rt.tenantByHost(r); ok {
+		rt.logger.Debugf("Request routed to tenant %q by host", t.Name)
+		t.Handler.ServeHTTP(w, r)
+		return
+	}
+
+	// By authentication path.
+	if t, ok := rt.tenantByPath(r.URL.Path); ok {
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = rt.pathPrefix
+		if r2.URL.Path == "" {
+			r2.URL.Path = "/"
+		}
+		r2.URL.RawPath = ""
+		r2.RequestURI = r2.URL.RequestURI()
+
+		rt.logger.Debugf("Request routed to tenant %q by path", t.Name)
+		t.Handler.ServeHTTP(w, r2)
+		return
+	}
+
+	rt.def.ServeHTTP(w, r)
+}
+
+// tenantByPath returns the tenant of the authentication path (`<authentication-path>/<name>`), only
+// the exact path is used, the forwarded original paths are never used to select the tenant.
+func (rt router) tenantByPath(path string) (Tenant, bool) {
+	if !rt.byPath {
+		return Tenant{}, false
+	}
+
+	name, ok := strings.CutPrefix(path, rt.pathPrefix+"/")
+	if !ok {
+		return Tenant{}, false
+	}
+
+	t, ok := rt.tenants[name]
+
+	return t, ok
+}
+
+// tenantByHost returns the tenant of the original request host, the exact hosts go before the
+// wildcard ones.
+func (rt router) tenantByHost(r *http.Request) (Tenant, bool) {
+	var wildcard *Tenant
+	for i, t := range rt.ordered {
+		if len(t.Hosts) == 0 {
+			continue
+		}
+
+		host := originalHost(r, t.HeaderKeys, rt.pathPrefix)
+		if host == "" {
+			continue
+		}
+
+		for _, h := range t.Hosts {
+			h = strings.ToLower(h)
+			if h == host {
+				return t, true
+			}
+
+			if suffix, ok := strings.CutPrefix(h, "*"); ok && wildcard == nil && strings.HasSuffix(host, suffix) {
+				wildcard = &rt.ordered[i]
+			}
+		}
+	}
+
+	if wildcard != nil {
+		return *wildcard, true
+	}
+
+	return Tenant{}, false
+}
+
+// originalHost returns the lowercase host of the original request URL, without port.
+func originalHost(r *http.Request, keys httpauthenticate.HeaderKeys, authenticationPath string) string {
+	_, u := httpauthenticate.OriginalRequest(r, keys, authenticationPath)
+	pu, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(pu.Hostname())
+}
@@ -0,0 +1,370 @@
+package tenant_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	appauth "github.com/slok/simple-ingress-external-auth/internal/app/auth"
+	httpauthenticate "github.com/slok/simple-ingress-external-auth/internal/http/authenticate"
+	httptenant "github.com/slok/simple-ingress-external-auth/internal/http/tenant"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+)
+
+func newAuthHandler(t *testing.T, tokens, preset string) (http.Handler, httpauthenticate.HeaderKeys) {
+	repo, err := memory.NewTokenRepository(log.Noop, tokens)
+	require.NoError(t, err)
+	svc, err := appauth.NewService(appauth.ServiceConfig{TokenGetter: repo})
+	require.NoError(t, err)
+	keys, err := httpauthenticate.ProxyPresetHeaderKeys(preset)
+	require.NoError(t, err)
+
+	return httpauthenticate.New(log.Noop, metrics.Noop, svc, httpauthenticate.Config{
+		HeaderKeys:         keys,
+		AuthenticationPath: "/auth",
+	}), keys
+}
+
+func TestIntegrationTenantRouter(t *testing.T) {
+	tests := map[string]struct {
+		path        string
+		httpHeaders map[string]string
+		token       string
+		expCode     int
+		expClientID string
+	}{
+		"A tenant token on its tenant path should be authenticated.": {
+			path:        "/auth/team-a",
+			token:       "token-a",
+			expCode:     http.StatusOK,
+			expClientID: "client-a",
+		},
+
+		"A tenant token on other tenant path should not be authenticated.": {
+			path:    "/auth/team-b",
+			token:   "token-a",
+			expCode: http.StatusUnauthorized,
+		},
+
+		"A tenant token on the default path should not be authenticated.": {
+			path:    "/auth",
+			token:   "token-a",
+			expCode: http.StatusUnauthorized,
+		},
+
+		"A default token on the default path should be authenticated.": {
+			path:        "/auth",
+			token:       "token-default",
+			expCode:     http.StatusOK,
+			expClientID: "client-default",
+		},
+
+		"A default token on a tenant path should not be authenticated.": {
+			path:    "/auth/team-a",
+			token:   "token-default",
+			expCode: http.StatusUnauthorized,
+		},
+
+		"An unknown tenant path should use the default handler.": {
+			path:        "/auth/team-c",
+			token:       "token-default",
+			expCode:     http.StatusOK,
+			expClientID: "client-default",
+		},
+
+		"A tenant should be selected by the original URL host.": {
+			path: "/auth",
+			httpHeaders: map[string]string{
+				"X-Original-Method": "GET",
+				"X-Original-URL":    "https://a.slok.dev/api",
+			},
+			token:       "token-a",
+			expCode:     http.StatusOK,
+			expClientID: "client-a",
+		},
+
+		"A tenant should be selected by the original URL host ignoring the port and case.": {
+			path: "/auth",
+			httpHeaders: map[string]string{
+				"X-Original-Method": "GET",
+				"X-Original-URL":    "https://A.slok.dev:8443/api",
+			},
+			token:       "token-a",
+			expCode:     http.StatusOK,
+			expClientID: "client-a",
+		},
+
+		"Other tenant tokens should not be authenticated on a tenant host.": {
+			path: "/auth",
+			httpHeaders: map[string]string{
+				"X-Original-Method": "GET",
+				"X-Original-URL":    "https://a.slok.dev/api",
+			},
+			token:   "token-b",
+			expCode: http.StatusUnauthorized,
+		},
+
+		"A tenant should be selected by a wildcard host.": {
+			path: "/auth",
+			httpHeaders: map[string]string{
+				"X-Forwarded-Method": "GET",
+				"X-Forwarded-Proto":  "https",
+				"X-Forwarded-Host":   "app.b.slok.dev",
+				"X-Forwarded-Uri":    "/api",
+			},
+			token:       "token-b",
+			expCode:     http.StatusOK,
+			expClientID: "client-b",
+		},
+
+		"An exact host should be selected before a wildcard host.": {
+			path: "/auth",
+			httpHeaders: map[string]string{
+				"X-Original-Method": "GET",
+				"X-Original-URL":    "https://a.b.slok.dev/api",
+			},
+			token:       "token-a",
+			expCode:     http.StatusOK,
+			expClientID: "client-a",
+		},
+
+		"A tenant should be selected with its own proxy header keys.": {
+			path: "/auth",
+			httpHeaders: map[string]string{
+				"X-Forwarded-Method": "GET",
+				"X-Forwarded-Proto":  "https",
+				"X-Forwarded-Host":   "traefik.slok.dev",
+				"X-Forwarded-Uri":    "/api",
+			},
+			token:       "token-b",
+			expCode:     http.StatusOK,
+			expClientID: "client-b",
+		},
+
+		"An unknown host should use the default handler.": {
+			path: "/auth",
+			httpHeaders: map[string]string{
+				"X-Original-Method": "GET",
+				"X-Original-URL":    "https://c.slok.dev/api",
+			},
+			token:   "token-a",
+			expCode: http.StatusUnauthorized,
+		},
+
+		"The host should go before the tenant path.": {
+			path: "/auth/team-b",
+			httpHeaders: map[string]string{
+				"X-Original-Method": "GET",
+				"X-Original-URL":    "https://a.slok.dev/api",
+			},
+			token:       "token-a",
+			expCode:     http.StatusOK,
+			expClientID: "client-a",
+		},
+
+		"Other tenant path should not authenticate its tokens on a tenant host.": {
+			path: "/auth/team-b",
+			httpHeaders: map[string]string{
+				"X-Original-Method": "GET",
+				"X-Original-URL":    "https://a.slok.dev/api",
+			},
+			token:   "token-b",
+			expCode: http.StatusUnauthorized,
+		},
+
+		"A path under a tenant path should not select the tenant.": {
+			path:    "/auth/team-a/api",
+			token:   "token-a",
+			expCode: http.StatusUnauthorized,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Create dependencies.
+			defHandler, _ := newAuthHandler(t, `{"version": "v1","tokens": [{"value": "token-default", "client_id": "client-default"}]}`, httpauthenticate.ProxyPresetNginx)
+			aHandler, aKeys := newAuthHandler(t, `{"version": "v1","tokens": [{"value": "token-a", "client_id": "client-a"}]}`, httpauthenticate.ProxyPresetNginx)
+			bHandler, bKeys := newAuthHandler(t, `{"version": "v1","tokens": [{"value": "token-b", "client_id": "client-b"}]}`, httpauthenticate.ProxyPresetTraefik)
+
+			handler, err := httptenant.New(log.Noop, httptenant.Config{
+				Tenants: []httptenant.Tenant{
+					{Name: "team-a", Hosts: []string{"a.slok.dev", "a.b.slok.dev"}, HeaderKeys: aKeys, Handler: aHandler},
+					{Name: "team-b", Hosts: []string{"*.b.slok.dev", "traefik.slok.dev"}, HeaderKeys: bKeys, Handler: bHandler},
+				},
+				Default:            defHandler,
+				AuthenticationPath: "/auth",
+			})
+			require.NoError(err)
+
+			// Run server.
+			mux := http.NewServeMux()
+			mux.Handle("/auth", handler)
+			mux.Handle("/auth/", handler)
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			// Make request.
+			req, _ := http.NewRequest(http.MethodGet, server.URL+test.path, nil)
+			req.Header.Set("Authorization", "Bearer "+test.token)
+			for k, v := range test.httpHeaders {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(err)
+			defer resp.Body.Close()
+
+			assert.Equal(test.expCode, resp.StatusCode)
+			assert.Equal(test.expClientID, resp.Header.Get("X-Ext-Auth-Client-Id"))
+		})
+	}
+}
+
+func TestIntegrationTenantRouterForwardedRequest(t *testing.T) {
+	tests := map[string]struct {
+		defaultForwarded bool
+		path             string
+		host             string
+		token            string
+		expCode          int
+		expClientID      string
+	}{
+		"A forwarded request tenant should be selected by host.": {
+			path:        "/auth/api/users",
+			host:        "a.slok.dev",
+			token:       "token-a",
+			expCode:     http.StatusOK,
+			expClientID: "client-a",
+		},
+
+		"A forwarded original path should not select other tenant on a tenant host.": {
+			path:    "/auth/team-b/api/users",
+			host:    "a.slok.dev",
+			token:   "token-b",
+			expCode: http.StatusUnauthorized,
+		},
+
+		"A forwarded original path that is a tenant path should not select other tenant on a tenant host.": {
+			path:    "/auth/team-b",
+			host:    "a.slok.dev",
+			token:   "token-b",
+			expCode: http.StatusUnauthorized,
+		},
+
+		"A forwarded original path should not select a tenant.": {
+			path:    "/auth/team-b/api/users",
+			host:    "c.slok.dev",
+			token:   "token-b",
+			expCode: http.StatusUnauthorized,
+		},
+
+		"A tenant path should select a tenant that doesn't forward the request.": {
+			path:        "/auth/team-b",
+			host:        "c.slok.dev",
+			token:       "token-b",
+			expCode:     http.StatusOK,
+			expClientID: "client-b",
+		},
+
+		"A tenant path should not select a tenant when the default handler forwards the request.": {
+			defaultForwarded: true,
+			path:             "/auth/team-b",
+			host:             "c.slok.dev",
+			token:            "token-b",
+			expCode:          http.StatusUnauthorized,
+		},
+
+		"A forwarded request without tenant should use the default handler.": {
+			path:    "/auth/api/users",
+			host:    "c.slok.dev",
+			token:   "token-a",
+			expCode: http.StatusUnauthorized,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Create dependencies.
+			aHandler, aKeys := newAuthHandler(t, `{"version": "v1","tokens": [{"value": "token-a", "client_id": "client-a", "allowed_url": "https://a.slok.dev/api/.*"}]}`, httpauthenticate.ProxyPresetEnvoy)
+			bHandler, bKeys := newAuthHandler(t, `{"version": "v1","tokens": [{"value": "token-b", "client_id": "client-b"}]}`, httpauthenticate.ProxyPresetNginx)
+			bHosts := []string{}
+			if test.defaultForwarded {
+				bHosts = []string{"b.slok.dev"}
+			}
+
+			handler, err := httptenant.New(log.Noop, httptenant.Config{
+				Tenants: []httptenant.Tenant{
+					{Name: "team-a", Hosts: []string{"a.slok.dev"}, HeaderKeys: aKeys, Handler: aHandler},
+					{Name: "team-b", Hosts: bHosts, HeaderKeys: bKeys, Handler: bHandler},
+				},
+				AuthenticationPath:      "/auth",
+				DefaultForwardedRequest: test.defaultForwarded,
+			})
+			require.NoError(err)
+
+			// Run server.
+			mux := http.NewServeMux()
+			mux.Handle("/auth/", handler)
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			// Make request.
+			req, _ := http.NewRequest(http.MethodGet, server.URL+test.path, nil)
+			req.Host = test.host
+			req.Header.Set("Authorization", "Bearer "+test.token)
+			req.Header.Set("X-Forwarded-Proto", "https")
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(err)
+			defer resp.Body.Close()
+
+			assert.Equal(test.expCode, resp.StatusCode)
+			assert.Equal(test.expClientID, resp.Header.Get("X-Ext-Auth-Client-Id"))
+		})
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	forwardedKeys, err := httpauthenticate.ProxyPresetHeaderKeys(httpauthenticate.ProxyPresetEnvoy)
+	require.NoError(t, err)
+	handler := http.NotFoundHandler()
+
+	tests := map[string]struct {
+		config httptenant.Config
+	}{
+		"A forwarded request tenant without hosts should fail.": {
+			config: httptenant.Config{
+				Tenants: []httptenant.Tenant{{Name: "team-a", HeaderKeys: forwardedKeys, Handler: handler}},
+			},
+		},
+
+		"A tenant without hosts should fail when the default handler forwards the request.": {
+			config: httptenant.Config{
+				Tenants:                 []httptenant.Tenant{{Name: "team-a", Handler: handler}},
+				DefaultForwardedRequest: true,
+			},
+		},
+
+		"Duplicated tenants should fail.": {
+			config: httptenant.Config{
+				Tenants: []httptenant.Tenant{{Name: "team-a", Handler: handler}, {Name: "team-a", Handler: handler}},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := httptenant.New(log.Noop, test.config)
+			assert.Error(t, err)
+		})
+	}
+}
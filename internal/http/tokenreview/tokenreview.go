@@ -0,0 +1,89 @@
+// Package tokenreview has the Kubernetes webhook token authentication (TokenReview) HTTP handler.
+package tokenreview
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+
+	"github.com/slok/simple-ingress-external-auth/internal/app/auth"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+)
+
+// Supported TokenReview API versions, both have the same format.
+const (
+	apiVersionV1      = "authentication.k8s.io/v1"
+	apiVersionV1beta1 = "authentication.k8s.io/v1beta1"
+)
+
+// maxBodySize is the max TokenReview request size.
+const maxBodySize = 1 << 20
+
+// New returns an HTTP handler that knows how to authenticate the Kubernetes API server webhook
+// TokenReview requests. The token client ID is returned as the username, and the token groups as
+// the user groups.
+//
+// The reviews don't have a request URL and method, the token URL and method restrictions are checked
+// with empty values, so the restricted tokens will normally not be authenticated.
+func New(logger log.Logger, authAppSvc auth.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Map request to model.
+		tr := authenticationv1.TokenReview{}
+		err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&tr)
+		if err != nil || (tr.APIVersion != apiVersionV1 && tr.APIVersion != apiVersionV1beta1) || tr.Kind != "TokenReview" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte("invalid TokenReview"))
+			if err != nil {
+				logger.Warningf("Error writing response body: %s", err)
+			}
+			return
+		}
+
+		// Review authentication.
+		status := authenticationv1.TokenReviewStatus{Error: "missing token"}
+		if tr.Spec.Token != "" {
+			resp, err := authAppSvc.Authenticate(r.Context(), auth.AuthenticateRequest{Review: model.TokenReview{Token: tr.Spec.Token}})
+			if err != nil {
+				logger.Errorf("auth app error: %s", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				_, err := w.Write([]byte("error authenticating"))
+				if err != nil {
+					logger.Warningf("Error writing response body: %s", err)
+				}
+				return
+			}
+
+			switch {
+			case !resp.Authenticated:
+				status = authenticationv1.TokenReviewStatus{Error: resp.Reason}
+			case resp.ClientID == "":
+				// Kubernetes users must have a username.
+				status = authenticationv1.TokenReviewStatus{Error: "token without client ID"}
+			default:
+				status = authenticationv1.TokenReviewStatus{
+					Authenticated: true,
+					User: authenticationv1.UserInfo{
+						Username: resp.ClientID,
+						Groups:   resp.Groups,
+					},
+				}
+			}
+		}
+
+		// Respond with the same API version.
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(authenticationv1.TokenReview{TypeMeta: tr.TypeMeta, Status: status})
+		if err != nil {
+			logger.Warningf("Error writing response body: %s", err)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package tokenreview_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	appauth "github.com/slok/simple-ingress-external-auth/internal/app/auth"
+	httptokenreview "github.com/slok/simple-ingress-external-auth/internal/http/tokenreview"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+)
+
+var tokens = `
+{
+	"version": "v1",
+	"tokens": [
+		{"value": "token0", "client_id": "admin0", "groups": ["system:masters"]},
+		{"value": "token1", "client_id": "admin1", "disable": true},
+		{"value": "token2", "client_id": "ingress0", "allowed_url": "https://app.slok.dev/.*"},
+		{"value": "token3", "client_id": "", "groups": ["viewers"]}
+	]
+}`
+
+func TestTokenReview(t *testing.T) {
+	tests := map[string]struct {
+		method  string
+		body    string
+		expCode int
+		expBody string
+	}{
+		"A valid token should be authenticated with the client ID and groups.": {
+			body:    `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"token0"}}`,
+			expCode: http.StatusOK,
+			expBody: `{"kind":"TokenReview","apiVersion":"authentication.k8s.io/v1","metadata":{},"spec":{},"status":{"authenticated":true,"user":{"username":"admin0","groups":["system:masters"]}}}`,
+		},
+
+		"A valid v1beta1 review should respond with v1beta1.": {
+			body:    `{"apiVersion":"authentication.k8s.io/v1beta1","kind":"TokenReview","spec":{"token":"token0"}}`,
+			expCode: http.StatusOK,
+			expBody: `{"kind":"TokenReview","apiVersion":"authentication.k8s.io/v1beta1","metadata":{},"spec":{},"status":{"authenticated":true,"user":{"username":"admin0","groups":["system:masters"]}}}`,
+		},
+
+		"A missing token should not be authenticated.": {
+			body:    `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"token9"}}`,
+			expCode: http.StatusOK,
+			expBody: `{"kind":"TokenReview","apiVersion":"authentication.k8s.io/v1","metadata":{},"spec":{},"status":{"user":{},"error":"invalidToken"}}`,
+		},
+
+		"A disabled token should not be authenticated.": {
+			body:    `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"token1"}}`,
+			expCode: http.StatusOK,
+			expBody: `{"kind":"TokenReview","apiVersion":"authentication.k8s.io/v1","metadata":{},"spec":{},"status":{"user":{},"error":"invalidToken"}}`,
+		},
+
+		"A token restricted to a URL should not be authenticated.": {
+			body:    `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"token2"}}`,
+			expCode: http.StatusOK,
+			expBody: `{"kind":"TokenReview","apiVersion":"authentication.k8s.io/v1","metadata":{},"spec":{},"status":{"user":{},"error":"invalidURL"}}`,
+		},
+
+		"A token without client ID should not be authenticated.": {
+			body:    `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"token3"}}`,
+			expCode: http.StatusOK,
+			expBody: `{"kind":"TokenReview","apiVersion":"authentication.k8s.io/v1","metadata":{},"spec":{},"status":{"user":{},"error":"token without client ID"}}`,
+		},
+
+		"A review without token should not be authenticated.": {
+			body:    `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{}}`,
+			expCode: http.StatusOK,
+			expBody: `{"kind":"TokenReview","apiVersion":"authentication.k8s.io/v1","metadata":{},"spec":{},"status":{"user":{},"error":"missing token"}}`,
+		},
+
+		"A review with an unknown API version should fail.": {
+			body:    `{"apiVersion":"authentication.k8s.io/v2","kind":"TokenReview","spec":{"token":"token0"}}`,
+			expCode: http.StatusBadRequest,
+		},
+
+		"An invalid review should fail.": {
+			body:    `{"apiVersion":`,
+			expCode: http.StatusBadRequest,
+		},
+
+		"A review that is not a POST should fail.": {
+			method:  http.MethodGet,
+			expCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			// Create dependencies.
+			repo, err := memory.NewTokenRepository(log.Noop, tokens)
+			require.NoError(err)
+			svc, err := appauth.NewService(appauth.ServiceConfig{TokenGetter: repo})
+			require.NoError(err)
+
+			// Run server.
+			server := httptest.NewServer(httptokenreview.New(log.Noop, svc))
+			defer server.Close()
+
+			// Make request.
+			method := http.MethodPost
+			if test.method != "" {
+				method = test.method
+			}
+			req, _ := http.NewRequest(method, server.URL, strings.NewReader(test.body))
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(err)
+			defer resp.Body.Close()
+
+			assert.Equal(test.expCode, resp.StatusCode)
+			if test.expBody != "" {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(err)
+				assert.JSONEq(test.expBody, string(body))
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+// Package httpsig verifies HTTP message signatures (RFC 9421).
+package httpsig
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Signature headers.
+const (
+	HeaderSignatureInput = "Signature-Input"
+	HeaderSignature      = "Signature"
+)
+
+// Request is the signed HTTP request. As the request is reviewed by an external auth service, the
+// method and the URL are the original ones forwarded by the proxy.
+type Request struct {
+	Method string
+	// URL is the absolute original URL.
+	URL    string
+	Header http.Header
+}
+
+// Signature is an HTTP message signature ready to be verified.
+type Signature struct {
+	Label     string
+	KeyID     string
+	Algorithm string
+	Created   time.Time
+	Expires   time.Time
+	Nonce     string
+	// Components are the covered component identifiers (e.g `@method`, `content-digest`).
+	Components []string
+	// Base is the signature base (what has been signed).
+	Base  []byte
+	Value []byte
+}
+
+// HasSignature returns true if the request headers have a signature.
+func HasSignature(h http.Header) bool {
+	return h.Get(HeaderSignatureInput) != "" || h.Get(HeaderSignature) != ""
+}
+
+// Parse gets the first signature of the request and creates its signature base.
+func Parse(r Request) (*Signature, error) {
+	inputs, err := parseSFDictionary(strings.Join(r.Header.Values(HeaderSignatureInput), ", "))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header: %w", HeaderSignatureInput, err)
+	}
+
+	values, err := parseSFDictionary(strings.Join(r.Header.Values(HeaderSignature), ", "))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header: %w", HeaderSignature, err)
+	}
+
+	for _, input := range inputs {
+		for _, value := range values {
+			if input.key == value.key {
+				return newSignature(r, input, value)
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("missing signature")
+}
+
+func newSignature(r Request, input, value sfMember) (*Signature, error) {
+	params, ok := input.value.(sfInnerList)
+	if !ok {
+		return nil, fmt.Errorf("signature %q input must be an inner list", input.key)
+	}
+
+	v, ok := value.value.(sfItem)
+	if !ok {
+		return nil, fmt.Errorf("signature %q must be a byte sequence", value.key)
+	}
+	sigValue, ok := v.value.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("signature %q must be a byte sequence", value.key)
+	}
+
+	sig := &Signature{Label: input.key, Value: sigValue}
+	for _, p := range params.params {
+		var ok bool
+		switch p.key {
+		case "keyid":
+			sig.KeyID, ok = p.value.(string)
+		case "alg":
+			sig.Algorithm, ok = p.value.(string)
+		case "nonce":
+			sig.Nonce, ok = p.value.(string)
+		case "created":
+			sig.Created, ok = unixParam(p.value)
+		case "expires":
+			sig.Expires, ok = unixParam(p.value)
+		default:
+			// Unknown parameters (e.g `tag`) are signed, but not used.
+			ok = true
+		}
+		if !ok {
+			return nil, fmt.Errorf("invalid signature %q parameter", p.key)
+		}
+	}
+
+	base, components, err := signatureBase(r, params)
+	if err != nil {
+		return nil, err
+	}
+	sig.Base = base
+	sig.Components = components
+
+	return sig, nil
+}
+
+func unixParam(v any) (time.Time, bool) {
+	n, ok := v.(int64)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(n, 0), true
+}
+
+// signatureBase creates the signature base of the covered components (RFC 9421 section 2.5).
+func signatureBase(r Request, params sfInnerList) ([]byte, []string, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid request URL: %w", err)
+	}
+
+	var b strings.Builder
+	components := make([]string, 0, len(params.items))
+	seen := map[string]bool{}
+	for _, item := range params.items {
+		name, ok := item.value.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("signature component identifiers must be strings")
+		}
+
+		if len(item.params) > 0 {
+			return nil, nil, fmt.Errorf("signature component %q parameters are not supported", name)
+		}
+
+		if seen[name] {
+			return nil, nil, fmt.Errorf("signature component %q is duplicated", name)
+		}
+		seen[name] = true
+
+		value, err := componentValue(r, u, name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, nil, fmt.Errorf("signature component %q has line breaks", name)
+		}
+
+		fmt.Fprintf(&b, "%s: %s\n", serializeSFBareItem(name), value)
+		components = append(components, name)
+	}
+
+	fmt.Fprintf(&b, "%s: %s", serializeSFBareItem("@signature-params"), serializeSFInnerList(params))
+
+	return []byte(b.String()), components, nil
+}
+
+func componentValue(r Request, u *url.URL, name string) (string, error) {
+	switch name {
+	case "@method":
+		return r.Method, nil
+	case "@target-uri":
+		return r.URL, nil
+	case "@authority":
+		return strings.ToLower(u.Host), nil
+	case "@scheme":
+		return strings.ToLower(u.Scheme), nil
+	case "@request-target":
+		return u.RequestURI(), nil
+	case "@path":
+		if u.EscapedPath() == "" {
+			return "/", nil
+		}
+		return u.EscapedPath(), nil
+	case "@query":
+		return "?" + u.RawQuery, nil
+	}
+
+	if strings.HasPrefix(name, "@") {
+		return "", fmt.Errorf("signature component %q is not supported", name)
+	}
+
+	if name != strings.ToLower(name) {
+		return "", fmt.Errorf("signature component %q must be lowercase", name)
+	}
+
+	values := r.Header.Values(name)
+	if len(values) == 0 {
+		return "", fmt.Errorf("signature component %q header is missing", name)
+	}
+
+	trimmed := make([]string, 0, len(values))
+	for _, v := range values {
+		trimmed = append(trimmed, strings.TrimSpace(v))
+	}
+
+	return strings.Join(trimmed, ", "), nil
+}
@@ -0,0 +1,241 @@
+package httpsig_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/simple-ingress-external-auth/internal/httpsig"
+)
+
+const testSharedSecret = "test-shared-secret"
+
+// RFC 9421 appendix B Ed25519 test key.
+const testKeyEd25519 = `-----BEGIN PUBLIC KEY-----
+MCowBQYDK2VwAyEAJrQLj5P/89iXES9+vFgrIy29clF9CC/oPPsw3c5D0bs=
+-----END PUBLIC KEY-----`
+
+const testHMACBase = `"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@authority": example.com
+"content-type": application/json
+"@signature-params": ("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`
+
+func hmacSignature(secret, base string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(base))
+	return ":" + base64.StdEncoding.EncodeToString(mac.Sum(nil)) + ":"
+}
+
+// newTestRequest returns the RFC 9421 appendix B test request.
+func newTestRequest(signatureInput, signature string) httpsig.Request {
+	h := http.Header{}
+	h.Set("Host", "example.com")
+	h.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	h.Set("Content-Type", "application/json")
+	h.Set("Content-Digest", "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:")
+	h.Set("Content-Length", "18")
+	h.Set("Signature-Input", signatureInput)
+	h.Set("Signature", signature)
+
+	return httpsig.Request{
+		Method: "POST",
+		URL:    "https://example.com/foo?param=Value&Pet=dog",
+		Header: h,
+	}
+}
+
+func TestParseAndVerify(t *testing.T) {
+	tests := map[string]struct {
+		req          httpsig.Request
+		algorithm    string
+		secret       string
+		publicKey    string
+		expSignature *httpsig.Signature
+		expParseErr  bool
+		expVerifyErr bool
+	}{
+		"A valid HMAC signature should be verified.": {
+			req: newTestRequest(
+				`sig-b25=("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`,
+				"sig-b25="+hmacSignature(testSharedSecret, testHMACBase),
+			),
+			secret: testSharedSecret,
+			expSignature: &httpsig.Signature{
+				Label:      "sig-b25",
+				KeyID:      "test-shared-secret",
+				Created:    time.Unix(1618884473, 0),
+				Components: []string{"date", "@authority", "content-type"},
+				Base:       []byte(testHMACBase),
+			},
+		},
+
+		"A valid Ed25519 signature should be verified.": {
+			req: newTestRequest(
+				`sig-b26=("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`,
+				`sig-b26=:wqcAqbmYJ2ji2glfAMaRy4gruYYnx2nEFN2HN6jrnDnQCK1u02Gb04v9EDgwUPiu4A0w6vuQv5lIp5WPpBKRCw==:`,
+			),
+			publicKey: testKeyEd25519,
+			expSignature: &httpsig.Signature{
+				Label:      "sig-b26",
+				KeyID:      "test-key-ed25519",
+				Created:    time.Unix(1618884473, 0),
+				Components: []string{"date", "@method", "@path", "@authority", "content-type", "content-length"},
+				Base: []byte(`"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@method": POST
+"@path": /foo
+"@authority": example.com
+"content-type": application/json
+"content-length": 18
+"@signature-params": ("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`),
+			},
+		},
+
+		"A signature with a tampered component should not be verified.": {
+			req: func() httpsig.Request {
+				r := newTestRequest(
+					`sig-b25=("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`,
+					"sig-b25="+hmacSignature(testSharedSecret, testHMACBase),
+				)
+				r.Header.Set("Content-Type", "text/plain")
+				return r
+			}(),
+			secret:       testSharedSecret,
+			expVerifyErr: true,
+		},
+
+		"A signature with the wrong key should not be verified.": {
+			req: newTestRequest(
+				`sig-b25=("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`,
+				"sig-b25="+hmacSignature(testSharedSecret, testHMACBase),
+			),
+			secret:       "wrong",
+			expVerifyErr: true,
+		},
+
+		"A signature with the wrong algorithm key should not be verified.": {
+			req: newTestRequest(
+				`sig-b25=("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`,
+				"sig-b25="+hmacSignature(testSharedSecret, testHMACBase),
+			),
+			publicKey:    testKeyEd25519,
+			expVerifyErr: true,
+		},
+
+		"A signature covering a missing header should fail.": {
+			req: newTestRequest(
+				`sig1=("x-missing");created=1618884473;keyid="test-shared-secret"`,
+				`sig1=:pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=:`,
+			),
+			expParseErr: true,
+		},
+
+		"A signature covering an unsupported component should fail.": {
+			req: newTestRequest(
+				`sig1=("@query-param";name="Pet");created=1618884473;keyid="test-shared-secret"`,
+				`sig1=:pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=:`,
+			),
+			expParseErr: true,
+		},
+
+		"A signature without value should fail.": {
+			req: newTestRequest(
+				`sig1=("@method");created=1618884473;keyid="test-shared-secret"`,
+				`sig2=:pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=:`,
+			),
+			expParseErr: true,
+		},
+
+		"An invalid signature input should fail.": {
+			req: newTestRequest(
+				`sig1=("@method";created=1618884473`,
+				`sig1=:pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=:`,
+			),
+			expParseErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			gotSig, err := httpsig.Parse(test.req)
+			if test.expParseErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+
+			if test.expSignature != nil {
+				test.expSignature.Value = gotSig.Value
+				assert.Equal(test.expSignature, gotSig)
+			}
+
+			alg, key, err := httpsig.ParseKey(test.algorithm, test.secret, test.publicKey)
+			require.NoError(err)
+
+			err = httpsig.Verify(alg, key, gotSig.Base, gotSig.Value)
+			if test.expVerifyErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+		})
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := map[string]struct {
+		algorithm    string
+		secret       string
+		publicKey    string
+		expAlgorithm string
+		expErr       bool
+	}{
+		"A secret should be an HMAC key.": {
+			secret:       "test",
+			expAlgorithm: httpsig.AlgorithmHMACSHA256,
+		},
+
+		"An Ed25519 public key should be inferred.": {
+			publicKey:    testKeyEd25519,
+			expAlgorithm: httpsig.AlgorithmEd25519,
+		},
+
+		"A key that doesn't match the algorithm should fail.": {
+			algorithm: httpsig.AlgorithmRSAPSSSHA512,
+			publicKey: testKeyEd25519,
+			expErr:    true,
+		},
+
+		"A secret and a public key should fail.": {
+			secret:    "test",
+			publicKey: testKeyEd25519,
+			expErr:    true,
+		},
+
+		"An invalid public key should fail.": {
+			publicKey: "test",
+			expErr:    true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			gotAlgorithm, _, err := httpsig.ParseKey(test.algorithm, test.secret, test.publicKey)
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				assert.Equal(test.expAlgorithm, gotAlgorithm)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package httpsig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+)
+
+// Supported signature algorithms (RFC 9421 section 3.3).
+const (
+	AlgorithmHMACSHA256      = "hmac-sha256"
+	AlgorithmEd25519         = "ed25519"
+	AlgorithmECDSAP256SHA256 = "ecdsa-p256-sha256"
+	AlgorithmRSAPSSSHA512    = "rsa-pss-sha512"
+	AlgorithmRSAV15SHA256    = "rsa-v1_5-sha256"
+)
+
+// ParseKey returns the signature verification key from a shared HMAC secret or a PEM public key
+// (`PUBLIC KEY`, `RSA PUBLIC KEY` or `CERTIFICATE`). If the algorithm is empty, it will be inferred
+// from the key (`rsa-pss-sha512` for RSA keys). The returned key is `[]byte` for HMAC secrets,
+// otherwise the public key.
+func ParseKey(algorithm, secret, publicKeyPEM string) (string, any, error) {
+	if (secret == "") == (publicKeyPEM == "") {
+		return "", nil, fmt.Errorf("one of secret or public key is required")
+	}
+
+	var key any = []byte(secret)
+	if publicKeyPEM != "" {
+		k, err := parsePublicKey(publicKeyPEM)
+		if err != nil {
+			return "", nil, err
+		}
+		key = k
+	}
+
+	if algorithm == "" {
+		switch k := key.(type) {
+		case []byte:
+			algorithm = AlgorithmHMACSHA256
+		case ed25519.PublicKey:
+			algorithm = AlgorithmEd25519
+		case *ecdsa.PublicKey:
+			if k.Curve == elliptic.P256() {
+				algorithm = AlgorithmECDSAP256SHA256
+			}
+		case *rsa.PublicKey:
+			algorithm = AlgorithmRSAPSSSHA512
+		}
+	}
+
+	if !keyMatchesAlgorithm(algorithm, key) {
+		return "", nil, fmt.Errorf("key can't be used with %q algorithm", algorithm)
+	}
+
+	return algorithm, key, nil
+}
+
+func parsePublicKey(data string) (any, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM public key")
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+func keyMatchesAlgorithm(algorithm string, key any) bool {
+	switch k := key.(type) {
+	case []byte:
+		return algorithm == AlgorithmHMACSHA256
+	case ed25519.PublicKey:
+		return algorithm == AlgorithmEd25519
+	case *ecdsa.PublicKey:
+		return algorithm == AlgorithmECDSAP256SHA256 && k.Curve == elliptic.P256()
+	case *rsa.PublicKey:
+		return algorithm == AlgorithmRSAPSSSHA512 || algorithm == AlgorithmRSAV15SHA256
+	}
+
+	return false
+}
+
+// Verify verifies the signature base signature with a key returned by ParseKey.
+func Verify(algorithm string, key any, base, signature []byte) error {
+	if !keyMatchesAlgorithm(algorithm, key) {
+		return fmt.Errorf("key can't be used with %q algorithm", algorithm)
+	}
+
+	var ok bool
+	switch algorithm {
+	case AlgorithmHMACSHA256:
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write(base)
+		ok = hmac.Equal(mac.Sum(nil), signature)
+	case AlgorithmEd25519:
+		ok = ed25519.Verify(key.(ed25519.PublicKey), base, signature)
+	case AlgorithmECDSAP256SHA256:
+		// The ECDSA signatures are the `r` and `s` values concatenated.
+		if len(signature) != 64 {
+			return fmt.Errorf("invalid ECDSA signature length")
+		}
+		digest := sha256.Sum256(base)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		ok = ecdsa.Verify(key.(*ecdsa.PublicKey), digest[:], r, s)
+	case AlgorithmRSAPSSSHA512:
+		digest := sha512.Sum512(base)
+		ok = rsa.VerifyPSS(key.(*rsa.PublicKey), crypto.SHA512, digest[:], signature, &rsa.PSSOptions{SaltLength: 64}) == nil
+	case AlgorithmRSAV15SHA256:
+		digest := sha256.Sum256(base)
+		ok = rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	}
+
+	if !ok {
+		return fmt.Errorf("invalid signature")
+	}
+
+	return nil
+}
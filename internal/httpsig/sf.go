@@ -0,0 +1,371 @@
+package httpsig
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This is the subset of the structured field values (RFC 8941) used by the signature headers:
+// dictionaries whose members are byte sequences (`Signature`) or inner lists of strings with
+// parameters (`Signature-Input`).
+
+// sfToken is a structured field token, to be serialized without quotes.
+type sfToken string
+
+type sfParam struct {
+	key   string
+	value any // string, sfToken, int64, bool or []byte.
+}
+
+type sfParams []sfParam
+
+func (p sfParams) get(key string) (any, bool) {
+	for _, param := range p {
+		if param.key == key {
+			return param.value, true
+		}
+	}
+
+	return nil, false
+}
+
+type sfItem struct {
+	value  any
+	params sfParams
+}
+
+type sfInnerList struct {
+	items  []sfItem
+	params sfParams
+}
+
+type sfMember struct {
+	key string
+	// value is an sfItem or an sfInnerList.
+	value any
+}
+
+type sfParser struct {
+	s string
+	i int
+}
+
+func (p *sfParser) eof() bool { return p.i >= len(p.s) }
+
+func (p *sfParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *sfParser) skipSP() {
+	for !p.eof() && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for !p.eof() && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// parseSFDictionary parses a structured field dictionary keeping the members order.
+func parseSFDictionary(s string) ([]sfMember, error) {
+	p := &sfParser{s: s}
+	p.skipSP()
+
+	var members []sfMember
+	for !p.eof() {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+
+		var value any
+		if p.peek() == '=' {
+			p.i++
+			value, err = p.parseItemOrInnerList()
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			params, err := p.parseParams()
+			if err != nil {
+				return nil, err
+			}
+			value = sfItem{value: true, params: params}
+		}
+		members = append(members, sfMember{key: key, value: value})
+
+		p.skipOWS()
+		if p.eof() {
+			break
+		}
+		if p.peek() != ',' {
+			return nil, fmt.Errorf("expected ',' at %d", p.i)
+		}
+		p.i++
+		p.skipOWS()
+		if p.eof() {
+			return nil, fmt.Errorf("trailing ','")
+		}
+	}
+
+	return members, nil
+}
+
+func (p *sfParser) parseItemOrInnerList() (any, error) {
+	if p.peek() == '(' {
+		return p.parseInnerList()
+	}
+
+	return p.parseItem()
+}
+
+func (p *sfParser) parseInnerList() (sfInnerList, error) {
+	p.i++ // (.
+
+	var list sfInnerList
+	for {
+		p.skipSP()
+		if p.eof() {
+			return sfInnerList{}, fmt.Errorf("unterminated inner list")
+		}
+
+		if p.peek() == ')' {
+			p.i++
+			params, err := p.parseParams()
+			if err != nil {
+				return sfInnerList{}, err
+			}
+			list.params = params
+			return list, nil
+		}
+
+		item, err := p.parseItem()
+		if err != nil {
+			return sfInnerList{}, err
+		}
+		list.items = append(list.items, item)
+
+		if c := p.peek(); c != ' ' && c != ')' {
+			return sfInnerList{}, fmt.Errorf("expected ' ' or ')' at %d", p.i)
+		}
+	}
+}
+
+func (p *sfParser) parseItem() (sfItem, error) {
+	value, err := p.parseBareItem()
+	if err != nil {
+		return sfItem{}, err
+	}
+
+	params, err := p.parseParams()
+	if err != nil {
+		return sfItem{}, err
+	}
+
+	return sfItem{value: value, params: params}, nil
+}
+
+func (p *sfParser) parseParams() (sfParams, error) {
+	var params sfParams
+	for p.peek() == ';' {
+		p.i++
+		p.skipSP()
+
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+
+		var value any = true
+		if p.peek() == '=' {
+			p.i++
+			value, err = p.parseBareItem()
+			if err != nil {
+				return nil, err
+			}
+		}
+		params = append(params, sfParam{key: key, value: value})
+	}
+
+	return params, nil
+}
+
+func (p *sfParser) parseKey() (string, error) {
+	start := p.i
+	for !p.eof() {
+		c := p.s[p.i]
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '_' && c != '-' && c != '.' && c != '*' {
+			break
+		}
+		if p.i == start && !(c >= 'a' && c <= 'z') && c != '*' {
+			break
+		}
+		p.i++
+	}
+
+	if p.i == start {
+		return "", fmt.Errorf("expected key at %d", p.i)
+	}
+
+	return p.s[start:p.i], nil
+}
+
+func (p *sfParser) parseBareItem() (any, error) {
+	c := p.peek()
+	switch {
+	case c == '"':
+		return p.parseString()
+	case c == ':':
+		return p.parseByteSequence()
+	case c == '?':
+		return p.parseBoolean()
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.parseInteger()
+	case c == '*' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return p.parseToken()
+	}
+
+	return nil, fmt.Errorf("unexpected character at %d", p.i)
+}
+
+func (p *sfParser) parseString() (string, error) {
+	p.i++ // ".
+
+	var b strings.Builder
+	for !p.eof() {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == '\\':
+			if p.eof() || (p.s[p.i] != '"' && p.s[p.i] != '\\') {
+				return "", fmt.Errorf("invalid string escape at %d", p.i)
+			}
+			b.WriteByte(p.s[p.i])
+			p.i++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", fmt.Errorf("invalid string character at %d", p.i-1)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", fmt.Errorf("unterminated string")
+}
+
+func (p *sfParser) parseByteSequence() ([]byte, error) {
+	p.i++ // :.
+
+	end := strings.IndexByte(p.s[p.i:], ':')
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated byte sequence")
+	}
+	data := p.s[p.i : p.i+end]
+	p.i += end + 1
+
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid byte sequence: %w", err)
+	}
+
+	return b, nil
+}
+
+func (p *sfParser) parseBoolean() (bool, error) {
+	p.i++ // ?.
+
+	switch p.peek() {
+	case '1':
+		p.i++
+		return true, nil
+	case '0':
+		p.i++
+		return false, nil
+	}
+
+	return false, fmt.Errorf("invalid boolean at %d", p.i)
+}
+
+func (p *sfParser) parseInteger() (int64, error) {
+	start := p.i
+	if p.peek() == '-' {
+		p.i++
+	}
+	for !p.eof() && p.s[p.i] >= '0' && p.s[p.i] <= '9' {
+		p.i++
+	}
+
+	if p.peek() == '.' {
+		return 0, fmt.Errorf("decimals are not supported")
+	}
+
+	n, err := strconv.ParseInt(p.s[start:p.i], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer: %w", err)
+	}
+
+	return n, nil
+}
+
+func (p *sfParser) parseToken() (sfToken, error) {
+	start := p.i
+	for !p.eof() {
+		c := p.s[p.i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),;<=>?@[\]{}`, c) >= 0 {
+			break
+		}
+		p.i++
+	}
+
+	return sfToken(p.s[start:p.i]), nil
+}
+
+func serializeSFInnerList(l sfInnerList) string {
+	items := make([]string, 0, len(l.items))
+	for _, item := range l.items {
+		items = append(items, serializeSFBareItem(item.value)+serializeSFParams(item.params))
+	}
+
+	return "(" + strings.Join(items, " ") + ")" + serializeSFParams(l.params)
+}
+
+func serializeSFParams(params sfParams) string {
+	var b strings.Builder
+	for _, param := range params {
+		b.WriteString(";" + param.key)
+		if v, ok := param.value.(bool); ok && v {
+			continue
+		}
+		b.WriteString("=" + serializeSFBareItem(param.value))
+	}
+
+	return b.String()
+}
+
+func serializeSFBareItem(v any) string {
+	switch v := v.(type) {
+	case string:
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+	case sfToken:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		if v {
+			return "?1"
+		}
+		return "?0"
+	case []byte:
+		return ":" + base64.StdEncoding.EncodeToString(v) + ":"
+	}
+
+	return ""
+}
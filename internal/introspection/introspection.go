@@ -0,0 +1,197 @@
+// Package introspection gets the tokens from an OAuth2 token introspection (RFC 7662) endpoint.
+package introspection
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+	"github.com/slok/simple-ingress-external-auth/internal/tokencache"
+	"github.com/slok/simple-ingress-external-auth/internal/tokenhash"
+)
+
+// TokenGetterConfig is the configuration of the TokenGetter.
+type TokenGetterConfig struct {
+	// URL is the introspection endpoint.
+	URL string
+	// ClientID and ClientSecret are the client credentials used to authenticate on the
+	// introspection endpoint (HTTP Basic).
+	ClientID     string
+	ClientSecret string
+	HTTPClient   *http.Client
+	// CacheSize is the max number of tokens (including the inactive ones) kept in memory, 0 disables the cache.
+	CacheSize int
+	// CacheTTL is how long the active tokens are cached, never after their expiration.
+	CacheTTL time.Duration
+	// NegativeCacheTTL is how long the inactive tokens are cached.
+	NegativeCacheTTL time.Duration
+	Logger           log.Logger
+}
+
+func (c *TokenGetterConfig) defaults() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("introspection URL must be an HTTP(S) URL")
+	}
+
+	if c.ClientID == "" {
+		return fmt.Errorf("client ID is required")
+	}
+
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if c.CacheSize < 0 {
+		return fmt.Errorf("cache size can't be negative")
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Noop
+	}
+
+	return nil
+}
+
+// TokenGetter gets the tokens from an introspection endpoint. The active tokens are mapped to token
+// validations, and the inactive ones are handled as missing tokens.
+//
+// The introspection results are cached in memory (including the inactive ones). Introspection
+// errors are not handled as missing tokens.
+type TokenGetter struct {
+	url              string
+	clientID         string
+	clientSecret     string
+	client           *http.Client
+	cache            *tokencache.Cache
+	cacheTTL         time.Duration
+	negativeCacheTTL time.Duration
+	logger           log.Logger
+}
+
+// NewTokenGetter returns a new TokenGetter.
+func NewTokenGetter(config TokenGetterConfig) (*TokenGetter, error) {
+	err := config.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return &TokenGetter{
+		url:              config.URL,
+		clientID:         config.ClientID,
+		clientSecret:     config.ClientSecret,
+		client:           config.HTTPClient,
+		cache:            tokencache.New(config.CacheSize),
+		cacheTTL:         config.CacheTTL,
+		negativeCacheTTL: config.NegativeCacheTTL,
+		logger:           config.Logger.WithValues(log.Kv{"svc": "introspection.TokenGetter"}),
+	}, nil
+}
+
+func (t *TokenGetter) GetStaticTokenValidation(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error) {
+	hash := tokenhash.SHA256(tokenValue)
+
+	token, ok := t.cache.Get(hash)
+	if !ok {
+		var err error
+		token, err = t.introspect(ctx, tokenValue)
+		if err != nil {
+			return nil, err
+		}
+
+		if token != nil {
+			ttl := t.cacheTTL
+			if !token.ExpiresAt.IsZero() {
+				ttl = min(ttl, time.Until(token.ExpiresAt))
+			}
+			t.cache.Set(hash, token, ttl)
+		} else {
+			t.cache.Set(hash, nil, t.negativeCacheTTL)
+		}
+	}
+
+	if token == nil {
+		return nil, fmt.Errorf("token not active: %w", internalerrors.ErrNotFound)
+	}
+
+	// Don't share the cached token.
+	tk := *token
+	tk.Value = tokenValue
+
+	return &tk, nil
+}
+
+// introspectionResponse is the RFC 7662 introspection response, only the used fields.
+type introspectionResponse struct {
+	Active   bool   `json:"active"`
+	Scope    string `json:"scope"`
+	ClientID string `json:"client_id"`
+	Subject  string `json:"sub"`
+	Exp      int64  `json:"exp"`
+}
+
+// introspect returns nil if the token is not active.
+func (t *TokenGetter) introspect(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error) {
+	form := url.Values{}
+	form.Set("token", tokenValue)
+	form.Set("token_type_hint", "access_token")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("could not create introspection request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// The client credentials are form encoded before using them on Basic auth (RFC 6749 section 2.3.1).
+	req.SetBasicAuth(url.QueryEscape(t.clientID), url.QueryEscape(t.clientSecret))
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not introspect token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("introspection endpoint returned %d status code", resp.StatusCode)
+	}
+
+	var ir introspectionResponse
+	err = json.NewDecoder(resp.Body).Decode(&ir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid introspection response: %w", err)
+	}
+
+	if !ir.Active {
+		return nil, nil
+	}
+
+	clientID := ir.ClientID
+	if clientID == "" {
+		clientID = ir.Subject
+	}
+
+	var expiresAt time.Time
+	if ir.Exp > 0 {
+		expiresAt = time.Unix(ir.Exp, 0)
+	}
+
+	var scopes []string
+	if ir.Scope != "" {
+		scopes = strings.Fields(ir.Scope)
+	}
+
+	return &model.StaticTokenValidation{
+		ClientID:  clientID,
+		ExpiresAt: expiresAt,
+		Scopes:    scopes,
+	}, nil
+}
@@ -0,0 +1,191 @@
+package introspection_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/introspection"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+)
+
+// testIntrospectionServer is an introspection endpoint that returns the responses of the
+// tokens, and tracks the requests.
+type testIntrospectionServer struct {
+	mu         sync.Mutex
+	tokens     map[string]map[string]any
+	statusCode int
+	requests   int
+}
+
+func (t *testIntrospectionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests++
+
+	if t.statusCode != 0 {
+		w.WriteHeader(t.statusCode)
+		return
+	}
+
+	user, pass, ok := r.BasicAuth()
+	if r.Method != http.MethodPost || !ok || user != "client%3A0" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	resp, ok := t.tokens[r.PostFormValue("token")]
+	if !ok {
+		resp = map[string]any{"active": false}
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func TestTokenGetter(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	tests := map[string]struct {
+		config      introspection.TokenGetterConfig
+		tokens      map[string]map[string]any
+		statusCode  int
+		getBefore   []string
+		change      func(s *testIntrospectionServer)
+		token       string
+		expToken    *model.StaticTokenValidation
+		expErr      error
+		expAnyErr   bool
+		expRequests int
+	}{
+		"An active token should be mapped.": {
+			tokens: map[string]map[string]any{
+				"t0": {"active": true, "client_id": "c0", "scope": "billing:read billing:write", "exp": exp.Unix()},
+			},
+			token: "t0",
+			expToken: &model.StaticTokenValidation{
+				Value:     "t0",
+				ClientID:  "c0",
+				ExpiresAt: exp,
+				Scopes:    []string{"billing:read", "billing:write"},
+			},
+			expRequests: 1,
+		},
+
+		"An active token without client ID should use the subject.": {
+			tokens: map[string]map[string]any{
+				"t0": {"active": true, "sub": "user0"},
+			},
+			token:       "t0",
+			expToken:    &model.StaticTokenValidation{Value: "t0", ClientID: "user0"},
+			expRequests: 1,
+		},
+
+		"An inactive token should return not found.": {
+			tokens: map[string]map[string]any{
+				"t0": {"active": false, "client_id": "c0"},
+			},
+			token:       "t0",
+			expErr:      internalerrors.ErrNotFound,
+			expRequests: 1,
+		},
+
+		"An introspection endpoint error should fail.": {
+			statusCode:  http.StatusInternalServerError,
+			token:       "t0",
+			expAnyErr:   true,
+			expRequests: 1,
+		},
+
+		"With cache, an active token should be cached.": {
+			config: introspection.TokenGetterConfig{CacheSize: 10, CacheTTL: time.Hour},
+			tokens: map[string]map[string]any{
+				"t0": {"active": true, "client_id": "c0"},
+			},
+			getBefore:   []string{"t0"},
+			change:      func(s *testIntrospectionServer) { s.tokens = nil },
+			token:       "t0",
+			expToken:    &model.StaticTokenValidation{Value: "t0", ClientID: "c0"},
+			expRequests: 1,
+		},
+
+		"With cache, an inactive token should be cached.": {
+			config:    introspection.TokenGetterConfig{CacheSize: 10, NegativeCacheTTL: time.Hour},
+			getBefore: []string{"t0"},
+			change: func(s *testIntrospectionServer) {
+				s.tokens = map[string]map[string]any{"t0": {"active": true, "client_id": "c0"}}
+			},
+			token:       "t0",
+			expErr:      internalerrors.ErrNotFound,
+			expRequests: 1,
+		},
+
+		"With cache, an active token should not be cached after its expiration.": {
+			config: introspection.TokenGetterConfig{CacheSize: 10, CacheTTL: time.Hour},
+			tokens: map[string]map[string]any{
+				"t0": {"active": true, "client_id": "c0", "exp": time.Now().Add(-time.Minute).Unix()},
+			},
+			getBefore:   []string{"t0"},
+			change:      func(s *testIntrospectionServer) { s.tokens = nil },
+			token:       "t0",
+			expErr:      internalerrors.ErrNotFound,
+			expRequests: 2,
+		},
+
+		"With cache, introspection errors should not be cached.": {
+			config:      introspection.TokenGetterConfig{CacheSize: 10, CacheTTL: time.Hour, NegativeCacheTTL: time.Hour},
+			statusCode:  http.StatusServiceUnavailable,
+			getBefore:   []string{"t0"},
+			change:      func(s *testIntrospectionServer) { s.statusCode = 0 },
+			token:       "t0",
+			expErr:      internalerrors.ErrNotFound,
+			expRequests: 2,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			srv := &testIntrospectionServer{tokens: test.tokens, statusCode: test.statusCode}
+			server := httptest.NewServer(srv)
+			defer server.Close()
+
+			test.config.URL = server.URL
+			test.config.ClientID = "client:0"
+			test.config.ClientSecret = "secret"
+			getter, err := introspection.NewTokenGetter(test.config)
+			require.NoError(err)
+
+			for _, token := range test.getBefore {
+				_, _ = getter.GetStaticTokenValidation(context.TODO(), token)
+			}
+
+			if test.change != nil {
+				srv.mu.Lock()
+				test.change(srv)
+				srv.mu.Unlock()
+			}
+
+			gotToken, err := getter.GetStaticTokenValidation(context.TODO(), test.token)
+			switch {
+			case test.expAnyErr:
+				assert.Error(err)
+			case test.expErr != nil:
+				assert.ErrorIs(err, test.expErr)
+			default:
+				require.NoError(err)
+				assert.Equal(test.expToken, gotToken)
+			}
+
+			assert.Equal(test.expRequests, srv.requests)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package jwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+)
+
+// Config is the JWT issuers configuration.
+type Config struct {
+	Issuers []IssuerConfig `json:"issuers"`
+}
+
+// IssuerConfig is the configuration of a trusted JWT issuer.
+type IssuerConfig struct {
+	// Issuer is the `iss` claim of the issuer tokens.
+	Issuer string `json:"issuer"`
+	// Audiences are the accepted `aud` claims, if empty the audience is not checked.
+	Audiences []string `json:"audiences,omitempty"`
+	// KeysFile is the JWKS or PEM file with the keys that verify the token signatures.
+	// Relative paths are relative to the configuration file.
+	KeysFile string `json:"keys_file,omitempty"`
+	// JWKSURL is the URL of the JWKS with the keys that verify the token signatures.
+	JWKSURL string `json:"jwks_url,omitempty"`
+	// DiscoveryURL is the OIDC discovery URL used to get the JWKS URL.
+	DiscoveryURL string `json:"discovery_url,omitempty"`
+	// ClientIDClaim is the claim used as the client ID, `sub` by default.
+	ClientIDClaim string `json:"client_id_claim,omitempty"`
+	// AllowMissingExpiry accepts the tokens without `exp` claim, they never expire.
+	AllowMissingExpiry bool `json:"allow_missing_expiry,omitempty"`
+	// AllowedURLRegex is the regex the request URL must match.
+	AllowedURLRegex string `json:"allowed_url,omitempty"`
+	// AllowedMethodRegex is the regex the request method must match.
+	AllowedMethodRegex string `json:"allowed_method,omitempty"`
+}
+
+// DecodeConfig decodes a JSON or YAML JWT issuers configuration.
+func DecodeConfig(data []byte) (*Config, error) {
+	c := &Config{}
+	err := json.Unmarshal(data, c)
+	if err != nil {
+		err = yaml.Unmarshal(data, c)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode JSON or YAML: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// IssuersOptions are the options used to create the issuers from the configuration.
+type IssuersOptions struct {
+	// BaseDir is the directory used to load the relative key files.
+	BaseDir string
+	// HTTPClient, RefreshInterval and MinRefetchInterval are used by the remote key sets.
+	HTTPClient         *http.Client
+	RefreshInterval    time.Duration
+	MinRefetchInterval time.Duration
+	Logger             log.Logger
+}
+
+// NewIssuersFromConfig returns the issuers of the configuration with their key sets. The file
+// keys are loaded and the remote keys are fetched lazily (see RemoteKeySet).
+func NewIssuersFromConfig(config Config, options IssuersOptions) ([]Issuer, error) {
+	issuers := make([]Issuer, 0, len(config.Issuers))
+	for _, ic := range config.Issuers {
+		if ic.Issuer == "" {
+			return nil, fmt.Errorf("issuer is required")
+		}
+
+		keys, err := newIssuerKeySet(ic, options)
+		if err != nil {
+			return nil, fmt.Errorf("issuer %q: %w", ic.Issuer, err)
+		}
+
+		i := Issuer{
+			Name:               ic.Issuer,
+			Audiences:          ic.Audiences,
+			Keys:               keys,
+			ClientIDClaim:      ic.ClientIDClaim,
+			AllowMissingExpiry: ic.AllowMissingExpiry,
+		}
+
+		if ic.AllowedURLRegex != "" {
+			i.AllowedURL, err = regexp.Compile(ic.AllowedURLRegex)
+			if err != nil {
+				return nil, fmt.Errorf("issuer %q: invalid allowed URL regex: %w", ic.Issuer, err)
+			}
+		}
+
+		if ic.AllowedMethodRegex != "" {
+			i.AllowedMethod, err = regexp.Compile(ic.AllowedMethodRegex)
+			if err != nil {
+				return nil, fmt.Errorf("issuer %q: invalid allowed method regex: %w", ic.Issuer, err)
+			}
+		}
+
+		issuers = append(issuers, i)
+	}
+
+	return issuers, nil
+}
+
+func newIssuerKeySet(ic IssuerConfig, options IssuersOptions) (KeySet, error) {
+	sources := 0
+	for _, s := range []string{ic.KeysFile, ic.JWKSURL, ic.DiscoveryURL} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, fmt.Errorf("one of keys file, JWKS URL or discovery URL is required")
+	}
+
+	if ic.KeysFile != "" {
+		keysFile := ic.KeysFile
+		if !filepath.IsAbs(keysFile) {
+			keysFile = filepath.Join(options.BaseDir, keysFile)
+		}
+
+		return NewFileKeySet(keysFile)
+	}
+
+	return NewRemoteKeySet(RemoteKeySetConfig{
+		JWKSURL:            ic.JWKSURL,
+		DiscoveryURL:       ic.DiscoveryURL,
+		Client:             options.HTTPClient,
+		RefreshInterval:    options.RefreshInterval,
+		MinRefetchInterval: options.MinRefetchInterval,
+		Logger:             options.Logger,
+	})
+}
@@ -0,0 +1,167 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	josejwt "github.com/go-jose/go-jose/v4/jwt"
+
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+)
+
+// SupportedAlgorithms are the JWT signature algorithms that can be verified.
+var SupportedAlgorithms = []jose.SignatureAlgorithm{jose.HS256, jose.RS256, jose.ES256, jose.EdDSA}
+
+// DefaultClientIDClaim is the default claim used as the client ID.
+const DefaultClientIDClaim = "sub"
+
+// Issuer is a trusted JWT issuer.
+type Issuer struct {
+	// Name is the `iss` claim of the issuer tokens.
+	Name string
+	// Audiences are the accepted `aud` claims, if empty the audience is not checked.
+	Audiences []string
+	// Keys are the keys used to verify the issuer tokens signatures.
+	Keys KeySet
+	// ClientIDClaim is the claim used as the client ID.
+	ClientIDClaim string
+	// AllowMissingExpiry accepts the tokens without `exp` claim, they never expire. By default
+	// they are rejected.
+	AllowMissingExpiry bool
+	// AllowedURL and AllowedMethod are used to validate all the issuer tokens.
+	AllowedURL    *regexp.Regexp
+	AllowedMethod *regexp.Regexp
+}
+
+// TokenGetter gets token validations from JWTs issued by trusted issuers, instead of storing
+// the tokens, the JWT signature and claims are verified.
+//
+// The tokens that are not JWTs or are not valid are handled as missing tokens. The JWT expiration
+// is returned as the token expiration, so it's handled as any other token.
+type TokenGetter struct {
+	issuers map[string]Issuer
+	now     func() time.Time
+	logger  log.Logger
+}
+
+// NewTokenGetter returns a new TokenGetter.
+func NewTokenGetter(logger log.Logger, issuers ...Issuer) (*TokenGetter, error) {
+	is := map[string]Issuer{}
+	for _, i := range issuers {
+		if i.Name == "" {
+			return nil, fmt.Errorf("issuer name is required")
+		}
+
+		if i.Keys == nil {
+			return nil, fmt.Errorf("issuer %q keys are required", i.Name)
+		}
+
+		if _, ok := is[i.Name]; ok {
+			return nil, fmt.Errorf("issuer %q has been declared multiple times", i.Name)
+		}
+
+		if i.ClientIDClaim == "" {
+			i.ClientIDClaim = DefaultClientIDClaim
+		}
+
+		is[i.Name] = i
+	}
+
+	return &TokenGetter{
+		issuers: is,
+		now:     time.Now,
+		logger:  logger.WithValues(log.Kv{"svc": "jwt.TokenGetter"}),
+	}, nil
+}
+
+func (t *TokenGetter) GetStaticTokenValidation(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error) {
+	// Fast path for non JWT tokens.
+	if strings.Count(tokenValue, ".") != 2 {
+		return nil, fmt.Errorf("not a JWT: %w", internalerrors.ErrNotFound)
+	}
+
+	token, err := josejwt.ParseSigned(tokenValue, SupportedAlgorithms)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT: %w", internalerrors.ErrNotFound)
+	}
+
+	// Get the issuer before verifying, so we know what keys we need.
+	unsafeClaims := josejwt.Claims{}
+	err = token.UnsafeClaimsWithoutVerification(&unsafeClaims)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT claims: %w", internalerrors.ErrNotFound)
+	}
+
+	issuer, ok := t.issuers[unsafeClaims.Issuer]
+	if !ok {
+		return nil, fmt.Errorf("unknown JWT issuer: %w", internalerrors.ErrNotFound)
+	}
+
+	claims, customClaims, err := t.verify(ctx, issuer, token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Expiration is checked by the authentication, as any other token.
+	err = claims.ValidateWithLeeway(josejwt.Expected{
+		Issuer:      issuer.Name,
+		AnyAudience: issuer.Audiences,
+		Time:        t.now(),
+	}, josejwt.DefaultLeeway)
+	if err != nil && !errors.Is(err, josejwt.ErrExpired) {
+		t.logger.WithValues(log.Kv{"issuer": issuer.Name}).Debugf("Invalid JWT claims: %s", err)
+		return nil, fmt.Errorf("invalid JWT claims: %s: %w", err, internalerrors.ErrNotFound)
+	}
+
+	// The tokens without expiration would be valid forever.
+	if claims.Expiry == nil && !issuer.AllowMissingExpiry {
+		t.logger.WithValues(log.Kv{"issuer": issuer.Name}).Debugf("Invalid JWT claims: missing exp claim")
+		return nil, fmt.Errorf("invalid JWT claims: missing exp claim: %w", internalerrors.ErrNotFound)
+	}
+
+	clientID, _ := customClaims[issuer.ClientIDClaim].(string)
+	v := &model.StaticTokenValidation{
+		Value:    tokenValue,
+		ClientID: clientID,
+		Common: model.TokenCommon{
+			AllowedURL:    issuer.AllowedURL,
+			AllowedMethod: issuer.AllowedMethod,
+		},
+	}
+	if claims.Expiry != nil {
+		v.ExpiresAt = claims.Expiry.Time()
+	}
+
+	return v, nil
+}
+
+func (t *TokenGetter) verify(ctx context.Context, issuer Issuer, token *josejwt.JSONWebToken) (*josejwt.Claims, map[string]any, error) {
+	keyID := ""
+	if len(token.Headers) > 0 {
+		keyID = token.Headers[0].KeyID
+	}
+
+	keys, err := issuer.Keys.Keys(ctx, keyID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get issuer %q keys: %w", issuer.Name, err)
+	}
+
+	for _, key := range keys {
+		claims := &josejwt.Claims{}
+		customClaims := map[string]any{}
+		err := token.Claims(key.Key, claims, &customClaims)
+		if err == nil {
+			return claims, customClaims, nil
+		}
+	}
+
+	t.logger.WithValues(log.Kv{"issuer": issuer.Name, "kid": keyID}).Debugf("Invalid JWT signature")
+	return nil, nil, fmt.Errorf("invalid JWT signature: %w", internalerrors.ErrNotFound)
+}
@@ -0,0 +1,344 @@
+package jwt_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	josejwt "github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/jwt"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+)
+
+type testKeys struct {
+	hmac  []byte
+	rsa   *rsa.PrivateKey
+	ecdsa *ecdsa.PrivateKey
+	ed    ed25519.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hmacKey := make([]byte, 32)
+	_, err = rand.Read(hmacKey)
+	require.NoError(t, err)
+
+	return testKeys{
+		hmac:  hmacKey,
+		rsa:   rsaKey,
+		ecdsa: ecdsaKey,
+		ed:    edKey,
+	}
+}
+
+func signToken(t *testing.T, alg jose.SignatureAlgorithm, key any, kid string, claims ...any) string {
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if kid != "" {
+		opts = opts.WithHeader(jose.HeaderKey("kid"), kid)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, opts)
+	require.NoError(t, err)
+
+	b := josejwt.Signed(signer)
+	for _, c := range claims {
+		b = b.Claims(c)
+	}
+	token, err := b.Serialize()
+	require.NoError(t, err)
+
+	return token
+}
+
+func TestTokenGetter(t *testing.T) {
+	keys := newTestKeys(t)
+	otherKeys := newTestKeys(t)
+	now := time.Now().Truncate(time.Second)
+
+	keySet := jwt.NewStaticKeySet(
+		jose.JSONWebKey{Key: keys.hmac, KeyID: "hmac"},
+		jose.JSONWebKey{Key: keys.rsa.Public(), KeyID: "rsa"},
+		jose.JSONWebKey{Key: keys.ecdsa.Public(), KeyID: "ecdsa"},
+		jose.JSONWebKey{Key: keys.ed.Public(), KeyID: "ed"},
+	)
+
+	issuers := []jwt.Issuer{
+		{
+			Name:          "https://ci.slok.dev",
+			Audiences:     []string{"simple-ingress-external-auth"},
+			Keys:          keySet,
+			ClientIDClaim: "repository",
+			AllowedURL:    regexp.MustCompile(`^https://slok\.dev/.*$`),
+			AllowedMethod: regexp.MustCompile(`^GET$`),
+		},
+		{
+			Name: "https://other.slok.dev",
+			Keys: keySet,
+		},
+		{
+			Name:               "https://unbounded.slok.dev",
+			Keys:               keySet,
+			AllowMissingExpiry: true,
+		},
+	}
+
+	claims := func(iss string, aud ...string) josejwt.Claims {
+		return josejwt.Claims{
+			Issuer:    iss,
+			Subject:   "test-sub",
+			Audience:  aud,
+			IssuedAt:  josejwt.NewNumericDate(now),
+			NotBefore: josejwt.NewNumericDate(now.Add(-time.Minute)),
+			Expiry:    josejwt.NewNumericDate(now.Add(time.Hour)),
+		}
+	}
+	ciClaims := claims("https://ci.slok.dev", "simple-ingress-external-auth")
+	customClaims := map[string]any{"repository": "slok/simple-ingress-external-auth"}
+
+	expCIToken := func(token string) *model.StaticTokenValidation {
+		return &model.StaticTokenValidation{
+			Value:     token,
+			ClientID:  "slok/simple-ingress-external-auth",
+			ExpiresAt: now.Add(time.Hour),
+			Common: model.TokenCommon{
+				AllowedURL:    regexp.MustCompile(`^https://slok\.dev/.*$`),
+				AllowedMethod: regexp.MustCompile(`^GET$`),
+			},
+		}
+	}
+
+	tests := map[string]struct {
+		token    func() string
+		expToken func(token string) *model.StaticTokenValidation
+		expErr   error
+	}{
+		"A HS256 signed token should be valid.": {
+			token:    func() string { return signToken(t, jose.HS256, keys.hmac, "hmac", ciClaims, customClaims) },
+			expToken: expCIToken,
+		},
+
+		"A RS256 signed token should be valid.": {
+			token:    func() string { return signToken(t, jose.RS256, keys.rsa, "rsa", ciClaims, customClaims) },
+			expToken: expCIToken,
+		},
+
+		"A ES256 signed token should be valid.": {
+			token:    func() string { return signToken(t, jose.ES256, keys.ecdsa, "ecdsa", ciClaims, customClaims) },
+			expToken: expCIToken,
+		},
+
+		"A EdDSA signed token should be valid.": {
+			token:    func() string { return signToken(t, jose.EdDSA, keys.ed, "ed", ciClaims, customClaims) },
+			expToken: expCIToken,
+		},
+
+		"A token without key ID should be verified with all the keys.": {
+			token:    func() string { return signToken(t, jose.ES256, keys.ecdsa, "", ciClaims, customClaims) },
+			expToken: expCIToken,
+		},
+
+		"A token should use the sub claim as the client ID by default.": {
+			token: func() string { return signToken(t, jose.RS256, keys.rsa, "rsa", claims("https://other.slok.dev")) },
+			expToken: func(token string) *model.StaticTokenValidation {
+				return &model.StaticTokenValidation{Value: token, ClientID: "test-sub", ExpiresAt: now.Add(time.Hour)}
+			},
+		},
+
+		"An expired token should be returned with its expiration.": {
+			token: func() string {
+				c := ciClaims
+				c.Expiry = josejwt.NewNumericDate(now.Add(-time.Hour))
+				return signToken(t, jose.RS256, keys.rsa, "rsa", c, customClaims)
+			},
+			expToken: func(token string) *model.StaticTokenValidation {
+				exp := expCIToken(token)
+				exp.ExpiresAt = now.Add(-time.Hour)
+				return exp
+			},
+		},
+
+		"A token without expiration should be missing.": {
+			token: func() string {
+				c := ciClaims
+				c.Expiry = nil
+				return signToken(t, jose.RS256, keys.rsa, "rsa", c, customClaims)
+			},
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A token without expiration of an issuer that allows it should not expire.": {
+			token: func() string {
+				c := claims("https://unbounded.slok.dev")
+				c.Expiry = nil
+				return signToken(t, jose.RS256, keys.rsa, "rsa", c)
+			},
+			expToken: func(token string) *model.StaticTokenValidation {
+				return &model.StaticTokenValidation{Value: token, ClientID: "test-sub"}
+			},
+		},
+
+		"A token signed with an unknown key should be missing.": {
+			token:  func() string { return signToken(t, jose.RS256, otherKeys.rsa, "rsa", ciClaims, customClaims) },
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A token signed with an unknown key without key ID should be missing.": {
+			token:  func() string { return signToken(t, jose.HS256, otherKeys.hmac, "", ciClaims, customClaims) },
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A token from an unknown issuer should be missing.": {
+			token:  func() string { return signToken(t, jose.RS256, keys.rsa, "rsa", claims("https://evil.slok.dev")) },
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A token with an invalid audience should be missing.": {
+			token: func() string {
+				return signToken(t, jose.RS256, keys.rsa, "rsa", claims("https://ci.slok.dev", "other"))
+			},
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A token that is not valid yet should be missing.": {
+			token: func() string {
+				c := ciClaims
+				c.NotBefore = josejwt.NewNumericDate(now.Add(time.Hour))
+				return signToken(t, jose.RS256, keys.rsa, "rsa", c, customClaims)
+			},
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A token with an unsupported algorithm should be missing.": {
+			token:  func() string { return signToken(t, jose.RS512, keys.rsa, "rsa", ciClaims, customClaims) },
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A regular token should be missing.": {
+			token:  func() string { return "gmMCgSWCDzuBKxznnH7+vCajFnhRIK1+sTRvGJI2g1I=" },
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A JWT looking invalid token should be missing.": {
+			token:  func() string { return "a.b.c" },
+			expErr: internalerrors.ErrNotFound,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			g, err := jwt.NewTokenGetter(log.Noop, issuers...)
+			require.NoError(err)
+
+			token := test.token()
+			gotToken, err := g.GetStaticTokenValidation(context.TODO(), token)
+			if test.expErr != nil {
+				assert.ErrorIs(err, test.expErr)
+				return
+			}
+			require.NoError(err)
+
+			exp := test.expToken(token)
+			assert.True(exp.ExpiresAt.Equal(gotToken.ExpiresAt))
+			exp.ExpiresAt = gotToken.ExpiresAt
+			assert.Equal(exp, gotToken)
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys := newTestKeys(t)
+
+	rsaDER, err := x509.MarshalPKIXPublicKey(keys.rsa.Public())
+	require.NoError(t, err)
+	ecdsaDER, err := x509.MarshalPKIXPublicKey(keys.ecdsa.Public())
+	require.NoError(t, err)
+
+	pemKeys := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaDER})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: ecdsaDER}))
+
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: keys.rsa.Public(), KeyID: "rsa", Algorithm: string(jose.RS256), Use: "sig"},
+		{Key: keys.ed.Public(), KeyID: "ed", Algorithm: string(jose.EdDSA), Use: "sig"},
+	}})
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		data      string
+		expKeyIDs []string
+		expKeys   []any
+		expErr    bool
+	}{
+		"A JWKS should be parsed.": {
+			data:      string(jwks),
+			expKeyIDs: []string{"rsa", "ed"},
+			expKeys:   []any{keys.rsa.Public(), keys.ed.Public()},
+		},
+
+		"PEM public keys should be parsed.": {
+			data:      pemKeys,
+			expKeyIDs: []string{"", ""},
+			expKeys:   []any{keys.rsa.Public(), keys.ecdsa.Public()},
+		},
+
+		"An empty JWKS should fail.": {
+			data:   `{"keys": []}`,
+			expErr: true,
+		},
+
+		"An unsupported PEM block should fail.": {
+			data:   string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("x")})),
+			expErr: true,
+		},
+
+		"Invalid data should fail.": {
+			data:   "something",
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			gotKeys, err := jwt.ParseKeys([]byte(test.data))
+			if test.expErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+
+			var gotKeyIDs []string
+			var gotKeyValues []any
+			for _, k := range gotKeys {
+				gotKeyIDs = append(gotKeyIDs, k.KeyID)
+				gotKeyValues = append(gotKeyValues, k.Key)
+			}
+			assert.Equal(test.expKeyIDs, gotKeyIDs)
+			assert.Equal(test.expKeys, gotKeyValues)
+		})
+	}
+}
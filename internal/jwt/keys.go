@@ -0,0 +1,119 @@
+package jwt
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// KeySet knows how to get the keys used to verify the JWT signatures.
+type KeySet interface {
+	// Keys returns the keys that match the key ID, if the key ID is empty, all the keys.
+	Keys(ctx context.Context, keyID string) ([]jose.JSONWebKey, error)
+}
+
+// StaticKeySet is a KeySet that always has the same keys.
+type StaticKeySet struct {
+	keys []jose.JSONWebKey
+}
+
+// NewStaticKeySet returns a new StaticKeySet.
+func NewStaticKeySet(keys ...jose.JSONWebKey) StaticKeySet {
+	return StaticKeySet{keys: keys}
+}
+
+func (s StaticKeySet) Keys(ctx context.Context, keyID string) ([]jose.JSONWebKey, error) {
+	return filterKeys(s.keys, keyID), nil
+}
+
+// NewFileKeySet returns a StaticKeySet with the keys of a JWKS or PEM file.
+func NewFileKeySet(path string) (StaticKeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return StaticKeySet{}, fmt.Errorf("could not read keys file: %w", err)
+	}
+
+	keys, err := ParseKeys(data)
+	if err != nil {
+		return StaticKeySet{}, fmt.Errorf("could not parse %s keys file: %w", path, err)
+	}
+
+	return NewStaticKeySet(keys...), nil
+}
+
+// ParseKeys parses a JWKS (JSON) or PEM encoded public keys and certificates. PEM keys don't
+// have key ID.
+func ParseKeys(data []byte) ([]jose.JSONWebKey, error) {
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		jwks := jose.JSONWebKeySet{}
+		err := json.Unmarshal(data, &jwks)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS: %w", err)
+		}
+
+		if len(jwks.Keys) == 0 {
+			return nil, fmt.Errorf("JWKS without keys")
+		}
+
+		return jwks.Keys, nil
+	}
+
+	var keys []jose.JSONWebKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key any
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid PEM %q: %w", block.Type, err)
+		}
+
+		keys = append(keys, jose.JSONWebKey{Key: key})
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no JWKS or PEM keys found")
+	}
+
+	return keys, nil
+}
+
+func filterKeys(keys []jose.JSONWebKey, keyID string) []jose.JSONWebKey {
+	if keyID == "" {
+		return keys
+	}
+
+	var res []jose.JSONWebKey
+	for _, k := range keys {
+		// Keys without ID can be used with any key ID.
+		if k.KeyID == keyID || k.KeyID == "" {
+			res = append(res, k)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,294 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+)
+
+// RemoteKeySetConfig is the configuration of the remote key set.
+type RemoteKeySetConfig struct {
+	// JWKSURL is the URL of the JWKS, required if DiscoveryURL is missing.
+	JWKSURL string
+	// DiscoveryURL is the OIDC discovery URL (e.g `https://issuer/.well-known/openid-configuration`)
+	// used to get the JWKS URL, required if JWKSURL is missing.
+	DiscoveryURL string
+	// Client is the HTTP client used to fetch the keys.
+	Client *http.Client
+	// RefreshInterval is the interval used to refresh the keys.
+	RefreshInterval time.Duration
+	// MinRefetchInterval is the minimum time between fetches triggered by unknown key IDs,
+	// so random key IDs can't be used to flood the issuer.
+	MinRefetchInterval time.Duration
+	// MaxDocumentSize is the max size of the fetched JWKS and discovery documents in bytes.
+	MaxDocumentSize int64
+	Logger          log.Logger
+}
+
+func (c *RemoteKeySetConfig) defaults() error {
+	if (c.JWKSURL == "") == (c.DiscoveryURL == "") {
+		return fmt.Errorf("one of JWKS URL or discovery URL is required")
+	}
+
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = time.Hour
+	}
+
+	if c.MinRefetchInterval == 0 {
+		c.MinRefetchInterval = time.Minute
+	}
+
+	if c.MaxDocumentSize == 0 {
+		c.MaxDocumentSize = 1024 * 1024
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Noop
+	}
+
+	return nil
+}
+
+// RemoteKeySet is a KeySet that fetches the keys from a JWKS URL (directly or using OIDC discovery).
+//
+// The keys are cached and refreshed periodically (see Run), and when a token uses an unknown
+// key ID (e.g the issuer rotated its keys). If a fetch fails the last known keys are kept.
+//
+// The keys are fetched without blocking the readers, the cached keys are served while a fetch
+// is in progress and the concurrent fetches are deduplicated.
+type RemoteKeySet struct {
+	jwksURL            string
+	discoveryURL       string
+	client             *http.Client
+	refreshInterval    time.Duration
+	minRefetchInterval time.Duration
+	maxDocumentSize    int64
+	now                func() time.Time
+	logger             log.Logger
+
+	mu      sync.RWMutex
+	keys    []jose.JSONWebKey
+	fetched bool
+
+	fetchMu   sync.Mutex
+	lastFetch time.Time
+	inflight  *fetchCall
+}
+
+// fetchCall is a fetch in progress, shared by the concurrent callers.
+type fetchCall struct {
+	done chan struct{}
+	err  error
+}
+
+// NewRemoteKeySet returns a new RemoteKeySet.
+func NewRemoteKeySet(config RemoteKeySetConfig) (*RemoteKeySet, error) {
+	err := config.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	url := config.JWKSURL
+	if url == "" {
+		url = config.DiscoveryURL
+	}
+
+	return &RemoteKeySet{
+		jwksURL:            config.JWKSURL,
+		discoveryURL:       config.DiscoveryURL,
+		client:             config.Client,
+		refreshInterval:    config.RefreshInterval,
+		minRefetchInterval: config.MinRefetchInterval,
+		maxDocumentSize:    config.MaxDocumentSize,
+		now:                time.Now,
+		logger:             config.Logger.WithValues(log.Kv{"svc": "jwt.RemoteKeySet", "url": url}),
+	}, nil
+}
+
+func (r *RemoteKeySet) Keys(ctx context.Context, keyID string) ([]jose.JSONWebKey, error) {
+	keys, fetched := r.cachedKeys()
+
+	// First use, we don't have keys yet.
+	if !fetched {
+		err := r.refetch(ctx)
+		keys, fetched = r.cachedKeys()
+		if err != nil && !fetched {
+			return nil, fmt.Errorf("could not fetch keys: %w", err)
+		}
+	}
+
+	filtered := filterKeys(keys, keyID)
+	if len(filtered) > 0 || keyID == "" {
+		return filtered, nil
+	}
+
+	// Unknown key ID, the keys could have been rotated.
+	err := r.refetch(ctx)
+	if err != nil {
+		r.logger.Warningf("Could not refetch keys for unknown key ID %q: %s", keyID, err)
+	}
+
+	keys, _ = r.cachedKeys()
+
+	return filterKeys(keys, keyID), nil
+}
+
+func (r *RemoteKeySet) cachedKeys() ([]jose.JSONWebKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.keys, r.fetched
+}
+
+// refetch fetches the keys if the rate limit allows it, a fetch in progress is always shared.
+func (r *RemoteKeySet) refetch(ctx context.Context) error {
+	return r.fetchShared(ctx, true)
+}
+
+// Refresh fetches the keys, on failure the last known keys are kept.
+func (r *RemoteKeySet) Refresh(ctx context.Context) error {
+	return r.fetchShared(ctx, false)
+}
+
+// fetchShared fetches the keys, the concurrent callers share the same fetch.
+func (r *RemoteKeySet) fetchShared(ctx context.Context, rateLimited bool) error {
+	r.fetchMu.Lock()
+	if c := r.inflight; c != nil {
+		r.fetchMu.Unlock()
+		select {
+		case <-c.done:
+			return c.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if rateLimited && !r.lastFetch.IsZero() && r.now().Sub(r.lastFetch) < r.minRefetchInterval {
+		since := r.now().Sub(r.lastFetch)
+		r.fetchMu.Unlock()
+		return fmt.Errorf("keys fetched %s ago, rate limited", since.Round(time.Second))
+	}
+
+	c := &fetchCall{done: make(chan struct{})}
+	r.inflight = c
+	r.lastFetch = r.now()
+	r.fetchMu.Unlock()
+
+	// The fetch is shared, it shouldn't be cancelled by the caller that started it, the HTTP
+	// client timeout limits it.
+	c.err = r.fetch(context.WithoutCancel(ctx))
+
+	r.fetchMu.Lock()
+	r.inflight = nil
+	r.fetchMu.Unlock()
+	close(c.done)
+
+	return c.err
+}
+
+// fetch fetches the keys and swaps them. It must be called only from fetchShared, so only one fetch runs at the same time.
+func (r *RemoteKeySet) fetch(ctx context.Context) error {
+	// Discover the JWKS URL once, it doesn't change.
+	if r.jwksURL == "" {
+		discovery := struct {
+			JWKSURI string `json:"jwks_uri"`
+		}{}
+		err := r.getJSON(ctx, r.discoveryURL, &discovery)
+		if err != nil {
+			return fmt.Errorf("could not get OIDC discovery: %w", err)
+		}
+
+		if discovery.JWKSURI == "" {
+			return fmt.Errorf("OIDC discovery without jwks_uri")
+		}
+		r.jwksURL = discovery.JWKSURI
+	}
+
+	jwks := jose.JSONWebKeySet{}
+	err := r.getJSON(ctx, r.jwksURL, &jwks)
+	if err != nil {
+		return fmt.Errorf("could not get JWKS: %w", err)
+	}
+
+	if len(jwks.Keys) == 0 {
+		return fmt.Errorf("JWKS without keys")
+	}
+
+	r.mu.Lock()
+	r.keys = jwks.Keys
+	r.fetched = true
+	r.mu.Unlock()
+	r.logger.Debugf("%d keys fetched", len(jwks.Keys))
+
+	return nil
+}
+
+func (r *RemoteKeySet) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	// Limited, so a broken issuer can't exhaust the memory.
+	data, err := io.ReadAll(io.LimitReader(resp.Body, r.maxDocumentSize+1))
+	if err != nil {
+		return fmt.Errorf("could not read response: %w", err)
+	}
+	if int64(len(data)) > r.maxDocumentSize {
+		return fmt.Errorf("response is bigger than %d bytes", r.maxDocumentSize)
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	return nil
+}
+
+// Run will fetch the keys and refresh them periodically until the context is cancelled.
+func (r *RemoteKeySet) Run(ctx context.Context) error {
+	r.logger.Infof("Refreshing JWT keys every %s", r.refreshInterval)
+
+	refresh := func() {
+		err := r.Refresh(ctx)
+		if err != nil {
+			r.logger.Errorf("Could not refresh keys, keeping the previous ones: %s", err)
+		}
+	}
+
+	refresh()
+	t := time.NewTicker(r.refreshInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			refresh()
+		}
+	}
+}
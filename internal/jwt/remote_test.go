@@ -0,0 +1,286 @@
+package jwt_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/simple-ingress-external-auth/internal/jwt"
+)
+
+// testJWKSServer serves an OIDC discovery and a JWKS with the key IDs, and tracks the requests.
+type testJWKSServer struct {
+	mu         sync.Mutex
+	url        string
+	keyIDs     []string
+	statusCode int
+	requests   int
+}
+
+func (t *testJWKSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.statusCode != 0 {
+		w.WriteHeader(t.statusCode)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		_ = json.NewEncoder(w).Encode(map[string]string{"jwks_uri": t.url + "/jwks"})
+	case "/jwks":
+		t.requests++
+		jwks := jose.JSONWebKeySet{}
+		for _, kid := range t.keyIDs {
+			jwks.Keys = append(jwks.Keys, jose.JSONWebKey{Key: []byte("secret-" + kid), KeyID: kid, Algorithm: string(jose.HS256)})
+		}
+		_ = json.NewEncoder(w).Encode(jwks)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	type step struct {
+		server    func(s *testJWKSServer)
+		refresh   bool
+		keyID     string
+		expKeyIDs []string
+		expErr    bool
+	}
+
+	tests := map[string]struct {
+		discovery          bool
+		minRefetchInterval time.Duration
+		maxDocumentSize    int64
+		steps              []step
+		expRequests        int
+	}{
+		"The keys should be fetched on first use and cached.": {
+			steps: []step{
+				{server: func(s *testJWKSServer) { s.keyIDs = []string{"k1", "k2"} }, keyID: "k1", expKeyIDs: []string{"k1"}},
+				{keyID: "k2", expKeyIDs: []string{"k2"}},
+				{keyID: "", expKeyIDs: []string{"k1", "k2"}},
+			},
+			expRequests: 1,
+		},
+
+		"The keys should be fetched using OIDC discovery.": {
+			discovery: true,
+			steps: []step{
+				{server: func(s *testJWKSServer) { s.keyIDs = []string{"k1"} }, keyID: "k1", expKeyIDs: []string{"k1"}},
+			},
+			expRequests: 1,
+		},
+
+		"An unknown key ID should refetch the keys.": {
+			minRefetchInterval: time.Nanosecond,
+			steps: []step{
+				{server: func(s *testJWKSServer) { s.keyIDs = []string{"k1"} }, keyID: "k1", expKeyIDs: []string{"k1"}},
+				{server: func(s *testJWKSServer) { s.keyIDs = []string{"k2"} }, keyID: "k2", expKeyIDs: []string{"k2"}},
+				{keyID: "k3", expKeyIDs: nil},
+			},
+			expRequests: 3,
+		},
+
+		"An unknown key ID refetch should be rate limited.": {
+			minRefetchInterval: time.Hour,
+			steps: []step{
+				{server: func(s *testJWKSServer) { s.keyIDs = []string{"k1"} }, keyID: "k1", expKeyIDs: []string{"k1"}},
+				{server: func(s *testJWKSServer) { s.keyIDs = []string{"k2"} }, keyID: "k2", expKeyIDs: nil},
+				{keyID: "k3", expKeyIDs: nil},
+			},
+			expRequests: 1,
+		},
+
+		"The refresh should update the keys.": {
+			minRefetchInterval: time.Hour,
+			steps: []step{
+				{server: func(s *testJWKSServer) { s.keyIDs = []string{"k1"} }, keyID: "k1", expKeyIDs: []string{"k1"}},
+				{server: func(s *testJWKSServer) { s.keyIDs = []string{"k2"} }, refresh: true, keyID: "k2", expKeyIDs: []string{"k2"}},
+				{keyID: "k1", expKeyIDs: nil},
+			},
+			expRequests: 2,
+		},
+
+		"A failed refresh should keep the last known keys.": {
+			steps: []step{
+				{server: func(s *testJWKSServer) { s.keyIDs = []string{"k1"} }, keyID: "k1", expKeyIDs: []string{"k1"}},
+				{server: func(s *testJWKSServer) { s.statusCode = http.StatusInternalServerError }, refresh: true, keyID: "k1", expKeyIDs: []string{"k1"}, expErr: true},
+			},
+			expRequests: 1,
+		},
+
+		"A JWKS bigger than the max document size should fail.": {
+			maxDocumentSize: 64,
+			steps: []step{
+				{server: func(s *testJWKSServer) { s.keyIDs = []string{"k1", "k2", "k3"} }, keyID: "k1", expErr: true},
+			},
+			expRequests: 1,
+		},
+
+		"A failed first fetch should fail.": {
+			steps: []step{
+				{server: func(s *testJWKSServer) { s.statusCode = http.StatusInternalServerError }, keyID: "k1", expErr: true},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			s := &testJWKSServer{}
+			server := httptest.NewServer(s)
+			defer server.Close()
+			s.url = server.URL
+
+			config := jwt.RemoteKeySetConfig{MinRefetchInterval: test.minRefetchInterval, MaxDocumentSize: test.maxDocumentSize}
+			if test.discovery {
+				config.DiscoveryURL = server.URL + "/.well-known/openid-configuration"
+			} else {
+				config.JWKSURL = server.URL + "/jwks"
+			}
+			keySet, err := jwt.NewRemoteKeySet(config)
+			require.NoError(err)
+
+			for _, step := range test.steps {
+				if step.server != nil {
+					s.mu.Lock()
+					step.server(s)
+					s.mu.Unlock()
+				}
+
+				var err error
+				if step.refresh {
+					err = keySet.Refresh(context.TODO())
+				}
+
+				keys, kErr := keySet.Keys(context.TODO(), step.keyID)
+				if err == nil {
+					err = kErr
+				}
+				if step.expErr {
+					assert.Error(err)
+				} else {
+					assert.NoError(err)
+				}
+
+				var gotKeyIDs []string
+				for _, k := range keys {
+					gotKeyIDs = append(gotKeyIDs, k.KeyID)
+				}
+				assert.Equal(step.expKeyIDs, gotKeyIDs)
+			}
+
+			assert.Equal(test.expRequests, s.requests)
+		})
+	}
+}
+
+func TestRemoteKeySetSlowFetch(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var requests atomic.Int32
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first fetch is served, the next ones wait until they are unblocked.
+		if requests.Add(1) > 1 {
+			<-block
+		}
+		jwks := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: []byte("secret-k1"), KeyID: "k1", Algorithm: string(jose.HS256)}}}
+		_ = json.NewEncoder(w).Encode(jwks)
+	}))
+	defer server.Close()
+
+	keySet, err := jwt.NewRemoteKeySet(jwt.RemoteKeySetConfig{JWKSURL: server.URL, MinRefetchInterval: time.Hour})
+	require.NoError(err)
+
+	_, err = keySet.Keys(context.TODO(), "k1")
+	require.NoError(err)
+
+	// Start a blocked refresh and multiple unknown key ID refetches, the refetches share the refresh
+	// fetch or are rate limited.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = keySet.Refresh(context.TODO())
+	}()
+	assert.Eventually(func() bool { return requests.Load() == 2 }, 5*time.Second, 10*time.Millisecond)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = keySet.Keys(context.TODO(), "unknown")
+		}()
+	}
+
+	// The cached keys should be served while fetching.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		keys, err := keySet.Keys(context.TODO(), "k1")
+		assert.NoError(err)
+		assert.Len(keys, 1)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cached keys blocked by the fetch")
+	}
+
+	close(block)
+	wg.Wait()
+
+	// The concurrent fetches should be shared.
+	assert.Equal(int32(2), requests.Load())
+}
+
+func TestRemoteKeySetConcurrentFirstUse(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var requests atomic.Int32
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-block
+		jwks := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: []byte("secret-k1"), KeyID: "k1", Algorithm: string(jose.HS256)}}}
+		_ = json.NewEncoder(w).Encode(jwks)
+	}))
+	defer server.Close()
+
+	keySet, err := jwt.NewRemoteKeySet(jwt.RemoteKeySetConfig{JWKSURL: server.URL, MinRefetchInterval: time.Hour})
+	require.NoError(err)
+
+	// All the first use callers should wait for the same fetch instead of being rate limited.
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys, err := keySet.Keys(context.TODO(), "k1")
+			assert.NoError(err)
+			assert.Len(keys, 1)
+		}()
+	}
+	assert.Eventually(func() bool { return requests.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	close(block)
+	wg.Wait()
+
+	assert.Equal(int32(1), requests.Load())
+}
@@ -0,0 +1,308 @@
+package lint
+
+import (
+	"fmt"
+	"math"
+	"regexp/syntax"
+	"sort"
+	"time"
+
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+	apiv1 "github.com/slok/simple-ingress-external-auth/pkg/api/v1"
+)
+
+// Lint rules.
+const (
+	RuleExpired         = "expired"
+	RuleExpiresSoon     = "expires-soon"
+	RuleShortToken      = "short-token"
+	RuleLowEntropy      = "low-entropy"
+	RuleMissingClientID = "missing-client-id"
+	RuleUnanchoredRegex = "unanchored-regex"
+	RuleDuplicatedRegex = "duplicated-regex"
+)
+
+// Rules are all the lint rules.
+var Rules = []string{
+	RuleExpired,
+	RuleExpiresSoon,
+	RuleShortToken,
+	RuleLowEntropy,
+	RuleMissingClientID,
+	RuleUnanchoredRegex,
+	RuleDuplicatedRegex,
+}
+
+// Config is the linter configuration.
+type Config struct {
+	// Now is the time used to check the expirations.
+	Now time.Time
+	// ExpiryWindow is the window used to flag the tokens that will expire soon, if nil, 7 days.
+	ExpiryWindow *time.Duration
+	// MinLength is the minimum length of the plain token values, if nil, 16.
+	MinLength *int
+	// MinEntropyBits is the minimum estimated entropy of the plain token values, if nil, 48.
+	MinEntropyBits *float64
+	// MaxRegexDuplicates is the max number of tokens that can use the same regex, if nil, 5.
+	MaxRegexDuplicates *int
+	// DisabledRules are the rules that will be ignored.
+	DisabledRules []string
+}
+
+func (c *Config) defaults() error {
+	if c.Now.IsZero() {
+		c.Now = time.Now()
+	}
+
+	// The zero values are valid settings (e.g a 0 min length), so only the unset ones are defaulted.
+	if c.ExpiryWindow == nil {
+		d := 7 * 24 * time.Hour
+		c.ExpiryWindow = &d
+	}
+	if *c.ExpiryWindow < 0 {
+		return fmt.Errorf("expiry window can't be negative")
+	}
+
+	if c.MinLength == nil {
+		l := 16
+		c.MinLength = &l
+	}
+	if *c.MinLength < 0 {
+		return fmt.Errorf("min length can't be negative")
+	}
+
+	if c.MinEntropyBits == nil {
+		b := 48.0
+		c.MinEntropyBits = &b
+	}
+	if *c.MinEntropyBits < 0 {
+		return fmt.Errorf("min entropy bits can't be negative")
+	}
+
+	if c.MaxRegexDuplicates == nil {
+		n := 5
+		c.MaxRegexDuplicates = &n
+	}
+	if *c.MaxRegexDuplicates < 0 {
+		return fmt.Errorf("max regex duplicates can't be negative")
+	}
+
+	for _, r := range c.DisabledRules {
+		if !isRule(r) {
+			return fmt.Errorf("unknown rule %q", r)
+		}
+	}
+
+	return nil
+}
+
+func isRule(rule string) bool {
+	for _, r := range Rules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// Source is a token configuration to lint.
+type Source struct {
+	// Config is the configuration, the v2 configurations are flattened to v1.
+	Config apiv1.Config
+	// TokenPositions are the v2 source positions of the flattened tokens, empty on v1.
+	TokenPositions []memory.TokenPosition
+}
+
+// Finding is a lint rule violation.
+type Finding struct {
+	Source string `json:"source,omitempty"`
+	// TokenIndex is the index of the v1 token on the configuration, -1 if the finding is not
+	// related with a single v1 token.
+	TokenIndex int `json:"token_index"`
+	// ClientIndex and CredentialIndex are the indexes of the v2 client and its credential, -1 if
+	// the finding is not related with a single v2 credential.
+	ClientIndex     int    `json:"client_index"`
+	CredentialIndex int    `json:"credential_index"`
+	ClientID        string `json:"client_id,omitempty"`
+	Rule            string `json:"rule"`
+	Message         string `json:"message"`
+}
+
+func (f Finding) String() string {
+	msg := fmt.Sprintf("%s: %s", f.Rule, f.Message)
+	switch {
+	case f.ClientIndex >= 0:
+		msg = fmt.Sprintf("client %d credential %d (client_id: %q): %s", f.ClientIndex, f.CredentialIndex, f.ClientID, msg)
+	case f.TokenIndex >= 0:
+		msg = fmt.Sprintf("token %d (client_id: %q): %s", f.TokenIndex, f.ClientID, msg)
+	}
+
+	if f.Source != "" {
+		msg = f.Source + ": " + msg
+	}
+
+	return msg
+}
+
+// Lint lints the tokens of the configurations (identified by their source name). The disabled
+// tokens are ignored.
+func Lint(config Config, configs map[string]Source) ([]Finding, error) {
+	err := config.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	disabled := map[string]bool{}
+	for _, r := range config.DisabledRules {
+		disabled[r] = true
+	}
+
+	// Be deterministic.
+	sources := make([]string, 0, len(configs))
+	for source := range configs {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	findings := []Finding{}
+	add := func(f Finding) {
+		if !disabled[f.Rule] {
+			findings = append(findings, f)
+		}
+	}
+
+	type regexKey struct{ field, regex string }
+	regexUsage := map[regexKey]int{}
+	for _, source := range sources {
+		positions := configs[source].TokenPositions
+		for i, t := range configs[source].Config.Tokens {
+			if t.Disable {
+				continue
+			}
+
+			newFinding := func(rule, msg string, args ...any) Finding {
+				f := Finding{Source: source, TokenIndex: i, ClientIndex: -1, CredentialIndex: -1, ClientID: t.ClientID, Rule: rule, Message: fmt.Sprintf(msg, args...)}
+				if i < len(positions) {
+					f.TokenIndex = -1
+					f.ClientIndex = positions[i].ClientIndex
+					f.CredentialIndex = positions[i].CredentialIndex
+				}
+				return f
+			}
+
+			if t.ExpiresAt != nil {
+				switch {
+				case t.ExpiresAt.Before(config.Now):
+					add(newFinding(RuleExpired, "token expired at %s", t.ExpiresAt.Format(time.RFC3339)))
+				case t.ExpiresAt.Before(config.Now.Add(*config.ExpiryWindow)):
+					add(newFinding(RuleExpiresSoon, "token expires at %s", t.ExpiresAt.Format(time.RFC3339)))
+				}
+			}
+
+			// Hashed tokens can't be checked.
+			if t.Value != "" {
+				if len(t.Value) < *config.MinLength {
+					add(newFinding(RuleShortToken, "token length is %d, expected at least %d", len(t.Value), *config.MinLength))
+				}
+
+				if bits := entropyBits(t.Value); bits < *config.MinEntropyBits {
+					add(newFinding(RuleLowEntropy, "token estimated entropy is %.0f bits, expected at least %.0f", bits, *config.MinEntropyBits))
+				}
+			}
+
+			if t.ClientID == "" {
+				add(newFinding(RuleMissingClientID, "token doesn't have a client ID"))
+			}
+
+			for field, regex := range map[string]string{"allowed_url": t.AllowedURLRegex, "allowed_method": t.AllowedMethodRegex} {
+				if regex == "" {
+					continue
+				}
+				regexUsage[regexKey{field: field, regex: regex}]++
+
+				if !isAnchored(regex) {
+					add(newFinding(RuleUnanchoredRegex, "%s regex %q is not anchored (^...$), it will match partially", field, regex))
+				}
+			}
+		}
+	}
+
+	// The map iteration of the regex fields is random.
+	sort.SliceStable(findings, func(i, j int) bool {
+		fi, fj := findings[i], findings[j]
+		if fi.Source != fj.Source {
+			return fi.Source < fj.Source
+		}
+		if fi.ClientIndex != fj.ClientIndex {
+			return fi.ClientIndex < fj.ClientIndex
+		}
+		if fi.CredentialIndex != fj.CredentialIndex {
+			return fi.CredentialIndex < fj.CredentialIndex
+		}
+		if fi.TokenIndex != fj.TokenIndex {
+			return fi.TokenIndex < fj.TokenIndex
+		}
+		return fi.Message < fj.Message
+	})
+
+	// Sort to be deterministic.
+	regexes := make([]regexKey, 0, len(regexUsage))
+	for r := range regexUsage {
+		regexes = append(regexes, r)
+	}
+	sort.Slice(regexes, func(i, j int) bool {
+		if regexes[i].field != regexes[j].field {
+			return regexes[i].field < regexes[j].field
+		}
+		return regexes[i].regex < regexes[j].regex
+	})
+	for _, r := range regexes {
+		if n := regexUsage[r]; n > *config.MaxRegexDuplicates {
+			add(Finding{TokenIndex: -1, ClientIndex: -1, CredentialIndex: -1, Rule: RuleDuplicatedRegex, Message: fmt.Sprintf("%s regex %q is used by %d tokens, consider grouping them in a v2 client", r.field, r.regex, n)})
+		}
+	}
+
+	return findings, nil
+}
+
+// isAnchored checks that the whole regex is anchored at the beginning and the end, e.g `^GET|POST$`
+// is not anchored, as the anchors only apply to each alternative.
+func isAnchored(regex string) bool {
+	re, err := syntax.Parse(regex, syntax.Perl)
+	if err != nil {
+		return false
+	}
+
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 {
+		return false
+	}
+
+	first, last := re.Sub[0].Op, re.Sub[len(re.Sub)-1].Op
+	start := first == syntax.OpBeginText || first == syntax.OpBeginLine
+	end := last == syntax.OpEndText || last == syntax.OpEndLine
+
+	return start && end
+}
+
+// entropyBits estimates the entropy of a token using its Shannon entropy per character
+// multiplied by its length.
+func entropyBits(s string) float64 {
+	if s == "" {
+		return 0
+	}
+
+	counts := map[rune]int{}
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+
+	var perChar float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		perChar -= p * math.Log2(p)
+	}
+
+	return perChar * float64(n)
+}
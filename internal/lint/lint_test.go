@@ -0,0 +1,185 @@
+package lint_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/simple-ingress-external-auth/internal/lint"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+	apiv1 "github.com/slok/simple-ingress-external-auth/pkg/api/v1"
+)
+
+const goodToken = "gmMCgSWCDzuBKxznnH7+vCajFnhRIK1+sTRvGJI2g1I="
+
+func ptr[T any](v T) *T { return &v }
+
+func TestLint(t *testing.T) {
+	now := time.Date(2022, 7, 4, 0, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		config      lint.Config
+		configs     map[string]lint.Source
+		expFindings []lint.Finding
+		expErr      bool
+	}{
+		"A good token should not have findings.": {
+			configs: map[string]lint.Source{
+				"a.json": {Config: apiv1.Config{Tokens: []apiv1.Token{
+					{Value: goodToken, ClientID: "c0", ExpiresAt: ptr(now.Add(30 * 24 * time.Hour)), Common: apiv1.Common{AllowedMethodRegex: "^(GET|POST)$", AllowedURLRegex: `^https://slok\.dev/.*$`}},
+				}}},
+			},
+			expFindings: []lint.Finding{},
+		},
+
+		"Risky tokens should have findings.": {
+			configs: map[string]lint.Source{
+				"a.json": {Config: apiv1.Config{Tokens: []apiv1.Token{
+					{Value: goodToken, ClientID: "c0", ExpiresAt: ptr(now.Add(-time.Hour))},
+					{Value: goodToken, ClientID: "c1", ExpiresAt: ptr(now.Add(time.Hour))},
+					{Value: "aaaaaaaaaaaaaaaaaaaaaaaa", ClientID: "c2"},
+					{Value: "1234", ClientID: "c3"},
+				}}},
+				"b.json": {Config: apiv1.Config{Tokens: []apiv1.Token{
+					{ValueHash: "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"},
+					{Value: goodToken, ClientID: "c5", Common: apiv1.Common{AllowedMethodRegex: "GET"}},
+					{Value: goodToken, ClientID: "c6", Common: apiv1.Common{AllowedMethodRegex: "^GET|POST$"}},
+					{Value: "1234", ClientID: "c7", Common: apiv1.Common{Disable: true}},
+				}}},
+			},
+			expFindings: []lint.Finding{
+				{Source: "a.json", TokenIndex: 0, ClientIndex: -1, CredentialIndex: -1, ClientID: "c0", Rule: "expired", Message: "token expired at 2022-07-03T23:00:00Z"},
+				{Source: "a.json", TokenIndex: 1, ClientIndex: -1, CredentialIndex: -1, ClientID: "c1", Rule: "expires-soon", Message: "token expires at 2022-07-04T01:00:00Z"},
+				{Source: "a.json", TokenIndex: 2, ClientIndex: -1, CredentialIndex: -1, ClientID: "c2", Rule: "low-entropy", Message: "token estimated entropy is 0 bits, expected at least 48"},
+				{Source: "a.json", TokenIndex: 3, ClientIndex: -1, CredentialIndex: -1, ClientID: "c3", Rule: "low-entropy", Message: "token estimated entropy is 8 bits, expected at least 48"},
+				{Source: "a.json", TokenIndex: 3, ClientIndex: -1, CredentialIndex: -1, ClientID: "c3", Rule: "short-token", Message: "token length is 4, expected at least 16"},
+				{Source: "b.json", TokenIndex: 0, ClientIndex: -1, CredentialIndex: -1, Rule: "missing-client-id", Message: "token doesn't have a client ID"},
+				{Source: "b.json", TokenIndex: 1, ClientIndex: -1, CredentialIndex: -1, ClientID: "c5", Rule: "unanchored-regex", Message: `allowed_method regex "GET" is not anchored (^...$), it will match partially`},
+				{Source: "b.json", TokenIndex: 2, ClientIndex: -1, CredentialIndex: -1, ClientID: "c6", Rule: "unanchored-regex", Message: `allowed_method regex "^GET|POST$" is not anchored (^...$), it will match partially`},
+			},
+		},
+
+		"The v2 token findings should have the client and credential positions.": {
+			configs: map[string]lint.Source{
+				"a.json": {
+					Config: apiv1.Config{Tokens: []apiv1.Token{
+						{Value: goodToken, ClientID: "c0"},
+						{Value: "1234", ClientID: "c1"},
+					}},
+					TokenPositions: []memory.TokenPosition{
+						{ClientIndex: 0, CredentialIndex: 0},
+						{ClientIndex: 1, CredentialIndex: 2},
+					},
+				},
+			},
+			expFindings: []lint.Finding{
+				{Source: "a.json", TokenIndex: -1, ClientIndex: 1, CredentialIndex: 2, ClientID: "c1", Rule: "low-entropy", Message: "token estimated entropy is 8 bits, expected at least 48"},
+				{Source: "a.json", TokenIndex: -1, ClientIndex: 1, CredentialIndex: 2, ClientID: "c1", Rule: "short-token", Message: "token length is 4, expected at least 16"},
+			},
+		},
+
+		"Disabled rules should be ignored.": {
+			config: lint.Config{DisabledRules: []string{lint.RuleShortToken, lint.RuleLowEntropy}},
+			configs: map[string]lint.Source{
+				"a.json": {Config: apiv1.Config{Tokens: []apiv1.Token{
+					{Value: "1234", ClientID: "c0"},
+				}}},
+			},
+			expFindings: []lint.Finding{},
+		},
+
+		"Zero thresholds should be used instead of the defaults.": {
+			config: lint.Config{ExpiryWindow: ptr(time.Duration(0)), MinLength: ptr(0), MinEntropyBits: ptr(0.0)},
+			configs: map[string]lint.Source{
+				"a.json": {Config: apiv1.Config{Tokens: []apiv1.Token{
+					{Value: "1", ClientID: "c0", ExpiresAt: ptr(now.Add(time.Hour))},
+				}}},
+			},
+			expFindings: []lint.Finding{},
+		},
+
+		"A zero max regex duplicates should flag every used regex.": {
+			config: lint.Config{MaxRegexDuplicates: ptr(0)},
+			configs: map[string]lint.Source{
+				"a.json": {Config: apiv1.Config{Tokens: []apiv1.Token{
+					{Value: goodToken, ClientID: "c0", Common: apiv1.Common{AllowedMethodRegex: "^GET$"}},
+				}}},
+			},
+			expFindings: []lint.Finding{
+				{TokenIndex: -1, ClientIndex: -1, CredentialIndex: -1, Rule: "duplicated-regex", Message: `allowed_method regex "^GET$" is used by 1 tokens, consider grouping them in a v2 client`},
+			},
+		},
+
+		"Negative thresholds should fail.": {
+			config: lint.Config{MinLength: ptr(-1)},
+			expErr: true,
+		},
+
+		"Unknown disabled rules should fail.": {
+			config: lint.Config{DisabledRules: []string{"something"}},
+			expErr: true,
+		},
+
+		"Regexes duplicated on many tokens should have findings.": {
+			config: lint.Config{MaxRegexDuplicates: ptr(1)},
+			configs: map[string]lint.Source{
+				"a.json": {Config: apiv1.Config{Tokens: []apiv1.Token{
+					{Value: goodToken + "0", ClientID: "c0", Common: apiv1.Common{AllowedMethodRegex: "^GET$"}},
+					{Value: goodToken + "1", ClientID: "c1", Common: apiv1.Common{AllowedMethodRegex: "^GET$"}},
+					{Value: goodToken + "2", ClientID: "c2", Common: apiv1.Common{AllowedMethodRegex: "^POST$"}},
+				}}},
+			},
+			expFindings: []lint.Finding{
+				{TokenIndex: -1, ClientIndex: -1, CredentialIndex: -1, Rule: "duplicated-regex", Message: `allowed_method regex "^GET$" is used by 2 tokens, consider grouping them in a v2 client`},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			config := test.config
+			config.Now = now
+			findings, err := lint.Lint(config, test.configs)
+			if test.expErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+
+			assert.Equal(test.expFindings, findings)
+		})
+	}
+}
+
+func TestFindingString(t *testing.T) {
+	tests := map[string]struct {
+		finding lint.Finding
+		exp     string
+	}{
+		"A v1 token finding should have the token position.": {
+			finding: lint.Finding{TokenIndex: 3, ClientIndex: -1, CredentialIndex: -1, ClientID: "c3", Rule: "short-token", Message: "msg"},
+			exp:     `token 3 (client_id: "c3"): short-token: msg`,
+		},
+
+		"A v2 token finding should have the client and credential positions.": {
+			finding: lint.Finding{TokenIndex: -1, ClientIndex: 1, CredentialIndex: 2, ClientID: "c1", Rule: "short-token", Message: "msg"},
+			exp:     `client 1 credential 2 (client_id: "c1"): short-token: msg`,
+		},
+
+		"A finding without token should not have position.": {
+			finding: lint.Finding{TokenIndex: -1, ClientIndex: -1, CredentialIndex: -1, Rule: "duplicated-regex", Message: "msg"},
+			exp:     `duplicated-regex: msg`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.exp, test.finding.String())
+		})
+	}
+}
@@ -8,7 +8,10 @@ import (
 )
 
 type Recorder interface {
-	TokenReview(ctx context.Context, success, valid bool, clientID, invalidReason string)
+	TokenReview(ctx context.Context, tenant string, success, valid bool, clientID, invalidReason string)
+	TokenConfigReload(ctx context.Context, tenant string, success bool, configHash string)
+	TokenConfigFetch(ctx context.Context, result string, duration time.Duration)
+	TokenSecretIgnored(ctx context.Context, reason string)
 
 	// Metrics.
 	httpmetrics.Recorder
@@ -18,7 +21,12 @@ type noop bool
 
 const Noop = noop(false)
 
-func (noop) TokenReview(ctx context.Context, success, valid bool, clientID, invalidReason string) {}
+func (noop) TokenReview(ctx context.Context, tenant string, success, valid bool, clientID, invalidReason string) {
+}
+func (noop) TokenConfigReload(ctx context.Context, tenant string, success bool, configHash string) {
+}
+func (noop) TokenConfigFetch(ctx context.Context, result string, duration time.Duration) {}
+func (noop) TokenSecretIgnored(ctx context.Context, reason string)                       {}
 func (noop) ObserveHTTPRequestDuration(ctx context.Context, h httpmetrics.HTTPReqProperties, t time.Duration) {
 }
 func (noop) ObserveHTTPResponseSize(ctx context.Context, h httpmetrics.HTTPReqProperties, t int64) {}
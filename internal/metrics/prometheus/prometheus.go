@@ -3,6 +3,7 @@ package prometheus
 import (
 	"context"
 	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	httpmetrics "github.com/slok/go-http-metrics/metrics"
@@ -14,7 +15,13 @@ const prefix = "simple_ingress_external_auth"
 type Recorder struct {
 	httpmetrics.Recorder
 
-	tokenReview *prometheus.CounterVec
+	tokenReview           *prometheus.CounterVec
+	tokenConfigReload     *prometheus.CounterVec
+	tokenConfigLastReload *prometheus.GaugeVec
+	tokenConfigInfo       *prometheus.GaugeVec
+	tokenConfigFetch      *prometheus.CounterVec
+	tokenConfigFetchDur   *prometheus.HistogramVec
+	tokenSecretIgnored    *prometheus.CounterVec
 }
 
 func NewRecorder(reg prometheus.Registerer) Recorder {
@@ -32,20 +39,92 @@ func NewRecorder(reg prometheus.Registerer) Recorder {
 			Subsystem: "token",
 			Name:      "reviews_total",
 			Help:      "The number of token reviews.",
-		}, []string{"success", "valid", "client_id", "invalid_reason"}),
+		}, []string{"tenant", "success", "valid", "client_id", "invalid_reason"}),
+
+		tokenConfigReload: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Subsystem: "token_config",
+			Name:      "reloads_total",
+			Help:      "The number of token configuration reloads.",
+		}, []string{"tenant", "success"}),
+
+		tokenConfigLastReload: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Subsystem: "token_config",
+			Name:      "last_reload_success_timestamp_seconds",
+			Help:      "The timestamp of the last successful token configuration reload.",
+		}, []string{"tenant"}),
+
+		tokenConfigInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Subsystem: "token_config",
+			Name:      "info",
+			Help:      "Information of the currently loaded token configuration.",
+		}, []string{"tenant", "hash"}),
+
+		tokenConfigFetch: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Subsystem: "token_config",
+			Name:      "fetches_total",
+			Help:      "The number of remote token configuration fetches.",
+		}, []string{"result"}),
+
+		tokenConfigFetchDur: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: prefix,
+			Subsystem: "token_config",
+			Name:      "fetch_duration_seconds",
+			Help:      "The duration of the remote token configuration fetches.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"result"}),
+
+		tokenSecretIgnored: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Subsystem: "kubernetes_token_secrets",
+			Name:      "ignored_total",
+			Help:      "The number of Kubernetes token Secrets ignored when loading the tokens.",
+		}, []string{"reason"}),
 	}
 
 	reg.MustRegister(
 		r.tokenReview,
+		r.tokenConfigReload,
+		r.tokenConfigLastReload,
+		r.tokenConfigInfo,
+		r.tokenConfigFetch,
+		r.tokenConfigFetchDur,
+		r.tokenSecretIgnored,
 	)
 
 	return r
 }
 
-func (r Recorder) TokenReview(ctx context.Context, success, valid bool, clientID, invalidReason string) {
+func (r Recorder) TokenReview(ctx context.Context, tenant string, success, valid bool, clientID, invalidReason string) {
 	r.tokenReview.WithLabelValues(
+		tenant,
 		strconv.FormatBool(success),
 		strconv.FormatBool(valid),
 		clientID,
 		invalidReason).Inc()
 }
+
+func (r Recorder) TokenConfigReload(ctx context.Context, tenant string, success bool, configHash string) {
+	r.tokenConfigReload.WithLabelValues(tenant, strconv.FormatBool(success)).Inc()
+	if !success {
+		return
+	}
+
+	r.tokenConfigLastReload.WithLabelValues(tenant).SetToCurrentTime()
+
+	// Only the loaded configuration of the tenant should be present.
+	r.tokenConfigInfo.DeletePartialMatch(prometheus.Labels{"tenant": tenant})
+	r.tokenConfigInfo.WithLabelValues(tenant, configHash).Set(1)
+}
+
+func (r Recorder) TokenConfigFetch(ctx context.Context, result string, duration time.Duration) {
+	r.tokenConfigFetch.WithLabelValues(result).Inc()
+	r.tokenConfigFetchDur.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+func (r Recorder) TokenSecretIgnored(ctx context.Context, reason string) {
+	r.tokenSecretIgnored.WithLabelValues(reason).Inc()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
@@ -14,27 +15,93 @@ import (
 
 func TestRecorder(t *testing.T) {
 	tests := map[string]struct {
-		measure    func(r metricsprometheus.Recorder)
-		expMetrics string
+		measure        func(r metricsprometheus.Recorder)
+		expMetrics     string
+		expMetricNames []string
 	}{
 		"Measure token reviews.": {
 			measure: func(r metricsprometheus.Recorder) {
-				r.TokenReview(context.TODO(), true, true, "client1", "")
-				r.TokenReview(context.TODO(), true, true, "client1", "")
-				r.TokenReview(context.TODO(), false, false, "client1", "")
-				r.TokenReview(context.TODO(), true, false, "client1", "something")
-				r.TokenReview(context.TODO(), true, false, "client1", "otherthing")
-				r.TokenReview(context.TODO(), true, false, "client2", "otherthing")
+				r.TokenReview(context.TODO(), "", true, true, "client1", "")
+				r.TokenReview(context.TODO(), "", true, true, "client1", "")
+				r.TokenReview(context.TODO(), "", false, false, "client1", "")
+				r.TokenReview(context.TODO(), "", true, false, "client1", "something")
+				r.TokenReview(context.TODO(), "", true, false, "client1", "otherthing")
+				r.TokenReview(context.TODO(), "tenant1", true, false, "client2", "otherthing")
 			},
 			expMetrics: `
 				# HELP simple_ingress_external_auth_token_reviews_total The number of token reviews.
 				# TYPE simple_ingress_external_auth_token_reviews_total counter
-				simple_ingress_external_auth_token_reviews_total{client_id="client1",invalid_reason="",success="false",valid="false"} 1
-				simple_ingress_external_auth_token_reviews_total{client_id="client1",invalid_reason="",success="true",valid="true"} 2
-				simple_ingress_external_auth_token_reviews_total{client_id="client1",invalid_reason="otherthing",success="true",valid="false"} 1
-				simple_ingress_external_auth_token_reviews_total{client_id="client2",invalid_reason="otherthing",success="true",valid="false"} 1
-				simple_ingress_external_auth_token_reviews_total{client_id="client1",invalid_reason="something",success="true",valid="false"} 1
+				simple_ingress_external_auth_token_reviews_total{client_id="client1",invalid_reason="",success="false",tenant="",valid="false"} 1
+				simple_ingress_external_auth_token_reviews_total{client_id="client1",invalid_reason="",success="true",tenant="",valid="true"} 2
+				simple_ingress_external_auth_token_reviews_total{client_id="client1",invalid_reason="otherthing",success="true",tenant="",valid="false"} 1
+				simple_ingress_external_auth_token_reviews_total{client_id="client2",invalid_reason="otherthing",success="true",tenant="tenant1",valid="false"} 1
+				simple_ingress_external_auth_token_reviews_total{client_id="client1",invalid_reason="something",success="true",tenant="",valid="false"} 1
 			`,
+			expMetricNames: []string{
+				"simple_ingress_external_auth_token_reviews_total",
+			},
+		},
+
+		"Measure token config reloads.": {
+			measure: func(r metricsprometheus.Recorder) {
+				r.TokenConfigReload(context.TODO(), "", true, "hash1")
+				r.TokenConfigReload(context.TODO(), "tenant1", true, "hash5")
+				r.TokenConfigReload(context.TODO(), "", false, "hash2")
+				r.TokenConfigReload(context.TODO(), "", true, "hash3")
+				r.TokenConfigReload(context.TODO(), "", false, "hash4")
+			},
+			expMetrics: `
+				# HELP simple_ingress_external_auth_token_config_info Information of the currently loaded token configuration.
+				# TYPE simple_ingress_external_auth_token_config_info gauge
+				simple_ingress_external_auth_token_config_info{hash="hash3",tenant=""} 1
+				simple_ingress_external_auth_token_config_info{hash="hash5",tenant="tenant1"} 1
+
+				# HELP simple_ingress_external_auth_token_config_reloads_total The number of token configuration reloads.
+				# TYPE simple_ingress_external_auth_token_config_reloads_total counter
+				simple_ingress_external_auth_token_config_reloads_total{success="false",tenant=""} 2
+				simple_ingress_external_auth_token_config_reloads_total{success="true",tenant=""} 2
+				simple_ingress_external_auth_token_config_reloads_total{success="true",tenant="tenant1"} 1
+			`,
+			expMetricNames: []string{
+				"simple_ingress_external_auth_token_config_info",
+				"simple_ingress_external_auth_token_config_reloads_total",
+			},
+		},
+
+		"Measure token config fetches.": {
+			measure: func(r metricsprometheus.Recorder) {
+				r.TokenConfigFetch(context.TODO(), "updated", 10*time.Millisecond)
+				r.TokenConfigFetch(context.TODO(), "not_modified", 10*time.Millisecond)
+				r.TokenConfigFetch(context.TODO(), "not_modified", 10*time.Millisecond)
+				r.TokenConfigFetch(context.TODO(), "error", 10*time.Millisecond)
+			},
+			expMetrics: `
+				# HELP simple_ingress_external_auth_token_config_fetches_total The number of remote token configuration fetches.
+				# TYPE simple_ingress_external_auth_token_config_fetches_total counter
+				simple_ingress_external_auth_token_config_fetches_total{result="error"} 1
+				simple_ingress_external_auth_token_config_fetches_total{result="not_modified"} 2
+				simple_ingress_external_auth_token_config_fetches_total{result="updated"} 1
+			`,
+			expMetricNames: []string{
+				"simple_ingress_external_auth_token_config_fetches_total",
+			},
+		},
+
+		"Measure ignored Kubernetes token Secrets.": {
+			measure: func(r metricsprometheus.Recorder) {
+				r.TokenSecretIgnored(context.TODO(), "invalid")
+				r.TokenSecretIgnored(context.TODO(), "conflict")
+				r.TokenSecretIgnored(context.TODO(), "conflict")
+			},
+			expMetrics: `
+				# HELP simple_ingress_external_auth_kubernetes_token_secrets_ignored_total The number of Kubernetes token Secrets ignored when loading the tokens.
+				# TYPE simple_ingress_external_auth_kubernetes_token_secrets_ignored_total counter
+				simple_ingress_external_auth_kubernetes_token_secrets_ignored_total{reason="conflict"} 2
+				simple_ingress_external_auth_kubernetes_token_secrets_ignored_total{reason="invalid"} 1
+			`,
+			expMetricNames: []string{
+				"simple_ingress_external_auth_kubernetes_token_secrets_ignored_total",
+			},
 		},
 	}
 
@@ -48,7 +115,7 @@ func TestRecorder(t *testing.T) {
 			test.measure(rec)
 
 			// Check metrics.
-			err := testutil.GatherAndCompare(reg, strings.NewReader(test.expMetrics))
+			err := testutil.GatherAndCompare(reg, strings.NewReader(test.expMetrics), test.expMetricNames...)
 			assert.NoError(err)
 		})
 	}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"crypto/x509"
 	"regexp"
 	"time"
 )
@@ -12,6 +13,14 @@ type StaticTokenValidation struct {
 	ClientID  string
 	ExpiresAt time.Time
 	Common    TokenCommon
+	// Scopes are the scopes granted to the token.
+	Scopes []string
+	// Groups are the groups of the token client (e.g Kubernetes user groups).
+	Groups []string
+	// Kubernetes is set when the token can authenticate the Kubernetes API server users.
+	Kubernetes bool
+	// SignatureKey is the key that must have signed the request, only on HTTP message signature reviews.
+	SignatureKey *SignatureKey
 }
 
 type TokenCommon struct {
@@ -19,9 +28,65 @@ type TokenCommon struct {
 	AllowedMethod *regexp.Regexp
 }
 
+// UserValidation represents a Basic auth user information that can be used to validate an authentication.
+type UserValidation struct {
+	Username string
+	// PasswordHash is an htpasswd compatible password hash (bcrypt or SHA).
+	PasswordHash string
+	ExpiresAt    time.Time
+	Common       TokenCommon
+}
+
+// CertValidation represents a client certificate identity information that can be used to validate an authentication.
+type CertValidation struct {
+	ClientID  string
+	ExpiresAt time.Time
+	Common    TokenCommon
+}
+
+// SignatureKey is an HTTP message signature (RFC 9421) verification key.
+type SignatureKey struct {
+	ID        string
+	Algorithm string
+	// Key is the HMAC secret (`[]byte`) or the public key.
+	Key any
+}
+
+// SignatureKeyValidation represents an HTTP message signature key information that can be used to validate an authentication.
+type SignatureKeyValidation struct {
+	Key       SignatureKey
+	ClientID  string
+	ExpiresAt time.Time
+	Common    TokenCommon
+}
+
+// SignatureReview represents the HTTP message signature (RFC 9421) of a request to be reviewed.
+type SignatureReview struct {
+	KeyID string
+	// Algorithm is the algorithm declared by the signer, optional.
+	Algorithm string
+	Created   time.Time
+	Expires   time.Time
+	Nonce     string
+	// Components are the covered component identifiers (e.g `@method`).
+	Components []string
+	// Base is the signed data and Value its signature.
+	Base  []byte
+	Value []byte
+}
+
 // TokenReview represents an auth requests sent by the client to be reviewed.
 type TokenReview struct {
-	Token      string
+	Token string
+	// Username and Password are set instead of the token when using Basic auth.
+	Username string
+	Password string
+	// ClientCert is the client certificate verified by the proxy, if any.
+	ClientCert *x509.Certificate
+	// Signature is the HTTP message signature of the request, if any.
+	Signature  *SignatureReview
 	HTTPURL    string
 	HTTPMethod string
+	// RequiredScopes are the scopes that the token must have, if any.
+	RequiredScopes []string
 }
@@ -0,0 +1,355 @@
+// Package oidc has the OpenID Connect authorization code login pieces: the provider client and the
+// browser sessions.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	josejwt "github.com/go-jose/go-jose/v4/jwt"
+
+	"github.com/slok/simple-ingress-external-auth/internal/jwt"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+)
+
+// idTokenAlgorithms are the supported ID token signature algorithms.
+var idTokenAlgorithms = []jose.SignatureAlgorithm{jose.RS256, jose.ES256, jose.EdDSA}
+
+// DefaultGroupsClaim is the default ID token claim with the user groups.
+const DefaultGroupsClaim = "groups"
+
+// Identity is the logged in user identity.
+type Identity struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+}
+
+// ClientID returns the identity client ID, the email if present, otherwise the subject.
+func (i Identity) ClientID() string {
+	if i.Email != "" {
+		return i.Email
+	}
+
+	return i.Subject
+}
+
+// ProviderConfig is the configuration of the Provider.
+type ProviderConfig struct {
+	// IssuerURL is the OIDC issuer, its discovery document is at `<issuer>/.well-known/openid-configuration`.
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the login callback URL, it must be registered on the provider.
+	RedirectURL string
+	// Scopes are the requested scopes, by default `openid`, `email` and `profile`.
+	Scopes []string
+	// GroupsClaim is the ID token claim with the user groups.
+	GroupsClaim string
+	HTTPClient  *http.Client
+	Logger      log.Logger
+}
+
+func (c *ProviderConfig) defaults() error {
+	u, err := url.Parse(c.IssuerURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("issuer URL must be an HTTP(S) URL")
+	}
+
+	if c.ClientID == "" {
+		return fmt.Errorf("client ID is required")
+	}
+
+	if c.RedirectURL == "" {
+		return fmt.Errorf("redirect URL is required")
+	}
+
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	if c.GroupsClaim == "" {
+		c.GroupsClaim = DefaultGroupsClaim
+	}
+
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Noop
+	}
+
+	return nil
+}
+
+// discovery is the OIDC discovery document, only the used fields.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OIDC provider client that knows how to start the authorization code flow and
+// exchange the codes for verified identities.
+//
+// The provider is discovered on first use, so it doesn't need to be available on start. The ID
+// token keys are fetched lazily and refetched on unknown key IDs (see jwt.RemoteKeySet).
+type Provider struct {
+	issuerURL    string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	groupsClaim  string
+	client       *http.Client
+	now          func() time.Time
+	logger       log.Logger
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      jwt.KeySet
+}
+
+// NewProvider returns a new Provider.
+func NewProvider(config ProviderConfig) (*Provider, error) {
+	err := config.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return &Provider{
+		issuerURL:    strings.TrimSuffix(config.IssuerURL, "/"),
+		clientID:     config.ClientID,
+		clientSecret: config.ClientSecret,
+		redirectURL:  config.RedirectURL,
+		scopes:       config.Scopes,
+		groupsClaim:  config.GroupsClaim,
+		client:       config.HTTPClient,
+		now:          time.Now,
+		logger:       config.Logger.WithValues(log.Kv{"svc": "oidc.Provider", "issuer": config.IssuerURL}),
+	}, nil
+}
+
+// AuthCodeURL returns the provider URL where the users are redirected to log in. The code challenge
+// is the PKCE S256 challenge.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	d, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.clientID)
+	q.Set("redirect_uri", p.redirectURL)
+	q.Set("scope", strings.Join(p.scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// Exchange exchanges the authorization code for the tokens and returns the identity of the verified
+// ID token, the ID token must have the login nonce.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	d, keys, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("could not create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// The client credentials are form encoded before using them on Basic auth (RFC 6749 section 2.3.1).
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not exchange code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("token endpoint returned %d status code: %s", resp.StatusCode, body)
+	}
+
+	tokens := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token response without ID token")
+	}
+
+	return p.verifyIDToken(ctx, d, keys, tokens.IDToken, nonce)
+}
+
+func (p *Provider) verifyIDToken(ctx context.Context, d *discovery, keys jwt.KeySet, idToken, nonce string) (*Identity, error) {
+	token, err := josejwt.ParseSigned(idToken, idTokenAlgorithms)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	keyID := ""
+	if len(token.Headers) > 0 {
+		keyID = token.Headers[0].KeyID
+	}
+
+	ks, err := keys.Keys(ctx, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get provider keys: %w", err)
+	}
+
+	var claims *josejwt.Claims
+	customClaims := map[string]any{}
+	for _, key := range ks {
+		c := &josejwt.Claims{}
+		err := token.Claims(key.Key, c, &customClaims)
+		if err == nil {
+			claims = c
+			break
+		}
+	}
+	if claims == nil {
+		return nil, fmt.Errorf("invalid ID token signature")
+	}
+
+	if claims.Expiry == nil {
+		return nil, fmt.Errorf("ID token without expiration")
+	}
+
+	err = claims.ValidateWithLeeway(josejwt.Expected{
+		Issuer:      d.Issuer,
+		AnyAudience: []string{p.clientID},
+		Time:        p.now(),
+	}, josejwt.DefaultLeeway)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+
+	if n, _ := customClaims["nonce"].(string); n == "" || n != nonce {
+		return nil, fmt.Errorf("invalid ID token nonce")
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("ID token without subject")
+	}
+
+	id := &Identity{Subject: claims.Subject}
+
+	// Only use verified emails.
+	if email, _ := customClaims["email"].(string); email != "" {
+		if verified, ok := customClaims["email_verified"].(bool); !ok || verified {
+			id.Email = email
+		}
+	}
+
+	switch groups := customClaims[p.groupsClaim].(type) {
+	case []any:
+		for _, g := range groups {
+			if g, ok := g.(string); ok {
+				id.Groups = append(id.Groups, g)
+			}
+		}
+	case string:
+		id.Groups = []string{groups}
+	}
+
+	return id, nil
+}
+
+// discover returns the provider discovery and keys, they are discovered once.
+func (p *Provider) discover(ctx context.Context) (*discovery, jwt.KeySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, p.keys, nil
+	}
+
+	d := &discovery{}
+	err := p.getJSON(ctx, p.issuerURL+"/.well-known/openid-configuration", d)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get OIDC discovery: %w", err)
+	}
+
+	if strings.TrimSuffix(d.Issuer, "/") != p.issuerURL {
+		return nil, nil, fmt.Errorf("OIDC discovery issuer %q doesn't match the issuer URL", d.Issuer)
+	}
+
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, nil, fmt.Errorf("OIDC discovery without authorization endpoint, token endpoint or jwks_uri")
+	}
+
+	keys, err := jwt.NewRemoteKeySet(jwt.RemoteKeySetConfig{
+		JWKSURL: d.JWKSURI,
+		Client:  p.client,
+		Logger:  p.logger,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create provider key set: %w", err)
+	}
+
+	p.discovery = d
+	p.keys = keys
+	p.logger.Debugf("OIDC provider discovered")
+
+	return d, keys, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	josejwt "github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/simple-ingress-external-auth/internal/oidc"
+)
+
+// testOIDCProvider is a mock OIDC provider, the authorization requests are simulated with
+// authorize, and the token endpoint returns an ID token with the claims.
+type testOIDCProvider struct {
+	mu         sync.Mutex
+	url        string
+	issuer     string
+	key        *ecdsa.PrivateKey
+	signKey    *ecdsa.PrivateKey
+	claims     map[string]any
+	statusCode int
+	codes      map[string]url.Values
+}
+
+func newTestOIDCProvider(t *testing.T) (*testOIDCProvider, func()) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	p := &testOIDCProvider{key: key, codes: map[string]url.Values{}}
+	server := httptest.NewServer(p)
+	p.url = server.URL
+	p.issuer = server.URL
+
+	return p, server.Close
+}
+
+// authorize simulates a user login with the authorization URL, returning the code.
+func (t *testOIDCProvider) authorize(authURL string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, _ := url.Parse(authURL)
+	code := "code-" + u.Query().Get("state")
+	t.codes[code] = u.Query()
+
+	return code
+}
+
+func (t *testOIDCProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 t.issuer,
+			"authorization_endpoint": t.url + "/authorize",
+			"token_endpoint":         t.url + "/token",
+			"jwks_uri":               t.url + "/jwks",
+		})
+	case "/jwks":
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: t.key.Public(), KeyID: "k1", Algorithm: string(jose.ES256)}}})
+	case "/token":
+		if t.statusCode != 0 {
+			w.WriteHeader(t.statusCode)
+			return
+		}
+
+		// Check the client, the code and the PKCE verifier.
+		user, pass, _ := r.BasicAuth()
+		authReq, ok := t.codes[r.PostFormValue("code")]
+		challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if user != "client0" || pass != "secret" || !ok || authReq.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		claims := map[string]any{"nonce": authReq.Get("nonce")}
+		for k, v := range t.claims {
+			claims[k] = v
+		}
+
+		signKey := t.key
+		if t.signKey != nil {
+			signKey = t.signKey
+		}
+		signer, _ := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: signKey}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "k1"))
+		idToken, _ := josejwt.Signed(signer).Claims(claims).Serialize()
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": idToken})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestProviderLogin(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		provider    func(p *testOIDCProvider)
+		verifier    string
+		nonce       string
+		expIdentity *oidc.Identity
+		expErr      bool
+	}{
+		"A valid login should return the identity.": {
+			provider: func(p *testOIDCProvider) {
+				p.claims = map[string]any{"iss": p.issuer, "aud": "client0", "exp": exp, "sub": "u0", "email": "u0@example.com", "groups": []string{"g0", "g1"}}
+			},
+			expIdentity: &oidc.Identity{Subject: "u0", Email: "u0@example.com", Groups: []string{"g0", "g1"}},
+		},
+
+		"A login with an unverified email should not use the email.": {
+			provider: func(p *testOIDCProvider) {
+				p.claims = map[string]any{"iss": p.issuer, "aud": "client0", "exp": exp, "sub": "u0", "email": "u0@example.com", "email_verified": false}
+			},
+			expIdentity: &oidc.Identity{Subject: "u0"},
+		},
+
+		"A login with a different nonce should fail.": {
+			provider: func(p *testOIDCProvider) {
+				p.claims = map[string]any{"iss": p.issuer, "aud": "client0", "exp": exp, "sub": "u0"}
+			},
+			nonce:  "other",
+			expErr: true,
+		},
+
+		"A login with a different code verifier should fail.": {
+			provider: func(p *testOIDCProvider) {
+				p.claims = map[string]any{"iss": p.issuer, "aud": "client0", "exp": exp, "sub": "u0"}
+			},
+			verifier: "other",
+			expErr:   true,
+		},
+
+		"A login with an ID token for other audience should fail.": {
+			provider: func(p *testOIDCProvider) {
+				p.claims = map[string]any{"iss": p.issuer, "aud": "client1", "exp": exp, "sub": "u0"}
+			},
+			expErr: true,
+		},
+
+		"A login with an expired ID token should fail.": {
+			provider: func(p *testOIDCProvider) {
+				p.claims = map[string]any{"iss": p.issuer, "aud": "client0", "exp": time.Now().Add(-time.Hour).Unix(), "sub": "u0"}
+			},
+			expErr: true,
+		},
+
+		"A login with an ID token signed by other key should fail.": {
+			provider: func(p *testOIDCProvider) {
+				p.claims = map[string]any{"iss": p.issuer, "aud": "client0", "exp": exp, "sub": "u0"}
+				p.signKey = otherKey
+			},
+			expErr: true,
+		},
+
+		"A login with a token endpoint error should fail.": {
+			provider: func(p *testOIDCProvider) {
+				p.statusCode = http.StatusInternalServerError
+			},
+			expErr: true,
+		},
+
+		"A provider with a different discovery issuer should fail.": {
+			provider: func(p *testOIDCProvider) {
+				p.issuer = "https://other.example.com"
+			},
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			mp, closeServer := newTestOIDCProvider(t)
+			defer closeServer()
+			test.provider(mp)
+
+			p, err := oidc.NewProvider(oidc.ProviderConfig{
+				IssuerURL:    mp.url,
+				ClientID:     "client0",
+				ClientSecret: "secret",
+				RedirectURL:  "https://auth.example.com/oauth2/callback",
+			})
+			require.NoError(err)
+
+			verifier := "verifier0"
+			challenge := sha256.Sum256([]byte(verifier))
+			authURL, err := p.AuthCodeURL(context.TODO(), "state0", "nonce0", base64.RawURLEncoding.EncodeToString(challenge[:]))
+			if err != nil {
+				assert.True(test.expErr)
+				return
+			}
+
+			u, err := url.Parse(authURL)
+			require.NoError(err)
+			assert.Equal(mp.url+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+			assert.Equal("client0", u.Query().Get("client_id"))
+			assert.Equal("https://auth.example.com/oauth2/callback", u.Query().Get("redirect_uri"))
+			assert.Equal("openid email profile", u.Query().Get("scope"))
+			assert.Equal("S256", u.Query().Get("code_challenge_method"))
+
+			if test.verifier != "" {
+				verifier = test.verifier
+			}
+			nonce := "nonce0"
+			if test.nonce != "" {
+				nonce = test.nonce
+			}
+
+			code := mp.authorize(authURL)
+			gotIdentity, err := p.Exchange(context.TODO(), code, verifier, nonce)
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				assert.Equal(test.expIdentity, gotIdentity)
+			}
+		})
+	}
+}
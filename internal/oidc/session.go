@@ -0,0 +1,169 @@
+package oidc
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+)
+
+// MinSecretLength is the minimum length of the cookie secret.
+const MinSecretLength = 32
+
+// Cookie value purposes, a value sealed for a purpose can't be opened for another one.
+const (
+	PurposeSession = "session"
+	PurposeLogin   = "login"
+)
+
+// Codec seals values (e.g cookie values) so they can't be read or tampered, it uses AES-256-GCM
+// with a key derived from a secret.
+type Codec struct {
+	aead cipher.AEAD
+}
+
+// NewCodec returns a new Codec.
+func NewCodec(secret string) (*Codec, error) {
+	if len(secret) < MinSecretLength {
+		return nil, fmt.Errorf("secret must have at least %d characters", MinSecretLength)
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Codec{aead: aead}, nil
+}
+
+// Seal returns the sealed JSON of the value for the purpose.
+func (c *Codec) Seal(purpose string, v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal value: %w", err)
+	}
+
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(data)+c.aead.Overhead())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", fmt.Errorf("could not generate nonce: %w", err)
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, data, []byte(purpose))
+
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open opens a value sealed for the purpose.
+func (c *Codec) Open(purpose, value string, v any) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return fmt.Errorf("invalid sealed value")
+	}
+
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	data, err := c.aead.Open(nil, nonce, ciphertext, []byte(purpose))
+	if err != nil {
+		return fmt.Errorf("invalid sealed value")
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal value: %w", err)
+	}
+
+	return nil
+}
+
+// Session is a logged in user session.
+type Session struct {
+	Identity
+	ExpiresAt time.Time `json:"exp"`
+}
+
+// Policy restricts the users that can log in.
+type Policy struct {
+	// AllowedUsers are the allowed user emails or subjects.
+	AllowedUsers []string
+	// AllowedGroups are the allowed user groups.
+	AllowedGroups []string
+}
+
+// Allows returns true if the identity is allowed, when the policy is empty, all the identities
+// are allowed.
+func (p Policy) Allows(id Identity) bool {
+	if len(p.AllowedUsers) == 0 && len(p.AllowedGroups) == 0 {
+		return true
+	}
+
+	for _, u := range p.AllowedUsers {
+		if u == id.Subject || (id.Email != "" && strings.EqualFold(u, id.Email)) {
+			return true
+		}
+	}
+
+	for _, g := range id.Groups {
+		if slices.Contains(p.AllowedGroups, g) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SessionTokenGetter gets token validations from session cookie values, the identity client ID
+// is used as the client ID.
+//
+// The sessions are checked again with the policy, so a policy change is applied to the existing
+// sessions. Invalid or not allowed sessions are handled as missing tokens.
+type SessionTokenGetter struct {
+	codec  *Codec
+	policy Policy
+	logger log.Logger
+}
+
+// NewSessionTokenGetter returns a new SessionTokenGetter.
+func NewSessionTokenGetter(logger log.Logger, codec *Codec, policy Policy) *SessionTokenGetter {
+	return &SessionTokenGetter{
+		codec:  codec,
+		policy: policy,
+		logger: logger.WithValues(log.Kv{"svc": "oidc.SessionTokenGetter"}),
+	}
+}
+
+func (s *SessionTokenGetter) GetStaticTokenValidation(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error) {
+	session := Session{}
+	err := s.codec.Open(PurposeSession, tokenValue, &session)
+	if err != nil {
+		return nil, fmt.Errorf("not a session: %w", internalerrors.ErrNotFound)
+	}
+
+	if !s.policy.Allows(session.Identity) {
+		s.logger.WithValues(log.Kv{"sub": session.Subject}).Debugf("Session user not allowed")
+		return nil, fmt.Errorf("session user not allowed: %w", internalerrors.ErrNotFound)
+	}
+
+	// Expiration is checked by the authentication, as any other token.
+	return &model.StaticTokenValidation{
+		Value:     tokenValue,
+		ClientID:  session.ClientID(),
+		ExpiresAt: session.ExpiresAt,
+	}, nil
+}
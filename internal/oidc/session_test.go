@@ -0,0 +1,121 @@
+package oidc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+	"github.com/slok/simple-ingress-external-auth/internal/oidc"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func TestSessionTokenGetter(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+
+	tests := map[string]struct {
+		policy   oidc.Policy
+		token    func(c *oidc.Codec) string
+		expToken *model.StaticTokenValidation
+		expErr   error
+	}{
+		"A session should use the email as client ID.": {
+			token: func(c *oidc.Codec) string {
+				v, _ := c.Seal(oidc.PurposeSession, oidc.Session{Identity: oidc.Identity{Subject: "u0", Email: "u0@example.com"}, ExpiresAt: exp})
+				return v
+			},
+			expToken: &model.StaticTokenValidation{ClientID: "u0@example.com", ExpiresAt: exp},
+		},
+
+		"A session without email should use the subject as client ID.": {
+			token: func(c *oidc.Codec) string {
+				v, _ := c.Seal(oidc.PurposeSession, oidc.Session{Identity: oidc.Identity{Subject: "u0"}, ExpiresAt: exp})
+				return v
+			},
+			expToken: &model.StaticTokenValidation{ClientID: "u0", ExpiresAt: exp},
+		},
+
+		"A session of an allowed user should be valid.": {
+			policy: oidc.Policy{AllowedUsers: []string{"U0@example.com"}},
+			token: func(c *oidc.Codec) string {
+				v, _ := c.Seal(oidc.PurposeSession, oidc.Session{Identity: oidc.Identity{Subject: "u0", Email: "u0@example.com"}, ExpiresAt: exp})
+				return v
+			},
+			expToken: &model.StaticTokenValidation{ClientID: "u0@example.com", ExpiresAt: exp},
+		},
+
+		"A session of an allowed group user should be valid.": {
+			policy: oidc.Policy{AllowedUsers: []string{"u1"}, AllowedGroups: []string{"g1"}},
+			token: func(c *oidc.Codec) string {
+				v, _ := c.Seal(oidc.PurposeSession, oidc.Session{Identity: oidc.Identity{Subject: "u0", Groups: []string{"g0", "g1"}}, ExpiresAt: exp})
+				return v
+			},
+			expToken: &model.StaticTokenValidation{ClientID: "u0", ExpiresAt: exp},
+		},
+
+		"A session of a user that is not allowed anymore should be missing.": {
+			policy: oidc.Policy{AllowedUsers: []string{"u1"}, AllowedGroups: []string{"g1"}},
+			token: func(c *oidc.Codec) string {
+				v, _ := c.Seal(oidc.PurposeSession, oidc.Session{Identity: oidc.Identity{Subject: "u0", Groups: []string{"g0"}}, ExpiresAt: exp})
+				return v
+			},
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A login state should not be used as a session.": {
+			token: func(c *oidc.Codec) string {
+				v, _ := c.Seal(oidc.PurposeLogin, oidc.Session{Identity: oidc.Identity{Subject: "u0"}, ExpiresAt: exp})
+				return v
+			},
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A session sealed with other secret should be missing.": {
+			token: func(_ *oidc.Codec) string {
+				c, _ := oidc.NewCodec("other-0123456789abcdef0123456789abcdef")
+				v, _ := c.Seal(oidc.PurposeSession, oidc.Session{Identity: oidc.Identity{Subject: "u0"}, ExpiresAt: exp})
+				return v
+			},
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A tampered session should be missing.": {
+			token: func(c *oidc.Codec) string {
+				v, _ := c.Seal(oidc.PurposeSession, oidc.Session{Identity: oidc.Identity{Subject: "u0"}, ExpiresAt: exp})
+				return v[:len(v)-2] + "AA"
+			},
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A regular token should be missing.": {
+			token:  func(_ *oidc.Codec) string { return "my-token" },
+			expErr: internalerrors.ErrNotFound,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			codec, err := oidc.NewCodec(testSecret)
+			require.NoError(err)
+			token := test.token(codec)
+
+			getter := oidc.NewSessionTokenGetter(log.Noop, codec, test.policy)
+			gotToken, err := getter.GetStaticTokenValidation(context.TODO(), token)
+			if test.expErr != nil {
+				assert.ErrorIs(err, test.expErr)
+			} else if assert.NoError(err) {
+				test.expToken.Value = token
+				assert.Equal(test.expToken, gotToken)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package reload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+)
+
+// Remote token config fetch results.
+const (
+	FetchResultUpdated     = "updated"
+	FetchResultNotModified = "not_modified"
+	FetchResultError       = "error"
+)
+
+// HTTPConfigLoaderConfig is the configuration of the HTTP config loader.
+type HTTPConfigLoaderConfig struct {
+	URL string
+	// Client is the HTTP client used to fetch the configuration, it can be used to set mTLS.
+	Client *http.Client
+	// BearerToken is the token used to authenticate against the configuration server.
+	BearerToken string
+	// MaxConfigSize is the max size of the fetched configuration in bytes.
+	MaxConfigSize   int64
+	MetricsRecorder metrics.Recorder
+	Logger          log.Logger
+}
+
+func (c *HTTPConfigLoaderConfig) defaults() error {
+	if c.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	if c.MaxConfigSize == 0 {
+		c.MaxConfigSize = 10 * 1024 * 1024
+	}
+
+	if c.MetricsRecorder == nil {
+		c.MetricsRecorder = metrics.Noop
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Noop
+	}
+
+	return nil
+}
+
+type httpConfigLoader struct {
+	url           string
+	client        *http.Client
+	bearerToken   string
+	maxConfigSize int64
+	metricsRec    metrics.Recorder
+	logger        log.Logger
+
+	mu      sync.Mutex
+	etag    string
+	data    string
+	fetched bool
+}
+
+// NewHTTPConfigLoader returns a ConfigLoader that fetches the configuration from an HTTP(S) URL.
+// It uses ETags so the configuration is only downloaded if it changed.
+//
+// Once fetched, if a fetch fails the last fetched configuration is returned, so the server being
+// down doesn't fail the load of the other configurations.
+func NewHTTPConfigLoader(config HTTPConfigLoaderConfig) (ConfigLoader, error) {
+	err := config.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return &httpConfigLoader{
+		url:           config.URL,
+		client:        config.Client,
+		bearerToken:   config.BearerToken,
+		maxConfigSize: config.MaxConfigSize,
+		metricsRec:    config.MetricsRecorder,
+		logger:        config.Logger.WithValues(log.Kv{"svc": "reload.httpConfigLoader", "url": config.URL}),
+	}, nil
+}
+
+func (h *httpConfigLoader) LoadConfigs(ctx context.Context) (map[string]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	start := time.Now()
+	result, err := h.fetch(ctx)
+	if err != nil {
+		result = FetchResultError
+	}
+	h.metricsRec.TokenConfigFetch(ctx, result, time.Since(start))
+	if err != nil {
+		if !h.fetched {
+			return nil, err
+		}
+		h.logger.Warningf("Using the last fetched token config: %s", err)
+	}
+
+	return map[string]string{h.url: h.data}, nil
+}
+
+func (h *httpConfigLoader) fetch(ctx context.Context) (result string, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return "", fmt.Errorf("could not create request: %w", err)
+	}
+
+	if h.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.bearerToken)
+	}
+
+	if h.etag != "" {
+		req.Header.Set("If-None-Match", h.etag)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not fetch token config: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return FetchResultNotModified, nil
+	case http.StatusOK:
+	default:
+		return "", fmt.Errorf("could not fetch token config: unexpected status code %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, h.maxConfigSize+1))
+	if err != nil {
+		return "", fmt.Errorf("could not read token config: %w", err)
+	}
+	if int64(len(data)) > h.maxConfigSize {
+		return "", fmt.Errorf("token config is bigger than %d bytes", h.maxConfigSize)
+	}
+
+	h.data = string(data)
+	h.etag = resp.Header.Get("ETag")
+	h.fetched = true
+
+	return FetchResultUpdated, nil
+}
+
+// Poller reloads the configuration periodically.
+type Poller struct {
+	interval time.Duration
+	reloader *Reloader
+	logger   log.Logger
+}
+
+// NewPoller returns a new Poller.
+func NewPoller(logger log.Logger, reloader *Reloader, interval time.Duration) Poller {
+	return Poller{
+		interval: interval,
+		reloader: reloader,
+		logger:   logger.WithValues(log.Kv{"svc": "reload.Poller", "interval": interval.String()}),
+	}
+}
+
+// Run will poll the configuration until the context is cancelled. On failures the
+// previous configuration is kept.
+func (p Poller) Run(ctx context.Context) error {
+	p.logger.Infof("Polling token config for changes")
+
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			err := p.reloader.ReloadIfChanged(ctx)
+			if err != nil {
+				p.logger.Errorf("Could not reload token config, keeping the previous one: %s", err)
+			}
+		}
+	}
+}
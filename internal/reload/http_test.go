@@ -0,0 +1,171 @@
+package reload_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+	"github.com/slok/simple-ingress-external-auth/internal/reload"
+)
+
+type testFetchRecorder struct {
+	metrics.Recorder
+
+	mu      sync.Mutex
+	results []string
+}
+
+func (t *testFetchRecorder) TokenConfigFetch(ctx context.Context, result string, duration time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.results = append(t.results, result)
+}
+
+// testConfigServer serves a config with its ETag, and tracks the requests.
+type testConfigServer struct {
+	mu         sync.Mutex
+	config     string
+	etag       string
+	statusCode int
+	authHeader string
+}
+
+func (t *testConfigServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.authHeader = r.Header.Get("Authorization")
+
+	if t.statusCode != 0 {
+		w.WriteHeader(t.statusCode)
+		return
+	}
+
+	if t.etag != "" && r.Header.Get("If-None-Match") == t.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("ETag", t.etag)
+	_, _ = w.Write([]byte(t.config))
+}
+
+func TestHTTPConfigLoader(t *testing.T) {
+	tests := map[string]struct {
+		bearerToken   string
+		maxConfigSize int64
+		// Each step changes the server and loads the config.
+		steps         []func(s *testConfigServer)
+		expConfigs    []string
+		expErrs       []bool
+		expResults    []string
+		expAuthHeader string
+	}{
+		"The config should be fetched and only downloaded again when it changes.": {
+			steps: []func(s *testConfigServer){
+				func(s *testConfigServer) { s.config, s.etag = "c0", `"e0"` },
+				func(s *testConfigServer) {},
+				func(s *testConfigServer) { s.config, s.etag = "c1", `"e1"` },
+			},
+			expConfigs: []string{"c0", "c0", "c1"},
+			expErrs:    []bool{false, false, false},
+			expResults: []string{"updated", "not_modified", "updated"},
+		},
+
+		"A server without ETags should download the config every time.": {
+			steps: []func(s *testConfigServer){
+				func(s *testConfigServer) { s.config = "c0" },
+				func(s *testConfigServer) { s.config = "c1" },
+			},
+			expConfigs: []string{"c0", "c1"},
+			expErrs:    []bool{false, false},
+			expResults: []string{"updated", "updated"},
+		},
+
+		"A server error should return the last fetched config.": {
+			steps: []func(s *testConfigServer){
+				func(s *testConfigServer) { s.config, s.etag = "c0", `"e0"` },
+				func(s *testConfigServer) { s.statusCode = http.StatusInternalServerError },
+				func(s *testConfigServer) { s.statusCode = 0 },
+			},
+			expConfigs: []string{"c0", "c0", "c0"},
+			expErrs:    []bool{false, false, false},
+			expResults: []string{"updated", "error", "not_modified"},
+		},
+
+		"A server error on the first fetch should fail.": {
+			steps: []func(s *testConfigServer){
+				func(s *testConfigServer) { s.config, s.statusCode = "c0", http.StatusInternalServerError },
+				func(s *testConfigServer) { s.statusCode = 0 },
+			},
+			expConfigs: []string{"", "c0"},
+			expErrs:    []bool{true, false},
+			expResults: []string{"error", "updated"},
+		},
+
+		"A config bigger than the max size should fail.": {
+			maxConfigSize: 4,
+			steps: []func(s *testConfigServer){
+				func(s *testConfigServer) { s.config = "c0" },
+				func(s *testConfigServer) { s.config = "c0c0c" },
+			},
+			expConfigs: []string{"c0", "c0"},
+			expErrs:    []bool{false, false},
+			expResults: []string{"updated", "error"},
+		},
+
+		"The bearer token should be sent.": {
+			bearerToken: "secret",
+			steps: []func(s *testConfigServer){
+				func(s *testConfigServer) { s.config = "c0" },
+			},
+			expConfigs:    []string{"c0"},
+			expErrs:       []bool{false},
+			expResults:    []string{"updated"},
+			expAuthHeader: "Bearer secret",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			s := &testConfigServer{}
+			server := httptest.NewServer(s)
+			defer server.Close()
+
+			rec := &testFetchRecorder{Recorder: metrics.Noop}
+			loader, err := reload.NewHTTPConfigLoader(reload.HTTPConfigLoaderConfig{
+				URL:             server.URL,
+				BearerToken:     test.bearerToken,
+				MaxConfigSize:   test.maxConfigSize,
+				MetricsRecorder: rec,
+			})
+			require.NoError(err)
+
+			for i, step := range test.steps {
+				s.mu.Lock()
+				step(s)
+				s.mu.Unlock()
+
+				configs, err := loader.LoadConfigs(context.TODO())
+				if test.expErrs[i] {
+					assert.Error(err)
+				} else if assert.NoError(err) {
+					assert.Equal(map[string]string{server.URL: test.expConfigs[i]}, configs)
+				}
+			}
+
+			assert.Equal(test.expResults, rec.results)
+			assert.Equal(test.expAuthHeader, s.authHeader)
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package reload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+)
+
+// ConfigLoader knows how to load raw token configurations. The configurations are
+// returned by their source name (e.g the file path).
+type ConfigLoader interface {
+	LoadConfigs(ctx context.Context) (map[string]string, error)
+}
+
+// ConfigLoaderFunc is a helper to use functions as ConfigLoader.
+type ConfigLoaderFunc func(ctx context.Context) (map[string]string, error)
+
+func (c ConfigLoaderFunc) LoadConfigs(ctx context.Context) (map[string]string, error) { return c(ctx) }
+
+// NewFileConfigLoader returns a ConfigLoader that reads the configuration files on every load.
+func NewFileConfigLoader(paths ...string) ConfigLoader {
+	return ConfigLoaderFunc(func(ctx context.Context) (map[string]string, error) {
+		configs := map[string]string{}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("could not read token config file: %w", err)
+			}
+			configs[path] = string(data)
+		}
+
+		return configs, nil
+	})
+}
+
+// NewDirConfigLoader returns a ConfigLoader that reads the JSON and YAML configuration files
+// of the directories (conf.d style) on every load. Hidden files are ignored.
+func NewDirConfigLoader(dirs ...string) ConfigLoader {
+	return ConfigLoaderFunc(func(ctx context.Context) (map[string]string, error) {
+		configs := map[string]string{}
+		for _, dir := range dirs {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				return nil, fmt.Errorf("could not read token config directory: %w", err)
+			}
+
+			for _, e := range entries {
+				if !isConfigFile(e.Name()) {
+					continue
+				}
+
+				// Stat follows symlinks (e.g Kubernetes volumes).
+				path := filepath.Join(dir, e.Name())
+				info, err := os.Stat(path)
+				if err != nil {
+					return nil, fmt.Errorf("could not stat token config file: %w", err)
+				}
+				if !info.Mode().IsRegular() {
+					continue
+				}
+
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return nil, fmt.Errorf("could not read token config file: %w", err)
+				}
+				configs[path] = string(data)
+			}
+		}
+
+		return configs, nil
+	})
+}
+
+func isConfigFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+
+	switch filepath.Ext(name) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+
+	return false
+}
+
+// NewStaticConfigLoader returns a ConfigLoader that always returns the same configuration.
+func NewStaticConfigLoader(name, config string) ConfigLoader {
+	return ConfigLoaderFunc(func(ctx context.Context) (map[string]string, error) {
+		return map[string]string{name: config}, nil
+	})
+}
+
+// NewMultiConfigLoader returns a ConfigLoader that merges the configurations of multiple loaders.
+func NewMultiConfigLoader(loaders ...ConfigLoader) ConfigLoader {
+	return ConfigLoaderFunc(func(ctx context.Context) (map[string]string, error) {
+		configs := map[string]string{}
+		for _, l := range loaders {
+			cs, err := l.LoadConfigs(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			for name, c := range cs {
+				if _, ok := configs[name]; ok {
+					return nil, fmt.Errorf("token config %q loaded multiple times", name)
+				}
+				configs[name] = c
+			}
+		}
+
+		return configs, nil
+	})
+}
+
+// ConfigApplier knows how to apply raw token configurations.
+// Implementations must leave the current configuration untouched if the new one is invalid.
+type ConfigApplier interface {
+	ReloadConfigs(configs map[string]string) error
+}
+
+// Reloader knows how to load a token configuration and apply it.
+type Reloader struct {
+	tenant     string
+	loader     ConfigLoader
+	applier    ConfigApplier
+	metricsRec metrics.Recorder
+	logger     log.Logger
+
+	mu          sync.Mutex
+	currentHash string
+}
+
+// NewReloader returns a new Reloader. The initial configs are the configurations that have
+// already been applied, used to detect changes on the next reloads. The tenant is empty for the
+// main token configuration.
+func NewReloader(logger log.Logger, metricsRec metrics.Recorder, tenant string, loader ConfigLoader, applier ConfigApplier, initialConfigs map[string]string) *Reloader {
+	hash := hashConfigs(initialConfigs)
+	metricsRec.TokenConfigReload(context.Background(), tenant, true, hash)
+
+	return &Reloader{
+		tenant:      tenant,
+		loader:      loader,
+		applier:     applier,
+		metricsRec:  metricsRec,
+		logger:      logger.WithValues(log.Kv{"svc": "reload.Reloader"}),
+		currentHash: hash,
+	}
+}
+
+// Reload loads the configuration and applies it, even if it didn't change.
+func (r *Reloader) Reload(ctx context.Context) error {
+	return r.reload(ctx, true)
+}
+
+// ReloadIfChanged loads the configuration and only applies it if it changed since the last
+// successful reload.
+func (r *Reloader) ReloadIfChanged(ctx context.Context) error {
+	return r.reload(ctx, false)
+}
+
+func (r *Reloader) reload(ctx context.Context, force bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	configs, err := r.loader.LoadConfigs(ctx)
+	if err != nil {
+		r.metricsRec.TokenConfigReload(ctx, r.tenant, false, "")
+		return fmt.Errorf("could not load token config: %w", err)
+	}
+
+	hash := hashConfigs(configs)
+	if !force && hash == r.currentHash {
+		r.logger.WithValues(log.Kv{"hash": hash}).Debugf("Token config didn't change, ignoring reload")
+		return nil
+	}
+
+	err = r.applier.ReloadConfigs(configs)
+	if err != nil {
+		r.metricsRec.TokenConfigReload(ctx, r.tenant, false, hash)
+		return fmt.Errorf("could not apply token config: %w", err)
+	}
+
+	r.currentHash = hash
+	r.metricsRec.TokenConfigReload(ctx, r.tenant, true, hash)
+	r.logger.WithValues(log.Kv{"hash": hash}).Infof("Token config reloaded")
+
+	return nil
+}
+
+// Reloaders are multiple token configuration reloaders (e.g the main and the tenant ones).
+type Reloaders []*Reloader
+
+// Reload reloads all the configurations, a failed reload doesn't stop the others.
+func (r Reloaders) Reload(ctx context.Context) error {
+	var errs []error
+	for _, reloader := range r {
+		err := reloader.Reload(ctx)
+		if err != nil {
+			if reloader.tenant != "" {
+				err = fmt.Errorf("tenant %q: %w", reloader.tenant, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func hashConfigs(configs map[string]string) string {
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		// Null separated to avoid ambiguities.
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(configs[name]))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
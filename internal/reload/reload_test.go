@@ -0,0 +1,233 @@
+package reload_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+	"github.com/slok/simple-ingress-external-auth/internal/reload"
+)
+
+type testApplier struct {
+	mu      sync.Mutex
+	err     error
+	applied []map[string]string
+}
+
+func (t *testApplier) ReloadConfigs(configs map[string]string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return t.err
+	}
+	t.applied = append(t.applied, configs)
+	return nil
+}
+
+func (t *testApplier) Applied() []map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]map[string]string{}, t.applied...)
+}
+
+func TestReloader(t *testing.T) {
+	tests := map[string]struct {
+		initialConfigs map[string]string
+		configs        map[string]string
+		loaderErr      error
+		applierErr     error
+		force          bool
+		expApplied     []map[string]string
+		expErr         bool
+	}{
+		"An unchanged config should not be applied.": {
+			initialConfigs: map[string]string{"f0": "c0"},
+			configs:        map[string]string{"f0": "c0"},
+			expApplied:     []map[string]string{},
+		},
+
+		"An unchanged config should be applied if forced.": {
+			initialConfigs: map[string]string{"f0": "c0"},
+			configs:        map[string]string{"f0": "c0"},
+			force:          true,
+			expApplied:     []map[string]string{{"f0": "c0"}},
+		},
+
+		"A changed config should be applied.": {
+			initialConfigs: map[string]string{"f0": "c0"},
+			configs:        map[string]string{"f0": "c1"},
+			expApplied:     []map[string]string{{"f0": "c1"}},
+		},
+
+		"A renamed config should be applied.": {
+			initialConfigs: map[string]string{"f0": "c0"},
+			configs:        map[string]string{"f1": "c0"},
+			expApplied:     []map[string]string{{"f1": "c0"}},
+		},
+
+		"A new config should be applied.": {
+			initialConfigs: map[string]string{"f0": "c0"},
+			configs:        map[string]string{"f0": "c0", "f1": "c1"},
+			expApplied:     []map[string]string{{"f0": "c0", "f1": "c1"}},
+		},
+
+		"An error loading the config should fail.": {
+			initialConfigs: map[string]string{"f0": "c0"},
+			loaderErr:      fmt.Errorf("something"),
+			expErr:         true,
+		},
+
+		"An error applying the config should fail.": {
+			initialConfigs: map[string]string{"f0": "c0"},
+			configs:        map[string]string{"f0": "c1"},
+			applierErr:     fmt.Errorf("something"),
+			expErr:         true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			loader := reload.ConfigLoaderFunc(func(ctx context.Context) (map[string]string, error) {
+				return test.configs, test.loaderErr
+			})
+			applier := &testApplier{err: test.applierErr}
+			r := reload.NewReloader(log.Noop, metrics.Noop, "", loader, applier, test.initialConfigs)
+
+			var err error
+			if test.force {
+				err = r.Reload(context.TODO())
+			} else {
+				err = r.ReloadIfChanged(context.TODO())
+			}
+
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				assert.Equal(test.expApplied, applier.Applied())
+			}
+		})
+	}
+}
+
+func TestFileWatcher(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	// Prepare a Kubernetes like volume where the file is a symlink to a data dir symlink.
+	dir := t.TempDir()
+	writeData := func(name, config string) {
+		dataDir := filepath.Join(dir, name)
+		require.NoError(os.Mkdir(dataDir, 0o755))
+		require.NoError(os.WriteFile(filepath.Join(dataDir, "config.json"), []byte(config), 0o644))
+		require.NoError(os.Symlink(name, filepath.Join(dir, "..data_tmp")))
+		require.NoError(os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	}
+	writeData("..v0", "c0")
+	path := filepath.Join(dir, "config.json")
+	require.NoError(os.Symlink(filepath.Join("..data", "config.json"), path))
+
+	applier := &testApplier{}
+	r := reload.NewReloader(log.Noop, metrics.Noop, "", reload.NewFileConfigLoader(path), applier, map[string]string{path: "c0"})
+	w := reload.NewFileWatcher(log.Noop, r, path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = w.Run(ctx) }()
+	time.Sleep(100 * time.Millisecond)
+
+	// Swap the data.
+	writeData("..v1", "c1")
+
+	assert.Eventually(func() bool {
+		applied := applier.Applied()
+		return len(applied) == 1 && applied[0][path] == "c1"
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestDirConfigLoader(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.json":       "c0",
+		"b.yaml":       "c1",
+		"c.yml":        "c2",
+		"d.txt":        "ignored",
+		".hidden.json": "ignored",
+	}
+	for name, data := range files {
+		require.NoError(os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644))
+	}
+	require.NoError(os.Mkdir(filepath.Join(dir, "e.json"), 0o755))
+	require.NoError(os.Symlink("a.json", filepath.Join(dir, "f.json")))
+
+	configs, err := reload.NewDirConfigLoader(dir).LoadConfigs(context.TODO())
+	require.NoError(err)
+
+	exp := map[string]string{
+		filepath.Join(dir, "a.json"): "c0",
+		filepath.Join(dir, "b.yaml"): "c1",
+		filepath.Join(dir, "c.yml"):  "c2",
+		filepath.Join(dir, "f.json"): "c0",
+	}
+	assert.Equal(exp, configs)
+}
+
+func TestDirWatcher(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	path0 := filepath.Join(dir, "a.json")
+	require.NoError(os.WriteFile(path0, []byte("c0"), 0o644))
+
+	applier := &testApplier{}
+	r := reload.NewReloader(log.Noop, metrics.Noop, "", reload.NewDirConfigLoader(dir), applier, map[string]string{path0: "c0"})
+	w := reload.NewFileWatcher(log.Noop, r, dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = w.Run(ctx) }()
+	time.Sleep(100 * time.Millisecond)
+
+	// Add a new file.
+	path1 := filepath.Join(dir, "b.json")
+	require.NoError(os.WriteFile(path1, []byte("c1"), 0o644))
+
+	exp := map[string]string{path0: "c0", path1: "c1"}
+	assert.Eventually(func() bool {
+		applied := applier.Applied()
+		return len(applied) == 1 && reflect.DeepEqual(exp, applied[0])
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestReloaders(t *testing.T) {
+	assert := assert.New(t)
+
+	loader := reload.NewStaticConfigLoader("f0", "c0")
+	applier0 := &testApplier{err: fmt.Errorf("something")}
+	applier1 := &testApplier{}
+	reloaders := reload.Reloaders{
+		reload.NewReloader(log.Noop, metrics.Noop, "", loader, applier0, nil),
+		reload.NewReloader(log.Noop, metrics.Noop, "tenant1", loader, applier1, nil),
+	}
+
+	// A failed reload should not stop the others.
+	err := reloaders.Reload(context.TODO())
+	assert.Error(err)
+	assert.Equal([]map[string]string{{"f0": "c0"}}, applier1.Applied())
+}
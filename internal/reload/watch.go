@@ -0,0 +1,150 @@
+package reload
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+)
+
+const watchDebounce = 250 * time.Millisecond
+
+// FileWatcher watches files and directories and reloads the configuration when they change.
+//
+// Instead of the files, the directories that contain them are watched, this way we
+// support editors that replace the file and Kubernetes ConfigMap/Secret volumes, that
+// swap a `..data` symlink atomically on every update.
+type FileWatcher struct {
+	paths    []string
+	reloader *Reloader
+	logger   log.Logger
+}
+
+// NewFileWatcher returns a new FileWatcher. The paths can be files or directories, in case of
+// a directory any change inside it will trigger a reload.
+func NewFileWatcher(logger log.Logger, reloader *Reloader, paths ...string) FileWatcher {
+	return FileWatcher{
+		paths:    paths,
+		reloader: reloader,
+		logger:   logger.WithValues(log.Kv{"svc": "reload.FileWatcher", "paths": strings.Join(paths, ",")}),
+	}
+}
+
+// Run will watch the paths until the context is cancelled.
+func (f FileWatcher) Run(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("could not create file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	for dir := range f.watchedDirs() {
+		err := watcher.Add(dir)
+		if err != nil {
+			return fmt.Errorf("could not watch %q directory: %w", dir, err)
+		}
+	}
+
+	f.logger.Infof("Watching token config files for changes")
+
+	// Changes usually come in bursts of events, so we debounce them.
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			if !f.isRelevant(event) {
+				continue
+			}
+
+			f.logger.Debugf("File event received: %s", event)
+			debounce = time.After(watchDebounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			f.logger.Errorf("Error watching token config files: %s", err)
+
+		case <-debounce:
+			debounce = nil
+			err := f.reloader.ReloadIfChanged(ctx)
+			if err != nil {
+				f.logger.Errorf("Could not reload token config, keeping the previous one: %s", err)
+			}
+		}
+	}
+}
+
+// watchedDirs returns the directories that need to be watched: the directories themselves and,
+// for the files, their directory and, if it's a symlink, the directory of the real file.
+func (f FileWatcher) watchedDirs() map[string]struct{} {
+	dirs := map[string]struct{}{}
+	for _, path := range f.paths {
+		if isDir(path) {
+			dirs[filepath.Clean(path)] = struct{}{}
+			continue
+		}
+
+		dirs[filepath.Dir(path)] = struct{}{}
+		realPath, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			dirs[filepath.Dir(realPath)] = struct{}{}
+		}
+	}
+
+	return dirs
+}
+
+func (f FileWatcher) isRelevant(event fsnotify.Event) bool {
+	if event.Has(fsnotify.Chmod) {
+		return false
+	}
+
+	name := filepath.Clean(event.Name)
+
+	// Kubernetes atomic writer internal files (e.g `..data`).
+	if strings.HasPrefix(filepath.Base(name), "..") {
+		return true
+	}
+
+	for _, path := range f.paths {
+		path = filepath.Clean(path)
+		if name == path {
+			return true
+		}
+
+		// Any file of a watched directory.
+		if isDir(path) {
+			if filepath.Dir(name) == path {
+				return true
+			}
+			continue
+		}
+
+		// The real file in case of a symlink.
+		realPath, err := filepath.EvalSymlinks(path)
+		if err == nil && name == realPath {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,404 @@
+// Package spoe has the HAProxy Stream Processing Offload Agent (SPOA), HAProxy sends the request
+// properties to the agent with SPOP, and the agent sets the authentication result on transaction
+// variables.
+package spoe
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slok/simple-ingress-external-auth/internal/app/auth"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+)
+
+// Message arguments.
+const (
+	ArgToken  = "token"
+	ArgMethod = "method"
+	ArgURL    = "url"
+)
+
+// Transaction variables, HAProxy prefixes them with the SPOE `var-prefix` (e.g `txn.auth.valid`).
+const (
+	VarValid    = "valid"
+	VarClientID = "client_id"
+	VarReason   = "reason"
+	// VarScopes are the authenticated token scopes (space separated), only set if the token has scopes.
+	VarScopes = "scopes"
+)
+
+// Config is the SPOE agent configuration.
+type Config struct {
+	// MessageName is the SPOE message with the authentication arguments, the rest of the messages
+	// are ignored. By default `authenticate`.
+	MessageName string
+	// Timeout is the max duration of a message authentication. By default 5s.
+	Timeout time.Duration
+}
+
+func (c *Config) defaults() {
+	if c.MessageName == "" {
+		c.MessageName = "authenticate"
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+}
+
+// maxPipelinedFrames is the max number of frames authenticated at the same time on a connection,
+// the next frames are not read until one of them finishes.
+const maxPipelinedFrames = 64
+
+// Agent is an SPOE agent that authenticates the `token`, `method` and `url` arguments of the SPOE
+// message. The `token` can be the raw token or a bearer authorization header value.
+//
+// It sets the `valid` (bool), `client_id` and `reason` (strings) transaction variables.
+type Agent struct {
+	authAppSvc  auth.Service
+	messageName string
+	timeout     time.Duration
+	logger      log.Logger
+
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+	listener net.Listener
+}
+
+// New returns a new SPOE agent.
+func New(logger log.Logger, authAppSvc auth.Service, config Config) *Agent {
+	config.defaults()
+
+	return &Agent{
+		authAppSvc:  authAppSvc,
+		messageName: config.MessageName,
+		timeout:     config.Timeout,
+		logger:      logger,
+		conns:       map[net.Conn]struct{}{},
+	}
+}
+
+// Serve accepts the HAProxy connections on the listener until the agent is closed.
+func (a *Agent) Serve(l net.Listener) error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		_ = l.Close()
+		return net.ErrClosed
+	}
+	a.listener = l
+	a.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			a.mu.Lock()
+			closed := a.closed
+			a.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		if !a.trackConn(conn) {
+			_ = conn.Close()
+			return nil
+		}
+
+		go func() {
+			defer a.untrackConn(conn)
+			a.handleConn(conn)
+		}()
+	}
+}
+
+// Close stops accepting connections, closes the current connections and waits for them.
+func (a *Agent) Close() error {
+	a.mu.Lock()
+	a.closed = true
+	var err error
+	if a.listener != nil {
+		err = a.listener.Close()
+	}
+	for conn := range a.conns {
+		_ = conn.Close()
+	}
+	a.mu.Unlock()
+
+	a.wg.Wait()
+
+	return err
+}
+
+func (a *Agent) trackConn(conn net.Conn) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return false
+	}
+	a.conns[conn] = struct{}{}
+	a.wg.Add(1)
+
+	return true
+}
+
+func (a *Agent) untrackConn(conn net.Conn) {
+	a.mu.Lock()
+	delete(a.conns, conn)
+	a.mu.Unlock()
+	_ = conn.Close()
+	a.wg.Done()
+}
+
+func (a *Agent) handleConn(conn net.Conn) {
+	logger := a.logger.WithValues(log.Kv{"remote-addr": conn.RemoteAddr().String()})
+	r := bufio.NewReader(conn)
+
+	err := a.serveConn(r, conn)
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return
+	}
+
+	// Protocol errors are notified to HAProxy before disconnecting.
+	var spopErr spopError
+	if !errors.As(err, &spopErr) {
+		logger.Warningf("SPOE connection error: %s", err)
+		return
+	}
+
+	logger.Warningf("SPOE protocol error: %s", spopErr.message)
+	err = writeDisconnect(conn, spopErr.status, spopErr.message)
+	if err != nil {
+		logger.Debugf("could not send SPOE disconnect: %s", err)
+	}
+}
+
+func (a *Agent) serveConn(r io.Reader, w io.Writer) error {
+	// Handshake.
+	f, err := readFrame(r, defaultMaxFrameSize)
+	if err != nil {
+		return err
+	}
+	if f.typ != frameTypeHAProxyHello {
+		return spopError{status: statusInvalidFrame, message: "expected HAProxy HELLO frame"}
+	}
+
+	maxFrameSize, healthcheck, err := negotiate(f.payload)
+	if err != nil {
+		return err
+	}
+
+	var hello []byte
+	hello = appendString(hello, "version")
+	hello = appendTypedData(hello, spopVersion)
+	hello = appendString(hello, "max-frame-size")
+	hello = appendTypedData(hello, maxFrameSize)
+	hello = appendString(hello, "capabilities")
+	hello = appendTypedData(hello, "pipelining")
+	err = writeFrame(w, frame{typ: frameTypeAgentHello, flags: frameFlagFin, payload: hello})
+	if err != nil {
+		return err
+	}
+
+	// HAProxy closes the health check connections after the handshake.
+	if healthcheck {
+		return nil
+	}
+
+	// The frames are pipelined, each one is authenticated on its own goroutine and acknowledged when
+	// it finishes, so the writes are serialized.
+	ctx, cancel := context.WithCancel(context.Background())
+	var (
+		writeMu  sync.Mutex
+		writeErr error
+		inflight sync.WaitGroup
+		sem      = make(chan struct{}, maxPipelinedFrames)
+	)
+	write := func(f frame) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if writeErr != nil {
+			return writeErr
+		}
+		writeErr = writeFrame(w, f)
+		return writeErr
+	}
+	defer func() {
+		cancel()
+		inflight.Wait()
+	}()
+
+	for {
+		f, err := readFrame(r, maxFrameSize)
+		if err != nil {
+			return err
+		}
+
+		switch f.typ {
+		case frameTypeNotify:
+			if f.flags&frameFlagFin == 0 {
+				return spopError{status: statusFragmentationUnsupported, message: "fragmentation not supported"}
+			}
+
+			msgs, err := decodeMessages(f.payload)
+			if err != nil {
+				return spopError{status: statusInvalidFrame, message: fmt.Sprintf("invalid NOTIFY frame: %s", err)}
+			}
+
+			sem <- struct{}{}
+			inflight.Add(1)
+			go func() {
+				defer func() {
+					<-sem
+					inflight.Done()
+				}()
+
+				var actions []setVar
+				for _, msg := range msgs {
+					if msg.name == a.messageName {
+						actions = a.authenticate(ctx, msg)
+						break
+					}
+				}
+
+				// A failed write breaks the connection, the next read or write returns the error.
+				_ = write(frame{
+					typ:      frameTypeAck,
+					flags:    frameFlagFin,
+					streamID: f.streamID,
+					frameID:  f.frameID,
+					payload:  encodeActions(actions),
+				})
+			}()
+
+			writeMu.Lock()
+			err = writeErr
+			writeMu.Unlock()
+			if err != nil {
+				return err
+			}
+
+		case frameTypeHAProxyDisconnect:
+			// Acknowledge the pending frames before disconnecting.
+			inflight.Wait()
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			return writeDisconnect(w, statusNormal, "")
+
+		default:
+			return spopError{status: statusInvalidFrame, message: fmt.Sprintf("unexpected frame type %d", f.typ)}
+		}
+	}
+}
+
+// negotiate checks the HAProxy HELLO frame and returns the max frame size.
+func negotiate(payload []byte) (maxFrameSize uint32, healthcheck bool, err error) {
+	kvs, err := decodeKVList(payload)
+	if err != nil {
+		return 0, false, spopError{status: statusInvalidFrame, message: fmt.Sprintf("invalid HELLO frame: %s", err)}
+	}
+
+	versions, ok := kvs["supported-versions"].(string)
+	if !ok {
+		return 0, false, spopError{status: statusNoVersion, message: "missing supported versions"}
+	}
+	supported := false
+	for v := range strings.SplitSeq(versions, ",") {
+		if strings.TrimSpace(v) == spopVersion {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return 0, false, spopError{status: statusUnsupportedVersion, message: fmt.Sprintf("unsupported versions %q", versions)}
+	}
+
+	size, ok := kvs["max-frame-size"].(uint64)
+	if !ok {
+		return 0, false, spopError{status: statusNoMaxFrameSize, message: "missing max frame size"}
+	}
+	if size < minFrameSize {
+		return 0, false, spopError{status: statusInvalidMaxFrameSize, message: fmt.Sprintf("max frame size %d is too small", size)}
+	}
+	maxFrameSize = uint32(min(size, defaultMaxFrameSize))
+
+	healthcheck, _ = kvs["healthcheck"].(bool)
+
+	return maxFrameSize, healthcheck, nil
+}
+
+func writeDisconnect(w io.Writer, status uint32, msg string) error {
+	var payload []byte
+	payload = appendString(payload, "status-code")
+	payload = appendTypedData(payload, status)
+	payload = appendString(payload, "message")
+	payload = appendTypedData(payload, msg)
+
+	return writeFrame(w, frame{typ: frameTypeAgentDisconnect, flags: frameFlagFin, payload: payload})
+}
+
+// authenticate authenticates the message arguments and returns the result variables.
+func (a *Agent) authenticate(ctx context.Context, msg message) []setVar {
+	token := parseToken(argString(msg.args[ArgToken]))
+	if token == "" {
+		return []setVar{{name: VarValid, value: false}, {name: VarReason, value: "missing token"}}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	resp, err := a.authAppSvc.Authenticate(ctx, auth.AuthenticateRequest{Review: model.TokenReview{
+		Token:      token,
+		HTTPMethod: argString(msg.args[ArgMethod]),
+		HTTPURL:    argString(msg.args[ArgURL]),
+	}})
+	if err != nil {
+		a.logger.Errorf("auth app error: %s", err)
+		return []setVar{{name: VarValid, value: false}, {name: VarReason, value: "error authenticating"}}
+	}
+
+	if !resp.Authenticated {
+		return []setVar{{name: VarValid, value: false}, {name: VarReason, value: resp.Reason}}
+	}
+
+	vars := []setVar{{name: VarValid, value: true}, {name: VarClientID, value: resp.ClientID}}
+	if len(resp.Scopes) > 0 {
+		vars = append(vars, setVar{name: VarScopes, value: strings.Join(resp.Scopes, " ")})
+	}
+
+	return vars
+}
+
+// parseToken returns the token of a raw token or bearer authorization header argument.
+func parseToken(s string) string {
+	s = strings.TrimSpace(s)
+	scheme, token, ok := strings.Cut(s, " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+
+	return s
+}
+
+func argString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+
+	return ""
+}
@@ -0,0 +1,346 @@
+package spoe_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	appauth "github.com/slok/simple-ingress-external-auth/internal/app/auth"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+	"github.com/slok/simple-ingress-external-auth/internal/spoe"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+)
+
+var longToken = strings.Repeat("t", 300)
+
+var tokens = `
+{
+	"version": "v1",
+	"tokens": [
+		{"value": "token0", "client_id": "client0"},
+		{"value": "token1", "client_id": "client1", "allowed_url": "https://app.slok.dev/api/.*", "allowed_method": "GET"},
+		{"value": "` + longToken + `", "client_id": "client2"},
+		{"value": "token3", "client_id": "client3", "scopes": ["s0", "s1"]}
+	]
+}`
+
+// SPOP encoding helpers, check https://www.haproxy.org/download/3.0/doc/SPOE.txt.
+
+func varint(v uint64) []byte {
+	if v < 240 {
+		return []byte{byte(v)}
+	}
+
+	b := []byte{byte(v) | 240}
+	v = (v - 240) >> 4
+	for v >= 128 {
+		b = append(b, byte(v)|128)
+		v = (v - 128) >> 7
+	}
+
+	return append(b, byte(v))
+}
+
+func str(s string) []byte { return append(varint(uint64(len(s))), s...) }
+
+func typedStr(s string) []byte { return append([]byte{8}, str(s)...) }
+
+func typedBool(v bool) []byte {
+	if v {
+		return []byte{0x11}
+	}
+	return []byte{1}
+}
+
+func typedUint32(v uint64) []byte { return append([]byte{3}, varint(v)...) }
+
+func kv(k string, v []byte) []byte { return append(str(k), v...) }
+
+func encodeFrame(typ byte, streamID, frameID uint64, payload ...[]byte) []byte {
+	b := []byte{typ, 0, 0, 0, 1}
+	b = append(b, varint(streamID)...)
+	b = append(b, varint(frameID)...)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(b))), b...)
+}
+
+func haproxyHello(versions string, healthcheck bool) []byte {
+	return encodeFrame(1, 0, 0,
+		kv("supported-versions", typedStr(versions)),
+		kv("max-frame-size", typedUint32(16380)),
+		kv("capabilities", typedStr("pipelining")),
+		kv("healthcheck", typedBool(healthcheck)),
+	)
+}
+
+func agentHello() []byte {
+	return encodeFrame(101, 0, 0,
+		kv("version", typedStr("2.0")),
+		kv("max-frame-size", typedUint32(16380)),
+		kv("capabilities", typedStr("pipelining")),
+	)
+}
+
+func agentDisconnect(status uint64, msg string) []byte {
+	return encodeFrame(102, 0, 0,
+		kv("status-code", typedUint32(status)),
+		kv("message", typedStr(msg)),
+	)
+}
+
+func notify(streamID, frameID uint64, name string, args ...[]byte) []byte {
+	msg := append(str(name), byte(len(args)))
+	for _, a := range args {
+		msg = append(msg, a...)
+	}
+
+	return encodeFrame(3, streamID, frameID, msg)
+}
+
+func setVar(name string, v []byte) []byte {
+	return append(append([]byte{1, 3, 2}, str(name)...), v...)
+}
+
+func ack(streamID, frameID uint64, actions ...[]byte) []byte {
+	return encodeFrame(103, streamID, frameID, actions...)
+}
+
+func TestAgent(t *testing.T) {
+	tests := map[string]struct {
+		config      spoe.Config
+		tokenGetter appauth.TokenGetter
+		send        [][]byte
+		exp         [][]byte
+	}{
+		"A valid token should set the valid and client ID variables.": {
+			send: [][]byte{
+				haproxyHello("2.0", false),
+				notify(1, 1, "authenticate", kv("token", typedStr("token0"))),
+			},
+			exp: [][]byte{
+				agentHello(),
+				ack(1, 1, setVar("valid", typedBool(true)), setVar("client_id", typedStr("client0"))),
+			},
+		},
+
+		"A valid token with scopes should set the scopes variable.": {
+			send: [][]byte{
+				haproxyHello("2.0", false),
+				notify(1, 1, "authenticate", kv("token", typedStr("token3"))),
+			},
+			exp: [][]byte{
+				agentHello(),
+				ack(1, 1, setVar("valid", typedBool(true)), setVar("client_id", typedStr("client3")), setVar("scopes", typedStr("s0 s1"))),
+			},
+		},
+
+		"A valid bearer authorization header token should set the valid and client ID variables.": {
+			send: [][]byte{
+				haproxyHello("1.0, 2.0", false),
+				notify(1, 1, "authenticate", kv("token", typedStr("Bearer token0"))),
+			},
+			exp: [][]byte{
+				agentHello(),
+				ack(1, 1, setVar("valid", typedBool(true)), setVar("client_id", typedStr("client0"))),
+			},
+		},
+
+		"The token should be validated with the method and URL arguments.": {
+			send: [][]byte{
+				haproxyHello("2.0", false),
+				notify(1, 1, "authenticate",
+					kv("token", typedStr("token1")),
+					kv("method", typedStr("GET")),
+					kv("url", typedStr("https://app.slok.dev/api/users"))),
+			},
+			exp: [][]byte{
+				agentHello(),
+				ack(1, 1, setVar("valid", typedBool(true)), setVar("client_id", typedStr("client1"))),
+			},
+		},
+
+		"A token with a not allowed URL should set the valid and reason variables.": {
+			send: [][]byte{
+				haproxyHello("2.0", false),
+				notify(300, 2, "authenticate",
+					kv("token", typedStr("token1")),
+					kv("method", typedStr("GET")),
+					kv("url", typedStr("https://app.slok.dev/admin"))),
+			},
+			exp: [][]byte{
+				agentHello(),
+				ack(300, 2, setVar("valid", typedBool(false)), setVar("reason", typedStr("invalidURL"))),
+			},
+		},
+
+		"A slow authentication should time out without blocking the next pipelined frames.": {
+			config: spoe.Config{Timeout: 200 * time.Millisecond},
+			tokenGetter: appauth.TokenGetterFunc(func(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error) {
+				if tokenValue == "slow" {
+					<-ctx.Done()
+					return nil, ctx.Err()
+				}
+				return &model.StaticTokenValidation{Value: tokenValue, ClientID: "client0"}, nil
+			}),
+			send: [][]byte{
+				haproxyHello("2.0", false),
+				notify(1, 1, "authenticate", kv("token", typedStr("slow"))),
+				notify(2, 1, "authenticate", kv("token", typedStr("token0"))),
+			},
+			exp: [][]byte{
+				agentHello(),
+				ack(2, 1, setVar("valid", typedBool(true)), setVar("client_id", typedStr("client0"))),
+				ack(1, 1, setVar("valid", typedBool(false)), setVar("reason", typedStr("error authenticating"))),
+			},
+		},
+
+		"A long token should be decoded.": {
+			send: [][]byte{
+				haproxyHello("2.0", false),
+				notify(1, 1, "authenticate", kv("token", typedStr(longToken))),
+			},
+			exp: [][]byte{
+				agentHello(),
+				ack(1, 1, setVar("valid", typedBool(true)), setVar("client_id", typedStr("client2"))),
+			},
+		},
+
+		"An invalid token should set the valid and reason variables.": {
+			send: [][]byte{
+				haproxyHello("2.0", false),
+				notify(1, 1, "authenticate", kv("token", typedStr("token9"))),
+			},
+			exp: [][]byte{
+				agentHello(),
+				ack(1, 1, setVar("valid", typedBool(false)), setVar("reason", typedStr("invalidToken"))),
+			},
+		},
+
+		"A missing token should set the valid and reason variables.": {
+			send: [][]byte{
+				haproxyHello("2.0", false),
+				notify(1, 1, "authenticate"),
+			},
+			exp: [][]byte{
+				agentHello(),
+				ack(1, 1, setVar("valid", typedBool(false)), setVar("reason", typedStr("missing token"))),
+			},
+		},
+
+		"Other messages should be acknowledged without variables.": {
+			send: [][]byte{
+				haproxyHello("2.0", false),
+				notify(1, 1, "other", kv("token", typedStr("token0"))),
+			},
+			exp: [][]byte{
+				agentHello(),
+				ack(1, 1),
+			},
+		},
+
+		"A custom message name should be used.": {
+			config: spoe.Config{MessageName: "check-auth"},
+			send: [][]byte{
+				haproxyHello("2.0", false),
+				notify(1, 1, "check-auth", kv("token", typedStr("token0"))),
+			},
+			exp: [][]byte{
+				agentHello(),
+				ack(1, 1, setVar("valid", typedBool(true)), setVar("client_id", typedStr("client0"))),
+			},
+		},
+
+		"A HAProxy disconnect should be answered with a disconnect.": {
+			send: [][]byte{
+				haproxyHello("2.0", false),
+				encodeFrame(2, 0, 0, kv("status-code", typedUint32(0)), kv("message", typedStr(""))),
+			},
+			exp: [][]byte{
+				agentHello(),
+				agentDisconnect(0, ""),
+			},
+		},
+
+		"A health check should only do the handshake.": {
+			send: [][]byte{
+				haproxyHello("2.0", true),
+			},
+			exp: [][]byte{
+				agentHello(),
+			},
+		},
+
+		"An unsupported version should disconnect.": {
+			send: [][]byte{
+				haproxyHello("1.0", false),
+			},
+			exp: [][]byte{
+				agentDisconnect(8, `unsupported versions "1.0"`),
+			},
+		},
+
+		"A frame that is not a hello on the handshake should disconnect.": {
+			send: [][]byte{
+				notify(1, 1, "authenticate", kv("token", typedStr("token0"))),
+			},
+			exp: [][]byte{
+				agentDisconnect(4, "expected HAProxy HELLO frame"),
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Create dependencies.
+			tokenGetter := test.tokenGetter
+			if tokenGetter == nil {
+				repo, err := memory.NewTokenRepository(log.Noop, tokens)
+				require.NoError(err)
+				tokenGetter = repo
+			}
+			svc, err := appauth.NewService(appauth.ServiceConfig{TokenGetter: tokenGetter})
+			require.NoError(err)
+
+			// Run agent.
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(err)
+			agent := spoe.New(log.Noop, svc, test.config)
+			go func() { _ = agent.Serve(l) }()
+			defer agent.Close()
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			require.NoError(err)
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			for _, f := range test.send {
+				_, err := conn.Write(f)
+				require.NoError(err)
+			}
+
+			// Check the agent frames.
+			var exp []byte
+			for _, f := range test.exp {
+				exp = append(exp, f...)
+			}
+			got := make([]byte, len(exp))
+			_, err = io.ReadFull(conn, got)
+			require.NoError(err)
+			assert.Equal(exp, got)
+		})
+	}
+}
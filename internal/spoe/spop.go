@@ -0,0 +1,356 @@
+package spoe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// SPOP (Stream Processing Offload Protocol) v2 implementation, only the parts used by the agent, check
+// https://www.haproxy.org/download/3.0/doc/SPOE.txt.
+
+const spopVersion = "2.0"
+
+// Frame types.
+const (
+	frameTypeHAProxyHello      byte = 1
+	frameTypeHAProxyDisconnect byte = 2
+	frameTypeNotify            byte = 3
+	frameTypeAgentHello        byte = 101
+	frameTypeAgentDisconnect   byte = 102
+	frameTypeAck               byte = 103
+)
+
+// frameFlagFin is set on the last frame of a fragmented payload, fragmentation is not supported, so all
+// the frames must have it.
+const frameFlagFin uint32 = 0x01
+
+// Data types, the type is on the lowest 4 bits, the highest 4 bits are the flags.
+const (
+	dataTypeNull   byte = 0
+	dataTypeBool   byte = 1
+	dataTypeInt32  byte = 2
+	dataTypeUint32 byte = 3
+	dataTypeInt64  byte = 4
+	dataTypeUint64 byte = 5
+	dataTypeIPv4   byte = 6
+	dataTypeIPv6   byte = 7
+	dataTypeString byte = 8
+	dataTypeBinary byte = 9
+
+	dataFlagTrue byte = 0x10
+)
+
+// Action types and variable scopes.
+const (
+	actionTypeSetVar byte = 1
+	varScopeTxn      byte = 2
+)
+
+// Disconnect status codes.
+const (
+	statusNormal                   uint32 = 0
+	statusFrameTooBig              uint32 = 3
+	statusInvalidFrame             uint32 = 4
+	statusNoVersion                uint32 = 5
+	statusNoMaxFrameSize           uint32 = 6
+	statusUnsupportedVersion       uint32 = 8
+	statusInvalidMaxFrameSize      uint32 = 9
+	statusFragmentationUnsupported uint32 = 10
+)
+
+// Frame sizes, the max frame size is the HAProxy default.
+const (
+	minFrameSize        = 256
+	defaultMaxFrameSize = 16380
+)
+
+// spopError is a protocol error, the agent disconnects with its status code.
+type spopError struct {
+	status  uint32
+	message string
+}
+
+func (e spopError) Error() string { return e.message }
+
+var errMalformed = errors.New("malformed data")
+
+type frame struct {
+	typ      byte
+	flags    uint32
+	streamID uint64
+	frameID  uint64
+	payload  []byte
+}
+
+// readFrame reads a frame, the frames bigger than max size are not read.
+func readFrame(r io.Reader, maxSize uint32) (*frame, error) {
+	var size uint32
+	err := binary.Read(r, binary.BigEndian, &size)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxSize {
+		return nil, spopError{status: statusFrameTooBig, message: fmt.Sprintf("frame of %d bytes is too big", size)}
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, err
+	}
+
+	d := decoder{b: data}
+	f := &frame{}
+	f.typ, err = d.byte()
+	if err != nil {
+		return nil, spopError{status: statusInvalidFrame, message: "invalid frame"}
+	}
+	flags, err := d.next(4)
+	if err != nil {
+		return nil, spopError{status: statusInvalidFrame, message: "invalid frame"}
+	}
+	f.flags = binary.BigEndian.Uint32(flags)
+	f.streamID, err = d.varint()
+	if err != nil {
+		return nil, spopError{status: statusInvalidFrame, message: "invalid frame"}
+	}
+	f.frameID, err = d.varint()
+	if err != nil {
+		return nil, spopError{status: statusInvalidFrame, message: "invalid frame"}
+	}
+	f.payload = d.b
+
+	return f, nil
+}
+
+func writeFrame(w io.Writer, f frame) error {
+	data := []byte{0, 0, 0, 0, f.typ}
+	data = binary.BigEndian.AppendUint32(data, f.flags)
+	data = appendVarint(data, f.streamID)
+	data = appendVarint(data, f.frameID)
+	data = append(data, f.payload...)
+	binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+
+	_, err := w.Write(data)
+	return err
+}
+
+// message is a NOTIFY frame message.
+type message struct {
+	name string
+	args map[string]any
+}
+
+// decodeMessages decodes the NOTIFY frame payload messages.
+func decodeMessages(payload []byte) ([]message, error) {
+	d := decoder{b: payload}
+	var msgs []message
+	for !d.empty() {
+		name, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		nbArgs, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		msg := message{name: name, args: map[string]any{}}
+		for range int(nbArgs) {
+			k, v, err := d.kv()
+			if err != nil {
+				return nil, err
+			}
+			msg.args[k] = v
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, nil
+}
+
+// decodeKVList decodes a HELLO or DISCONNECT frame payload.
+func decodeKVList(payload []byte) (map[string]any, error) {
+	d := decoder{b: payload}
+	kvs := map[string]any{}
+	for !d.empty() {
+		k, v, err := d.kv()
+		if err != nil {
+			return nil, err
+		}
+		kvs[k] = v
+	}
+
+	return kvs, nil
+}
+
+// setVar is a set-var action on the transaction scope.
+type setVar struct {
+	name  string
+	value any
+}
+
+// encodeActions encodes the ACK frame payload.
+func encodeActions(actions []setVar) []byte {
+	var b []byte
+	for _, a := range actions {
+		b = append(b, actionTypeSetVar, 3, varScopeTxn)
+		b = appendString(b, a.name)
+		b = appendTypedData(b, a.value)
+	}
+
+	return b
+}
+
+type decoder struct {
+	b []byte
+}
+
+func (d *decoder) empty() bool { return len(d.b) == 0 }
+
+func (d *decoder) next(n uint64) ([]byte, error) {
+	if uint64(len(d.b)) < n {
+		return nil, errMalformed
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+
+	return b, nil
+}
+
+func (d *decoder) byte() (byte, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+
+	return b[0], nil
+}
+
+// varint decodes the SPOP variable-length integers.
+func (d *decoder) varint() (uint64, error) {
+	b, err := d.byte()
+	if err != nil {
+		return 0, err
+	}
+
+	v := uint64(b)
+	if v < 240 {
+		return v, nil
+	}
+
+	for shift := 4; ; shift += 7 {
+		if shift > 63 {
+			return 0, errMalformed
+		}
+		b, err = d.byte()
+		if err != nil {
+			return 0, err
+		}
+		v += uint64(b) << shift
+		if b < 128 {
+			return v, nil
+		}
+	}
+}
+
+func (d *decoder) string() (string, error) {
+	n, err := d.varint()
+	if err != nil {
+		return "", err
+	}
+	b, err := d.next(n)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func (d *decoder) kv() (string, any, error) {
+	k, err := d.string()
+	if err != nil {
+		return "", nil, err
+	}
+	v, err := d.typedData()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return k, v, nil
+}
+
+func (d *decoder) typedData() (any, error) {
+	t, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t & 0x0f {
+	case dataTypeNull:
+		return nil, nil
+	case dataTypeBool:
+		return t&dataFlagTrue != 0, nil
+	case dataTypeInt32, dataTypeInt64:
+		v, err := d.varint()
+		return int64(v), err
+	case dataTypeUint32, dataTypeUint64:
+		return d.varint()
+	case dataTypeIPv4:
+		b, err := d.next(net.IPv4len)
+		return net.IP(b), err
+	case dataTypeIPv6:
+		b, err := d.next(net.IPv6len)
+		return net.IP(b), err
+	case dataTypeString:
+		return d.string()
+	case dataTypeBinary:
+		n, err := d.varint()
+		if err != nil {
+			return nil, err
+		}
+		return d.next(n)
+	}
+
+	return nil, errMalformed
+}
+
+// appendVarint encodes the SPOP variable-length integers.
+func appendVarint(b []byte, v uint64) []byte {
+	if v < 240 {
+		return append(b, byte(v))
+	}
+
+	b = append(b, byte(v)|240)
+	v = (v - 240) >> 4
+	for v >= 128 {
+		b = append(b, byte(v)|128)
+		v = (v - 128) >> 7
+	}
+
+	return append(b, byte(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendVarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendTypedData(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return append(b, dataTypeBool|dataFlagTrue)
+		}
+		return append(b, dataTypeBool)
+	case uint32:
+		return appendVarint(append(b, dataTypeUint32), uint64(v))
+	case string:
+		return appendString(append(b, dataTypeString), v)
+	}
+
+	return append(b, dataTypeNull)
+}
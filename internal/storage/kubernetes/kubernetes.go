@@ -0,0 +1,168 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listerscorev1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+	apiv1 "github.com/slok/simple-ingress-external-auth/pkg/api/v1"
+)
+
+// Reasons of the ignored Secrets.
+const (
+	ignoredReasonInvalid  = "invalid"
+	ignoredReasonConflict = "conflict"
+)
+
+// TokenRepository loads the tokens from Kubernetes Secrets, one token per Secret.
+//
+// The Secrets are watched and the tokens updated when they change. Invalid Secrets and the
+// Secrets that conflict with others (e.g duplicated tokens) are ignored, the rest are loaded.
+type TokenRepository struct {
+	repo       *memory.TokenRepository
+	lister     listerscorev1.SecretLister
+	selector   labels.Selector
+	metricsRec metrics.Recorder
+	logger     log.Logger
+
+	mu sync.Mutex
+}
+
+// NewTokenRepository returns a new TokenRepository. It will wait until the Secrets have been loaded
+// and will keep watching them until the context is cancelled.
+// An empty namespace will watch the Secrets on all namespaces.
+func NewTokenRepository(ctx context.Context, logger log.Logger, metricsRec metrics.Recorder, client kubernetes.Interface, namespace, labelSelector string) (*TokenRepository, error) {
+	selector, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector: %w", err)
+	}
+
+	logger = logger.WithValues(log.Kv{"svc": "kubernetes.TokenRepository", "namespace": namespace, "selector": labelSelector})
+
+	repo, err := memory.NewTokenRepositoryFromConfigV1(logger, apiv1.Config{Version: "v1"})
+	if err != nil {
+		return nil, fmt.Errorf("could not create memory token repository: %w", err)
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = selector.String()
+		}),
+	)
+	secretInformer := factory.Core().V1().Secrets()
+
+	r := &TokenRepository{
+		repo:       repo,
+		lister:     secretInformer.Lister(),
+		selector:   selector,
+		metricsRec: metricsRec,
+		logger:     logger,
+	}
+
+	// Until the initial list has been synced, we don't need to load the tokens on every event.
+	informer := secretInformer.Informer()
+	onEvent := func() {
+		if informer.HasSynced() {
+			r.sync()
+		}
+	}
+	_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj any) { onEvent() },
+		UpdateFunc: func(oldObj, newObj any) { onEvent() },
+		DeleteFunc: func(obj any) { onEvent() },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not add Secret event handler: %w", err)
+	}
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return nil, fmt.Errorf("could not sync Kubernetes Secrets")
+	}
+	r.sync()
+
+	return r, nil
+}
+
+func (r *TokenRepository) GetStaticTokenValidation(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error) {
+	return r.repo.GetStaticTokenValidation(ctx, tokenValue)
+}
+
+func (r *TokenRepository) sync() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	secrets, err := r.lister.List(r.selector)
+	if err != nil {
+		r.logger.Errorf("Could not list Secrets: %s", err)
+		return
+	}
+
+	// Be deterministic.
+	sort.Slice(secrets, func(i, j int) bool {
+		return secretID(secrets[i]) < secretID(secrets[j])
+	})
+
+	type secretToken struct {
+		secret *corev1.Secret
+		token  apiv1.Token
+		key    string
+	}
+
+	var tokens []secretToken
+	secretsByKey := map[string][]string{}
+	for _, s := range secrets {
+		token, err := mapSecretToTokenV1(s)
+		if err == nil {
+			err = memory.ValidateConfigV1(apiv1.Config{Version: "v1", Tokens: []apiv1.Token{*token}})
+		}
+		var key string
+		if err == nil {
+			key, err = tokenKey(*token)
+		}
+		if err != nil {
+			r.logger.WithValues(log.Kv{"secret": secretID(s)}).Warningf("Ignoring invalid token Secret: %s", err)
+			r.metricsRec.TokenSecretIgnored(context.Background(), ignoredReasonInvalid)
+			continue
+		}
+
+		tokens = append(tokens, secretToken{secret: s, token: *token, key: key})
+		secretsByKey[key] = append(secretsByKey[key], secretID(s))
+	}
+
+	// The Secrets with the same token are ignored, none of them is trusted over the others.
+	config := apiv1.Config{Version: "v1"}
+	for _, t := range tokens {
+		if ids := secretsByKey[t.key]; len(ids) > 1 {
+			r.logger.WithValues(log.Kv{"secret": secretID(t.secret)}).Warningf("Ignoring token Secret, the token is also declared on %s", strings.Join(ids, ", "))
+			r.metricsRec.TokenSecretIgnored(context.Background(), ignoredReasonConflict)
+			continue
+		}
+
+		config.Tokens = append(config.Tokens, t.token)
+	}
+
+	err = r.repo.ReloadConfigV1(config)
+	if err != nil {
+		r.logger.Errorf("Could not load tokens from Secrets, keeping the previous ones: %s", err)
+	}
+}
+
+func secretID(s *corev1.Secret) string {
+	return s.Namespace + "/" + s.Name
+}
@@ -0,0 +1,215 @@
+package kubernetes_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/metrics"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/kubernetes"
+)
+
+func newSecret(ns, name string, labels, annotations map[string]string, data map[string]string) *corev1.Secret {
+	s := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   ns,
+			Name:        name,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Data: map[string][]byte{},
+	}
+	for k, v := range data {
+		s.Data[k] = []byte(v)
+	}
+
+	return s
+}
+
+var tokenLabels = map[string]string{"auth": "token"}
+
+func TestTokenRepositoryGetStaticTokenValidation(t *testing.T) {
+	tests := map[string]struct {
+		secrets  []runtime.Object
+		token    string
+		expToken *model.StaticTokenValidation
+		expErr   bool
+	}{
+		"A missing token should fail.": {
+			secrets: []runtime.Object{
+				newSecret("ns0", "s0", tokenLabels, nil, map[string]string{"value": "t0"}),
+			},
+			token:  "t1",
+			expErr: true,
+		},
+
+		"A token on a Secret without the labels should fail.": {
+			secrets: []runtime.Object{
+				newSecret("ns0", "s0", nil, nil, map[string]string{"value": "t0"}),
+			},
+			token:  "t0",
+			expErr: true,
+		},
+
+		"A disabled token should fail.": {
+			secrets: []runtime.Object{
+				newSecret("ns0", "s0", tokenLabels, nil, map[string]string{"value": "t0", "disable": "true"}),
+			},
+			token:  "t0",
+			expErr: true,
+		},
+
+		"A basic token should use the Secret as the client ID.": {
+			secrets: []runtime.Object{
+				newSecret("ns0", "s0", tokenLabels, nil, map[string]string{"value": "t0"}),
+			},
+			token: "t0",
+			expToken: &model.StaticTokenValidation{
+				Value:    "t0",
+				ClientID: "ns0/s0",
+			},
+		},
+
+		"A token with properties on annotations and data should be returned.": {
+			secrets: []runtime.Object{
+				newSecret("ns0", "s0", tokenLabels,
+					map[string]string{
+						"simple-ingress-external-auth.slok.dev/client_id":      "c0",
+						"simple-ingress-external-auth.slok.dev/allowed_url":    "https://custom.host.slok.dev/.*",
+						"simple-ingress-external-auth.slok.dev/allowed_method": "PUT",
+					},
+					map[string]string{
+						"value":          "t0",
+						"expires_at":     "2022-07-04T14:21:22.52Z",
+						"allowed_method": "(GET|POST)",
+					}),
+			},
+			token: "t0",
+			expToken: &model.StaticTokenValidation{
+				Value:     "t0",
+				ClientID:  "ns0/c0",
+				ExpiresAt: time.Date(2022, time.Month(7), 4, 14, 21, 22, 520000000, time.UTC),
+				Common: model.TokenCommon{
+					AllowedURL:    regexp.MustCompile(`https://custom.host.slok.dev/.*`),
+					AllowedMethod: regexp.MustCompile(`(GET|POST)`),
+				},
+			},
+		},
+
+		"A Secret client ID should be namespaced.": {
+			secrets: []runtime.Object{
+				newSecret("ns0", "s0", tokenLabels, map[string]string{"simple-ingress-external-auth.slok.dev/client_id": "ns1/s1"}, map[string]string{"value": "t0"}),
+			},
+			token: "t0",
+			expToken: &model.StaticTokenValidation{
+				Value:    "t0",
+				ClientID: "ns0/ns1/s1",
+			},
+		},
+
+		"Secrets with the same token should be ignored.": {
+			secrets: []runtime.Object{
+				newSecret("ns0", "s0", tokenLabels, nil, map[string]string{"value": "t0"}),
+				newSecret("ns1", "s1", tokenLabels, nil, map[string]string{"value": "t0"}),
+			},
+			token:  "t0",
+			expErr: true,
+		},
+
+		"Secrets with the same plain and hashed token should be ignored.": {
+			secrets: []runtime.Object{
+				newSecret("ns0", "s0", tokenLabels, nil, map[string]string{"value": "test"}),
+				newSecret("ns1", "s1", tokenLabels, nil, map[string]string{"value_hash": "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}),
+			},
+			token:  "test",
+			expErr: true,
+		},
+
+		"Secrets with the same token should not affect the others.": {
+			secrets: []runtime.Object{
+				newSecret("ns0", "s0", tokenLabels, nil, map[string]string{"value": "t0"}),
+				newSecret("ns1", "s1", tokenLabels, nil, map[string]string{"value": "t0"}),
+				newSecret("ns2", "s2", tokenLabels, nil, map[string]string{"value": "t2"}),
+			},
+			token: "t2",
+			expToken: &model.StaticTokenValidation{
+				Value:    "t2",
+				ClientID: "ns2/s2",
+			},
+		},
+
+		"An invalid Secret should be ignored and not affect the others.": {
+			secrets: []runtime.Object{
+				newSecret("ns0", "s0", tokenLabels, nil, map[string]string{"value": "t0", "allowed_url": "("}),
+				newSecret("ns1", "s1", tokenLabels, nil, map[string]string{"value": "t1"}),
+			},
+			token: "t1",
+			expToken: &model.StaticTokenValidation{
+				Value:    "t1",
+				ClientID: "ns1/s1",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			client := fake.NewClientset(test.secrets...)
+			repo, err := kubernetes.NewTokenRepository(ctx, log.Noop, metrics.Noop, client, "", "auth=token")
+			require.NoError(err)
+
+			token, err := repo.GetStaticTokenValidation(ctx, test.token)
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				assert.Equal(test.expToken, token)
+			}
+		})
+	}
+}
+
+func TestTokenRepositoryWatchSecrets(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewClientset(newSecret("ns0", "s0", tokenLabels, nil, map[string]string{"value": "t0"}))
+	repo, err := kubernetes.NewTokenRepository(ctx, log.Noop, metrics.Noop, client, "", "auth=token")
+	require.NoError(err)
+
+	// Rotate the token.
+	_, err = client.CoreV1().Secrets("ns0").Update(ctx, newSecret("ns0", "s0", tokenLabels, nil, map[string]string{"value": "t1"}), metav1.UpdateOptions{})
+	require.NoError(err)
+
+	assert.Eventually(func() bool {
+		_, err0 := repo.GetStaticTokenValidation(ctx, "t0")
+		_, err1 := repo.GetStaticTokenValidation(ctx, "t1")
+		return err0 != nil && err1 == nil
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// Delete the token.
+	err = client.CoreV1().Secrets("ns0").Delete(ctx, "s0", metav1.DeleteOptions{})
+	require.NoError(err)
+
+	assert.Eventually(func() bool {
+		_, err := repo.GetStaticTokenValidation(ctx, "t1")
+		return err != nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,91 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/slok/simple-ingress-external-auth/internal/tokenhash"
+	apiv1 "github.com/slok/simple-ingress-external-auth/pkg/api/v1"
+)
+
+// AnnotationPrefix is the prefix of the Secret annotations that can be used to set the
+// token properties that are not secret (e.g `simple-ingress-external-auth.slok.dev/client_id`).
+const AnnotationPrefix = "simple-ingress-external-auth.slok.dev/"
+
+// Secret keys, the same as the v1 configuration token fields.
+const (
+	keyValue         = "value"
+	keyValueHash     = "value_hash"
+	keyClientID      = "client_id"
+	keyExpiresAt     = "expires_at"
+	keyAllowedURL    = "allowed_url"
+	keyAllowedMethod = "allowed_method"
+	keyDisable       = "disable"
+)
+
+func mapSecretToTokenV1(s *corev1.Secret) (*apiv1.Token, error) {
+	// Secret data has priority over annotations.
+	get := func(key string) string {
+		if v, ok := s.Data[key]; ok {
+			return string(v)
+		}
+		return s.Annotations[AnnotationPrefix+key]
+	}
+
+	t := &apiv1.Token{
+		// Only on data, these are secret.
+		Value:     string(s.Data[keyValue]),
+		ValueHash: string(s.Data[keyValueHash]),
+		ClientID:  get(keyClientID),
+	}
+	t.AllowedURLRegex = get(keyAllowedURL)
+	t.AllowedMethodRegex = get(keyAllowedMethod)
+
+	// By default identify the token by its Secret. The client IDs are namespaced so a namespace
+	// can't claim the client ID of other namespaces.
+	if t.ClientID == "" {
+		t.ClientID = s.Namespace + "/" + s.Name
+	} else {
+		t.ClientID = s.Namespace + "/" + t.ClientID
+	}
+
+	if v := get(keyExpiresAt); v != "" {
+		expiresAt, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", keyExpiresAt, err)
+		}
+		t.ExpiresAt = &expiresAt
+	}
+
+	if v := get(keyDisable); v != "" {
+		disable, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", keyDisable, err)
+		}
+		t.Disable = disable
+	}
+
+	return t, nil
+}
+
+// tokenKey returns the key that identifies the token value, the tokens with the same key
+// can't be loaded at the same time.
+func tokenKey(t apiv1.Token) (string, error) {
+	if t.ValueHash == "" {
+		return tokenhash.AlgorithmSHA256 + ":" + tokenhash.SHA256(t.Value), nil
+	}
+
+	hash, err := tokenhash.Parse(t.ValueHash)
+	if err != nil {
+		return "", err
+	}
+
+	if hash.Algorithm == tokenhash.AlgorithmSHA256 {
+		return tokenhash.AlgorithmSHA256 + ":" + hash.SHA256, nil
+	}
+
+	return hash.Algorithm + ":" + hash.KeyID, nil
+}
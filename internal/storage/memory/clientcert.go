@@ -0,0 +1,130 @@
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+	apiv1 "github.com/slok/simple-ingress-external-auth/pkg/api/v1"
+)
+
+// Client certificate identity match kinds.
+const (
+	certMatchFingerprint = "fingerprint"
+	certMatchSAN         = "san"
+	certMatchSubject     = "subject"
+)
+
+// GetCertValidation returns the client certificate identity declared on the configuration that
+// matches the certificate. The matches are checked by fingerprint, SAN and subject, in that order.
+func (t *TokenRepository) GetCertValidation(ctx context.Context, cert *x509.Certificate) (*model.CertValidation, error) {
+	certs := t.tokens.Load().certs
+	for _, key := range certMatchKeys(cert) {
+		if e, ok := certs[key]; ok {
+			c := e.cert
+			return &c, nil
+		}
+	}
+
+	return nil, fmt.Errorf("client certificate not found: %w", internalerrors.ErrNotFound)
+}
+
+type certEntry struct {
+	cert   model.CertValidation
+	source string
+}
+
+func (t *tokenSet) addCert(source, key string, cert model.CertValidation) error {
+	if e, ok := t.certs[key]; ok {
+		if e.source == "" && source == "" {
+			return fmt.Errorf("client certificate %s has been declared multiple times", key)
+		}
+		return fmt.Errorf("client certificate %s has been declared multiple times (%s and %s)", key, e.source, source)
+	}
+	t.certs[key] = certEntry{cert: cert, source: source}
+
+	return nil
+}
+
+// mapClientCertV1ToModel maps a v1 client certificate identity returning also its match key, if the
+// identity is disabled it will return a nil identity.
+func mapClientCertV1ToModel(c apiv1.ClientCert) (string, *model.CertValidation, error) {
+	var matches []string
+	if c.Subject != "" {
+		matches = append(matches, certMatchKey(certMatchSubject, c.Subject))
+	}
+
+	if c.SAN != "" {
+		matches = append(matches, certMatchKey(certMatchSAN, c.SAN))
+	}
+
+	if c.Fingerprint != "" {
+		fp := normalizeFingerprint(c.Fingerprint)
+		d, err := hex.DecodeString(fp)
+		if err != nil || len(d) != sha256.Size {
+			return "", nil, fmt.Errorf("client %q certificate fingerprint must be a hex encoded SHA256", c.ClientID)
+		}
+		matches = append(matches, certMatchKey(certMatchFingerprint, fp))
+	}
+
+	if len(matches) != 1 {
+		return "", nil, fmt.Errorf("client %q certificate requires one of subject, SAN or fingerprint", c.ClientID)
+	}
+	key := matches[0]
+
+	if c.Disable {
+		return key, nil, nil
+	}
+
+	var expiresAt time.Time
+	if c.ExpiresAt != nil {
+		expiresAt = *c.ExpiresAt
+	}
+
+	common, err := mapCommonV1ToModel(c.Common)
+	if err != nil {
+		return "", nil, fmt.Errorf("client certificate %s: %w", key, err)
+	}
+
+	return key, &model.CertValidation{
+		ClientID:  c.ClientID,
+		ExpiresAt: expiresAt,
+		Common:    *common,
+	}, nil
+}
+
+// certMatchKeys returns the keys a certificate can match, ordered by priority.
+func certMatchKeys(cert *x509.Certificate) []string {
+	fp := sha256.Sum256(cert.Raw)
+	keys := []string{certMatchKey(certMatchFingerprint, hex.EncodeToString(fp[:]))}
+
+	for _, san := range cert.DNSNames {
+		keys = append(keys, certMatchKey(certMatchSAN, san))
+	}
+	for _, san := range cert.URIs {
+		keys = append(keys, certMatchKey(certMatchSAN, san.String()))
+	}
+	for _, san := range cert.EmailAddresses {
+		keys = append(keys, certMatchKey(certMatchSAN, san))
+	}
+	for _, san := range cert.IPAddresses {
+		keys = append(keys, certMatchKey(certMatchSAN, san.String()))
+	}
+
+	return append(keys, certMatchKey(certMatchSubject, cert.Subject.String()))
+}
+
+func certMatchKey(kind, value string) string {
+	return fmt.Sprintf("%s %q", kind, value)
+}
+
+func normalizeFingerprint(fp string) string {
+	fp = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(fp)), "sha256:")
+	return strings.ReplaceAll(fp, ":", "")
+}
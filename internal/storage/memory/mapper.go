@@ -4,82 +4,456 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/drone/envsubst"
 	"github.com/ghodss/yaml"
 
+	"github.com/slok/simple-ingress-external-auth/internal/htpasswd"
 	"github.com/slok/simple-ingress-external-auth/internal/model"
+	"github.com/slok/simple-ingress-external-auth/internal/tokenhash"
 	apiv1 "github.com/slok/simple-ingress-external-auth/pkg/api/v1"
+	apiv2 "github.com/slok/simple-ingress-external-auth/pkg/api/v2"
 )
 
-func mapJSONV1ToModel(data string) (map[string]model.StaticTokenValidation, error) {
+// DecodeConfig decodes a raw JSON or YAML v1 or v2 token configuration, substituting the env vars.
+// The v2 configurations are mapped to v1.
+func DecodeConfig(data string) (*apiv1.Config, error) {
+	version, err := DecodeConfigVersion(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch version {
+	case "v1":
+		return DecodeConfigV1(data)
+	case "v2":
+		c2, err := DecodeConfigV2(data)
+		if err != nil {
+			return nil, err
+		}
+		return mapConfigV2ToV1(*c2)
+	default:
+		return nil, fmt.Errorf("invalid version, expected v1 or v2, got %s", version)
+	}
+}
+
+// DecodeConfigVersion returns the version of a raw JSON or YAML token configuration.
+func DecodeConfigVersion(data string) (string, error) {
+	c := struct {
+		Version string `json:"version"`
+	}{}
+	err := decodeConfig(data, &c)
+	if err != nil {
+		return "", err
+	}
+
+	return c.Version, nil
+}
+
+// DecodeConfigV1 decodes a raw JSON or YAML v1 token configuration, substituting the env vars.
+func DecodeConfigV1(data string) (*apiv1.Config, error) {
+	c1 := apiv1.Config{}
+	err := decodeConfig(data, &c1)
+	if err != nil {
+		return nil, err
+	}
+
+	if c1.Version != "v1" {
+		return nil, fmt.Errorf("invalid version, expected v1, got %s", c1.Version)
+	}
+
+	return &c1, nil
+}
+
+// DecodeConfigV2 decodes a raw JSON or YAML v2 token configuration, substituting the env vars.
+func DecodeConfigV2(data string) (*apiv2.Config, error) {
+	c2 := apiv2.Config{}
+	err := decodeConfig(data, &c2)
+	if err != nil {
+		return nil, err
+	}
+
+	if c2.Version != "v2" {
+		return nil, fmt.Errorf("invalid version, expected v2, got %s", c2.Version)
+	}
+
+	return &c2, nil
+}
+
+func decodeConfig(data string, c any) error {
 	// Substitute env vars in the required strings.
 	envedData, err := envsubst.EvalEnv(data)
 	if err != nil {
-		return nil, fmt.Errorf("could not substitute env vars into the configuration: %w", err)
+		return fmt.Errorf("could not substitute env vars into the configuration: %w", err)
 	}
 
 	// Try loading first in JSON and then YAML.
-	c1 := apiv1.Config{}
-	err = json.Unmarshal([]byte(envedData), &c1)
+	err = json.Unmarshal([]byte(envedData), c)
 	if err != nil {
-		err2 := yaml.Unmarshal([]byte(envedData), &c1)
+		err2 := yaml.Unmarshal([]byte(envedData), c)
 		if err2 != nil {
-			return nil, fmt.Errorf("json and yaml unrmashal failed, json: %q, yaml: %q", err, err2)
+			return fmt.Errorf("json and yaml unrmashal failed, json: %q, yaml: %q", err, err2)
 		}
 	}
 
+	return nil
+}
+
+// mapConfigV2ToV1 flattens the v2 clients into v1 tokens, the credentials inherit the client properties.
+func mapConfigV2ToV1(c2 apiv2.Config) (*apiv1.Config, error) {
+	c1 := &apiv1.Config{Version: "v1"}
+	clientIDs := map[string]struct{}{}
+	for _, c := range c2.Clients {
+		err := checkClientIDV2(c, clientIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		appendClientV2ToV1(c1, c)
+	}
+
+	for _, u := range c2.Users {
+		c1.Users = append(c1.Users, mapUserV2ToV1(u))
+	}
+
+	return c1, nil
+}
+
+func mapUserV2ToV1(u apiv2.User) apiv1.User {
+	return apiv1.User{
+		Common: apiv1.Common{
+			Disable:            u.Disable,
+			AllowedURLRegex:    u.AllowedURLRegex,
+			AllowedMethodRegex: u.AllowedMethodRegex,
+		},
+		Username:     u.Username,
+		PasswordHash: u.PasswordHash,
+		ExpiresAt:    u.ExpiresAt,
+	}
+}
+
+// checkClientIDV2 checks that the client ID is set and it has not been declared before, the
+// declared client IDs are tracked on clientIDs.
+func checkClientIDV2(c apiv2.Client, clientIDs map[string]struct{}) error {
+	if c.ID == "" {
+		return fmt.Errorf("client id can't be empty")
+	}
+
+	if _, ok := clientIDs[c.ID]; ok {
+		return fmt.Errorf("client %q has been declared multiple times", c.ID)
+	}
+	clientIDs[c.ID] = struct{}{}
+
+	return nil
+}
+
+// appendClientV2ToV1 flattens the v2 client credentials, certificates and signature keys into
+// the v1 configuration.
+func appendClientV2ToV1(c1 *apiv1.Config, c apiv2.Client) {
+	for _, cred := range c.Credentials {
+		common, expiresAt := inheritClientCommonV2(c.Common, cred.Common)
+		c1.Tokens = append(c1.Tokens, apiv1.Token{
+			Common:     common,
+			Value:      cred.Value,
+			ValueHash:  cred.ValueHash,
+			ClientID:   c.ID,
+			ExpiresAt:  expiresAt,
+			Groups:     c.Groups,
+			Scopes:     c.Scopes,
+			Kubernetes: c.Kubernetes,
+		})
+	}
+
+	for _, cert := range c.Certificates {
+		common, expiresAt := inheritClientCommonV2(c.Common, cert.Common)
+		c1.ClientCerts = append(c1.ClientCerts, apiv1.ClientCert{
+			Common:      common,
+			ClientID:    c.ID,
+			Subject:     cert.Subject,
+			SAN:         cert.SAN,
+			Fingerprint: cert.Fingerprint,
+			ExpiresAt:   expiresAt,
+		})
+	}
+
+	for _, key := range c.SignatureKeys {
+		common, expiresAt := inheritClientCommonV2(c.Common, key.Common)
+		c1.SignatureKeys = append(c1.SignatureKeys, apiv1.SignatureKey{
+			Common:    common,
+			KeyID:     key.KeyID,
+			ClientID:  c.ID,
+			Algorithm: key.Algorithm,
+			Secret:    key.Secret,
+			PublicKey: key.PublicKey,
+			ExpiresAt: expiresAt,
+		})
+	}
+}
+
+// inheritClientCommonV2 returns the properties of a client credential: the URL and method restrictions
+// override the client ones, the credential can only be disabled and the earliest expiration wins.
+func inheritClientCommonV2(client, cred apiv2.Common) (apiv1.Common, *time.Time) {
+	common := apiv1.Common{
+		Disable:            client.Disable || cred.Disable,
+		AllowedURLRegex:    client.AllowedURLRegex,
+		AllowedMethodRegex: client.AllowedMethodRegex,
+	}
+
+	if cred.AllowedURLRegex != "" {
+		common.AllowedURLRegex = cred.AllowedURLRegex
+	}
+
+	if cred.AllowedMethodRegex != "" {
+		common.AllowedMethodRegex = cred.AllowedMethodRegex
+	}
+
+	expiresAt := client.ExpiresAt
+	if cred.ExpiresAt != nil && (expiresAt == nil || cred.ExpiresAt.Before(*expiresAt)) {
+		expiresAt = cred.ExpiresAt
+	}
+
+	return common, expiresAt
+}
+
+// ValidateConfigV1 checks that a v1 token configuration is valid and could be loaded.
+func ValidateConfigV1(c apiv1.Config) error {
+	_, err := mapConfigV1ToModel(c)
+	return err
+}
+
+func mapConfigV1ToModel(c1 apiv1.Config) (*tokenSet, error) {
+	tokens := newTokenSet()
+	err := addConfigV1ToModel(tokens, "", c1)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tokens.checkHashedDuplicates()
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// mapJSONConfigsToModel maps multiple raw v1 or v2 configurations into the same token set, the
+// configurations are identified by their source name (e.g the file path).
+func mapJSONConfigsToModel(configs map[string]string) (*tokenSet, error) {
+	// Be deterministic.
+	sources := make([]string, 0, len(configs))
+	for source := range configs {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	tokens := newTokenSet()
+	for _, source := range sources {
+		c1, err := DecodeConfig(configs[source])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+
+		err = addConfigV1ToModel(tokens, source, *c1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := tokens.checkHashedDuplicates()
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func addConfigV1ToModel(tokens *tokenSet, source string, c1 apiv1.Config) (err error) {
+	defer func() {
+		if err != nil && source != "" {
+			err = fmt.Errorf("%s: %w", source, err)
+		}
+	}()
+
 	if c1.Version != "v1" {
-		return nil, fmt.Errorf("invalid version, expected v1, got %s", c1.Version)
+		return fmt.Errorf("invalid version, expected v1, got %s", c1.Version)
 	}
 
 	// Map.
-	tokens := map[string]model.StaticTokenValidation{}
 	for _, t := range c1.Tokens {
-		if t.Value == "" {
-			return nil, fmt.Errorf("token value can't be empty")
+		token, hash, err := mapTokenV1ToModel(t)
+		if err != nil {
+			return err
 		}
 
-		if t.Disable {
+		// Disabled.
+		if token == nil {
 			continue
 		}
 
-		var expiresAt time.Time
-		if t.ExpiresAt != nil {
-			expiresAt = *t.ExpiresAt
+		// Check same token is not twice.
+		err = tokens.add(source, *token, hash)
+		if err != nil {
+			return err
 		}
+	}
 
-		token := model.StaticTokenValidation{
-			Value:     t.Value,
-			ClientID:  t.ClientID,
-			ExpiresAt: expiresAt,
+	for _, u := range c1.Users {
+		user, err := mapUserV1ToModel(u)
+		if err != nil {
+			return err
 		}
 
-		if t.AllowedMethodRegex != "" {
-			r, err := regexp.Compile(t.AllowedMethodRegex)
-			if err != nil {
-				return nil, fmt.Errorf("could not compile %s regex: %w", t.AllowedMethodRegex, err)
-			}
-			token.Common.AllowedMethod = r
+		// Disabled.
+		if user == nil {
+			continue
 		}
 
-		if t.AllowedURLRegex != "" {
-			r, err := regexp.Compile(t.AllowedURLRegex)
-			if err != nil {
-				return nil, fmt.Errorf("could not compile %s regex: %w", t.AllowedURLRegex, err)
-			}
-			token.Common.AllowedURL = r
+		err = tokens.addUser(source, *user)
+		if err != nil {
+			return err
 		}
+	}
 
-		// Check same token is not twice.
-		_, ok := tokens[token.Value]
-		if ok {
-			return nil, fmt.Errorf("a token has been declared multiple times")
+	for _, c := range c1.ClientCerts {
+		key, cert, err := mapClientCertV1ToModel(c)
+		if err != nil {
+			return err
+		}
+
+		// Disabled.
+		if cert == nil {
+			continue
 		}
 
-		tokens[token.Value] = token
+		err = tokens.addCert(source, key, *cert)
+		if err != nil {
+			return err
+		}
 	}
 
-	return tokens, nil
+	for _, k := range c1.SignatureKeys {
+		key, err := mapSignatureKeyV1ToModel(k)
+		if err != nil {
+			return err
+		}
+
+		// Disabled.
+		if key == nil {
+			continue
+		}
+
+		err = tokens.addSignatureKey(source, *key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mapTokenV1ToModel maps a v1 token, if the token is disabled it will return a nil token.
+func mapTokenV1ToModel(t apiv1.Token) (*model.StaticTokenValidation, *tokenhash.Hash, error) {
+	if t.Value == "" && t.ValueHash == "" {
+		return nil, nil, fmt.Errorf("token value can't be empty")
+	}
+
+	if t.Value != "" && t.ValueHash != "" {
+		return nil, nil, fmt.Errorf("token value and value hash can't be used at the same time")
+	}
+
+	if t.Disable {
+		return nil, nil, nil
+	}
+
+	var expiresAt time.Time
+	if t.ExpiresAt != nil {
+		expiresAt = *t.ExpiresAt
+	}
+
+	token := &model.StaticTokenValidation{
+		Value:      t.Value,
+		ClientID:   t.ClientID,
+		ExpiresAt:  expiresAt,
+		Groups:     t.Groups,
+		Scopes:     t.Scopes,
+		Kubernetes: t.Kubernetes,
+	}
+
+	common, err := mapCommonV1ToModel(t.Common)
+	if err != nil {
+		return nil, nil, err
+	}
+	token.Common = *common
+
+	var hash *tokenhash.Hash
+	if t.ValueHash != "" {
+		var err error
+		hash, err = tokenhash.Parse(t.ValueHash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid token value hash: %w", err)
+		}
+	}
+
+	return token, hash, nil
+}
+
+// mapUserV1ToModel maps a v1 user, if the user is disabled it will return a nil user.
+func mapUserV1ToModel(u apiv1.User) (*model.UserValidation, error) {
+	if u.Username == "" {
+		return nil, fmt.Errorf("user username can't be empty")
+	}
+
+	if strings.Contains(u.Username, ":") {
+		return nil, fmt.Errorf("user %q: username can't contain ':'", u.Username)
+	}
+
+	err := htpasswd.CheckHash(u.PasswordHash)
+	if err != nil {
+		return nil, fmt.Errorf("user %q: invalid password hash: %w", u.Username, err)
+	}
+
+	if u.Disable {
+		return nil, nil
+	}
+
+	var expiresAt time.Time
+	if u.ExpiresAt != nil {
+		expiresAt = *u.ExpiresAt
+	}
+
+	common, err := mapCommonV1ToModel(u.Common)
+	if err != nil {
+		return nil, fmt.Errorf("user %q: %w", u.Username, err)
+	}
+
+	return &model.UserValidation{
+		Username:     u.Username,
+		PasswordHash: u.PasswordHash,
+		ExpiresAt:    expiresAt,
+		Common:       *common,
+	}, nil
+}
+
+func mapCommonV1ToModel(c apiv1.Common) (*model.TokenCommon, error) {
+	common := &model.TokenCommon{}
+	if c.AllowedMethodRegex != "" {
+		r, err := regexp.Compile(c.AllowedMethodRegex)
+		if err != nil {
+			return nil, fmt.Errorf("could not compile %s regex: %w", c.AllowedMethodRegex, err)
+		}
+		common.AllowedMethod = r
+	}
+
+	if c.AllowedURLRegex != "" {
+		r, err := regexp.Compile(c.AllowedURLRegex)
+		if err != nil {
+			return nil, fmt.Errorf("could not compile %s regex: %w", c.AllowedURLRegex, err)
+		}
+		common.AllowedURL = r
+	}
+
+	return common, nil
 }
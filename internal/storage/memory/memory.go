@@ -3,32 +3,258 @@ package memory
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync/atomic"
 
 	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
 	"github.com/slok/simple-ingress-external-auth/internal/log"
 	"github.com/slok/simple-ingress-external-auth/internal/model"
+	"github.com/slok/simple-ingress-external-auth/internal/tokenhash"
+	apiv1 "github.com/slok/simple-ingress-external-auth/pkg/api/v1"
 )
 
 type TokenRepository struct {
-	tokens map[string]model.StaticTokenValidation
+	logger log.Logger
+	tokens atomic.Pointer[tokenSet]
 }
 
 func NewTokenRepository(logger log.Logger, config string) (*TokenRepository, error) {
-	tokens, err := mapJSONV1ToModel(config)
+	c, err := DecodeConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
-	logger.WithValues(log.Kv{"svc": "memory.TokenRepository", "tokens": len(tokens)}).Infof("Token validations loaded")
+	return NewTokenRepositoryFromConfigV1(logger, *c)
+}
+
+// NewTokenRepositoryFromConfigV1 returns a new TokenRepository from an already decoded configuration.
+func NewTokenRepositoryFromConfigV1(logger log.Logger, config apiv1.Config) (*TokenRepository, error) {
+	t := &TokenRepository{
+		logger: logger.WithValues(log.Kv{"svc": "memory.TokenRepository"}),
+	}
+
+	err := t.ReloadConfigV1(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// NewTokenRepositoryFromConfigs returns a new TokenRepository that merges multiple raw configurations.
+// The configurations are identified by their source name (e.g the file path).
+func NewTokenRepositoryFromConfigs(logger log.Logger, configs map[string]string) (*TokenRepository, error) {
+	t := &TokenRepository{
+		logger: logger.WithValues(log.Kv{"svc": "memory.TokenRepository"}),
+	}
+
+	err := t.ReloadConfigs(configs)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// ReloadConfigs will load and merge multiple raw configurations and replace the current ones
+// atomically. If the new configurations are invalid, the current ones will be kept.
+func (t *TokenRepository) ReloadConfigs(configs map[string]string) error {
+	tokens, err := mapJSONConfigsToModel(configs)
+	if err != nil {
+		return err
+	}
+
+	t.tokens.Store(tokens)
+	t.logger.WithValues(log.Kv{"tokens": tokens.len(), "users": len(tokens.users), "client-certs": len(tokens.certs), "signature-keys": len(tokens.signatureKeys), "configs": len(configs)}).Infof("Token validations loaded")
 
-	return &TokenRepository{tokens: tokens}, nil
+	return nil
 }
 
-func (t TokenRepository) GetStaticTokenValidation(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error) {
-	token, ok := t.tokens[tokenValue]
+// ReloadConfigV1 is like ReloadConfigs but with a single already decoded configuration.
+func (t *TokenRepository) ReloadConfigV1(config apiv1.Config) error {
+	tokens, err := mapConfigV1ToModel(config)
+	if err != nil {
+		return err
+	}
+
+	t.tokens.Store(tokens)
+	t.logger.WithValues(log.Kv{"tokens": tokens.len(), "users": len(tokens.users), "client-certs": len(tokens.certs), "signature-keys": len(tokens.signatureKeys)}).Infof("Token validations loaded")
+
+	return nil
+}
+
+func (t *TokenRepository) GetStaticTokenValidation(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error) {
+	token, ok := t.tokens.Load().get(tokenValue)
 	if !ok {
 		return nil, fmt.Errorf("token not found: %w", internalerrors.ErrNotFound)
 	}
 
 	return &token, nil
 }
+
+// GetUserValidation returns the Basic auth users declared on the configuration.
+func (t *TokenRepository) GetUserValidation(ctx context.Context, username string) (*model.UserValidation, error) {
+	e, ok := t.tokens.Load().users[username]
+	if !ok {
+		return nil, fmt.Errorf("user not found: %w", internalerrors.ErrNotFound)
+	}
+
+	user := e.user
+	return &user, nil
+}
+
+// tokenSet indexes the token validations by their value, or by their hash in case of
+// the hashed tokens. It also indexes the Basic auth users by their username, the client
+// certificate identities and the HTTP message signature keys by their key ID.
+type tokenSet struct {
+	byValue  map[string]tokenEntry
+	bySHA256 map[string]tokenEntry
+	// Salted hashes can't be indexed by the token, they are indexed by the token key ID so
+	// a token is verified at most against one salted hash.
+	salted map[string]saltedToken
+	users  map[string]userEntry
+	// certs are indexed by their match key (see certMatchKey).
+	certs         map[string]certEntry
+	signatureKeys map[string]signatureKeyEntry
+}
+
+// tokenEntry is a token with the name of the configuration where it was declared.
+type tokenEntry struct {
+	token  model.StaticTokenValidation
+	source string
+}
+
+type userEntry struct {
+	user   model.UserValidation
+	source string
+}
+
+type saltedToken struct {
+	hash   tokenhash.Hash
+	token  model.StaticTokenValidation
+	source string
+}
+
+func newTokenSet() *tokenSet {
+	return &tokenSet{
+		byValue:       map[string]tokenEntry{},
+		bySHA256:      map[string]tokenEntry{},
+		salted:        map[string]saltedToken{},
+		users:         map[string]userEntry{},
+		certs:         map[string]certEntry{},
+		signatureKeys: map[string]signatureKeyEntry{},
+	}
+}
+
+func (t *tokenSet) len() int {
+	return len(t.byValue) + len(t.bySHA256) + len(t.salted)
+}
+
+func (t *tokenSet) add(source string, token model.StaticTokenValidation, hash *tokenhash.Hash) error {
+	if hash == nil {
+		if e, ok := t.byValue[token.Value]; ok {
+			return newDuplicatedTokenError(e.source, source)
+		}
+		t.byValue[token.Value] = tokenEntry{token: token, source: source}
+		return nil
+	}
+
+	switch hash.Algorithm {
+	case tokenhash.AlgorithmSHA256:
+		if e, ok := t.bySHA256[hash.SHA256]; ok {
+			return newDuplicatedTokenError(e.source, source)
+		}
+		t.bySHA256[hash.SHA256] = tokenEntry{token: token, source: source}
+	default:
+		if e, ok := t.salted[hash.KeyID]; ok {
+			return newDuplicatedKeyIDError(hash.KeyID, e.source, source)
+		}
+		t.salted[hash.KeyID] = saltedToken{hash: *hash, token: token, source: source}
+	}
+
+	return nil
+}
+
+func (t *tokenSet) addUser(source string, user model.UserValidation) error {
+	if e, ok := t.users[user.Username]; ok {
+		if e.source == "" && source == "" {
+			return fmt.Errorf("user %q has been declared multiple times", user.Username)
+		}
+		return fmt.Errorf("user %q has been declared multiple times (%s and %s)", user.Username, e.source, source)
+	}
+	t.users[user.Username] = userEntry{user: user, source: source}
+
+	return nil
+}
+
+// checkHashedDuplicates checks that the plain tokens are not declared also as a hashed token.
+// Salted hashes can't be checked.
+func (t *tokenSet) checkHashedDuplicates() error {
+	errs := t.hashedDuplicateErrors()
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+// hashedDuplicateErrors is like checkHashedDuplicates but returns all the duplicates.
+func (t *tokenSet) hashedDuplicateErrors() []error {
+	if len(t.bySHA256) == 0 {
+		return nil
+	}
+
+	var errs []error
+	for value, e := range t.byValue {
+		if he, ok := t.bySHA256[tokenhash.SHA256(value)]; ok {
+			errs = append(errs, newDuplicatedTokenError(he.source, e.source))
+		}
+	}
+
+	// Be deterministic.
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+
+	return errs
+}
+
+func newDuplicatedTokenError(source1, source2 string) error {
+	if source1 == "" && source2 == "" {
+		return fmt.Errorf("a token has been declared multiple times")
+	}
+
+	return fmt.Errorf("a token has been declared multiple times (%s and %s)", source1, source2)
+}
+
+func newDuplicatedKeyIDError(keyID, source1, source2 string) error {
+	if source1 == "" && source2 == "" {
+		return fmt.Errorf("hashed token key ID %q has been declared multiple times", keyID)
+	}
+
+	return fmt.Errorf("hashed token key ID %q has been declared multiple times (%s and %s)", keyID, source1, source2)
+}
+
+func (t *tokenSet) get(tokenValue string) (model.StaticTokenValidation, bool) {
+	if e, ok := t.byValue[tokenValue]; ok {
+		return e.token, true
+	}
+
+	// For hashed tokens, once verified, we know the token value.
+	if len(t.bySHA256) > 0 {
+		if e, ok := t.bySHA256[tokenhash.SHA256(tokenValue)]; ok {
+			token := e.token
+			token.Value = tokenValue
+			return token, true
+		}
+	}
+
+	if len(t.salted) > 0 {
+		keyID := tokenhash.KeyID(tokenValue)
+		if st, ok := t.salted[keyID]; ok && keyID != "" && st.hash.Verify(tokenValue) {
+			token := st.token
+			token.Value = tokenValue
+			return token, true
+		}
+	}
+
+	return model.StaticTokenValidation{}, false
+}
@@ -2,14 +2,20 @@ package memory_test
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
 	"os"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
 	"github.com/slok/simple-ingress-external-auth/internal/log"
 	"github.com/slok/simple-ingress-external-auth/internal/model"
 	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
@@ -61,6 +67,29 @@ tokens:
 - value: t3
   disable: true
   allowed_method: PUT
+`
+	goodV2YAMLConfig = `
+version: v2
+clients:
+- id: c0
+  expires_at: 2022-07-04T14:21:22.52Z
+  allowed_url: https://custom.host.slok.dev/.*
+  allowed_method: (GET|POST)
+  credentials:
+  - value: t0
+  - value: t1
+    expires_at: 2022-06-04T14:21:22.52Z
+    allowed_method: PUT
+  - value: t2
+    expires_at: 2022-08-04T14:21:22.52Z
+  - value: t3
+    disable: true
+
+- id: c1
+  disable: true
+  credentials:
+  - value: t4
+  - value: t5
 `
 )
 
@@ -78,6 +107,60 @@ func TestTokenRepositoryGetStaticTokenValidation(t *testing.T) {
 			expErr: true,
 		},
 
+		"A v2 client credential should inherit the client properties.": {
+			config: goodV2YAMLConfig,
+			token:  "t0",
+			expToken: &model.StaticTokenValidation{
+				Value:     "t0",
+				ClientID:  "c0",
+				ExpiresAt: time.Date(2022, 7, 4, 14, 21, 22, 520000000, time.UTC),
+				Common: model.TokenCommon{
+					AllowedURL:    regexp.MustCompile(`https://custom.host.slok.dev/.*`),
+					AllowedMethod: regexp.MustCompile(`(GET|POST)`),
+				},
+			},
+		},
+
+		"A v2 client credential should override the client properties.": {
+			config: goodV2YAMLConfig,
+			token:  "t1",
+			expToken: &model.StaticTokenValidation{
+				Value:     "t1",
+				ClientID:  "c0",
+				ExpiresAt: time.Date(2022, 6, 4, 14, 21, 22, 520000000, time.UTC),
+				Common: model.TokenCommon{
+					AllowedURL:    regexp.MustCompile(`https://custom.host.slok.dev/.*`),
+					AllowedMethod: regexp.MustCompile(`PUT`),
+				},
+			},
+		},
+
+		"A v2 client credential should not expire after the client.": {
+			config: goodV2YAMLConfig,
+			token:  "t2",
+			expToken: &model.StaticTokenValidation{
+				Value:     "t2",
+				ClientID:  "c0",
+				ExpiresAt: time.Date(2022, 7, 4, 14, 21, 22, 520000000, time.UTC),
+				Common: model.TokenCommon{
+					AllowedURL:    regexp.MustCompile(`https://custom.host.slok.dev/.*`),
+					AllowedMethod: regexp.MustCompile(`(GET|POST)`),
+				},
+			},
+		},
+
+		"A v2 disabled credential should fail.": {
+			config: goodV2YAMLConfig,
+			token:  "t3",
+			expErr: true,
+		},
+
+		"A v2 disabled client should revoke all its credentials.": {
+			config: goodV2YAMLConfig,
+			token:  "t5",
+			expErr: true,
+		},
+
 		"If the token is disabled, it should fail": {
 			config: goodJSONConfig,
 			token:  "t3",
@@ -121,6 +204,66 @@ func TestTokenRepositoryGetStaticTokenValidation(t *testing.T) {
 			},
 		},
 
+		"A token with groups should return the groups.": {
+			config: `{"version": "v1", "tokens": [{"value": "t0", "client_id": "c0", "groups": ["g0", "g1"]}]}`,
+			token:  "t0",
+			expToken: &model.StaticTokenValidation{
+				Value:    "t0",
+				ClientID: "c0",
+				Groups:   []string{"g0", "g1"},
+			},
+		},
+
+		"A v2 credential should inherit the client groups.": {
+			config: `{"version": "v2", "clients": [{"id": "c0", "groups": ["g0"], "credentials": [{"value": "t0"}]}]}`,
+			token:  "t0",
+			expToken: &model.StaticTokenValidation{
+				Value:    "t0",
+				ClientID: "c0",
+				Groups:   []string{"g0"},
+			},
+		},
+
+		"A token allowed on Kubernetes should be returned as Kubernetes token.": {
+			config: `{"version": "v1", "tokens": [{"value": "t0", "client_id": "c0", "kubernetes": true}]}`,
+			token:  "t0",
+			expToken: &model.StaticTokenValidation{
+				Value:      "t0",
+				ClientID:   "c0",
+				Kubernetes: true,
+			},
+		},
+
+		"A v2 credential should inherit the client Kubernetes option.": {
+			config: `{"version": "v2", "clients": [{"id": "c0", "kubernetes": true, "credentials": [{"value": "t0"}]}]}`,
+			token:  "t0",
+			expToken: &model.StaticTokenValidation{
+				Value:      "t0",
+				ClientID:   "c0",
+				Kubernetes: true,
+			},
+		},
+
+		"A token with scopes should return the scopes.": {
+			config: `{"version": "v1", "tokens": [{"value": "t0", "client_id": "c0", "scopes": ["s0", "s1"]}]}`,
+			token:  "t0",
+			expToken: &model.StaticTokenValidation{
+				Value:    "t0",
+				ClientID: "c0",
+				Scopes:   []string{"s0", "s1"},
+			},
+		},
+
+		"A v2 credential should inherit the client scopes.": {
+			config: `{"version": "v2", "clients": [{"id": "c0", "scopes": ["s0"], "credentials": [{"value": "t0"}]}]}`,
+			token:  "t0",
+			expToken: &model.StaticTokenValidation{
+				Value:    "t0",
+				ClientID: "c0",
+				Scopes:   []string{"s0"},
+			},
+		},
+
 		"A token form the env vars should be set correctly.": {
 			env: map[string]string{
 				"TEST_TOKEN": "1234567890",
@@ -155,6 +298,57 @@ tokens:
 				Value: "1234567890",
 			},
 		},
+
+		"A token with a sha256 hash should be returned.": {
+			config: `
+version: v1
+tokens:
+- value_hash: sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+  client_id: c0
+`,
+			token: "test",
+			expToken: &model.StaticTokenValidation{
+				Value:    "test",
+				ClientID: "c0",
+			},
+		},
+
+		"A token with a wrong sha256 hash should fail.": {
+			config: `
+version: v1
+tokens:
+- value_hash: sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+  client_id: c0
+`,
+			token:  "test2",
+			expErr: true,
+		},
+
+		"A token with an argon2id hash should be returned.": {
+			config: `
+version: v1
+tokens:
+- value: t0
+- value_hash: argon2id:k1:$argon2id$v=19$m=19456,t=2,p=1$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8
+  client_id: c0
+`,
+			token: "k1.abc",
+			expToken: &model.StaticTokenValidation{
+				Value:    "k1.abc",
+				ClientID: "c0",
+			},
+		},
+
+		"A token with an argon2id hash and other key ID should fail.": {
+			config: `
+version: v1
+tokens:
+- value_hash: argon2id:k1:$argon2id$v=19$m=19456,t=2,p=1$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8
+  client_id: c0
+`,
+			token:  "k2.abc",
+			expErr: true,
+		},
 	}
 
 	for name, test := range tests {
@@ -185,3 +379,414 @@ tokens:
 		})
 	}
 }
+
+func TestTokenRepositoryReloadConfigs(t *testing.T) {
+	tests := map[string]struct {
+		configs    map[string]string
+		newConfigs map[string]string
+		expErr     bool
+		expTokens  map[string]bool
+	}{
+		"A valid config should replace the previous tokens.": {
+			configs:    map[string]string{"a.json": `{"version": "v1", "tokens": [{"value": "t0"}, {"value": "t1"}]}`},
+			newConfigs: map[string]string{"a.json": `{"version": "v1", "tokens": [{"value": "t1"}, {"value": "t2"}]}`},
+			expTokens:  map[string]bool{"t0": false, "t1": true, "t2": true},
+		},
+
+		"Multiple valid configs should replace the previous tokens.": {
+			configs: map[string]string{"a.json": `{"version": "v1", "tokens": [{"value": "t0"}]}`},
+			newConfigs: map[string]string{
+				"a.json": `{"version": "v1", "tokens": [{"value": "t1"}]}`,
+				"b.json": `{"version": "v1", "tokens": [{"value": "t2"}]}`,
+			},
+			expTokens: map[string]bool{"t0": false, "t1": true, "t2": true},
+		},
+
+		"An invalid config should fail and keep the previous tokens.": {
+			configs:    map[string]string{"a.json": `{"version": "v1", "tokens": [{"value": "t0"}, {"value": "t1"}]}`},
+			newConfigs: map[string]string{"a.json": `{"version": "v1", "tokens": [{"value": "t2"}, {"value": "t2"}]}`},
+			expErr:     true,
+			expTokens:  map[string]bool{"t0": true, "t1": true, "t2": false},
+		},
+
+		"A token declared on multiple configs should fail and keep the previous tokens.": {
+			configs: map[string]string{"a.json": `{"version": "v1", "tokens": [{"value": "t0"}]}`},
+			newConfigs: map[string]string{
+				"a.json": `{"version": "v1", "tokens": [{"value": "t1"}]}`,
+				"b.json": `{"version": "v1", "tokens": [{"value": "t1"}]}`,
+			},
+			expErr:    true,
+			expTokens: map[string]bool{"t0": true, "t1": false},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			repo, err := memory.NewTokenRepositoryFromConfigs(log.Noop, test.configs)
+			require.NoError(err)
+
+			err = repo.ReloadConfigs(test.newConfigs)
+			if test.expErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+
+			for token, exp := range test.expTokens {
+				_, err := repo.GetStaticTokenValidation(context.TODO(), token)
+				assert.Equal(exp, err == nil, token)
+			}
+		})
+	}
+}
+
+func TestNewTokenRepositoryInvalidConfig(t *testing.T) {
+	tests := map[string]struct {
+		config string
+	}{
+		"A token with value and value hash should fail.": {
+			config: `{"version": "v1", "tokens": [{"value": "test", "value_hash": "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}]}`,
+		},
+
+		"A token with an invalid value hash should fail.": {
+			config: `{"version": "v1", "tokens": [{"value_hash": "sha256:1234"}]}`,
+		},
+
+		"A token declared as plain and hashed should fail.": {
+			config: `{"version": "v1", "tokens": [{"value": "test"}, {"value_hash": "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}]}`,
+		},
+
+		"Argon2id hashed tokens with the same key ID should fail.": {
+			config: `{"version": "v1", "tokens": [{"value_hash": "argon2id:k1:$argon2id$v=19$m=19456,t=2,p=1$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8"}, {"value_hash": "argon2id:k1:$argon2id$v=19$m=19456,t=2,p=1$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8"}]}`,
+		},
+
+		"A v2 client without id should fail.": {
+			config: `{"version": "v2", "clients": [{"credentials": [{"value": "test"}]}]}`,
+		},
+
+		"A v2 client declared multiple times should fail.": {
+			config: `{"version": "v2", "clients": [{"id": "c0", "credentials": [{"value": "t0"}]}, {"id": "c0", "credentials": [{"value": "t1"}]}]}`,
+		},
+
+		"A v2 token declared on multiple clients should fail.": {
+			config: `{"version": "v2", "clients": [{"id": "c0", "credentials": [{"value": "t0"}]}, {"id": "c1", "credentials": [{"value": "t0"}]}]}`,
+		},
+
+		"An unknown version should fail.": {
+			config: `{"version": "v3"}`,
+		},
+
+		"A user without username should fail.": {
+			config: `{"version": "v1", "users": [{"password_hash": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="}]}`,
+		},
+
+		"A user with an unsupported password hash should fail.": {
+			config: `{"version": "v1", "users": [{"username": "u0", "password_hash": "secret"}]}`,
+		},
+
+		"A user declared multiple times should fail.": {
+			config: `{"version": "v1", "users": [{"username": "u0", "password_hash": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="}, {"username": "u0", "password_hash": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="}]}`,
+		},
+
+		"A client cert without match should fail.": {
+			config: `{"version": "v1", "client_certs": [{"client_id": "c0"}]}`,
+		},
+
+		"A client cert with multiple matches should fail.": {
+			config: `{"version": "v1", "client_certs": [{"client_id": "c0", "subject": "CN=c0", "san": "c0.slok.dev"}]}`,
+		},
+
+		"A client cert with an invalid fingerprint should fail.": {
+			config: `{"version": "v1", "client_certs": [{"client_id": "c0", "fingerprint": "1234"}]}`,
+		},
+
+		"A signature key without key id should fail.": {
+			config: `{"version": "v1", "signature_keys": [{"client_id": "c0", "secret": "s0"}]}`,
+		},
+
+		"A signature key without secret or public key should fail.": {
+			config: `{"version": "v1", "signature_keys": [{"key_id": "k0", "client_id": "c0"}]}`,
+		},
+
+		"A signature key with an algorithm that doesn't match the key should fail.": {
+			config: `{"version": "v1", "signature_keys": [{"key_id": "k0", "client_id": "c0", "secret": "s0", "algorithm": "ed25519"}]}`,
+		},
+
+		"A signature key declared multiple times should fail.": {
+			config: `{"version": "v1", "signature_keys": [{"key_id": "k0", "client_id": "c0", "secret": "s0"}, {"key_id": "k0", "client_id": "c1", "secret": "s1"}]}`,
+		},
+
+		"A client cert declared multiple times should fail.": {
+			config: `{"version": "v1", "client_certs": [{"client_id": "c0", "san": "c0.slok.dev"}, {"client_id": "c1", "san": "c0.slok.dev"}]}`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := memory.NewTokenRepository(log.Noop, test.config)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewTokenRepositoryFromConfigs(t *testing.T) {
+	tests := map[string]struct {
+		configs     map[string]string
+		token       string
+		expClientID string
+		expErr      string
+	}{
+		"Tokens from multiple configs should be merged.": {
+			configs: map[string]string{
+				"a.json": `{"version": "v1", "tokens": [{"value": "t1", "client_id": "c1"}]}`,
+				"b.yaml": "version: v1\ntokens:\n- value: t2\n  client_id: c2\n",
+			},
+			token:       "t2",
+			expClientID: "c2",
+		},
+
+		"A token declared on multiple configs should fail with the config names.": {
+			configs: map[string]string{
+				"a.json": `{"version": "v1", "tokens": [{"value": "t1"}]}`,
+				"b.json": `{"version": "v1", "tokens": [{"value": "t2"}, {"value": "t1"}]}`,
+			},
+			expErr: "b.json: a token has been declared multiple times (a.json and b.json)",
+		},
+
+		"A token declared as plain and hashed on multiple configs should fail with the config names.": {
+			configs: map[string]string{
+				"a.json": `{"version": "v1", "tokens": [{"value": "test"}]}`,
+				"b.json": `{"version": "v1", "tokens": [{"value_hash": "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}]}`,
+			},
+			expErr: "a token has been declared multiple times (b.json and a.json)",
+		},
+
+		"An invalid config should fail with the config name.": {
+			configs: map[string]string{
+				"a.json": `{"version": "v1", "tokens": [{"value": "t1"}]}`,
+				"b.json": `{"version": "v3"}`,
+			},
+			expErr: "b.json: invalid version, expected v1 or v2, got v3",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			repo, err := memory.NewTokenRepositoryFromConfigs(log.Noop, test.configs)
+			if test.expErr != "" {
+				assert.EqualError(err, test.expErr)
+				return
+			}
+			require.NoError(err)
+
+			token, err := repo.GetStaticTokenValidation(context.TODO(), test.token)
+			require.NoError(err)
+			assert.Equal(test.expClientID, token.ClientID)
+		})
+	}
+}
+
+func TestTokenRepositoryGetUserValidation(t *testing.T) {
+	tests := map[string]struct {
+		config   string
+		username string
+		expUser  *model.UserValidation
+		expErr   error
+	}{
+		"A missing user should return not found.": {
+			config:   `{"version": "v1", "users": [{"username": "u0", "password_hash": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="}]}`,
+			username: "u1",
+			expErr:   internalerrors.ErrNotFound,
+		},
+
+		"A disabled user should return not found.": {
+			config:   `{"version": "v1", "users": [{"username": "u0", "password_hash": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "disable": true}]}`,
+			username: "u0",
+			expErr:   internalerrors.ErrNotFound,
+		},
+
+		"A v1 user should be returned.": {
+			config:   `{"version": "v1", "users": [{"username": "u0", "password_hash": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "allowed_method": "GET", "expires_at": "2022-07-04T14:21:22.52Z"}]}`,
+			username: "u0",
+			expUser: &model.UserValidation{
+				Username:     "u0",
+				PasswordHash: "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+				ExpiresAt:    time.Date(2022, 7, 4, 14, 21, 22, 520000000, time.UTC),
+				Common:       model.TokenCommon{AllowedMethod: regexp.MustCompile("GET")},
+			},
+		},
+
+		"A v2 user should be returned.": {
+			config:   `{"version": "v2", "users": [{"username": "u0", "password_hash": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "allowed_url": "https://slok.dev/.*"}]}`,
+			username: "u0",
+			expUser: &model.UserValidation{
+				Username:     "u0",
+				PasswordHash: "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+				Common:       model.TokenCommon{AllowedURL: regexp.MustCompile("https://slok.dev/.*")},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			repo, err := memory.NewTokenRepository(log.Noop, test.config)
+			require.NoError(err)
+
+			gotUser, err := repo.GetUserValidation(context.TODO(), test.username)
+			if test.expErr != nil {
+				assert.ErrorIs(err, test.expErr)
+				return
+			}
+			require.NoError(err)
+
+			assert.Equal(test.expUser, gotUser)
+		})
+	}
+}
+
+func TestTokenRepositoryGetCertValidation(t *testing.T) {
+	cert := &x509.Certificate{
+		Raw:      []byte("cert0"),
+		Subject:  pkix.Name{CommonName: "c0", Organization: []string{"slok"}},
+		DNSNames: []string{"c0.slok.dev"},
+	}
+	fp := sha256.Sum256(cert.Raw)
+	fingerprint := hex.EncodeToString(fp[:])
+
+	tests := map[string]struct {
+		config  string
+		expCert *model.CertValidation
+		expErr  error
+	}{
+		"A missing client cert should return not found.": {
+			config: `{"version": "v1", "client_certs": [{"client_id": "c1", "subject": "CN=c1,O=slok"}]}`,
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A disabled client cert should return not found.": {
+			config: `{"version": "v1", "client_certs": [{"client_id": "c0", "subject": "CN=c0,O=slok", "disable": true}]}`,
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A client cert should be matched by subject.": {
+			config: `{"version": "v1", "client_certs": [{"client_id": "c0", "subject": "CN=c0,O=slok", "allowed_method": "GET", "expires_at": "2022-07-04T14:21:22.52Z"}]}`,
+			expCert: &model.CertValidation{
+				ClientID:  "c0",
+				ExpiresAt: time.Date(2022, 7, 4, 14, 21, 22, 520000000, time.UTC),
+				Common:    model.TokenCommon{AllowedMethod: regexp.MustCompile("GET")},
+			},
+		},
+
+		"A client cert should be matched by SAN.": {
+			config:  `{"version": "v1", "client_certs": [{"client_id": "c0", "san": "c0.slok.dev"}]}`,
+			expCert: &model.CertValidation{ClientID: "c0"},
+		},
+
+		"A client cert should be matched by fingerprint.": {
+			config:  `{"version": "v1", "client_certs": [{"client_id": "c0", "fingerprint": "SHA256:` + strings.ToUpper(fingerprint) + `"}]}`,
+			expCert: &model.CertValidation{ClientID: "c0"},
+		},
+
+		"A client cert fingerprint match should have priority over the other matches.": {
+			config:  `{"version": "v1", "client_certs": [{"client_id": "c0", "subject": "CN=c0,O=slok"}, {"client_id": "c1", "fingerprint": "` + fingerprint + `"}]}`,
+			expCert: &model.CertValidation{ClientID: "c1"},
+		},
+
+		"A v2 client cert should inherit the client properties.": {
+			config: `{"version": "v2", "clients": [{"id": "c0", "allowed_url": "https://slok.dev/.*", "certificates": [{"san": "c0.slok.dev"}]}]}`,
+			expCert: &model.CertValidation{
+				ClientID: "c0",
+				Common:   model.TokenCommon{AllowedURL: regexp.MustCompile("https://slok.dev/.*")},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			repo, err := memory.NewTokenRepository(log.Noop, test.config)
+			require.NoError(err)
+
+			gotCert, err := repo.GetCertValidation(context.TODO(), cert)
+			if test.expErr != nil {
+				assert.ErrorIs(err, test.expErr)
+				return
+			}
+			require.NoError(err)
+
+			assert.Equal(test.expCert, gotCert)
+		})
+	}
+}
+
+func TestTokenRepositoryGetSignatureKeyValidation(t *testing.T) {
+	tests := map[string]struct {
+		config string
+		keyID  string
+		expKey *model.SignatureKeyValidation
+		expErr error
+	}{
+		"A missing signature key should return not found.": {
+			config: `{"version": "v1", "signature_keys": [{"key_id": "k0", "client_id": "c0", "secret": "s0"}]}`,
+			keyID:  "k1",
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A disabled signature key should return not found.": {
+			config: `{"version": "v1", "signature_keys": [{"key_id": "k0", "client_id": "c0", "secret": "s0", "disable": true}]}`,
+			keyID:  "k0",
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A v1 signature key should be returned.": {
+			config: `{"version": "v1", "signature_keys": [{"key_id": "k0", "client_id": "c0", "secret": "s0", "allowed_method": "POST", "expires_at": "2022-07-04T14:21:22.52Z"}]}`,
+			keyID:  "k0",
+			expKey: &model.SignatureKeyValidation{
+				Key:       model.SignatureKey{ID: "k0", Algorithm: "hmac-sha256", Key: []byte("s0")},
+				ClientID:  "c0",
+				ExpiresAt: time.Date(2022, 7, 4, 14, 21, 22, 520000000, time.UTC),
+				Common:    model.TokenCommon{AllowedMethod: regexp.MustCompile("POST")},
+			},
+		},
+
+		"A v2 signature key should inherit the client properties.": {
+			config: `{"version": "v2", "clients": [{"id": "c0", "allowed_url": "https://slok.dev/.*", "signature_keys": [{"key_id": "k0", "secret": "s0"}]}]}`,
+			keyID:  "k0",
+			expKey: &model.SignatureKeyValidation{
+				Key:      model.SignatureKey{ID: "k0", Algorithm: "hmac-sha256", Key: []byte("s0")},
+				ClientID: "c0",
+				Common:   model.TokenCommon{AllowedURL: regexp.MustCompile("https://slok.dev/.*")},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			repo, err := memory.NewTokenRepository(log.Noop, test.config)
+			require.NoError(err)
+
+			gotKey, err := repo.GetSignatureKeyValidation(context.TODO(), test.keyID)
+			if test.expErr != nil {
+				assert.ErrorIs(err, test.expErr)
+				return
+			}
+			require.NoError(err)
+
+			assert.Equal(test.expKey, gotKey)
+		})
+	}
+}
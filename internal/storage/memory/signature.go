@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/slok/simple-ingress-external-auth/internal/httpsig"
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+	apiv1 "github.com/slok/simple-ingress-external-auth/pkg/api/v1"
+)
+
+// GetSignatureKeyValidation returns the HTTP message signature key declared on the configuration.
+func (t *TokenRepository) GetSignatureKeyValidation(ctx context.Context, keyID string) (*model.SignatureKeyValidation, error) {
+	e, ok := t.tokens.Load().signatureKeys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("signature key not found: %w", internalerrors.ErrNotFound)
+	}
+
+	key := e.key
+	return &key, nil
+}
+
+type signatureKeyEntry struct {
+	key    model.SignatureKeyValidation
+	source string
+}
+
+func (t *tokenSet) addSignatureKey(source string, key model.SignatureKeyValidation) error {
+	if e, ok := t.signatureKeys[key.Key.ID]; ok {
+		if e.source == "" && source == "" {
+			return fmt.Errorf("signature key %q has been declared multiple times", key.Key.ID)
+		}
+		return fmt.Errorf("signature key %q has been declared multiple times (%s and %s)", key.Key.ID, e.source, source)
+	}
+	t.signatureKeys[key.Key.ID] = signatureKeyEntry{key: key, source: source}
+
+	return nil
+}
+
+// mapSignatureKeyV1ToModel maps a v1 signature key, if the key is disabled it will return a nil key.
+func mapSignatureKeyV1ToModel(k apiv1.SignatureKey) (*model.SignatureKeyValidation, error) {
+	if k.KeyID == "" {
+		return nil, fmt.Errorf("signature key id can't be empty")
+	}
+
+	algorithm, key, err := httpsig.ParseKey(k.Algorithm, k.Secret, k.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("signature key %q: %w", k.KeyID, err)
+	}
+
+	if k.Disable {
+		return nil, nil
+	}
+
+	var expiresAt time.Time
+	if k.ExpiresAt != nil {
+		expiresAt = *k.ExpiresAt
+	}
+
+	common, err := mapCommonV1ToModel(k.Common)
+	if err != nil {
+		return nil, fmt.Errorf("signature key %q: %w", k.KeyID, err)
+	}
+
+	return &model.SignatureKeyValidation{
+		Key: model.SignatureKey{
+			ID:        k.KeyID,
+			Algorithm: algorithm,
+			Key:       key,
+		},
+		ClientID:  k.ClientID,
+		ExpiresAt: expiresAt,
+		Common:    *common,
+	}, nil
+}
@@ -0,0 +1,294 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	apiv1 "github.com/slok/simple-ingress-external-auth/pkg/api/v1"
+)
+
+// ValidationError is a token configuration error.
+type ValidationError struct {
+	// Source is the name of the configuration (e.g the file path).
+	Source string
+	// Kind is the kind of the configuration entry with the error: `token`, `user`, `client cert` or
+	// `signature key` on v1, and `client`, `credential`, `certificate`, `signature key` or `user` on
+	// v2. Empty if the error is not related with a single entry.
+	Kind string
+	// Index is the index of the entry on its configuration section, on the v2 client entries (e.g
+	// credentials) the index on the client.
+	Index int
+	// ClientIndex is the index of the v2 client of the entry, -1 if the entry is not on a client.
+	ClientIndex int
+	ClientID    string
+	Err         error
+}
+
+func (v ValidationError) Error() string {
+	msg := v.Err.Error()
+	if v.Kind != "" {
+		pos := fmt.Sprintf("%s %d", v.Kind, v.Index)
+		if v.ClientIndex >= 0 {
+			pos = fmt.Sprintf("client %d %s", v.ClientIndex, pos)
+		}
+
+		if v.ClientID != "" {
+			pos = fmt.Sprintf("%s (client_id: %q)", pos, v.ClientID)
+		}
+
+		msg = pos + ": " + msg
+	}
+
+	if v.Source != "" {
+		msg = v.Source + ": " + msg
+	}
+
+	return msg
+}
+
+func (v ValidationError) Unwrap() error { return v.Err }
+
+// ValidationReport is the result of validating token configurations.
+type ValidationReport struct {
+	Errors        []ValidationError
+	Configs       int
+	Tokens        int
+	Users         int
+	ClientCerts   int
+	SignatureKeys int
+	Disabled      int
+	Expired       int
+}
+
+// ValidateConfigs validates raw token configurations (identified by their source name) the same way
+// they are loaded, but instead of stopping on the first error, it reports all of them.
+func ValidateConfigs(configs map[string]string, now time.Time) ValidationReport {
+	// Be deterministic.
+	sources := make([]string, 0, len(configs))
+	for source := range configs {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	report := ValidationReport{Configs: len(configs)}
+	tokens := newTokenSet()
+	for _, source := range sources {
+		c, errs := decodeValidatedConfig(configs[source])
+		for _, e := range errs {
+			e.Source = source
+			report.Errors = append(report.Errors, e)
+		}
+		if c == nil {
+			continue
+		}
+
+		newError := func(pos entryPosition, clientID string, err error) ValidationError {
+			return ValidationError{Source: source, Kind: pos.kind, Index: pos.index, ClientIndex: pos.client, ClientID: clientID, Err: err}
+		}
+
+		for i, t := range c.config.Tokens {
+			report.Tokens++
+
+			token, hash, err := mapTokenV1ToModel(t)
+			if err == nil && token != nil {
+				err = tokens.add(source, *token, hash)
+			}
+			if err != nil {
+				report.Errors = append(report.Errors, newError(c.tokens[i], t.ClientID, err))
+				continue
+			}
+
+			switch {
+			case token == nil:
+				report.Disabled++
+			case !token.ExpiresAt.IsZero() && token.ExpiresAt.Before(now):
+				report.Expired++
+			}
+		}
+
+		for i, u := range c.config.Users {
+			report.Users++
+
+			user, err := mapUserV1ToModel(u)
+			if err == nil && user != nil {
+				err = tokens.addUser(source, *user)
+			}
+			if err != nil {
+				report.Errors = append(report.Errors, newError(c.users[i], "", err))
+				continue
+			}
+
+			switch {
+			case user == nil:
+				report.Disabled++
+			case !user.ExpiresAt.IsZero() && user.ExpiresAt.Before(now):
+				report.Expired++
+			}
+		}
+
+		for i, cc := range c.config.ClientCerts {
+			report.ClientCerts++
+
+			key, cert, err := mapClientCertV1ToModel(cc)
+			if err == nil && cert != nil {
+				err = tokens.addCert(source, key, *cert)
+			}
+			if err != nil {
+				report.Errors = append(report.Errors, newError(c.clientCerts[i], cc.ClientID, err))
+				continue
+			}
+
+			switch {
+			case cert == nil:
+				report.Disabled++
+			case !cert.ExpiresAt.IsZero() && cert.ExpiresAt.Before(now):
+				report.Expired++
+			}
+		}
+
+		for i, k := range c.config.SignatureKeys {
+			report.SignatureKeys++
+
+			key, err := mapSignatureKeyV1ToModel(k)
+			if err == nil && key != nil {
+				err = tokens.addSignatureKey(source, *key)
+			}
+			if err != nil {
+				report.Errors = append(report.Errors, newError(c.signatureKeys[i], k.ClientID, err))
+				continue
+			}
+
+			switch {
+			case key == nil:
+				report.Disabled++
+			case !key.ExpiresAt.IsZero() && key.ExpiresAt.Before(now):
+				report.Expired++
+			}
+		}
+	}
+
+	for _, err := range tokens.hashedDuplicateErrors() {
+		report.Errors = append(report.Errors, ValidationError{Index: -1, ClientIndex: -1, Err: err})
+	}
+
+	return report
+}
+
+// TokenPosition is the source position of a token of a v2 configuration.
+type TokenPosition struct {
+	// ClientIndex is the index of the token client.
+	ClientIndex int
+	// CredentialIndex is the index of the token credential on its client.
+	CredentialIndex int
+}
+
+// DecodeConfigTokenPositions decodes a raw token configuration like DecodeConfig, on the v2
+// configurations it also returns the source position of each flattened token.
+func DecodeConfigTokenPositions(data string) (*apiv1.Config, []TokenPosition, error) {
+	c, errs := decodeValidatedConfig(data)
+	if len(errs) > 0 {
+		err := errs[0].Err
+		if errs[0].Kind != "" {
+			err = errs[0]
+		}
+		return nil, nil, err
+	}
+
+	var positions []TokenPosition
+	for _, p := range c.tokens {
+		if p.client >= 0 {
+			positions = append(positions, TokenPosition{ClientIndex: p.client, CredentialIndex: p.index})
+		}
+	}
+
+	return &c.config, positions, nil
+}
+
+// entryPosition is the position of a configuration entry on its source configuration.
+type entryPosition struct {
+	kind   string
+	index  int
+	client int
+}
+
+// validatedConfig is a configuration flattened to v1, with the source positions of its entries.
+type validatedConfig struct {
+	config        apiv1.Config
+	tokens        []entryPosition
+	users         []entryPosition
+	clientCerts   []entryPosition
+	signatureKeys []entryPosition
+}
+
+// decodeValidatedConfig decodes a raw token configuration like DecodeConfig, returning the source
+// positions of the flattened entries, so the errors point to the source ones. The v2 clients are
+// mapped one by one, so an invalid client doesn't stop validating the rest.
+func decodeValidatedConfig(data string) (*validatedConfig, []ValidationError) {
+	newError := func(err error) []ValidationError {
+		return []ValidationError{{Index: -1, ClientIndex: -1, Err: err}}
+	}
+
+	version, err := DecodeConfigVersion(data)
+	if err != nil {
+		return nil, newError(err)
+	}
+
+	if version != "v2" {
+		c1, err := DecodeConfig(data)
+		if err != nil {
+			return nil, newError(err)
+		}
+
+		c := &validatedConfig{config: *c1}
+		c.tokens = v1Positions("token", len(c1.Tokens))
+		c.users = v1Positions("user", len(c1.Users))
+		c.clientCerts = v1Positions("client cert", len(c1.ClientCerts))
+		c.signatureKeys = v1Positions("signature key", len(c1.SignatureKeys))
+
+		return c, nil
+	}
+
+	c2, err := DecodeConfigV2(data)
+	if err != nil {
+		return nil, newError(err)
+	}
+
+	var errs []ValidationError
+	c := &validatedConfig{config: apiv1.Config{Version: "v1"}}
+	clientIDs := map[string]struct{}{}
+	for i, client := range c2.Clients {
+		err := checkClientIDV2(client, clientIDs)
+		if err != nil {
+			errs = append(errs, ValidationError{Kind: "client", Index: i, ClientIndex: -1, ClientID: client.ID, Err: err})
+			continue
+		}
+
+		appendClientV2ToV1(&c.config, client)
+		for j := range client.Credentials {
+			c.tokens = append(c.tokens, entryPosition{kind: "credential", index: j, client: i})
+		}
+		for j := range client.Certificates {
+			c.clientCerts = append(c.clientCerts, entryPosition{kind: "certificate", index: j, client: i})
+		}
+		for j := range client.SignatureKeys {
+			c.signatureKeys = append(c.signatureKeys, entryPosition{kind: "signature key", index: j, client: i})
+		}
+	}
+
+	for _, u := range c2.Users {
+		c.config.Users = append(c.config.Users, mapUserV2ToV1(u))
+	}
+	c.users = v1Positions("user", len(c2.Users))
+
+	return c, errs
+}
+
+func v1Positions(kind string, n int) []entryPosition {
+	positions := make([]entryPosition, 0, n)
+	for i := range n {
+		positions = append(positions, entryPosition{kind: kind, index: i, client: -1})
+	}
+
+	return positions
+}
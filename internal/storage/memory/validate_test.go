@@ -0,0 +1,188 @@
+package memory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+)
+
+func TestValidateConfigs(t *testing.T) {
+	now := time.Date(2022, 7, 4, 0, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		configs   map[string]string
+		expErrs   []string
+		expReport memory.ValidationReport
+	}{
+		"A valid config should return the summary.": {
+			configs: map[string]string{
+				"a.json": `{"version": "v1", "tokens": [
+					{"value": "t0"},
+					{"value": "t1", "disable": true},
+					{"value": "t2", "expires_at": "2022-07-03T00:00:00Z"},
+					{"value": "t3", "expires_at": "2022-07-05T00:00:00Z"}
+				], "users": [
+					{"username": "u0", "password_hash": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="},
+					{"username": "u1", "password_hash": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "disable": true}
+				], "client_certs": [
+					{"client_id": "c0", "subject": "CN=c0"},
+					{"client_id": "c1", "san": "c1.slok.dev", "expires_at": "2022-07-03T00:00:00Z"}
+				], "signature_keys": [
+					{"key_id": "k0", "client_id": "c0", "secret": "s0", "disable": true}
+				]}`,
+			},
+			expReport: memory.ValidationReport{Configs: 1, Tokens: 4, Users: 2, ClientCerts: 2, SignatureKeys: 1, Disabled: 3, Expired: 2},
+		},
+
+		"All the errors should be reported.": {
+			configs: map[string]string{
+				"a.json": `{"version": "v1", "tokens": [
+					{"value": "t0", "client_id": "c0"},
+					{"value": "t1", "client_id": "c1", "allowed_url": "("},
+					{"client_id": "c2"},
+					{"value": "t0", "client_id": "c3"},
+					{"value": "test", "client_id": "c4"}
+				], "users": [
+					{"username": "u0", "password_hash": "secret"}
+				], "client_certs": [
+					{"client_id": "c6"}
+				], "signature_keys": [
+					{"key_id": "k0", "client_id": "c7"}
+				]}`,
+				"b.json": `{"version": "v3"}`,
+				"c.yaml": "version: v1\ntokens:\n- value_hash: sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08\n  client_id: c5\n",
+			},
+			expErrs: []string{
+				`a.json: token 1 (client_id: "c1"): could not compile ( regex: error parsing regexp: missing closing ): ` + "`(`",
+				`a.json: token 2 (client_id: "c2"): token value can't be empty`,
+				`a.json: token 3 (client_id: "c3"): a token has been declared multiple times (a.json and a.json)`,
+				`a.json: user 0: user "u0": invalid password hash: unsupported password hash, only bcrypt and SHA are supported`,
+				`a.json: client cert 0 (client_id: "c6"): client "c6" certificate requires one of subject, SAN or fingerprint`,
+				`a.json: signature key 0 (client_id: "c7"): signature key "k0": one of secret or public key is required`,
+				`b.json: invalid version, expected v1 or v2, got v3`,
+				`a token has been declared multiple times (c.yaml and a.json)`,
+			},
+			expReport: memory.ValidationReport{Configs: 3, Tokens: 6, Users: 1, ClientCerts: 1, SignatureKeys: 1},
+		},
+
+		"The v2 config errors should be reported with the client and credential positions.": {
+			configs: map[string]string{
+				"a.json": `{"version": "v2", "clients": [
+					{"id": "c0", "credentials": [{"value": "t0"}, {"value": "t1", "allowed_url": "("}]},
+					{"id": "c1", "credentials": [{"value": "t2"}, {"value": "t3"}, {}]}
+				]}`,
+			},
+			expErrs: []string{
+				`a.json: client 0 credential 1 (client_id: "c0"): could not compile ( regex: error parsing regexp: missing closing ): ` + "`(`",
+				`a.json: client 1 credential 2 (client_id: "c1"): token value can't be empty`,
+			},
+			expReport: memory.ValidationReport{Configs: 1, Tokens: 5},
+		},
+
+		"The v2 config entry errors should be reported with their positions.": {
+			configs: map[string]string{
+				"a.json": `{"version": "v2", "clients": [
+					{"id": "c0", "credentials": [{"value": "t0"}], "certificates": [{"subject": "CN=c0"}, {}]},
+					{"id": "c1", "credentials": [{"value": "t1"}], "signature_keys": [{"key_id": "k0"}]}
+				], "users": [
+					{"username": "u0", "password_hash": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="},
+					{"username": "", "password_hash": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="}
+				]}`,
+			},
+			expErrs: []string{
+				`a.json: user 1: user username can't be empty`,
+				`a.json: client 0 certificate 1 (client_id: "c0"): client "c0" certificate requires one of subject, SAN or fingerprint`,
+				`a.json: client 1 signature key 0 (client_id: "c1"): signature key "k0": one of secret or public key is required`,
+			},
+			expReport: memory.ValidationReport{Configs: 1, Tokens: 2, Users: 2, ClientCerts: 2, SignatureKeys: 1},
+		},
+
+		"The v2 invalid clients should be reported and the rest of the clients validated.": {
+			configs: map[string]string{
+				"a.json": `{"version": "v2", "clients": [
+					{"id": "", "credentials": [{"value": "t0"}]},
+					{"id": "c1", "credentials": [{"value": "t1"}]},
+					{"id": "c1", "credentials": [{"value": "t2"}]},
+					{"id": "c3", "credentials": [{"value": "t3"}, {"value": "t4", "allowed_method": "("}]}
+				]}`,
+			},
+			expErrs: []string{
+				`a.json: client 0: client id can't be empty`,
+				`a.json: client 2 (client_id: "c1"): client "c1" has been declared multiple times`,
+				`a.json: client 3 credential 1 (client_id: "c3"): could not compile ( regex: error parsing regexp: missing closing ): ` + "`(`",
+			},
+			expReport: memory.ValidationReport{Configs: 1, Tokens: 3},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			report := memory.ValidateConfigs(test.configs, now)
+
+			gotErrs := []string{}
+			for _, err := range report.Errors {
+				gotErrs = append(gotErrs, err.Error())
+			}
+			if test.expErrs == nil {
+				test.expErrs = []string{}
+			}
+			assert.Equal(test.expErrs, gotErrs)
+
+			report.Errors = nil
+			assert.Equal(test.expReport, report)
+		})
+	}
+}
+
+func TestDecodeConfigTokenPositions(t *testing.T) {
+	tests := map[string]struct {
+		config       string
+		expTokens    int
+		expPositions []memory.TokenPosition
+		expErr       bool
+	}{
+		"A v1 config should not have token positions.": {
+			config:    `{"version": "v1", "tokens": [{"value": "t0"}, {"value": "t1"}]}`,
+			expTokens: 2,
+		},
+
+		"A v2 config should have the client and credential position of each token.": {
+			config: `{"version": "v2", "clients": [
+				{"id": "c0", "credentials": [{"value": "t0"}]},
+				{"id": "c1", "credentials": [{"value": "t1"}, {"value": "t2"}]}
+			]}`,
+			expTokens: 3,
+			expPositions: []memory.TokenPosition{
+				{ClientIndex: 0, CredentialIndex: 0},
+				{ClientIndex: 1, CredentialIndex: 0},
+				{ClientIndex: 1, CredentialIndex: 1},
+			},
+		},
+
+		"An invalid v2 config should fail.": {
+			config: `{"version": "v2", "clients": [{"id": "", "credentials": [{"value": "t0"}]}]}`,
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			config, positions, err := memory.DecodeConfigTokenPositions(test.config)
+			if test.expErr {
+				assert.Error(err)
+				return
+			}
+			if assert.NoError(err) {
+				assert.Len(config.Tokens, test.expTokens)
+				assert.Equal(test.expPositions, positions)
+			}
+		})
+	}
+}
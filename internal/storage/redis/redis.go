@@ -0,0 +1,183 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+	"github.com/slok/simple-ingress-external-auth/internal/tokencache"
+	"github.com/slok/simple-ingress-external-auth/internal/tokenhash"
+)
+
+// DefaultKeyPrefix is the default prefix of the token Redis keys.
+const DefaultKeyPrefix = "simple-ingress-external-auth:token:"
+
+// Redis token hash fields, the same as the v1 configuration token fields.
+const (
+	fieldClientID      = "client_id"
+	fieldExpiresAt     = "expires_at"
+	fieldAllowedURL    = "allowed_url"
+	fieldAllowedMethod = "allowed_method"
+	fieldDisable       = "disable"
+)
+
+// RepositoryConfig is the configuration of the TokenRepository.
+type RepositoryConfig struct {
+	Client redis.Cmdable
+	Logger log.Logger
+	// KeyPrefix is the prefix of the token keys, the key of a token is the prefix followed by
+	// the hex encoded SHA256 of the token value.
+	KeyPrefix string
+	// CacheSize is the max number of tokens (including the missing ones) kept in memory, 0 disables the cache.
+	CacheSize int
+	// CacheTTL is how long the found tokens are cached.
+	CacheTTL time.Duration
+	// NegativeCacheTTL is how long the missing tokens are cached.
+	NegativeCacheTTL time.Duration
+}
+
+func (c *RepositoryConfig) defaults() error {
+	if c.Client == nil {
+		return fmt.Errorf("redis client is required")
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Noop
+	}
+
+	if c.KeyPrefix == "" {
+		c.KeyPrefix = DefaultKeyPrefix
+	}
+
+	if c.CacheSize < 0 {
+		return fmt.Errorf("cache size can't be negative")
+	}
+
+	return nil
+}
+
+// TokenRepository gets the tokens from Redis hashes, so multiple instances can share the same
+// token store, and the tokens can be managed directly on Redis.
+//
+// The tokens are cached in memory (including the missing ones) to reduce the Redis load. Redis
+// errors are not handled as missing tokens.
+type TokenRepository struct {
+	client           redis.Cmdable
+	keyPrefix        string
+	cache            *tokencache.Cache
+	cacheTTL         time.Duration
+	negativeCacheTTL time.Duration
+	logger           log.Logger
+}
+
+// NewTokenRepository returns a new TokenRepository.
+func NewTokenRepository(config RepositoryConfig) (*TokenRepository, error) {
+	err := config.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return &TokenRepository{
+		client:           config.Client,
+		keyPrefix:        config.KeyPrefix,
+		cache:            tokencache.New(config.CacheSize),
+		cacheTTL:         config.CacheTTL,
+		negativeCacheTTL: config.NegativeCacheTTL,
+		logger:           config.Logger.WithValues(log.Kv{"svc": "redis.TokenRepository"}),
+	}, nil
+}
+
+func (r *TokenRepository) GetStaticTokenValidation(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error) {
+	hash := tokenhash.SHA256(tokenValue)
+
+	token, ok := r.cache.Get(hash)
+	if !ok {
+		var err error
+		token, err = r.getToken(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if token != nil {
+			r.cache.Set(hash, token, r.cacheTTL)
+		} else {
+			r.cache.Set(hash, nil, r.negativeCacheTTL)
+		}
+	}
+
+	if token == nil {
+		return nil, fmt.Errorf("token not found: %w", internalerrors.ErrNotFound)
+	}
+
+	// Don't share the cached token.
+	t := *token
+	t.Value = tokenValue
+
+	return &t, nil
+}
+
+// getToken returns nil if the token is missing or disabled.
+func (r *TokenRepository) getToken(ctx context.Context, hash string) (*model.StaticTokenValidation, error) {
+	fields, err := r.client.HGetAll(ctx, r.keyPrefix+hash).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not get token from redis: %w", err)
+	}
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	token, disabled, err := mapHashToModel(fields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis token %s: %w", r.keyPrefix+hash, err)
+	}
+
+	if disabled {
+		return nil, nil
+	}
+
+	return token, nil
+}
+
+func mapHashToModel(fields map[string]string) (token *model.StaticTokenValidation, disabled bool, err error) {
+	if v := fields[fieldDisable]; v != "" {
+		disabled, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid %s: %w", fieldDisable, err)
+		}
+	}
+
+	token = &model.StaticTokenValidation{
+		ClientID: fields[fieldClientID],
+	}
+
+	if v := fields[fieldExpiresAt]; v != "" {
+		token.ExpiresAt, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid %s: %w", fieldExpiresAt, err)
+		}
+	}
+
+	if v := fields[fieldAllowedURL]; v != "" {
+		token.Common.AllowedURL, err = regexp.Compile(v)
+		if err != nil {
+			return nil, false, fmt.Errorf("could not compile %s regex: %w", v, err)
+		}
+	}
+
+	if v := fields[fieldAllowedMethod]; v != "" {
+		token.Common.AllowedMethod, err = regexp.Compile(v)
+		if err != nil {
+			return nil, false, fmt.Errorf("could not compile %s regex: %w", v, err)
+		}
+	}
+
+	return token, disabled, nil
+}
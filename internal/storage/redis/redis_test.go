@@ -0,0 +1,232 @@
+package redis_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/redis"
+	"github.com/slok/simple-ingress-external-auth/internal/tokenhash"
+)
+
+func setToken(mr *miniredis.Miniredis, token string, fields ...string) {
+	mr.HSet(redis.DefaultKeyPrefix+tokenhash.SHA256(token), fields...)
+}
+
+func TestTokenRepositoryGetStaticTokenValidation(t *testing.T) {
+	tests := map[string]struct {
+		prepare  func(t *testing.T, mr *miniredis.Miniredis)
+		token    string
+		expToken *model.StaticTokenValidation
+		expErr   error
+		expAnErr bool
+	}{
+		"A missing token should fail.": {
+			prepare: func(t *testing.T, mr *miniredis.Miniredis) {},
+			token:   "t0",
+			expErr:  internalerrors.ErrNotFound,
+		},
+
+		"A disabled token should fail.": {
+			prepare: func(t *testing.T, mr *miniredis.Miniredis) {
+				setToken(mr, "t0", "client_id", "c0", "disable", "true")
+			},
+			token:  "t0",
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"An existing token should be returned (basic).": {
+			prepare: func(t *testing.T, mr *miniredis.Miniredis) {
+				setToken(mr, "t0", "client_id", "c0")
+			},
+			token: "t0",
+			expToken: &model.StaticTokenValidation{
+				Value:    "t0",
+				ClientID: "c0",
+			},
+		},
+
+		"An existing token should be returned (full).": {
+			prepare: func(t *testing.T, mr *miniredis.Miniredis) {
+				setToken(mr, "t0",
+					"client_id", "c0",
+					"expires_at", "2022-07-04T14:21:22Z",
+					"allowed_url", "https://custom.host.slok.dev/.*",
+					"allowed_method", "(GET|POST)",
+					"disable", "false",
+				)
+			},
+			token: "t0",
+			expToken: &model.StaticTokenValidation{
+				Value:     "t0",
+				ClientID:  "c0",
+				ExpiresAt: time.Date(2022, 7, 4, 14, 21, 22, 0, time.UTC),
+				Common: model.TokenCommon{
+					AllowedURL:    regexp.MustCompile(`https://custom.host.slok.dev/.*`),
+					AllowedMethod: regexp.MustCompile(`(GET|POST)`),
+				},
+			},
+		},
+
+		"An invalid token should fail with an error.": {
+			prepare: func(t *testing.T, mr *miniredis.Miniredis) {
+				setToken(mr, "t0", "expires_at", "tomorrow")
+			},
+			token:    "t0",
+			expAnErr: true,
+		},
+
+		"A Redis error should fail with an error instead of a missing token.": {
+			prepare: func(t *testing.T, mr *miniredis.Miniredis) {
+				mr.SetError("something")
+			},
+			token:    "t0",
+			expAnErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			mr := miniredis.RunT(t)
+			test.prepare(t, mr)
+
+			repo, err := redis.NewTokenRepository(redis.RepositoryConfig{
+				Client: goredis.NewClient(&goredis.Options{Addr: mr.Addr()}),
+				Logger: log.Noop,
+			})
+			require.NoError(err)
+
+			token, err := repo.GetStaticTokenValidation(context.TODO(), test.token)
+
+			switch {
+			case test.expAnErr:
+				if assert.Error(err) {
+					assert.NotErrorIs(err, internalerrors.ErrNotFound)
+				}
+			case test.expErr != nil:
+				assert.ErrorIs(err, test.expErr)
+			default:
+				if assert.NoError(err) {
+					assert.Equal(test.expToken, token)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenRepositoryCache(t *testing.T) {
+	tests := map[string]struct {
+		config    redis.RepositoryConfig
+		initial   []string
+		getBefore []string
+		// Changes Redis after the initial gets.
+		change   func(t *testing.T, mr *miniredis.Miniredis)
+		wait     time.Duration
+		token    string
+		expFound bool
+	}{
+		"Without cache, the token changes should be applied.": {
+			initial:   []string{"t0"},
+			getBefore: []string{"t0"},
+			change:    func(t *testing.T, mr *miniredis.Miniredis) { mr.FlushAll() },
+			token:     "t0",
+			expFound:  false,
+		},
+
+		"With cache, a found token should be cached.": {
+			config:    redis.RepositoryConfig{CacheSize: 10, CacheTTL: time.Hour},
+			initial:   []string{"t0"},
+			getBefore: []string{"t0"},
+			change:    func(t *testing.T, mr *miniredis.Miniredis) { mr.FlushAll() },
+			token:     "t0",
+			expFound:  true,
+		},
+
+		"With cache, a missing token should be cached.": {
+			config:    redis.RepositoryConfig{CacheSize: 10, NegativeCacheTTL: time.Hour},
+			getBefore: []string{"t0"},
+			change:    func(t *testing.T, mr *miniredis.Miniredis) { setToken(mr, "t0", "client_id", "c0") },
+			token:     "t0",
+			expFound:  false,
+		},
+
+		"With cache, a missing token should not be cached if negative cache is disabled.": {
+			config:    redis.RepositoryConfig{CacheSize: 10, CacheTTL: time.Hour},
+			getBefore: []string{"t0"},
+			change:    func(t *testing.T, mr *miniredis.Miniredis) { setToken(mr, "t0", "client_id", "c0") },
+			token:     "t0",
+			expFound:  true,
+		},
+
+		"With cache, an expired cached token should be get again.": {
+			config:    redis.RepositoryConfig{CacheSize: 10, CacheTTL: 50 * time.Millisecond},
+			initial:   []string{"t0"},
+			getBefore: []string{"t0"},
+			change:    func(t *testing.T, mr *miniredis.Miniredis) { mr.FlushAll() },
+			wait:      100 * time.Millisecond,
+			token:     "t0",
+			expFound:  false,
+		},
+
+		"With cache, the least recently used token should be evicted when the cache is full.": {
+			config:    redis.RepositoryConfig{CacheSize: 2, CacheTTL: time.Hour},
+			initial:   []string{"t0", "t1", "t2"},
+			getBefore: []string{"t0", "t1", "t0", "t2"},
+			change:    func(t *testing.T, mr *miniredis.Miniredis) { mr.FlushAll() },
+			token:     "t1",
+			expFound:  false,
+		},
+
+		"With cache, the most recently used token should not be evicted when the cache is full.": {
+			config:    redis.RepositoryConfig{CacheSize: 2, CacheTTL: time.Hour},
+			initial:   []string{"t0", "t1", "t2"},
+			getBefore: []string{"t0", "t1", "t0", "t2"},
+			change:    func(t *testing.T, mr *miniredis.Miniredis) { mr.FlushAll() },
+			token:     "t0",
+			expFound:  true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			mr := miniredis.RunT(t)
+			for _, token := range test.initial {
+				setToken(mr, token, "client_id", "c-"+token)
+			}
+
+			config := test.config
+			config.Client = goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+			config.Logger = log.Noop
+			repo, err := redis.NewTokenRepository(config)
+			require.NoError(err)
+
+			for _, token := range test.getBefore {
+				_, _ = repo.GetStaticTokenValidation(context.TODO(), token)
+			}
+			test.change(t, mr)
+			time.Sleep(test.wait)
+
+			_, err = repo.GetStaticTokenValidation(context.TODO(), test.token)
+			if test.expFound {
+				assert.NoError(err)
+			} else {
+				assert.ErrorIs(err, internalerrors.ErrNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+	"github.com/slok/simple-ingress-external-auth/internal/tokenhash"
+	apiv1 "github.com/slok/simple-ingress-external-auth/pkg/api/v1"
+)
+
+const insertTokenQuery = `
+INSERT INTO tokens (value, value_hash, client_id, expires_at, allowed_url, allowed_method, disable)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+`
+
+// ImportConfigV1 imports the tokens of a v1 configuration into the database in a single transaction.
+// If replace is set, the current tokens will be deleted, otherwise the tokens will be added to the
+// current ones. It returns the number of imported tokens. The configurations with settings that the
+// database doesn't support (e.g users or token scopes) are rejected, instead of dropping them.
+func ImportConfigV1(ctx context.Context, db *sql.DB, config apiv1.Config, replace bool) (n int, err error) {
+	err = memory.ValidateConfigV1(config)
+	if err != nil {
+		return 0, fmt.Errorf("invalid token config: %w", err)
+	}
+
+	if unsupported := unsupportedSettings(config); len(unsupported) > 0 {
+		return 0, fmt.Errorf("token config settings not supported by the database: %s", strings.Join(unsupported, ", "))
+	}
+
+	err = EnsureSchema(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if replace {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens`)
+		if err != nil {
+			return 0, fmt.Errorf("could not delete tokens: %w", err)
+		}
+	}
+
+	stmt, err := tx.PrepareContext(ctx, insertTokenQuery)
+	if err != nil {
+		return 0, fmt.Errorf("could not prepare insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for i, t := range config.Tokens {
+		args, err := mapTokenV1ToRow(t)
+		if err != nil {
+			return 0, fmt.Errorf("invalid token %d: %w", i, err)
+		}
+
+		_, err = stmt.ExecContext(ctx, args...)
+		if err != nil {
+			return 0, fmt.Errorf("could not insert token %d: %w", i, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return len(config.Tokens), nil
+}
+
+// unsupportedSettings returns the configuration settings that are not stored on the database.
+func unsupportedSettings(config apiv1.Config) []string {
+	var unsupported []string
+	if len(config.Users) > 0 {
+		unsupported = append(unsupported, "users")
+	}
+
+	if len(config.ClientCerts) > 0 {
+		unsupported = append(unsupported, "client_certs")
+	}
+
+	if len(config.SignatureKeys) > 0 {
+		unsupported = append(unsupported, "signature_keys")
+	}
+
+	var scopes, groups, kubernetes bool
+	for _, t := range config.Tokens {
+		scopes = scopes || len(t.Scopes) > 0
+		groups = groups || len(t.Groups) > 0
+		kubernetes = kubernetes || t.Kubernetes
+	}
+
+	if scopes {
+		unsupported = append(unsupported, "token scopes")
+	}
+
+	if groups {
+		unsupported = append(unsupported, "token groups")
+	}
+
+	if kubernetes {
+		unsupported = append(unsupported, "token kubernetes")
+	}
+
+	return unsupported
+}
+
+func mapTokenV1ToRow(t apiv1.Token) ([]any, error) {
+	var value, valueHash, expiresAt *string
+	if t.Value != "" {
+		value = &t.Value
+	}
+
+	if t.ValueHash != "" {
+		hash, err := tokenhash.Parse(t.ValueHash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token value hash: %w", err)
+		}
+
+		// Salted hashes can't be indexed.
+		if hash.Algorithm != tokenhash.AlgorithmSHA256 {
+			return nil, fmt.Errorf("%s token value hashes are not supported, only %s", hash.Algorithm, tokenhash.AlgorithmSHA256)
+		}
+
+		// Normalized, so we can query it.
+		h := tokenhash.AlgorithmSHA256 + ":" + hash.SHA256
+		valueHash = &h
+	}
+
+	if t.ExpiresAt != nil {
+		e := t.ExpiresAt.UTC().Format(time.RFC3339)
+		expiresAt = &e
+	}
+
+	return []any{value, valueHash, t.ClientID, expiresAt, t.AllowedURLRegex, t.AllowedMethodRegex, t.Disable}, nil
+}
@@ -0,0 +1,133 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+	"github.com/slok/simple-ingress-external-auth/internal/tokenhash"
+)
+
+// Schema is the SQL schema of the tokens, it mirrors the v1 configuration token.
+//
+// Only one of `value` or `value_hash` can be set, and only `sha256` hashes are supported, so
+// the tokens can be indexed. `expires_at` is an RFC3339 timestamp.
+const Schema = `
+CREATE TABLE IF NOT EXISTS tokens (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	value          TEXT UNIQUE,
+	value_hash     TEXT UNIQUE,
+	client_id      TEXT NOT NULL DEFAULT '',
+	expires_at     TEXT,
+	allowed_url    TEXT NOT NULL DEFAULT '',
+	allowed_method TEXT NOT NULL DEFAULT '',
+	disable        BOOLEAN NOT NULL DEFAULT FALSE,
+	CHECK ((value IS NULL) <> (value_hash IS NULL))
+);
+`
+
+// EnsureSchema creates the schema if it doesn't exist.
+func EnsureSchema(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, Schema)
+	if err != nil {
+		return fmt.Errorf("could not create schema: %w", err)
+	}
+
+	return nil
+}
+
+// TokenRepository gets the tokens from an SQL database (SQLite).
+//
+// The tokens are queried on every request, so the database can be updated by other tools
+// and the changes will be applied without reloading.
+type TokenRepository struct {
+	db     *sql.DB
+	logger log.Logger
+
+	// The same regexes are used by lots of tokens, compile them only once.
+	regexes sync.Map
+}
+
+// NewTokenRepository returns a new TokenRepository, the schema will be created if missing.
+func NewTokenRepository(ctx context.Context, logger log.Logger, db *sql.DB) (*TokenRepository, error) {
+	err := EnsureSchema(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenRepository{
+		db:     db,
+		logger: logger.WithValues(log.Kv{"svc": "sql.TokenRepository"}),
+	}, nil
+}
+
+const getTokenQuery = `
+SELECT client_id, expires_at, allowed_url, allowed_method
+FROM tokens
+WHERE (value = ? OR value_hash = ?) AND NOT disable
+LIMIT 1
+`
+
+func (r *TokenRepository) GetStaticTokenValidation(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error) {
+	var (
+		clientID, allowedURL, allowedMethod string
+		expiresAt                           sql.NullString
+	)
+	row := r.db.QueryRowContext(ctx, getTokenQuery, tokenValue, tokenhash.AlgorithmSHA256+":"+tokenhash.SHA256(tokenValue))
+	err := row.Scan(&clientID, &expiresAt, &allowedURL, &allowedMethod)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("token not found: %w", internalerrors.ErrNotFound)
+		}
+		return nil, fmt.Errorf("could not get token: %w", err)
+	}
+
+	token := &model.StaticTokenValidation{
+		Value:    tokenValue,
+		ClientID: clientID,
+	}
+
+	if expiresAt.Valid && expiresAt.String != "" {
+		token.ExpiresAt, err = time.Parse(time.RFC3339, expiresAt.String)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token expires at: %w", err)
+		}
+	}
+
+	token.Common.AllowedURL, err = r.regex(allowedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Common.AllowedMethod, err = r.regex(allowedMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (r *TokenRepository) regex(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	if re, ok := r.regexes.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("could not compile %s regex: %w", expr, err)
+	}
+	r.regexes.Store(expr, re)
+
+	return re, nil
+}
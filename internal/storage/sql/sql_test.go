@@ -0,0 +1,231 @@
+package sql_test
+
+import (
+	"context"
+	dbsql "database/sql"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/memory"
+	"github.com/slok/simple-ingress-external-auth/internal/storage/sql"
+)
+
+func newTestDB(t *testing.T) *dbsql.DB {
+	db, err := dbsql.Open("sqlite", filepath.Join(t.TempDir(), "tokens.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+const testConfig = `
+version: v1
+tokens:
+- value: t0
+  client_id: c0
+
+- value: t1
+  client_id: c1
+  expires_at: 2022-07-04T14:21:22Z
+  allowed_url: https://custom.host.slok.dev/.*
+  allowed_method: (GET|POST)
+
+- value_hash: sha256:9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08
+  client_id: c2
+
+- value: t3
+  disable: true
+`
+
+func TestTokenRepositoryGetStaticTokenValidation(t *testing.T) {
+	tests := map[string]struct {
+		sql      string
+		token    string
+		expToken *model.StaticTokenValidation
+		expErr   error
+	}{
+		"A missing token should fail.": {
+			token:  "t4",
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"A disabled token should fail.": {
+			token:  "t3",
+			expErr: internalerrors.ErrNotFound,
+		},
+
+		"An existing token should be returned (basic).": {
+			token: "t0",
+			expToken: &model.StaticTokenValidation{
+				Value:    "t0",
+				ClientID: "c0",
+			},
+		},
+
+		"An existing token should be returned (full).": {
+			token: "t1",
+			expToken: &model.StaticTokenValidation{
+				Value:     "t1",
+				ClientID:  "c1",
+				ExpiresAt: time.Date(2022, 7, 4, 14, 21, 22, 0, time.UTC),
+				Common: model.TokenCommon{
+					AllowedURL:    regexp.MustCompile(`https://custom.host.slok.dev/.*`),
+					AllowedMethod: regexp.MustCompile(`(GET|POST)`),
+				},
+			},
+		},
+
+		"An existing hashed token should be returned.": {
+			token: "test",
+			expToken: &model.StaticTokenValidation{
+				Value:    "test",
+				ClientID: "c2",
+			},
+		},
+
+		"A token added with SQL should be returned.": {
+			sql:   `INSERT INTO tokens (value, client_id) VALUES ('t5', 'c5')`,
+			token: "t5",
+			expToken: &model.StaticTokenValidation{
+				Value:    "t5",
+				ClientID: "c5",
+			},
+		},
+
+		"A token disabled with SQL should fail.": {
+			sql:    `UPDATE tokens SET disable = TRUE WHERE client_id = 'c0'`,
+			token:  "t0",
+			expErr: internalerrors.ErrNotFound,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			db := newTestDB(t)
+			config, err := memory.DecodeConfigV1(testConfig)
+			require.NoError(err)
+			_, err = sql.ImportConfigV1(context.TODO(), db, *config, false)
+			require.NoError(err)
+
+			repo, err := sql.NewTokenRepository(context.TODO(), log.Noop, db)
+			require.NoError(err)
+
+			if test.sql != "" {
+				_, err := db.Exec(test.sql)
+				require.NoError(err)
+			}
+
+			token, err := repo.GetStaticTokenValidation(context.TODO(), test.token)
+
+			if test.expErr != nil {
+				assert.ErrorIs(err, test.expErr)
+			} else if assert.NoError(err) {
+				assert.Equal(test.expToken, token)
+			}
+		})
+	}
+}
+
+func TestImportConfigV1(t *testing.T) {
+	tests := map[string]struct {
+		initialConfig string
+		config        string
+		replace       bool
+		expTokens     int
+		expErr        bool
+	}{
+		"Importing a config should add the tokens.": {
+			initialConfig: `{"version": "v1", "tokens": [{"value": "t0"}]}`,
+			config:        `{"version": "v1", "tokens": [{"value": "t1"}, {"value": "t2"}]}`,
+			expTokens:     3,
+		},
+
+		"Importing a config with replace should replace the tokens.": {
+			initialConfig: `{"version": "v1", "tokens": [{"value": "t0"}]}`,
+			config:        `{"version": "v1", "tokens": [{"value": "t1"}, {"value": "t2"}]}`,
+			replace:       true,
+			expTokens:     2,
+		},
+
+		"Importing a token that already exists should fail and keep the previous tokens.": {
+			initialConfig: `{"version": "v1", "tokens": [{"value": "t0"}]}`,
+			config:        `{"version": "v1", "tokens": [{"value": "t1"}, {"value": "t0"}]}`,
+			expTokens:     1,
+			expErr:        true,
+		},
+
+		"Importing an invalid config should fail.": {
+			initialConfig: `{"version": "v1", "tokens": [{"value": "t0"}]}`,
+			config:        `{"version": "v1", "tokens": [{"value": "t1"}, {"value": "t1"}]}`,
+			expTokens:     1,
+			expErr:        true,
+		},
+
+		"Importing an argon2id hashed token should fail.": {
+			initialConfig: `{"version": "v1", "tokens": [{"value": "t0"}]}`,
+			config:        `{"version": "v1", "tokens": [{"value_hash": "argon2id:k1:$argon2id$v=19$m=19456,t=2,p=1$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8"}]}`,
+			replace:       true,
+			expTokens:     1,
+			expErr:        true,
+		},
+
+		"Importing a config with users should fail.": {
+			initialConfig: `{"version": "v1", "tokens": [{"value": "t0"}]}`,
+			config:        `{"version": "v1", "tokens": [{"value": "t1"}], "users": [{"username": "u0", "password_hash": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="}]}`,
+			expTokens:     1,
+			expErr:        true,
+		},
+
+		"Importing a config with token scopes should fail.": {
+			initialConfig: `{"version": "v1", "tokens": [{"value": "t0"}]}`,
+			config:        `{"version": "v1", "tokens": [{"value": "t1", "scopes": ["s0"]}]}`,
+			expTokens:     1,
+			expErr:        true,
+		},
+
+		"Importing a config with kubernetes tokens should fail.": {
+			initialConfig: `{"version": "v1", "tokens": [{"value": "t0"}]}`,
+			config:        `{"version": "v1", "tokens": [{"value": "t1", "kubernetes": true}]}`,
+			expTokens:     1,
+			expErr:        true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			db := newTestDB(t)
+			initialConfig, err := memory.DecodeConfigV1(test.initialConfig)
+			require.NoError(err)
+			_, err = sql.ImportConfigV1(context.TODO(), db, *initialConfig, false)
+			require.NoError(err)
+
+			config, err := memory.DecodeConfigV1(test.config)
+			require.NoError(err)
+			_, err = sql.ImportConfigV1(context.TODO(), db, *config, test.replace)
+			if test.expErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+
+			var gotTokens int
+			require.NoError(db.QueryRow(`SELECT COUNT(*) FROM tokens`).Scan(&gotTokens))
+			assert.Equal(test.expTokens, gotTokens)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+// Package tenant has the tenants configuration, each tenant has its own tokens and authentication
+// behavior.
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// Config is the tenants configuration.
+type Config struct {
+	Tenants []TenantConfig `json:"tenants"`
+}
+
+// TenantConfig is a tenant, selected by the authentication path (`<authentication-path>/<name>`) or by
+// the original request host. The empty options use the command flags values.
+type TenantConfig struct {
+	// Name is the tenant name, used on the authentication path and on the metrics.
+	Name string `json:"name"`
+	// Hosts are the original request hosts of the tenant, `*.` selects the subdomains.
+	Hosts []string `json:"hosts,omitempty"`
+	// TokenConfigFiles are the tenant token configuration files, only these tokens are valid for the
+	// tenant.
+	TokenConfigFiles []string `json:"token_config_files"`
+	// ProxyPreset is the proxy that sends the tenant authentication requests.
+	ProxyPreset string `json:"proxy_preset,omitempty"`
+	// ClientIDHeader is the response header with the client ID.
+	ClientIDHeader string `json:"client_id_header,omitempty"`
+	// RequestMethodHeader is the original method header, overrides the proxy preset.
+	RequestMethodHeader string `json:"request_method_header,omitempty"`
+	// RequestURLHeader is the original URL header, overrides the proxy preset.
+	RequestURLHeader string `json:"request_url_header,omitempty"`
+	// TokenSources are the places where the token is searched (e.g `header:X-Api-Key`).
+	TokenSources []string `json:"token_sources,omitempty"`
+	// BasicAuthRealm is the realm of the Basic auth challenge returned on the unauthenticated requests.
+	BasicAuthRealm string `json:"basic_auth_realm,omitempty"`
+}
+
+// DecodeConfig decodes a JSON or YAML tenants configuration.
+func DecodeConfig(data []byte) (*Config, error) {
+	c := &Config{}
+	err := json.Unmarshal(data, c)
+	if err != nil {
+		err = yaml.Unmarshal(data, c)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode JSON or YAML: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+var nameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// Validate validates the tenants configuration, the tenant names and hosts must be unique.
+func (c Config) Validate() error {
+	if len(c.Tenants) == 0 {
+		return fmt.Errorf("at least one tenant is required")
+	}
+
+	names := map[string]struct{}{}
+	hosts := map[string]string{}
+	for _, t := range c.Tenants {
+		if !nameRegex.MatchString(t.Name) {
+			return fmt.Errorf("invalid tenant name %q, only lowercase alphanumeric characters and '-' are allowed", t.Name)
+		}
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("duplicated tenant %q", t.Name)
+		}
+		names[t.Name] = struct{}{}
+
+		if len(t.TokenConfigFiles) == 0 {
+			return fmt.Errorf("tenant %q: token config files are required", t.Name)
+		}
+
+		for _, h := range t.Hosts {
+			h = strings.ToLower(h)
+			if h == "" || h == "*." {
+				return fmt.Errorf("tenant %q: invalid empty host", t.Name)
+			}
+			if other, ok := hosts[h]; ok {
+				return fmt.Errorf("tenant %q: host %q is already used by tenant %q", t.Name, h, other)
+			}
+			hosts[h] = t.Name
+		}
+	}
+
+	return nil
+}
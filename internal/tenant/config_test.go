@@ -0,0 +1,77 @@
+package tenant_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/simple-ingress-external-auth/internal/tenant"
+)
+
+func TestConfig(t *testing.T) {
+	tests := map[string]struct {
+		config    string
+		expConfig *tenant.Config
+		expErr    bool
+	}{
+		"A YAML config should be loaded.": {
+			config: `
+tenants:
+  - name: team-a
+    hosts: [a.slok.dev, "*.a.slok.dev"]
+    token_config_files: [team-a.yaml]
+    proxy_preset: traefik
+  - name: team-b
+    token_config_files: [team-b.yaml]
+    token_sources: ["header:X-Api-Key"]
+`,
+			expConfig: &tenant.Config{Tenants: []tenant.TenantConfig{
+				{Name: "team-a", Hosts: []string{"a.slok.dev", "*.a.slok.dev"}, TokenConfigFiles: []string{"team-a.yaml"}, ProxyPreset: "traefik"},
+				{Name: "team-b", TokenConfigFiles: []string{"team-b.yaml"}, TokenSources: []string{"header:X-Api-Key"}},
+			}},
+		},
+
+		"A config without tenants should fail.": {
+			config: `{"tenants": []}`,
+			expErr: true,
+		},
+
+		"A tenant with an invalid name should fail.": {
+			config: `{"tenants": [{"name": "Team/A", "token_config_files": ["a.yaml"]}]}`,
+			expErr: true,
+		},
+
+		"A tenant without token config files should fail.": {
+			config: `{"tenants": [{"name": "team-a"}]}`,
+			expErr: true,
+		},
+
+		"Duplicated tenants should fail.": {
+			config: `{"tenants": [{"name": "team-a", "token_config_files": ["a.yaml"]}, {"name": "team-a", "token_config_files": ["b.yaml"]}]}`,
+			expErr: true,
+		},
+
+		"A host used by multiple tenants should fail.": {
+			config: `{"tenants": [{"name": "team-a", "hosts": ["a.slok.dev"], "token_config_files": ["a.yaml"]}, {"name": "team-b", "hosts": ["A.slok.dev"], "token_config_files": ["b.yaml"]}]}`,
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			config, err := tenant.DecodeConfig([]byte(test.config))
+			require.NoError(err)
+
+			err = config.Validate()
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				assert.Equal(test.expConfig, config)
+			}
+		})
+	}
+}
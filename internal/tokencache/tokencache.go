@@ -0,0 +1,83 @@
+// Package tokencache caches the token validations of the remote token sources.
+package tokencache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+)
+
+// Cache is a bounded LRU cache with expiration. A nil token is a negative entry (the token
+// doesn't exist).
+type Cache struct {
+	size int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key       string
+	token     *model.StaticTokenValidation
+	expiresAt time.Time
+}
+
+// New returns a new Cache of the max size, 0 disables the cache.
+func New(size int) *Cache {
+	return &Cache{
+		size:    size,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// Get returns the cached token, a nil token is a cached missing token.
+func (c *Cache) Get(key string) (token *model.StaticTokenValidation, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return entry.token, true
+}
+
+// Set caches a token (nil for missing tokens) for the TTL, evicting the least recently used token
+// if the cache is full.
+func (c *Cache) Set(key string, token *model.StaticTokenValidation, ttl time.Duration) {
+	if c.size <= 0 || ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{key: key, token: token, expiresAt: time.Now().Add(ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+
+	// Evict the least recently used.
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
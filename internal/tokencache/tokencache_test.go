@@ -0,0 +1,171 @@
+package tokencache_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+	"github.com/slok/simple-ingress-external-auth/internal/tokencache"
+)
+
+func TestCache(t *testing.T) {
+	// set is a cache set, or a cache get if get is set.
+	type set struct {
+		get   bool
+		key   string
+		token *model.StaticTokenValidation
+		ttl   time.Duration
+	}
+
+	tests := map[string]struct {
+		size      int
+		sets      []set
+		expTokens map[string]*model.StaticTokenValidation
+	}{
+		"A cached token should be returned.": {
+			size: 10,
+			sets: []set{
+				{key: "k0", token: &model.StaticTokenValidation{Value: "t0", ClientID: "c0"}, ttl: time.Hour},
+			},
+			expTokens: map[string]*model.StaticTokenValidation{
+				"k0": {Value: "t0", ClientID: "c0"},
+			},
+		},
+
+		"A not cached token should not be returned.": {
+			size: 10,
+			sets: []set{{key: "k1", token: &model.StaticTokenValidation{Value: "t1"}, ttl: time.Hour}},
+			expTokens: map[string]*model.StaticTokenValidation{
+				"k1": {Value: "t1"},
+			},
+		},
+
+		"A cached missing token should be returned as a nil token.": {
+			size: 10,
+			sets: []set{{key: "k0", token: nil, ttl: time.Hour}},
+			expTokens: map[string]*model.StaticTokenValidation{
+				"k0": nil,
+			},
+		},
+
+		"An expired token should not be returned.": {
+			size: 10,
+			sets: []set{
+				{key: "k0", token: &model.StaticTokenValidation{Value: "t0"}, ttl: time.Nanosecond},
+				{key: "k1", token: &model.StaticTokenValidation{Value: "t1"}, ttl: time.Hour},
+			},
+			expTokens: map[string]*model.StaticTokenValidation{
+				"k1": {Value: "t1"},
+			},
+		},
+
+		"A token without TTL should not be cached.": {
+			size:      10,
+			sets:      []set{{key: "k0", token: &model.StaticTokenValidation{Value: "t0"}, ttl: 0}},
+			expTokens: map[string]*model.StaticTokenValidation{},
+		},
+
+		"A disabled cache should not cache tokens.": {
+			size:      0,
+			sets:      []set{{key: "k0", token: &model.StaticTokenValidation{Value: "t0"}, ttl: time.Hour}},
+			expTokens: map[string]*model.StaticTokenValidation{},
+		},
+
+		"Setting a cached token should replace it.": {
+			size: 10,
+			sets: []set{
+				{key: "k0", token: &model.StaticTokenValidation{Value: "t0"}, ttl: time.Hour},
+				{key: "k0", token: nil, ttl: time.Hour},
+			},
+			expTokens: map[string]*model.StaticTokenValidation{
+				"k0": nil,
+			},
+		},
+
+		"A full cache should evict the oldest token.": {
+			size: 2,
+			sets: []set{
+				{key: "k0", token: &model.StaticTokenValidation{Value: "t0"}, ttl: time.Hour},
+				{key: "k1", token: &model.StaticTokenValidation{Value: "t1"}, ttl: time.Hour},
+				{key: "k2", token: &model.StaticTokenValidation{Value: "t2"}, ttl: time.Hour},
+			},
+			expTokens: map[string]*model.StaticTokenValidation{
+				"k1": {Value: "t1"},
+				"k2": {Value: "t2"},
+			},
+		},
+
+		"A full cache should evict the least recently used token.": {
+			size: 2,
+			sets: []set{
+				{key: "k0", token: &model.StaticTokenValidation{Value: "t0"}, ttl: time.Hour},
+				{key: "k1", token: &model.StaticTokenValidation{Value: "t1"}, ttl: time.Hour},
+				// Use k0, so k1 is the least recently used.
+				{get: true, key: "k0"},
+				{key: "k2", token: &model.StaticTokenValidation{Value: "t2"}, ttl: time.Hour},
+			},
+			expTokens: map[string]*model.StaticTokenValidation{
+				"k0": {Value: "t0"},
+				"k2": {Value: "t2"},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			c := tokencache.New(test.size)
+			for _, s := range test.sets {
+				if s.get {
+					c.Get(s.key)
+					continue
+				}
+				c.Set(s.key, s.token, s.ttl)
+			}
+
+			for _, key := range []string{"k0", "k1", "k2"} {
+				expToken, expOK := test.expTokens[key]
+				gotToken, gotOK := c.Get(key)
+				assert.Equal(expOK, gotOK, key)
+				assert.Equal(expToken, gotToken, key)
+			}
+		})
+	}
+}
+
+func TestCacheConcurrency(t *testing.T) {
+	assert := assert.New(t)
+
+	const size = 50
+	c := tokencache.New(size)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 200 {
+				key := fmt.Sprintf("k%d", (i*200+j)%100)
+				c.Set(key, &model.StaticTokenValidation{Value: key}, time.Hour)
+				if token, ok := c.Get(key); ok && token != nil {
+					assert.Equal(key, token.Value)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The cache should never be bigger than its size.
+	cached := 0
+	for i := range 100 {
+		if _, ok := c.Get(fmt.Sprintf("k%d", i)); ok {
+			cached++
+		}
+	}
+	assert.Equal(size, cached)
+}
@@ -0,0 +1,252 @@
+package tokenhash
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Supported hash algorithms.
+const (
+	AlgorithmSHA256   = "sha256"
+	AlgorithmArgon2id = "argon2id"
+)
+
+// Argon2id default parameters used when generating hashes (OWASP recommendation).
+const (
+	argon2idMemory  = 19 * 1024
+	argon2idTime    = 2
+	argon2idThreads = 1
+	argon2idKeyLen  = 32
+	argon2idSaltLen = 16
+)
+
+// Argon2id parameter limits accepted when parsing hashes, the hashes are verified on the
+// requests so the expensive parameters are rejected. The OWASP recommended parameters (from
+// m=47104,t=1 to m=7168,t=5) are accepted.
+const (
+	argon2idMaxMemory  = 46 * 1024
+	argon2idMaxTime    = 5
+	argon2idMaxThreads = 4
+	// argon2idMaxCost is the max memory (KiB) * time.
+	argon2idMaxCost    = 46 * 1024
+	argon2idMinSaltLen = 8
+	argon2idMaxSaltLen = 64
+	argon2idMinKeyLen  = 16
+	argon2idMaxKeyLen  = 64
+)
+
+// keyIDSeparator separates the key ID from the secret on the argon2id hashed tokens (`<key-id>.<secret>`).
+const keyIDSeparator = "."
+
+var keyIDRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// argon2idDerivations limits the concurrent argon2id derivations, so the requests with a known key
+// ID and a wrong secret can't use unbounded memory and CPU.
+var argon2idDerivations = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// Hash is a parsed token hash.
+//
+// The encoded format is `<algorithm>:<hash>`:
+//   - `sha256:<hex encoded sha256>`.
+//   - `argon2id:<key-id>:<PHC encoded argon2id>` (e.g `argon2id:k1:$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>`).
+//
+// The argon2id hashes are salted so they can't be indexed by the token, the argon2id hashed
+// tokens are `<key-id>.<secret>` and the hashes are indexed by the key ID, this way a token
+// is verified at most against one argon2id hash.
+type Hash struct {
+	Algorithm string
+	// SHA256 is the sha256 digest (hex encoded) when the algorithm is sha256.
+	SHA256 string
+	// KeyID is the key ID of the token when the algorithm is argon2id.
+	KeyID string
+
+	argon2id *argon2idHash
+}
+
+type argon2idHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+	// verified is the sha256 digest of the last verified token, so the valid tokens are derived once.
+	verified atomic.Pointer[[sha256.Size]byte]
+}
+
+// Parse parses an encoded token hash.
+func Parse(encoded string) (*Hash, error) {
+	alg, value, ok := strings.Cut(encoded, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid hash format, expected <algorithm>:<hash>")
+	}
+
+	switch alg {
+	case AlgorithmSHA256:
+		d, err := hex.DecodeString(value)
+		if err != nil || len(d) != sha256.Size {
+			return nil, fmt.Errorf("invalid sha256 hash, expected a hex encoded sha256 digest")
+		}
+		return &Hash{Algorithm: alg, SHA256: strings.ToLower(value)}, nil
+
+	case AlgorithmArgon2id:
+		keyID, phc, ok := strings.Cut(value, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid argon2id hash, expected argon2id:<key-id>:<hash>")
+		}
+		if !keyIDRegexp.MatchString(keyID) {
+			return nil, fmt.Errorf("invalid argon2id hash key ID %q, must match %s", keyID, keyIDRegexp)
+		}
+
+		h, err := parseArgon2id(phc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid argon2id hash: %w", err)
+		}
+		return &Hash{Algorithm: alg, KeyID: keyID, argon2id: h}, nil
+	}
+
+	return nil, fmt.Errorf("unknown hash algorithm %q", alg)
+}
+
+func parseArgon2id(s string) (*argon2idHash, error) {
+	// PHC format: $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>.
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != AlgorithmArgon2id {
+		return nil, fmt.Errorf("expected PHC format")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version: %w", err)
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+
+	h := &argon2idHash{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameters: %w", err)
+	}
+	if h.time < 1 || h.time > argon2idMaxTime {
+		return nil, fmt.Errorf("time parameter must be between 1 and %d", argon2idMaxTime)
+	}
+	if h.threads < 1 || h.threads > argon2idMaxThreads {
+		return nil, fmt.Errorf("parallelism parameter must be between 1 and %d", argon2idMaxThreads)
+	}
+	// Argon2 requires at least 8KiB of memory per thread.
+	if h.memory < 8*uint32(h.threads) || h.memory > argon2idMaxMemory {
+		return nil, fmt.Errorf("memory parameter must be between %d and %d KiB", 8*uint32(h.threads), argon2idMaxMemory)
+	}
+	if h.memory*h.time > argon2idMaxCost {
+		return nil, fmt.Errorf("memory and time parameters product must be up to %d", argon2idMaxCost)
+	}
+
+	h.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, fmt.Errorf("invalid salt: %w", err)
+	}
+	if len(h.salt) < argon2idMinSaltLen || len(h.salt) > argon2idMaxSaltLen {
+		return nil, fmt.Errorf("salt length must be between %d and %d bytes", argon2idMinSaltLen, argon2idMaxSaltLen)
+	}
+
+	h.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	if len(h.key) < argon2idMinKeyLen || len(h.key) > argon2idMaxKeyLen {
+		return nil, fmt.Errorf("key length must be between %d and %d bytes", argon2idMinKeyLen, argon2idMaxKeyLen)
+	}
+
+	return h, nil
+}
+
+// Verify returns true if the token matches the hash.
+func (h Hash) Verify(token string) bool {
+	switch h.Algorithm {
+	case AlgorithmSHA256:
+		return subtle.ConstantTimeCompare([]byte(SHA256(token)), []byte(h.SHA256)) == 1
+	case AlgorithmArgon2id:
+		if KeyID(token) != h.KeyID {
+			return false
+		}
+		return h.argon2id.verify(token)
+	}
+
+	return false
+}
+
+func (a *argon2idHash) verify(token string) bool {
+	digest := sha256.Sum256([]byte(token))
+	if v := a.verified.Load(); v != nil && subtle.ConstantTimeCompare(v[:], digest[:]) == 1 {
+		return true
+	}
+
+	argon2idDerivations <- struct{}{}
+	key := argon2.IDKey([]byte(token), a.salt, a.time, a.memory, a.threads, uint32(len(a.key)))
+	<-argon2idDerivations
+
+	if subtle.ConstantTimeCompare(key, a.key) != 1 {
+		return false
+	}
+	a.verified.Store(&digest)
+
+	return true
+}
+
+// SHA256 returns the hex encoded sha256 digest of a token.
+func SHA256(token string) string {
+	d := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(d[:])
+}
+
+// KeyID returns the key ID of a `<key-id>.<secret>` token, empty if the token doesn't have a key ID.
+func KeyID(token string) string {
+	keyID, _, ok := strings.Cut(token, keyIDSeparator)
+	if !ok || !keyIDRegexp.MatchString(keyID) {
+		return ""
+	}
+
+	return keyID
+}
+
+// Generate returns the encoded hash of a token using the algorithm.
+func Generate(algorithm, token string) (string, error) {
+	switch algorithm {
+	case AlgorithmSHA256:
+		return AlgorithmSHA256 + ":" + SHA256(token), nil
+
+	case AlgorithmArgon2id:
+		keyID := KeyID(token)
+		if keyID == "" {
+			return "", fmt.Errorf("argon2id hashed tokens require a key ID, expected <key-id>.<secret> token")
+		}
+
+		salt := make([]byte, argon2idSaltLen)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return "", fmt.Errorf("could not generate salt: %w", err)
+		}
+
+		key := argon2.IDKey([]byte(token), salt, argon2idTime, argon2idMemory, argon2idThreads, argon2idKeyLen)
+		return fmt.Sprintf("%s:%s:$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			AlgorithmArgon2id,
+			keyID,
+			argon2.Version,
+			argon2idMemory, argon2idTime, argon2idThreads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+
+	return "", fmt.Errorf("unknown hash algorithm %q", algorithm)
+}
@@ -0,0 +1,168 @@
+package tokenhash_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/simple-ingress-external-auth/internal/tokenhash"
+)
+
+func TestHashVerify(t *testing.T) {
+	tests := map[string]struct {
+		hash      string
+		token     string
+		expErr    bool
+		expVerify bool
+	}{
+		"Missing algorithm should fail.": {
+			hash:   "0123456789",
+			expErr: true,
+		},
+
+		"Unknown algorithm should fail.": {
+			hash:   "md5:0123456789",
+			expErr: true,
+		},
+
+		"Invalid sha256 hash should fail.": {
+			hash:   "sha256:0123456789",
+			expErr: true,
+		},
+
+		"Invalid argon2id hash should fail.": {
+			hash:   "argon2id:$argon2id$v=19$m=19456$c2FsdA$a2V5",
+			expErr: true,
+		},
+
+		"A valid sha256 hash should verify the correct token.": {
+			hash:      "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+			token:     "test",
+			expVerify: true,
+		},
+
+		"A valid sha256 hash should not verify an incorrect token.": {
+			hash:      "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+			token:     "test2",
+			expVerify: false,
+		},
+
+		"A valid argon2id hash should verify the correct token.": {
+			hash:      "argon2id:k1:$argon2id$v=19$m=19456,t=2,p=1$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8",
+			token:     "k1.abc",
+			expVerify: true,
+		},
+
+		"A valid argon2id hash should not verify an incorrect token.": {
+			hash:      "argon2id:k1:$argon2id$v=19$m=19456,t=2,p=1$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8",
+			token:     "k1.abcd",
+			expVerify: false,
+		},
+
+		"A valid argon2id hash should not verify the token secret with other key ID.": {
+			hash:      "argon2id:k1:$argon2id$v=19$m=19456,t=2,p=1$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8",
+			token:     "k2.abc",
+			expVerify: false,
+		},
+
+		"An argon2id hash without key ID should fail.": {
+			hash:   "argon2id:$argon2id$v=19$m=19456,t=2,p=1$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8",
+			expErr: true,
+		},
+
+		"An argon2id hash with an invalid key ID should fail.": {
+			hash:   "argon2id:k.1:$argon2id$v=19$m=19456,t=2,p=1$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8",
+			expErr: true,
+		},
+
+		"An argon2id hash with too much memory should fail.": {
+			hash:   "argon2id:k1:$argon2id$v=19$m=4194304,t=2,p=1$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8",
+			expErr: true,
+		},
+
+		"An argon2id hash with too little memory should fail.": {
+			hash:   "argon2id:k1:$argon2id$v=19$m=8,t=2,p=4$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8",
+			expErr: true,
+		},
+
+		"An argon2id hash with too many iterations should fail.": {
+			hash:   "argon2id:k1:$argon2id$v=19$m=19456,t=1000,p=1$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8",
+			expErr: true,
+		},
+
+		"An argon2id hash with too many threads should fail.": {
+			hash:   "argon2id:k1:$argon2id$v=19$m=19456,t=2,p=255$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8",
+			expErr: true,
+		},
+
+		"An argon2id hash with too much memory and iterations should fail.": {
+			hash:   "argon2id:k1:$argon2id$v=19$m=19456,t=3,p=1$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8",
+			expErr: true,
+		},
+
+		"An argon2id hash with the max memory should be valid.": {
+			hash:      "argon2id:k1:$argon2id$v=19$m=47104,t=1,p=1$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8",
+			token:     "k1.abc",
+			expVerify: false,
+		},
+
+		"An argon2id hash with a short key should fail.": {
+			hash:   "argon2id:k1:$argon2id$v=19$m=19456,t=2,p=1$9ld05+3RLCT4FBTLjjeUow$a2V5",
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			h, err := tokenhash.Parse(test.hash)
+			if test.expErr {
+				assert.Error(err)
+				return
+			}
+
+			if assert.NoError(err) {
+				assert.Equal(test.expVerify, h.Verify(test.token))
+			}
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	for _, alg := range []string{tokenhash.AlgorithmSHA256, tokenhash.AlgorithmArgon2id} {
+		t.Run(alg, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			encoded, err := tokenhash.Generate(alg, "k1.my-token")
+			require.NoError(err)
+
+			h, err := tokenhash.Parse(encoded)
+			require.NoError(err)
+			assert.Equal(alg, h.Algorithm)
+			assert.True(h.Verify("k1.my-token"))
+			assert.False(h.Verify("k1.other-token"))
+		})
+	}
+}
+
+func TestHashVerifyArgon2idVerified(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	h, err := tokenhash.Parse("argon2id:k1:$argon2id$v=19$m=19456,t=2,p=1$9ld05+3RLCT4FBTLjjeUow$LchQbr9lpT0U6M3O9VO6dZJepsuP0cj8O7ke8aB5Qm8")
+	require.NoError(err)
+
+	// The verified token should keep being the only valid token.
+	assert.True(h.Verify("k1.abc"))
+	assert.True(h.Verify("k1.abc"))
+	assert.False(h.Verify("k1.abcd"))
+	assert.True(h.Verify("k1.abc"))
+}
+
+func TestGenerateArgon2idWithoutKeyID(t *testing.T) {
+	_, err := tokenhash.Generate(tokenhash.AlgorithmArgon2id, "my-token")
+	assert.Error(t, err)
+}
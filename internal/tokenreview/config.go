@@ -0,0 +1,75 @@
+package tokenreview
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/ghodss/yaml"
+)
+
+// Config is the Kubernetes TokenReview service accounts configuration.
+type Config struct {
+	// ServiceAccounts are the accepted service accounts, if empty, all the service accounts are accepted.
+	ServiceAccounts []ServiceAccountConfig `json:"service_accounts"`
+}
+
+// ServiceAccountConfig selects service accounts of a namespace and restricts them.
+type ServiceAccountConfig struct {
+	// Namespace is the service account namespace.
+	Namespace string `json:"namespace"`
+	// Name is the service account name, if empty, all the namespace service accounts are selected.
+	Name string `json:"name,omitempty"`
+	// AllowedURLRegex is the regex the request URL must match.
+	AllowedURLRegex string `json:"allowed_url,omitempty"`
+	// AllowedMethodRegex is the regex the request method must match.
+	AllowedMethodRegex string `json:"allowed_method,omitempty"`
+}
+
+// DecodeConfig decodes a JSON or YAML TokenReview configuration.
+func DecodeConfig(data []byte) (*Config, error) {
+	c := &Config{}
+	err := json.Unmarshal(data, c)
+	if err != nil {
+		err = yaml.Unmarshal(data, c)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode JSON or YAML: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// NewServiceAccountsFromConfig returns the service accounts of the configuration.
+func NewServiceAccountsFromConfig(config Config) ([]ServiceAccount, error) {
+	sas := make([]ServiceAccount, 0, len(config.ServiceAccounts))
+	for _, sc := range config.ServiceAccounts {
+		if sc.Namespace == "" {
+			return nil, fmt.Errorf("service account namespace is required")
+		}
+
+		sa := ServiceAccount{
+			Namespace: sc.Namespace,
+			Name:      sc.Name,
+		}
+
+		var err error
+		if sc.AllowedURLRegex != "" {
+			sa.AllowedURL, err = regexp.Compile(sc.AllowedURLRegex)
+			if err != nil {
+				return nil, fmt.Errorf("service account %q: invalid allowed URL regex: %w", sa, err)
+			}
+		}
+
+		if sc.AllowedMethodRegex != "" {
+			sa.AllowedMethod, err = regexp.Compile(sc.AllowedMethodRegex)
+			if err != nil {
+				return nil, fmt.Errorf("service account %q: invalid allowed method regex: %w", sa, err)
+			}
+		}
+
+		sas = append(sas, sa)
+	}
+
+	return sas, nil
+}
@@ -0,0 +1,244 @@
+// Package tokenreview gets the Kubernetes service account tokens validations using the Kubernetes
+// TokenReview API.
+package tokenreview
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/log"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+	"github.com/slok/simple-ingress-external-auth/internal/tokencache"
+	"github.com/slok/simple-ingress-external-auth/internal/tokenhash"
+)
+
+// serviceAccountUserPrefix is the prefix of the service account usernames (`system:serviceaccount:<namespace>:<name>`).
+const serviceAccountUserPrefix = "system:serviceaccount:"
+
+// ServiceAccount selects service accounts and restricts their tokens.
+type ServiceAccount struct {
+	Namespace string
+	// Name is the service account name, if empty, all the namespace service accounts are selected.
+	Name string
+	// AllowedURL and AllowedMethod are used to validate the selected service account tokens.
+	AllowedURL    *regexp.Regexp
+	AllowedMethod *regexp.Regexp
+}
+
+func (s ServiceAccount) String() string {
+	if s.Name == "" {
+		return s.Namespace + "/*"
+	}
+
+	return s.Namespace + "/" + s.Name
+}
+
+// TokenGetterConfig is the configuration of the TokenGetter.
+type TokenGetterConfig struct {
+	Client kubernetes.Interface
+	// Audiences are the audiences the tokens must be valid for, if empty, the Kubernetes API server audiences.
+	Audiences []string
+	// ServiceAccounts are the accepted service accounts, if empty, all the service accounts are accepted.
+	// The service account specific entries take precedence over the namespace ones.
+	ServiceAccounts []ServiceAccount
+	// CacheSize is the max number of tokens (including the invalid ones) kept in memory, 0 disables the cache.
+	CacheSize int
+	// CacheTTL is how long the valid tokens are cached, never after their expiration.
+	CacheTTL time.Duration
+	// NegativeCacheTTL is how long the invalid tokens are cached.
+	NegativeCacheTTL time.Duration
+	Logger           log.Logger
+}
+
+func (c *TokenGetterConfig) defaults() error {
+	if c.Client == nil {
+		return fmt.Errorf("kubernetes client is required")
+	}
+
+	if c.CacheSize < 0 {
+		return fmt.Errorf("cache size can't be negative")
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Noop
+	}
+
+	return nil
+}
+
+// TokenGetter gets the Kubernetes service account tokens validations using the Kubernetes TokenReview
+// API, the service account user (`system:serviceaccount:<namespace>:<name>`) is used as the client ID.
+//
+// The tokens that are not JWTs, not authenticated, or not from an accepted service account are handled
+// as missing tokens. The review results are cached in memory (including the invalid ones). TokenReview
+// API errors are not handled as missing tokens.
+type TokenGetter struct {
+	client           kubernetes.Interface
+	audiences        []string
+	serviceAccounts  []ServiceAccount
+	cache            *tokencache.Cache
+	cacheTTL         time.Duration
+	negativeCacheTTL time.Duration
+	logger           log.Logger
+}
+
+// NewTokenGetter returns a new TokenGetter.
+func NewTokenGetter(config TokenGetterConfig) (*TokenGetter, error) {
+	err := config.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return &TokenGetter{
+		client:           config.Client,
+		audiences:        config.Audiences,
+		serviceAccounts:  config.ServiceAccounts,
+		cache:            tokencache.New(config.CacheSize),
+		cacheTTL:         config.CacheTTL,
+		negativeCacheTTL: config.NegativeCacheTTL,
+		logger:           config.Logger.WithValues(log.Kv{"svc": "tokenreview.TokenGetter"}),
+	}, nil
+}
+
+func (t *TokenGetter) GetStaticTokenValidation(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error) {
+	// Fast path for non JWT tokens, the service account tokens are JWTs.
+	if strings.Count(tokenValue, ".") != 2 {
+		return nil, fmt.Errorf("not a JWT: %w", internalerrors.ErrNotFound)
+	}
+
+	hash := tokenhash.SHA256(tokenValue)
+
+	token, ok := t.cache.Get(hash)
+	if !ok {
+		var err error
+		token, err = t.review(ctx, tokenValue)
+		if err != nil {
+			return nil, err
+		}
+
+		if token != nil {
+			ttl := t.cacheTTL
+			if !token.ExpiresAt.IsZero() {
+				ttl = min(ttl, time.Until(token.ExpiresAt))
+			}
+			t.cache.Set(hash, token, ttl)
+		} else {
+			t.cache.Set(hash, nil, t.negativeCacheTTL)
+		}
+	}
+
+	if token == nil {
+		return nil, fmt.Errorf("token not valid: %w", internalerrors.ErrNotFound)
+	}
+
+	// Don't share the cached token.
+	tk := *token
+	tk.Value = tokenValue
+
+	return &tk, nil
+}
+
+// review returns nil if the token is not valid.
+func (t *TokenGetter) review(ctx context.Context, tokenValue string) (*model.StaticTokenValidation, error) {
+	tr, err := t.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     tokenValue,
+			Audiences: t.audiences,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not review token: %w", err)
+	}
+
+	if !tr.Status.Authenticated {
+		t.logger.Debugf("Token not authenticated: %s", tr.Status.Error)
+		return nil, nil
+	}
+
+	// The token must be valid for one of our audiences.
+	if len(t.audiences) > 0 && !slices.ContainsFunc(tr.Status.Audiences, func(a string) bool { return slices.Contains(t.audiences, a) }) {
+		t.logger.Debugf("Token not valid for the audiences")
+		return nil, nil
+	}
+
+	username := tr.Status.User.Username
+	namespace, name, ok := strings.Cut(strings.TrimPrefix(username, serviceAccountUserPrefix), ":")
+	if !strings.HasPrefix(username, serviceAccountUserPrefix) || !ok {
+		t.logger.WithValues(log.Kv{"user": username}).Debugf("Token is not a service account token")
+		return nil, nil
+	}
+
+	sa, ok := t.matchServiceAccount(namespace, name)
+	if !ok {
+		t.logger.WithValues(log.Kv{"user": username}).Debugf("Service account not accepted")
+		return nil, nil
+	}
+
+	return &model.StaticTokenValidation{
+		ClientID:  username,
+		ExpiresAt: jwtExpiration(tokenValue),
+		Common: model.TokenCommon{
+			AllowedURL:    sa.AllowedURL,
+			AllowedMethod: sa.AllowedMethod,
+		},
+	}, nil
+}
+
+// matchServiceAccount returns the service account restrictions, the service account entries take
+// precedence over the namespace ones.
+func (t *TokenGetter) matchServiceAccount(namespace, name string) (ServiceAccount, bool) {
+	if len(t.serviceAccounts) == 0 {
+		return ServiceAccount{Namespace: namespace, Name: name}, true
+	}
+
+	var nsMatch *ServiceAccount
+	for i, sa := range t.serviceAccounts {
+		if sa.Namespace != namespace {
+			continue
+		}
+
+		if sa.Name == name {
+			return sa, true
+		}
+
+		if sa.Name == "" && nsMatch == nil {
+			nsMatch = &t.serviceAccounts[i]
+		}
+	}
+
+	if nsMatch != nil {
+		return *nsMatch, true
+	}
+
+	return ServiceAccount{}, false
+}
+
+// jwtExpiration returns the JWT `exp` claim without verifying the JWT (already verified by Kubernetes),
+// zero if missing.
+func jwtExpiration(token string) time.Time {
+	parts := strings.Split(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+
+	claims := struct {
+		Exp int64 `json:"exp"`
+	}{}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp <= 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(claims.Exp, 0)
+}
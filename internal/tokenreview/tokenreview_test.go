@@ -0,0 +1,268 @@
+package tokenreview_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	kubetesting "k8s.io/client-go/testing"
+
+	"github.com/slok/simple-ingress-external-auth/internal/internalerrors"
+	"github.com/slok/simple-ingress-external-auth/internal/model"
+	"github.com/slok/simple-ingress-external-auth/internal/tokenreview"
+)
+
+// newTestToken returns a JWT like token, the signature is not used, Kubernetes verifies it.
+func newTestToken(name string, exp time.Time) string {
+	payload := fmt.Sprintf(`{"sub":%q,"exp":%d}`, name, exp.Unix())
+	return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+}
+
+// newTestClient returns a fake Kubernetes client that reviews the tokens with their statuses, and
+// tracks the reviews.
+func newTestClient(statuses map[string]authenticationv1.TokenReviewStatus, reviews *int) *fake.Clientset {
+	client := fake.NewClientset()
+	client.PrependReactor("create", "tokenreviews", func(action kubetesting.Action) (bool, runtime.Object, error) {
+		*reviews++
+		tr := action.(kubetesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if tr.Spec.Token == "error.error.error" {
+			return true, nil, fmt.Errorf("something")
+		}
+
+		tr = tr.DeepCopy()
+		tr.Status = statuses[tr.Spec.Token]
+		return true, tr, nil
+	})
+
+	return client
+}
+
+func saStatus(ns, name string, audiences ...string) authenticationv1.TokenReviewStatus {
+	return authenticationv1.TokenReviewStatus{
+		Authenticated: true,
+		User:          authenticationv1.UserInfo{Username: "system:serviceaccount:" + ns + ":" + name},
+		Audiences:     audiences,
+	}
+}
+
+func TestTokenGetter(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	t0 := newTestToken("t0", exp)
+
+	tests := map[string]struct {
+		config     tokenreview.TokenGetterConfig
+		statuses   map[string]authenticationv1.TokenReviewStatus
+		getBefore  []string
+		token      string
+		expToken   *model.StaticTokenValidation
+		expErr     error
+		expAnyErr  bool
+		expReviews int
+	}{
+		"A service account token should use the service account user as the client ID.": {
+			statuses:   map[string]authenticationv1.TokenReviewStatus{t0: saStatus("ns0", "sa0")},
+			token:      t0,
+			expToken:   &model.StaticTokenValidation{Value: t0, ClientID: "system:serviceaccount:ns0:sa0", ExpiresAt: exp},
+			expReviews: 1,
+		},
+
+		"A token that is not a JWT should not be reviewed.": {
+			token:      "t0",
+			expErr:     internalerrors.ErrNotFound,
+			expReviews: 0,
+		},
+
+		"A not authenticated token should return not found.": {
+			statuses:   map[string]authenticationv1.TokenReviewStatus{t0: {Authenticated: false, Error: "invalid token"}},
+			token:      t0,
+			expErr:     internalerrors.ErrNotFound,
+			expReviews: 1,
+		},
+
+		"A token of a user that is not a service account should return not found.": {
+			statuses: map[string]authenticationv1.TokenReviewStatus{t0: {
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: "system:node:node0"},
+			}},
+			token:      t0,
+			expErr:     internalerrors.ErrNotFound,
+			expReviews: 1,
+		},
+
+		"A token for other audiences should return not found.": {
+			config:     tokenreview.TokenGetterConfig{Audiences: []string{"ingress"}},
+			statuses:   map[string]authenticationv1.TokenReviewStatus{t0: saStatus("ns0", "sa0", "other")},
+			token:      t0,
+			expErr:     internalerrors.ErrNotFound,
+			expReviews: 1,
+		},
+
+		"A token for our audiences should be valid.": {
+			config:     tokenreview.TokenGetterConfig{Audiences: []string{"ingress"}},
+			statuses:   map[string]authenticationv1.TokenReviewStatus{t0: saStatus("ns0", "sa0", "ingress")},
+			token:      t0,
+			expToken:   &model.StaticTokenValidation{Value: t0, ClientID: "system:serviceaccount:ns0:sa0", ExpiresAt: exp},
+			expReviews: 1,
+		},
+
+		"A service account with a namespace entry should use the namespace restrictions.": {
+			config: tokenreview.TokenGetterConfig{ServiceAccounts: []tokenreview.ServiceAccount{
+				{Namespace: "ns0", AllowedMethod: regexp.MustCompile("GET")},
+			}},
+			statuses: map[string]authenticationv1.TokenReviewStatus{t0: saStatus("ns0", "sa0")},
+			token:    t0,
+			expToken: &model.StaticTokenValidation{
+				Value:     t0,
+				ClientID:  "system:serviceaccount:ns0:sa0",
+				ExpiresAt: exp,
+				Common:    model.TokenCommon{AllowedMethod: regexp.MustCompile("GET")},
+			},
+			expReviews: 1,
+		},
+
+		"A service account with its own entry should take precedence over the namespace entry.": {
+			config: tokenreview.TokenGetterConfig{ServiceAccounts: []tokenreview.ServiceAccount{
+				{Namespace: "ns0", AllowedMethod: regexp.MustCompile("GET")},
+				{Namespace: "ns0", Name: "sa0", AllowedURL: regexp.MustCompile("https://billing/.*")},
+			}},
+			statuses: map[string]authenticationv1.TokenReviewStatus{t0: saStatus("ns0", "sa0")},
+			token:    t0,
+			expToken: &model.StaticTokenValidation{
+				Value:     t0,
+				ClientID:  "system:serviceaccount:ns0:sa0",
+				ExpiresAt: exp,
+				Common:    model.TokenCommon{AllowedURL: regexp.MustCompile("https://billing/.*")},
+			},
+			expReviews: 1,
+		},
+
+		"A service account without entry should return not found.": {
+			config: tokenreview.TokenGetterConfig{ServiceAccounts: []tokenreview.ServiceAccount{
+				{Namespace: "ns0", Name: "sa1"},
+				{Namespace: "ns1"},
+			}},
+			statuses:   map[string]authenticationv1.TokenReviewStatus{t0: saStatus("ns0", "sa0")},
+			token:      t0,
+			expErr:     internalerrors.ErrNotFound,
+			expReviews: 1,
+		},
+
+		"A TokenReview API error should fail.": {
+			token:      "error.error.error",
+			expAnyErr:  true,
+			expReviews: 1,
+		},
+
+		"With cache, a valid token should be cached.": {
+			config:     tokenreview.TokenGetterConfig{CacheSize: 10, CacheTTL: time.Hour},
+			statuses:   map[string]authenticationv1.TokenReviewStatus{t0: saStatus("ns0", "sa0")},
+			getBefore:  []string{t0},
+			token:      t0,
+			expToken:   &model.StaticTokenValidation{Value: t0, ClientID: "system:serviceaccount:ns0:sa0", ExpiresAt: exp},
+			expReviews: 1,
+		},
+
+		"With cache, an invalid token should be cached.": {
+			config:     tokenreview.TokenGetterConfig{CacheSize: 10, NegativeCacheTTL: time.Hour},
+			getBefore:  []string{t0},
+			token:      t0,
+			expErr:     internalerrors.ErrNotFound,
+			expReviews: 1,
+		},
+
+		"With cache, TokenReview API errors should not be cached.": {
+			config:     tokenreview.TokenGetterConfig{CacheSize: 10, CacheTTL: time.Hour, NegativeCacheTTL: time.Hour},
+			getBefore:  []string{"error.error.error"},
+			token:      "error.error.error",
+			expAnyErr:  true,
+			expReviews: 2,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			reviews := 0
+			test.config.Client = newTestClient(test.statuses, &reviews)
+			getter, err := tokenreview.NewTokenGetter(test.config)
+			require.NoError(err)
+
+			for _, token := range test.getBefore {
+				_, _ = getter.GetStaticTokenValidation(context.TODO(), token)
+			}
+
+			gotToken, err := getter.GetStaticTokenValidation(context.TODO(), test.token)
+			switch {
+			case test.expAnyErr:
+				assert.Error(err)
+			case test.expErr != nil:
+				assert.ErrorIs(err, test.expErr)
+			default:
+				require.NoError(err)
+				assert.Equal(test.expToken, gotToken)
+			}
+
+			assert.Equal(test.expReviews, reviews)
+		})
+	}
+}
+
+func TestNewServiceAccountsFromConfig(t *testing.T) {
+	tests := map[string]struct {
+		config string
+		expSAs []tokenreview.ServiceAccount
+		expErr bool
+	}{
+		"A YAML config should be loaded.": {
+			config: `
+service_accounts:
+  - namespace: ns0
+    allowed_method: GET
+  - namespace: ns1
+    name: sa0
+    allowed_url: https://billing/.*
+`,
+			expSAs: []tokenreview.ServiceAccount{
+				{Namespace: "ns0", AllowedMethod: regexp.MustCompile("GET")},
+				{Namespace: "ns1", Name: "sa0", AllowedURL: regexp.MustCompile("https://billing/.*")},
+			},
+		},
+
+		"A service account without namespace should fail.": {
+			config: `{"service_accounts": [{"name": "sa0"}]}`,
+			expErr: true,
+		},
+
+		"A service account with an invalid regex should fail.": {
+			config: `{"service_accounts": [{"namespace": "ns0", "allowed_url": "("}]}`,
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			config, err := tokenreview.DecodeConfig([]byte(test.config))
+			require.NoError(err)
+
+			gotSAs, err := tokenreview.NewServiceAccountsFromConfig(*config)
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				assert.Equal(test.expSAs, gotSAs)
+			}
+		})
+	}
+}
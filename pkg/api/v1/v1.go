@@ -5,6 +5,11 @@ import "time"
 type Config struct {
 	Version string  `json:"version"`
 	Tokens  []Token `json:"tokens"`
+	Users   []User  `json:"users,omitempty"`
+	// ClientCerts are the client certificate identities.
+	ClientCerts []ClientCert `json:"client_certs,omitempty"`
+	// SignatureKeys are the HTTP message signature (RFC 9421) keys.
+	SignatureKeys []SignatureKey `json:"signature_keys,omitempty"`
 }
 
 type Common struct {
@@ -16,7 +21,56 @@ type Common struct {
 type Token struct {
 	Common
 
-	Value     string     `json:"value"`
+	Value     string     `json:"value,omitempty"`
+	ValueHash string     `json:"value_hash,omitempty"`
 	ClientID  string     `json:"client_id"`
 	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Groups are the groups of the token client (e.g Kubernetes user groups).
+	Groups []string `json:"groups,omitempty"`
+	// Scopes are the scopes granted to the token, checked against the required scopes of the
+	// authentication requests.
+	Scopes []string `json:"scopes,omitempty"`
+	// Kubernetes allows the token to authenticate the Kubernetes API server users (webhook token
+	// authentication).
+	Kubernetes bool `json:"kubernetes,omitempty"`
+}
+
+// User is a Basic auth user, the username is used as the client ID.
+type User struct {
+	Common
+
+	Username string `json:"username"`
+	// PasswordHash is an htpasswd compatible password hash (bcrypt or SHA).
+	PasswordHash string     `json:"password_hash"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
+// ClientCert is a client certificate identity, the certificates are matched by one of subject,
+// SAN or SHA256 fingerprint.
+type ClientCert struct {
+	Common
+
+	ClientID string `json:"client_id"`
+	// Subject is the certificate subject distinguished name (RFC 2253), e.g `CN=app1,O=slok`.
+	Subject string `json:"subject,omitempty"`
+	// SAN is one of the certificate subject alternative names (DNS, URI, email or IP).
+	SAN string `json:"san,omitempty"`
+	// Fingerprint is the hex encoded SHA256 fingerprint of the certificate (colons are ignored).
+	Fingerprint string     `json:"fingerprint,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// SignatureKey is an HTTP message signature (RFC 9421) key, identified by the signature `keyid`. It
+// has one of a shared HMAC secret or a PEM public key.
+type SignatureKey struct {
+	Common
+
+	KeyID    string `json:"key_id"`
+	ClientID string `json:"client_id"`
+	// Algorithm is one of `hmac-sha256`, `ed25519`, `ecdsa-p256-sha256`, `rsa-pss-sha512` or
+	// `rsa-v1_5-sha256`, if missing, it's inferred from the key.
+	Algorithm string     `json:"algorithm,omitempty"`
+	Secret    string     `json:"secret,omitempty"`
+	PublicKey string     `json:"public_key,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
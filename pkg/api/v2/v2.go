@@ -0,0 +1,75 @@
+package v2
+
+import "time"
+
+type Config struct {
+	Version string   `json:"version"`
+	Clients []Client `json:"clients"`
+	Users   []User   `json:"users,omitempty"`
+}
+
+type Common struct {
+	Disable            bool       `json:"disable,omitempty"`
+	AllowedURLRegex    string     `json:"allowed_url,omitempty"`
+	AllowedMethodRegex string     `json:"allowed_method,omitempty"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`
+}
+
+// Client is the owner of the credentials, the client properties are inherited by all
+// its credentials (e.g disabling a client will disable all of its credentials).
+type Client struct {
+	Common
+
+	ID          string       `json:"id"`
+	Credentials []Credential `json:"credentials"`
+	// Groups are the client groups (e.g Kubernetes user groups), used by all the client credentials.
+	Groups []string `json:"groups,omitempty"`
+	// Scopes are the scopes granted to the client, used by all the client credentials.
+	Scopes []string `json:"scopes,omitempty"`
+	// Kubernetes allows the client credentials to authenticate the Kubernetes API server users
+	// (webhook token authentication).
+	Kubernetes bool `json:"kubernetes,omitempty"`
+	// Certificates are the client certificate identities of the client.
+	Certificates []Certificate `json:"certificates,omitempty"`
+	// SignatureKeys are the HTTP message signature (RFC 9421) keys of the client.
+	SignatureKeys []SignatureKey `json:"signature_keys,omitempty"`
+}
+
+// Credential is a client token. The URL and method restrictions override the client ones,
+// and the expiration can only be shorter than the client one.
+type Credential struct {
+	Common
+
+	Value     string `json:"value,omitempty"`
+	ValueHash string `json:"value_hash,omitempty"`
+}
+
+// Certificate is a client certificate identity, matched by one of subject, SAN or SHA256 fingerprint.
+// Like the credentials, it inherits the client properties.
+type Certificate struct {
+	Common
+
+	Subject     string `json:"subject,omitempty"`
+	SAN         string `json:"san,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// SignatureKey is an HTTP message signature (RFC 9421) key, with one of a shared HMAC secret or
+// a PEM public key. Like the credentials, it inherits the client properties.
+type SignatureKey struct {
+	Common
+
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+// User is a Basic auth user, the username is used as the client ID.
+type User struct {
+	Common
+
+	Username string `json:"username"`
+	// PasswordHash is an htpasswd compatible password hash (bcrypt or SHA).
+	PasswordHash string `json:"password_hash"`
+}